@@ -0,0 +1,375 @@
+//nolint:package-comments,revive,mnd,exhaustruct,errchkjson
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	requestIDHeader    = "X-Request-ID"
+	maxRequestIDLength = 128
+	problemContentType = "application/problem+json"
+	problemTypePrefix  = "urn:poppy-journey-planner:problem:"
+	upstreamRetryAfter = 30 * time.Second
+)
+
+// apiErrorCode is the machine-readable class of a v2 error. Clients branch
+// on it; the individual validation issues explain the details.
+type apiErrorCode string
+
+const (
+	apiErrorInvalidRequest      apiErrorCode = "invalid_request"
+	apiErrorValidationFailed    apiErrorCode = "validation_failed"
+	apiErrorNotFound            apiErrorCode = "not_found"
+	apiErrorMethodNotAllowed    apiErrorCode = "method_not_allowed"
+	apiErrorUnauthorized        apiErrorCode = "unauthorized"
+	apiErrorForbidden           apiErrorCode = "forbidden"
+	apiErrorRateLimited         apiErrorCode = "rate_limited"
+	apiErrorPayloadTooLarge     apiErrorCode = "payload_too_large"
+	apiErrorNoVehicle           apiErrorCode = "no_vehicle_available"
+	apiErrorUpstreamUnavailable apiErrorCode = "upstream_unavailable"
+	apiErrorUpstreamTimeout     apiErrorCode = "upstream_timeout"
+	apiErrorInternal            apiErrorCode = "internal_error"
+)
+
+var apiErrorTitles = map[apiErrorCode]string{
+	apiErrorInvalidRequest:      "Invalid request",
+	apiErrorValidationFailed:    "Journey validation failed",
+	apiErrorNotFound:            "Not found",
+	apiErrorMethodNotAllowed:    "Method not allowed",
+	apiErrorUnauthorized:        "Unauthorized",
+	apiErrorForbidden:           "Forbidden",
+	apiErrorRateLimited:         "Too many requests",
+	apiErrorPayloadTooLarge:     "Payload too large",
+	apiErrorNoVehicle:           "No vehicle available",
+	apiErrorUpstreamUnavailable: "Upstream service unavailable",
+	apiErrorUpstreamTimeout:     "Upstream service timed out",
+	apiErrorInternal:            "Internal error",
+}
+
+var apiErrorStatuses = map[apiErrorCode]int{
+	apiErrorInvalidRequest:      http.StatusBadRequest,
+	apiErrorValidationFailed:    http.StatusUnprocessableEntity,
+	apiErrorNotFound:            http.StatusNotFound,
+	apiErrorMethodNotAllowed:    http.StatusMethodNotAllowed,
+	apiErrorUnauthorized:        http.StatusUnauthorized,
+	apiErrorForbidden:           http.StatusForbidden,
+	apiErrorRateLimited:         http.StatusTooManyRequests,
+	apiErrorPayloadTooLarge:     http.StatusRequestEntityTooLarge,
+	apiErrorNoVehicle:           http.StatusServiceUnavailable,
+	apiErrorUpstreamUnavailable: http.StatusServiceUnavailable,
+	apiErrorUpstreamTimeout:     http.StatusGatewayTimeout,
+	apiErrorInternal:            http.StatusInternalServerError,
+}
+
+// Problem is an RFC 9457 problem details body, extended with the error
+// code, the request ID and the validation issues.
+type Problem struct {
+	Type      string            `json:"type"`
+	Title     string            `json:"title"`
+	Status    int               `json:"status"`
+	Detail    string            `json:"detail,omitempty"`
+	Instance  string            `json:"instance,omitempty"`
+	Code      apiErrorCode      `json:"code"`
+	RequestID string            `json:"requestId,omitempty"`
+	Issues    []ValidationIssue `json:"issues,omitempty"`
+}
+
+func newProblem(r *http.Request, code apiErrorCode, detail string) Problem {
+	return Problem{
+		Type:      problemTypePrefix + string(code),
+		Title:     apiErrorTitles[code],
+		Status:    apiErrorStatuses[code],
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		Code:      code,
+		RequestID: requestIDFromContext(r.Context()),
+	}
+}
+
+// classifyError maps a planning error to its v2 error code. Upstream
+// failures are told apart from invalid input through the validation
+// issue codes and the wrapped cause.
+func classifyError(err error) apiErrorCode {
+	if isTimeout(err) {
+		return apiErrorUpstreamTimeout
+	}
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		return apiErrorInternal
+	}
+
+	for _, issue := range validationErr.Issues {
+		switch issue.Code {
+		case validationCodeVehiclesUnavailable, validationCodePricingUnavailable:
+			return apiErrorUpstreamUnavailable
+		case validationCodeNoVehicle:
+			return apiErrorNoVehicle
+		case validationCodeAddressNotFound:
+			if validationErr.cause != nil &&
+				!errors.Is(validationErr.cause, errAddressNotFound) {
+				return apiErrorUpstreamUnavailable
+			}
+		}
+	}
+
+	return apiErrorValidationFailed
+}
+
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func respondProblem(w http.ResponseWriter, problem Problem) {
+	if problem.Status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", strconv.Itoa(int(upstreamRetryAfter.Seconds())))
+	}
+
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(problem.Status)
+
+	_ = json.NewEncoder(w).Encode(problem)
+}
+
+func respondError(w http.ResponseWriter, r *http.Request, err error) {
+	problem := newProblem(r, classifyError(err), err.Error())
+
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		problem.Issues = validationErr.Issues
+	}
+
+	respondProblem(w, problem)
+}
+
+// respondData writes a v2 success body. Unlike v1 there is no envelope;
+// failures are told apart by status code and content type.
+func respondData(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(data)
+}
+
+type requestIDKey struct{}
+
+// withRequestID tags every request with an ID, reusing a sane incoming
+// X-Request-ID so IDs can be followed across services, and echoes it back.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(requestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = newRequestID()
+		}
+
+		w.Header().Set(requestIDHeader, requestID)
+
+		ctx := context.WithValue(r.Context(), requestIDKey{}, requestID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+
+	for _, char := range requestID {
+		if char < '!' || char > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newRequestID() string {
+	buffer := make([]byte, 16)
+	_, _ = rand.Read(buffer)
+
+	return hex.EncodeToString(buffer)
+}
+
+func requestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+
+	return requestID
+}
+
+func planJourneyV2Handler(
+	client *http.Client,
+	cache *upstreamCache,
+	addressGeocoder geocoder,
+	quotes *quoteStore,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var requestData PlanJourneyRequest
+
+		// v2 shares the v1 request contract described in the OpenAPI document.
+		if err := apiSpec.decodeRequest(r, "/api/v1/plan-journey", &requestData); err != nil {
+			if respondBodyTooLarge(w, r, err) {
+				return
+			}
+
+			if errors.Is(err, errInvalidRequestBody) {
+				respondProblem(w, newProblem(r, apiErrorInvalidRequest, "Invalid JSON request body"))
+
+				return
+			}
+
+			respondError(w, r, err)
+
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		plan, err := planJourney(ctx, client, cache, addressGeocoder, requestData.Journey)
+		if err != nil {
+			respondError(w, r, err)
+
+			return
+		}
+
+		quotes.recordOrWarn(ctx, plan)
+
+		if respondPlanExport(w, r, plan) {
+			return
+		}
+
+		respondData(w, http.StatusOK, plan)
+	}
+}
+
+func optimizeJourneyV2Handler(
+	client *http.Client,
+	cache *upstreamCache,
+	addressGeocoder geocoder,
+	quotes *quoteStore,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request OptimizationRequest
+
+		if err := apiSpec.decodeRequest(r, "/api/v1/optimize-journey", &request); err != nil {
+			if respondBodyTooLarge(w, r, err) {
+				return
+			}
+
+			if errors.Is(err, errInvalidRequestBody) {
+				respondProblem(w, newProblem(r, apiErrorInvalidRequest, "Invalid JSON request body"))
+
+				return
+			}
+
+			respondError(w, r, err)
+
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 20*time.Second)
+		defer cancel()
+
+		result, err := optimizeJourney(ctx, client, cache, addressGeocoder, request)
+		if err != nil {
+			respondError(w, r, err)
+
+			return
+		}
+
+		quotes.recordOrWarn(ctx, result.Plan)
+
+		respondData(w, http.StatusOK, result)
+	}
+}
+
+func vehiclesV2Handler(client *http.Client, cache *upstreamCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		vehicles, err := cache.fetchVehicles(ctx, client)
+		if err != nil {
+			code := apiErrorUpstreamUnavailable
+			if isTimeout(err) {
+				code = apiErrorUpstreamTimeout
+			}
+
+			respondProblem(w, newProblem(r, code, "Could not fetch available vehicles from Poppy"))
+
+			return
+		}
+
+		respondData(w, http.StatusOK, vehicles)
+	}
+}
+
+func healthV2Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		respondData(w, http.StatusOK, map[string]any{
+			"status":     "healthy",
+			"version":    currentBuildInfo().Version,
+			"apiVersion": "v2",
+			"service":    "poppy-journey-planner",
+		})
+	}
+}
+
+// apiV2Methods are the methods the v2 catch-all answers. It is registered
+// per method, as a method-less "/api/v2/" conflicts with "GET /".
+var apiV2Methods = []string{
+	http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+}
+
+// notFoundV2Handler answers the /api/v2 requests no route matches with a
+// problem body instead of the plain-text default: 405 with an Allow header
+// when the path is known under other methods, 404 otherwise.
+func notFoundV2Handler(known []route) http.HandlerFunc {
+	mux := http.NewServeMux()
+
+	for _, route := range known {
+		if strings.Contains(route.pattern, " /api/v2/") {
+			mux.HandleFunc(route.pattern, route.handler)
+		}
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var allowed []string
+
+		for _, method := range apiV2Methods {
+			if _, pattern := mux.Handler(&http.Request{Method: method, Host: r.Host, URL: r.URL}); pattern == "" {
+				continue
+			}
+
+			allowed = append(allowed, method)
+			if method == http.MethodGet {
+				allowed = append(allowed, http.MethodHead)
+			}
+		}
+
+		if len(allowed) == 0 {
+			respondProblem(w, newProblem(r, apiErrorNotFound, "No such endpoint"))
+
+			return
+		}
+
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		respondProblem(w, newProblem(r, apiErrorMethodNotAllowed, r.Method+" is not allowed on "+r.URL.Path))
+	}
+}
@@ -0,0 +1,234 @@
+//nolint:package-comments,revive,mnd,exhaustruct,err113
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected apiErrorCode
+	}{
+		{
+			name: "Invalid input",
+			err: newValidationError(nil, ValidationIssue{
+				Code: validationCodeNegativePause,
+			}),
+			expected: apiErrorValidationFailed,
+		},
+		{
+			name: "Poppy down",
+			err: newValidationError(errors.New("connection refused"), ValidationIssue{
+				Code: validationCodeVehiclesUnavailable,
+			}),
+			expected: apiErrorUpstreamUnavailable,
+		},
+		{
+			name: "Poppy timeout",
+			err: newValidationError(
+				fmt.Errorf("request failed: %w", context.DeadlineExceeded),
+				ValidationIssue{Code: validationCodePricingUnavailable},
+			),
+			expected: apiErrorUpstreamTimeout,
+		},
+		{
+			name: "No vehicle",
+			err: newValidationError(nil, ValidationIssue{
+				Code: validationCodeNoVehicle,
+			}),
+			expected: apiErrorNoVehicle,
+		},
+		{
+			name: "Unknown address",
+			err: newValidationError(
+				fmt.Errorf("[orsGeocoder] %w", errAddressNotFound),
+				ValidationIssue{Code: validationCodeAddressNotFound},
+			),
+			expected: apiErrorValidationFailed,
+		},
+		{
+			name: "Geocoder down",
+			err: newValidationError(
+				errors.New("API returned status 502"),
+				ValidationIssue{Code: validationCodeAddressNotFound},
+			),
+			expected: apiErrorUpstreamUnavailable,
+		},
+		{
+			name: "Wrapped validation error",
+			err: fmt.Errorf("[optimizeJourney] %w", newValidationError(nil, ValidationIssue{
+				Code: validationCodeEndOutsideParkingZone,
+			})),
+			expected: apiErrorValidationFailed,
+		},
+		{
+			name:     "Unexpected error",
+			err:      errors.New("boom"),
+			expected: apiErrorInternal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := classifyError(tt.err); actual != tt.expected {
+				t.Errorf("Expected %s but got %s", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestRespondError(t *testing.T) {
+	request := httptest.NewRequest(http.MethodPost, "/api/v2/plan-journey", nil)
+	request = request.WithContext(context.WithValue(request.Context(), requestIDKey{}, "abc"))
+	recorder := httptest.NewRecorder()
+
+	respondError(recorder, request, newValidationError(errors.New("down"), ValidationIssue{
+		Code:    validationCodeVehiclesUnavailable,
+		Message: "Could not fetch available vehicles from Poppy",
+	}))
+
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 but got %d", recorder.Code)
+	}
+
+	if contentType := recorder.Header().Get("Content-Type"); contentType != problemContentType {
+		t.Errorf("Expected content type %s but got %s", problemContentType, contentType)
+	}
+
+	if recorder.Header().Get("Retry-After") == "" {
+		t.Error("Expected a Retry-After header")
+	}
+
+	var problem Problem
+	if err := json.NewDecoder(recorder.Body).Decode(&problem); err != nil {
+		t.Fatalf("Expected a problem body but got %v", err)
+	}
+
+	if problem.Code != apiErrorUpstreamUnavailable ||
+		problem.Status != http.StatusServiceUnavailable ||
+		problem.Type != problemTypePrefix+string(apiErrorUpstreamUnavailable) ||
+		problem.RequestID != "abc" ||
+		problem.Instance != "/api/v2/plan-journey" ||
+		len(problem.Issues) != 1 {
+		t.Errorf("Unexpected problem %+v", problem)
+	}
+}
+
+func TestWithRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		reused   bool
+	}{
+		{name: "Generated", incoming: "", reused: false},
+		{name: "Reused", incoming: "client-id-42", reused: true},
+		{name: "Rejected", incoming: "bad id\n", reused: false},
+		{name: "Too long", incoming: strings.Repeat("a", maxRequestIDLength+1), reused: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+
+			handler := withRequestID(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				seen = requestIDFromContext(r.Context())
+			}))
+
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.incoming != "" {
+				request.Header.Set(requestIDHeader, tt.incoming)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if seen == "" || recorder.Header().Get(requestIDHeader) != seen {
+				t.Errorf(
+					"Expected the request ID %q to be echoed but got %q",
+					seen,
+					recorder.Header().Get(requestIDHeader),
+				)
+			}
+
+			if reused := seen == tt.incoming; reused != tt.reused {
+				t.Errorf("Expected reuse %v but got %v", tt.reused, reused)
+			}
+		})
+	}
+}
+
+func TestPlanJourneyV2Handler_InvalidBody(t *testing.T) {
+	handler := planJourneyV2Handler(http.DefaultClient, nil, nil, nil)
+	request := httptest.NewRequest(http.MethodPost, "/api/v2/plan-journey", strings.NewReader("{"))
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 but got %d", recorder.Code)
+	}
+
+	var problem Problem
+	if err := json.NewDecoder(recorder.Body).Decode(&problem); err != nil || problem.Code != apiErrorInvalidRequest {
+		t.Errorf("Expected an invalid_request problem but got %+v (%v)", problem, err)
+	}
+}
+
+func TestPlanJourneyV2Handler_ValidationFailed(t *testing.T) {
+	handler := planJourneyV2Handler(http.DefaultClient, nil, nil, nil)
+	request := httptest.NewRequest(
+		http.MethodPost,
+		"/api/v2/plan-journey",
+		strings.NewReader(`{"journey": {"legs": []}}`),
+	)
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422 but got %d", recorder.Code)
+	}
+}
+
+func TestNotFoundV2Handler(t *testing.T) {
+	mux := newRouter(services{})
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		expected int
+		code     apiErrorCode
+		allow    string
+	}{
+		{name: "Wrong method", method: http.MethodGet, path: "/api/v2/plan-journey", expected: http.StatusMethodNotAllowed, code: apiErrorMethodNotAllowed, allow: "POST"},
+		{name: "Wrong method on GET route", method: http.MethodDelete, path: "/api/v2/vehicles", expected: http.StatusMethodNotAllowed, code: apiErrorMethodNotAllowed, allow: "GET, HEAD"},
+		{name: "Unknown path", method: http.MethodGet, path: "/api/v2/nowhere", expected: http.StatusNotFound, code: apiErrorNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			mux.ServeHTTP(recorder, httptest.NewRequest(tt.method, tt.path, nil))
+
+			if recorder.Code != tt.expected || recorder.Header().Get("Allow") != tt.allow {
+				t.Fatalf("Expected status %d with Allow %q but got %d with %q",
+					tt.expected, tt.allow, recorder.Code, recorder.Header().Get("Allow"))
+			}
+
+			var problem Problem
+			if err := json.NewDecoder(recorder.Body).Decode(&problem); err != nil || problem.Code != tt.code {
+				t.Errorf("Expected a %s problem but got %+v (%v)", tt.code, problem, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,537 @@
+//nolint:package-comments,revive,mnd,exhaustruct,err113
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRequestsPerMinute = 60
+	defaultORSCallsPerDay    = 500
+	apiKeyHeader             = "X-API-Key"
+
+	// The web interface plans without a key, so it is limited per client
+	// IP instead, with a smaller ORS budget than a key gets.
+	anonymousRequestsPerMinute = 20
+	anonymousORSCallsPerDay    = 100
+	// maxAnonymousClients is the number of client IPs remembered. Past it,
+	// the least recently seen IP is forgotten and starts over with full
+	// buckets when it comes back.
+	maxAnonymousClients = 10000
+)
+
+var errORSBudgetExhausted = errors.New("ORS budget exhausted")
+
+// publicAPIPaths are served without an API key, so probes and API
+// explorers keep working.
+var publicAPIPaths = []string{
+	"/api/v1/health",
+	"/api/v1/health/live",
+	"/api/v1/health/ready",
+	"/api/v2/health",
+	"/api/openapi.json",
+}
+
+// APIKey is a configured client. Zero limits fall back to the defaults.
+type APIKey struct {
+	Name              string  `json:"name"`
+	Key               string  `json:"key"`
+	Admin             bool    `json:"admin"`
+	RequestsPerMinute float64 `json:"requestsPerMinute"`
+	ORSCallsPerDay    float64 `json:"orsCallsPerDay"`
+}
+
+// KeyUsage counts what a client did since the server started.
+type KeyUsage struct {
+	Name               string     `json:"name"`
+	Requests           int64      `json:"requests"`
+	RateLimited        int64      `json:"rateLimited"`
+	ORSCalls           int64      `json:"orsCalls"`
+	ORSBudgetExhausted int64      `json:"orsBudgetExhausted"`
+	RequestsPerMinute  float64    `json:"requestsPerMinute"`
+	RequestTokensLeft  float64    `json:"requestTokensLeft"`
+	ORSCallsPerDay     float64    `json:"orsCallsPerDay"`
+	ORSBudgetLeft      float64    `json:"orsBudgetLeft"`
+	LastRequestAt      *time.Time `json:"lastRequestAt,omitempty"`
+}
+
+// tokenBucket holds up to capacity tokens and refills continuously, so a
+// client may burst up to its whole allowance and then continues at the
+// refill rate. It is not safe for concurrent use.
+type tokenBucket struct {
+	capacity float64
+	perToken time.Duration
+	tokens   float64
+	updated  time.Time
+}
+
+func newTokenBucket(capacity float64, window time.Duration, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		capacity: capacity,
+		perToken: time.Duration(float64(window) / capacity),
+		tokens:   capacity,
+		updated:  now,
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = math.Min(b.capacity, b.tokens+float64(elapsed)/float64(b.perToken))
+		b.updated = now
+	}
+}
+
+// take removes a token. Without one, it reports how long until the next
+// token is available.
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	b.refill(now)
+
+	if b.tokens >= 1 {
+		b.tokens--
+
+		return true, 0
+	}
+
+	return false, time.Duration((1 - b.tokens) * float64(b.perToken))
+}
+
+func (b *tokenBucket) remaining(now time.Time) float64 {
+	b.refill(now)
+
+	return math.Floor(b.tokens)
+}
+
+type apiClient struct {
+	mu       sync.Mutex
+	key      APIKey
+	requests *tokenBucket
+	ors      *tokenBucket
+	usage    KeyUsage
+}
+
+func (c *apiClient) allowRequest(now time.Time) (bool, time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	allowed, retryAfter := c.requests.take(now)
+
+	c.usage.Requests++
+	c.usage.LastRequestAt = &now
+
+	if !allowed {
+		c.usage.RateLimited++
+	}
+
+	return allowed, retryAfter
+}
+
+func (c *apiClient) spendORS(now time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if allowed, _ := c.ors.take(now); !allowed {
+		c.usage.ORSBudgetExhausted++
+
+		return fmt.Errorf("[apiClient] %s: %w", c.key.Name, errORSBudgetExhausted)
+	}
+
+	c.usage.ORSCalls++
+
+	return nil
+}
+
+func (c *apiClient) snapshot(now time.Time) KeyUsage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	usage := c.usage
+	usage.RequestTokensLeft = c.requests.remaining(now)
+	usage.ORSBudgetLeft = c.ors.remaining(now)
+
+	return usage
+}
+
+func newAPIClient(key APIKey, now time.Time) *apiClient {
+	return &apiClient{
+		key:      key,
+		requests: newTokenBucket(key.RequestsPerMinute, time.Minute, now),
+		ors:      newTokenBucket(key.ORSCallsPerDay, 24*time.Hour, now),
+		usage: KeyUsage{
+			Name:              key.Name,
+			RequestsPerMinute: key.RequestsPerMinute,
+			ORSCallsPerDay:    key.ORSCallsPerDay,
+		},
+	}
+}
+
+// apiKeyAuth authenticates API requests and enforces per-key limits. An
+// apiKeyAuth without keys lets every API request through, which keeps
+// local development free of configuration. The web planning routes are
+// limited per client IP either way.
+type apiKeyAuth struct {
+	clients map[string]*apiClient
+
+	anonymousMu sync.Mutex
+	// anonymous lists the anonymousEntry of every remembered IP, most
+	// recently seen first, and anonymousByIP indexes it.
+	anonymous     *list.List
+	anonymousByIP map[string]*list.Element
+}
+
+type anonymousEntry struct {
+	ip     string
+	client *apiClient
+}
+
+// hashAPIKey is how keys are looked up, so the lookup does not leak how
+// much of a guessed key was right.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func newAPIKeyAuth(keys []APIKey, now time.Time) (*apiKeyAuth, error) {
+	auth := &apiKeyAuth{clients: map[string]*apiClient{}}
+	names := map[string]bool{}
+
+	for _, key := range keys {
+		if key.Name == "" || key.Key == "" {
+			return nil, fmt.Errorf("[newAPIKeyAuth] API keys need a name and a key, got name %q", key.Name)
+		}
+
+		hash := hashAPIKey(key.Key)
+		if names[key.Name] || auth.clients[hash] != nil {
+			return nil, fmt.Errorf("[newAPIKeyAuth] API key %q is configured twice", key.Name)
+		}
+
+		// A bucket smaller than one token never lets anything through.
+		if (key.RequestsPerMinute != 0 && key.RequestsPerMinute < 1) ||
+			(key.ORSCallsPerDay != 0 && key.ORSCallsPerDay < 1) {
+			return nil, fmt.Errorf(
+				"[newAPIKeyAuth] API key %q needs requestsPerMinute and orsCallsPerDay of at least 1, or 0 for the default",
+				key.Name,
+			)
+		}
+
+		if key.RequestsPerMinute == 0 {
+			key.RequestsPerMinute = defaultRequestsPerMinute
+		}
+
+		if key.ORSCallsPerDay == 0 {
+			key.ORSCallsPerDay = defaultORSCallsPerDay
+		}
+
+		names[key.Name] = true
+		auth.clients[hash] = newAPIClient(key, now)
+	}
+
+	return auth, nil
+}
+
+// parseAPIKeys reads the API_KEYS format, comma-separated name:key pairs.
+func parseAPIKeys(value string) ([]APIKey, error) {
+	keys := []APIKey{}
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, key, ok := strings.Cut(entry, ":")
+		if !ok || strings.TrimSpace(name) == "" || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("[parseAPIKeys] expected name:key but got %q", entry)
+		}
+
+		keys = append(keys, APIKey{Name: strings.TrimSpace(name), Key: strings.TrimSpace(key)})
+	}
+
+	return keys, nil
+}
+
+// loadAPIKeys reads the keys of the apiKeysFile setting, a JSON array of
+// APIKey with optional per-key limits and admin flag, and of apiKeys.
+func loadAPIKeys(config Config) ([]APIKey, error) {
+	keys := []APIKey{}
+
+	if path := config.APIKeysFile; path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("[loadAPIKeys] could not read %s: %w", path, err)
+		}
+
+		if err := json.Unmarshal(data, &keys); err != nil {
+			return nil, fmt.Errorf("[loadAPIKeys] could not decode %s: %w", path, err)
+		}
+	}
+
+	envKeys, err := parseAPIKeys(config.APIKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(keys, envKeys...), nil
+}
+
+func (a *apiKeyAuth) enabled() bool {
+	return a != nil && len(a.clients) > 0
+}
+
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		return key
+	}
+
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+
+	return ""
+}
+
+// requiresAPIKey covers the API and the metrics, which reveal usage and
+// upstream health.
+func requiresAPIKey(path string) bool {
+	return (strings.HasPrefix(path, "/api/") && !slices.Contains(publicAPIPaths, path)) || path == "/metrics"
+}
+
+func requiresAdminKey(path string) bool {
+	return strings.HasPrefix(path, "/api/v1/admin/") || path == "/metrics"
+}
+
+// isWebPlanningPath matches the planning routes of the web interface:
+// /plan, /plan.ics, /plan/stream and /plan/events.
+func isWebPlanningPath(path string) bool {
+	return path == "/plan" || strings.HasPrefix(path, "/plan.") || strings.HasPrefix(path, "/plan/")
+}
+
+// clientIP is the address of the caller: the value of the configured
+// proxy header when there is one, otherwise the peer address. The header
+// is only trusted when configured, as a direct caller can set any header.
+func clientIP(r *http.Request) string {
+	if header := configFromContext(r.Context()).ClientIPHeader; header != "" {
+		if value, _, _ := strings.Cut(r.Header.Get(header), ","); strings.TrimSpace(value) != "" {
+			return strings.TrimSpace(value)
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+func isLoopback(ip string) bool {
+	parsed := net.ParseIP(ip)
+
+	return parsed != nil && parsed.IsLoopback()
+}
+
+// anonymousClient returns the client of the caller's IP, creating it on
+// first use. At most maxAnonymousClients are kept, in LRU order.
+func (a *apiKeyAuth) anonymousClient(ip string, now time.Time) *apiClient {
+	a.anonymousMu.Lock()
+	defer a.anonymousMu.Unlock()
+
+	if element := a.anonymousByIP[ip]; element != nil {
+		a.anonymous.MoveToFront(element)
+
+		return element.Value.(*anonymousEntry).client
+	}
+
+	if a.anonymous == nil {
+		a.anonymous = list.New()
+		a.anonymousByIP = map[string]*list.Element{}
+	}
+
+	if a.anonymous.Len() >= maxAnonymousClients {
+		oldest := a.anonymous.Remove(a.anonymous.Back()).(*anonymousEntry)
+		delete(a.anonymousByIP, oldest.ip)
+	}
+
+	client := newAPIClient(APIKey{
+		Name:              "anonymous " + ip,
+		RequestsPerMinute: anonymousRequestsPerMinute,
+		ORSCallsPerDay:    anonymousORSCallsPerDay,
+	}, now)
+	a.anonymousByIP[ip] = a.anonymous.PushFront(&anonymousEntry{ip: ip, client: client})
+
+	return client
+}
+
+// authenticate finds the client a request is limited and budgeted as. It
+// answers the request itself and returns false when the key is missing or
+// not allowed; a nil client means the request is not limited.
+func (a *apiKeyAuth) authenticate(w http.ResponseWriter, r *http.Request) (*apiClient, bool) {
+	if isWebPlanningPath(r.URL.Path) {
+		return a.anonymousClient(clientIP(r), time.Now()), true
+	}
+
+	if !requiresAPIKey(r.URL.Path) {
+		return nil, true
+	}
+
+	// Without keys the API is open, but usage and metrics stay with the
+	// operator on the same host.
+	if !a.enabled() {
+		if requiresAdminKey(r.URL.Path) && !isLoopback(clientIP(r)) {
+			respondAPIError(w, r, apiErrorForbidden, "This endpoint is only served to localhost without API keys configured")
+
+			return nil, false
+		}
+
+		return nil, true
+	}
+
+	client := a.clients[hashAPIKey(apiKeyFromRequest(r))]
+	if client == nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="poppy-journey-planner"`)
+		respondAPIError(w, r, apiErrorUnauthorized, "A valid API key is required")
+
+		return nil, false
+	}
+
+	if requiresAdminKey(r.URL.Path) && !client.key.Admin {
+		respondAPIError(w, r, apiErrorForbidden, "This endpoint requires an admin API key")
+
+		return nil, false
+	}
+
+	return client, true
+}
+
+// respondAPIError answers in the error format of the API version the
+// request was made to.
+func respondAPIError(w http.ResponseWriter, r *http.Request, code apiErrorCode, message string) {
+	if strings.HasPrefix(r.URL.Path, "/api/v2/") {
+		respondProblem(w, newProblem(r, code, message))
+
+		return
+	}
+
+	respondJSON(w, apiErrorStatuses[code], APIResponse{
+		Success: false,
+		Error:   message,
+	})
+}
+
+// middleware checks the API key of every non-public /api request and of
+// the metrics, applies the request rate limit and hands the ORS budget of
+// the key, or of the caller's IP on the web planning routes, down to the
+// planning code through the request context.
+func (a *apiKeyAuth) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, ok := a.authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		if client == nil {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		allowed, retryAfter := client.allowRequest(time.Now())
+
+		w.Header().Set("X-RateLimit-Limit", strconv.FormatFloat(client.key.RequestsPerMinute, 'f', -1, 64))
+
+		if !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			respondAPIError(w, r, apiErrorRateLimited, "Rate limit exceeded")
+
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(withORSBudget(r.Context(), client)))
+	})
+}
+
+type orsBudgetKey struct{}
+
+func withORSBudget(ctx context.Context, client *apiClient) context.Context {
+	return context.WithValue(ctx, orsBudgetKey{}, client)
+}
+
+// apiClientFromContext returns the client the request behind ctx was
+// authenticated as, or nil.
+func apiClientFromContext(ctx context.Context) *apiClient {
+	client, _ := ctx.Value(orsBudgetKey{}).(*apiClient)
+
+	return client
+}
+
+// requestOwner is the name of the API key behind ctx, which watches and
+// saved journeys are created under, or "" without API keys.
+func requestOwner(ctx context.Context) string {
+	if client := apiClientFromContext(ctx); client != nil {
+		return client.key.Name
+	}
+
+	return ""
+}
+
+// canAccessOwner reports whether the caller behind ctx may see a record
+// created under owner: each API key sees its own records, and admin keys
+// see every record.
+func canAccessOwner(ctx context.Context, owner string) bool {
+	if client := apiClientFromContext(ctx); client != nil && client.key.Admin {
+		return true
+	}
+
+	return owner == requestOwner(ctx)
+}
+
+// spendORSBudget takes one ORS call from the budget of the client behind
+// ctx. Callers treat a refusal like an ORS failure and fall back to
+// estimates. Requests without a client, such as API requests without keys
+// configured and background work, are not budgeted.
+func spendORSBudget(ctx context.Context) error {
+	client, ok := ctx.Value(orsBudgetKey{}).(*apiClient)
+	if !ok {
+		return nil
+	}
+
+	return client.spendORS(time.Now())
+}
+
+// usageHandler lists the usage counters of every key, by name.
+func usageHandler(auth *apiKeyAuth) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		now := time.Now()
+		usage := []KeyUsage{}
+
+		if auth != nil {
+			for _, client := range auth.clients {
+				usage = append(usage, client.snapshot(now))
+			}
+		}
+
+		slices.SortFunc(usage, func(a, b KeyUsage) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+
+		respondJSON(w, http.StatusOK, APIResponse{
+			Success: true,
+			Data:    usage,
+		})
+	}
+}
@@ -0,0 +1,451 @@
+//nolint:package-comments,revive,mnd,exhaustruct
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Date(2025, 6, 2, 9, 0, 0, 0, time.UTC)
+	bucket := newTokenBucket(3, time.Minute, start)
+
+	for i := range 3 {
+		if allowed, _ := bucket.take(start); !allowed {
+			t.Fatalf("Expected token %d of the burst to be allowed", i+1)
+		}
+	}
+
+	allowed, retryAfter := bucket.take(start)
+	if allowed {
+		t.Fatal("Expected an empty bucket to refuse")
+	}
+
+	if retryAfter != 20*time.Second {
+		t.Errorf("Expected a retry after 20s but got %v", retryAfter)
+	}
+
+	if allowed, _ := bucket.take(start.Add(20 * time.Second)); !allowed {
+		t.Error("Expected a token after one refill period")
+	}
+
+	if remaining := bucket.remaining(start.Add(time.Hour)); remaining != 3 {
+		t.Errorf("Expected the bucket to refill up to its capacity but got %v", remaining)
+	}
+}
+
+func TestParseAPIKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected []APIKey
+		wantErr  bool
+	}{
+		{name: "Empty", value: "", expected: []APIKey{}},
+		{
+			name:  "Pairs",
+			value: "alice:secret-1, bob:secret:2,",
+			expected: []APIKey{
+				{Name: "alice", Key: "secret-1"},
+				{Name: "bob", Key: "secret:2"},
+			},
+		},
+		{name: "Missing key", value: "alice:", wantErr: true},
+		{name: "Missing separator", value: "alice", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := parseAPIKeys(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %t but got %v", tt.wantErr, err)
+			}
+
+			if len(keys) != len(tt.expected) {
+				t.Fatalf("Expected %d keys but got %+v", len(tt.expected), keys)
+			}
+
+			for i, key := range keys {
+				if key != tt.expected[i] {
+					t.Errorf("Expected %+v but got %+v", tt.expected[i], key)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	_ = os.WriteFile(path, []byte(`[{"name": "ops", "key": "admin-key", "admin": true, "requestsPerMinute": 5}]`), 0o600)
+
+	keys, err := loadAPIKeys(Config{APIKeysFile: path, APIKeys: "alice:alice-key"})
+	if err != nil {
+		t.Fatalf("Expected the keys to load but got %v", err)
+	}
+
+	if len(keys) != 2 || !keys[0].Admin || keys[0].RequestsPerMinute != 5 || keys[1].Name != "alice" {
+		t.Errorf("Expected the file and env keys but got %+v", keys)
+	}
+
+	auth, err := newAPIKeyAuth(keys, time.Now())
+	if err != nil {
+		t.Fatalf("Expected the keys to be accepted but got %v", err)
+	}
+
+	alice := auth.clients[hashAPIKey("alice-key")]
+	if alice == nil || alice.key.RequestsPerMinute != defaultRequestsPerMinute ||
+		alice.key.ORSCallsPerDay != defaultORSCallsPerDay {
+		t.Errorf("Expected alice to get the default limits but got %+v", alice)
+	}
+
+	if _, err := newAPIKeyAuth(append(keys, APIKey{Name: "copy", Key: "alice-key"}), time.Now()); err == nil {
+		t.Error("Expected a duplicate key to be rejected")
+	}
+
+	for _, key := range []APIKey{
+		{Name: "slow", Key: "slow-key", RequestsPerMinute: 0.5},
+		{Name: "frugal", Key: "frugal-key", ORSCallsPerDay: 0.9},
+		{Name: "negative", Key: "negative-key", RequestsPerMinute: -1},
+	} {
+		if _, err := newAPIKeyAuth([]APIKey{key}, time.Now()); err == nil {
+			t.Errorf("Expected the limits of %s to be rejected", key.Name)
+		}
+	}
+}
+
+func newTestAuthHandler(t *testing.T) (http.Handler, *apiKeyAuth) {
+	t.Helper()
+
+	auth, err := newAPIKeyAuth([]APIKey{
+		{Name: "alice", Key: "alice-key", RequestsPerMinute: 2, ORSCallsPerDay: 1},
+		{Name: "ops", Key: "admin-key", Admin: true},
+	}, time.Now())
+	if err != nil {
+		t.Fatalf("Expected the keys to be accepted but got %v", err)
+	}
+
+	mux := http.NewServeMux()
+	// Each call makes two ORS calls, one more than alice's daily budget.
+	mux.HandleFunc("GET /api/v1/vehicles", func(w http.ResponseWriter, r *http.Request) {
+		_ = spendORSBudget(r.Context())
+		_ = spendORSBudget(r.Context())
+
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("GET /api/v2/vehicles", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("GET /api/v1/health", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("GET /api/v1/admin/usage", usageHandler(auth))
+	mux.HandleFunc("GET /metrics", metricsHandler())
+
+	return withRequestID(auth.middleware(mux)), auth
+}
+
+func TestAPIKeyAuth_Middleware(t *testing.T) {
+	handler, _ := newTestAuthHandler(t)
+
+	tests := []struct {
+		name        string
+		path        string
+		headers     map[string]string
+		expected    int
+		contentType string
+	}{
+		{name: "Public path", path: "/api/v1/health", expected: http.StatusOK},
+		{name: "Missing key", path: "/api/v1/vehicles", expected: http.StatusUnauthorized},
+		{
+			name:     "Wrong key",
+			path:     "/api/v1/vehicles",
+			headers:  map[string]string{"X-API-Key": "guess"},
+			expected: http.StatusUnauthorized,
+		},
+		{
+			name:        "Missing key on v2",
+			path:        "/api/v2/vehicles",
+			expected:    http.StatusUnauthorized,
+			contentType: problemContentType,
+		},
+		{
+			name:     "Header key",
+			path:     "/api/v1/vehicles",
+			headers:  map[string]string{"X-API-Key": "admin-key"},
+			expected: http.StatusOK,
+		},
+		{
+			name:     "Bearer key",
+			path:     "/api/v2/vehicles",
+			headers:  map[string]string{"Authorization": "Bearer admin-key"},
+			expected: http.StatusOK,
+		},
+		{
+			name:     "Admin endpoint without admin key",
+			path:     "/api/v1/admin/usage",
+			headers:  map[string]string{"X-API-Key": "alice-key"},
+			expected: http.StatusForbidden,
+		},
+		{
+			name:     "Admin endpoint",
+			path:     "/api/v1/admin/usage",
+			headers:  map[string]string{"X-API-Key": "admin-key"},
+			expected: http.StatusOK,
+		},
+		{name: "Metrics without key", path: "/metrics", expected: http.StatusUnauthorized},
+		{
+			name:     "Metrics without admin key",
+			path:     "/metrics",
+			headers:  map[string]string{"X-API-Key": "alice-key"},
+			expected: http.StatusForbidden,
+		},
+		{
+			name:     "Metrics",
+			path:     "/metrics",
+			headers:  map[string]string{"Authorization": "Bearer admin-key"},
+			expected: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			for name, value := range tt.headers {
+				request.Header.Set(name, value)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.expected {
+				t.Errorf("Expected status %d but got %d", tt.expected, recorder.Code)
+			}
+
+			if tt.contentType != "" && recorder.Header().Get("Content-Type") != tt.contentType {
+				t.Errorf("Expected content type %s but got %s", tt.contentType, recorder.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestAPIKeyAuth_RateLimit(t *testing.T) {
+	handler, _ := newTestAuthHandler(t)
+
+	statuses := []int{}
+
+	for range 3 {
+		request := httptest.NewRequest(http.MethodGet, "/api/v2/vehicles", nil)
+		request.Header.Set("X-API-Key", "alice-key")
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+
+		statuses = append(statuses, recorder.Code)
+
+		if recorder.Code == http.StatusTooManyRequests && recorder.Header().Get("Retry-After") == "" {
+			t.Error("Expected a Retry-After header on 429")
+		}
+	}
+
+	expected := []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}
+	for i := range expected {
+		if statuses[i] != expected[i] {
+			t.Errorf("Expected statuses %v but got %v", expected, statuses)
+
+			break
+		}
+	}
+}
+
+func TestAPIKeyAuth_Usage(t *testing.T) {
+	handler, _ := newTestAuthHandler(t)
+
+	request := httptest.NewRequest(http.MethodGet, "/api/v1/vehicles", nil)
+	request.Header.Set("X-API-Key", "alice-key")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	request = httptest.NewRequest(http.MethodGet, "/api/v1/admin/usage", nil)
+	request.Header.Set("X-API-Key", "admin-key")
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	var response struct {
+		Data []KeyUsage `json:"data"`
+	}
+
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+		t.Fatalf("Expected a JSON body but got %v", err)
+	}
+
+	if len(response.Data) != 2 || response.Data[0].Name != "alice" {
+		t.Fatalf("Expected usage for alice and ops but got %+v", response.Data)
+	}
+
+	alice := response.Data[0]
+	if alice.Requests != 1 || alice.ORSCalls != 1 || alice.ORSBudgetExhausted != 1 || alice.ORSBudgetLeft != 0 {
+		t.Errorf("Expected alice's request and ORS calls to be counted but got %+v", alice)
+	}
+}
+
+func TestAPIKeyAuth_Disabled(t *testing.T) {
+	auth, _ := newAPIKeyAuth(nil, time.Now())
+
+	called := false
+	handler := auth.middleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		called = spendORSBudget(r.Context()) == nil
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/vehicles", nil))
+
+	if !called {
+		t.Error("Expected requests to pass without keys configured")
+	}
+
+	if err := spendORSBudget(context.Background()); err != nil {
+		t.Errorf("Expected no budget without a client but got %v", err)
+	}
+}
+
+func TestAPIKeyAuth_DisabledAdminRoutes(t *testing.T) {
+	auth, _ := newAPIKeyAuth(nil, time.Now())
+	handler := withConfigContext(defaultConfig(), auth.middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	tests := []struct {
+		name       string
+		path       string
+		remoteAddr string
+		expected   int
+	}{
+		{name: "Metrics from localhost", path: "/metrics", remoteAddr: "127.0.0.1:4321", expected: http.StatusOK},
+		{name: "Usage from localhost", path: "/api/v1/admin/usage", remoteAddr: "[::1]:4321", expected: http.StatusOK},
+		{name: "Metrics from elsewhere", path: "/metrics", remoteAddr: "192.0.2.1:4321", expected: http.StatusForbidden},
+		{name: "Usage from elsewhere", path: "/api/v1/admin/usage", remoteAddr: "192.0.2.1:4321", expected: http.StatusForbidden},
+		{name: "API from elsewhere", path: "/api/v1/vehicles", remoteAddr: "192.0.2.1:4321", expected: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			request.RemoteAddr = tt.remoteAddr
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.expected {
+				t.Errorf("Expected status %d but got %d", tt.expected, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestAPIKeyAuth_AnonymousWebPlanning(t *testing.T) {
+	for _, keys := range [][]APIKey{nil, {{Name: "alice", Key: "alice-key"}}} {
+		auth, _ := newAPIKeyAuth(keys, time.Now())
+
+		budgeted := 0
+		handler := withConfigContext(Config{ClientIPHeader: "Fly-Client-IP"}, auth.middleware(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if spendORSBudget(r.Context()) == nil {
+					budgeted++
+				}
+
+				w.WriteHeader(http.StatusOK)
+			}),
+		))
+
+		statuses := map[string][]int{}
+
+		for range anonymousRequestsPerMinute + 1 {
+			for _, ip := range []string{"192.0.2.1", "192.0.2.2"} {
+				request := httptest.NewRequest(http.MethodPost, "/plan", nil)
+				request.Header.Set("Fly-Client-IP", ip)
+
+				recorder := httptest.NewRecorder()
+				handler.ServeHTTP(recorder, request)
+
+				statuses[ip] = append(statuses[ip], recorder.Code)
+			}
+		}
+
+		for ip, codes := range statuses {
+			if codes[anonymousRequestsPerMinute-1] != http.StatusOK ||
+				codes[anonymousRequestsPerMinute] != http.StatusTooManyRequests {
+				t.Errorf("Expected %s to be limited after %d requests but got %v", ip, anonymousRequestsPerMinute, codes)
+			}
+		}
+
+		if budgeted != 2*anonymousRequestsPerMinute {
+			t.Errorf("Expected every allowed request to spend from an ORS budget but got %d", budgeted)
+		}
+
+		if client := auth.anonymousClient("192.0.2.1", time.Now()); client.usage.ORSCalls != anonymousRequestsPerMinute {
+			t.Errorf("Expected the ORS calls to be budgeted per IP but got %+v", client.usage)
+		}
+	}
+}
+
+func TestAPIKeyAuth_AnonymousClientsBounded(t *testing.T) {
+	auth, _ := newAPIKeyAuth(nil, time.Now())
+	now := time.Now()
+
+	first := auth.anonymousClient("192.0.2.1", now)
+	second := auth.anonymousClient("192.0.2.2", now)
+
+	for i := range maxAnonymousClients - 1 {
+		auth.anonymousClient(fmt.Sprintf("10.%d.%d.%d", i>>16, i>>8&0xff, i&0xff), now)
+
+		if i == 0 {
+			auth.anonymousClient("192.0.2.1", now)
+		}
+	}
+
+	if auth.anonymous.Len() != maxAnonymousClients || len(auth.anonymousByIP) != maxAnonymousClients {
+		t.Fatalf("Expected %d clients but got %d", maxAnonymousClients, auth.anonymous.Len())
+	}
+
+	if auth.anonymousClient("192.0.2.1", now) != first {
+		t.Error("Expected the recently seen IP to be kept")
+	}
+
+	if auth.anonymousClient("192.0.2.2", now) == second {
+		t.Error("Expected the least recently seen IP to be forgotten")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		value    string
+		expected string
+	}{
+		{name: "Peer address", expected: "192.0.2.10"},
+		{name: "Untrusted header", value: "198.51.100.1", expected: "192.0.2.10"},
+		{name: "Trusted header", header: "X-Forwarded-For", value: "198.51.100.1, 10.0.0.1", expected: "198.51.100.1"},
+		{name: "Empty trusted header", header: "Fly-Client-IP", expected: "192.0.2.10"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/plan/events", nil)
+			request.RemoteAddr = "192.0.2.10:51234"
+			request.Header.Set("X-Forwarded-For", tt.value)
+			request.Header.Set("Fly-Client-IP", tt.value)
+			request = request.WithContext(withConfig(request.Context(), Config{ClientIPHeader: tt.header}))
+
+			if ip := clientIP(request); ip != tt.expected {
+				t.Errorf("Expected %s but got %s", tt.expected, ip)
+			}
+		})
+	}
+}
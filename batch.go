@@ -0,0 +1,288 @@
+//nolint:package-comments,revive,mnd,exhaustruct,errchkjson
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	maxBatchJourneys  = 1000
+	batchPlanTimeout  = 10 * time.Second
+	ndjsonContentType = "application/x-ndjson"
+)
+
+type BatchPlanRequest struct {
+	Journeys []Journey `json:"journeys"`
+}
+
+type BatchPlanResult struct {
+	Index   int               `json:"index"`
+	Success bool              `json:"success"`
+	Plan    *JourneyPlan      `json:"plan,omitempty"`
+	Error   string            `json:"error,omitempty"`
+	Issues  []ValidationIssue `json:"issues,omitempty"`
+}
+
+type BatchPlanResponse struct {
+	Results   []BatchPlanResult `json:"results"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+}
+
+// batchJob is a journey of the batch, or the error that kept it from
+// being decoded.
+type batchJob struct {
+	journey Journey
+	err     error
+}
+
+func newBatchResult(index int, plan *JourneyPlan, err error) BatchPlanResult {
+	if err != nil {
+		return BatchPlanResult{
+			Index:  index,
+			Error:  err.Error(),
+			Issues: validationIssues(err),
+		}
+	}
+
+	return BatchPlanResult{Index: index, Success: true, Plan: plan}
+}
+
+// planBatch plans every job with at most workers journeys in flight and
+// passes the results to emit in job order as soon as each one and all
+// those before it are done. The workers share the upstream cache, so the
+// fleet, pricing and geozones are fetched once for the whole batch.
+func planBatch(
+	ctx context.Context,
+	client *http.Client,
+	cache *upstreamCache,
+	addressGeocoder geocoder,
+	quotes *quoteStore,
+	jobs []batchJob,
+	workers int,
+	emit func(BatchPlanResult),
+) {
+	results := make([]BatchPlanResult, len(jobs))
+	ready := make([]chan struct{}, len(jobs))
+
+	for i := range ready {
+		ready[i] = make(chan struct{})
+	}
+
+	queue := make(chan int)
+
+	var wg sync.WaitGroup
+
+	for range min(workers, len(jobs)) {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range queue {
+				results[i] = planBatchJob(ctx, client, cache, addressGeocoder, quotes, i, jobs[i])
+				close(ready[i])
+			}
+		}()
+	}
+
+	go func() {
+		defer close(queue)
+
+		for i := range jobs {
+			select {
+			case queue <- i:
+			case <-ctx.Done():
+				for ; i < len(jobs); i++ {
+					results[i] = newBatchResult(i, nil, ctx.Err())
+					close(ready[i])
+				}
+
+				return
+			}
+		}
+	}()
+
+	for i := range jobs {
+		<-ready[i]
+		emit(results[i])
+	}
+
+	wg.Wait()
+}
+
+func planBatchJob(
+	ctx context.Context,
+	client *http.Client,
+	cache *upstreamCache,
+	addressGeocoder geocoder,
+	quotes *quoteStore,
+	index int,
+	job batchJob,
+) BatchPlanResult {
+	if job.err != nil {
+		return newBatchResult(index, nil, job.err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, batchPlanTimeout)
+	defer cancel()
+
+	plan, err := planJourney(ctx, client, cache, addressGeocoder, job.journey)
+	if err == nil {
+		quotes.recordOrWarn(ctx, plan)
+	}
+
+	return newBatchResult(index, plan, err)
+}
+
+// decodeBatchJobs validates every journey of the batch on its own, so one
+// malformed journey fails its own result instead of the whole batch.
+func decodeBatchJobs(journeys []json.RawMessage) []batchJob {
+	jobs := make([]batchJob, len(journeys))
+
+	for i, data := range journeys {
+		var value any
+		if err := json.Unmarshal(data, &value); err != nil {
+			jobs[i].err = fmt.Errorf("[decodeBatchJobs] %w: %w", errInvalidRequestBody, err)
+
+			continue
+		}
+
+		if validationErr := apiSpec.validateComponent("Journey", value); validationErr != nil {
+			jobs[i].err = validationErr
+
+			continue
+		}
+
+		if err := json.Unmarshal(data, &jobs[i].journey); err != nil {
+			jobs[i].err = fmt.Errorf("[decodeBatchJobs] %w: %w", errInvalidRequestBody, err)
+		}
+	}
+
+	return jobs
+}
+
+func validateBatchSize(count int) *ValidationError {
+	switch {
+	case count == 0:
+		return newValidationError(nil, ValidationIssue{
+			Code:    validationCodeBatchEmpty,
+			Field:   "journeys",
+			Message: "Batch has no journeys",
+			Remedy:  "Add at least one journey",
+		})
+	case count > maxBatchJourneys:
+		return newValidationError(nil, ValidationIssue{
+			Code:  validationCodeBatchTooLarge,
+			Field: "journeys",
+			Message: fmt.Sprintf(
+				"Batch has %d journeys, the maximum is %d",
+				count,
+				maxBatchJourneys,
+			),
+			Remedy: "Split the batch into several requests",
+		})
+	}
+
+	return nil
+}
+
+func planJourneysBatchHandler(
+	client *http.Client,
+	cache *upstreamCache,
+	addressGeocoder geocoder,
+	quotes *quoteStore,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var requestData struct {
+			Journeys []json.RawMessage `json:"journeys"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
+			if respondBodyTooLarge(w, r, err) {
+				return
+			}
+
+			respondJSON(w, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error:   "Invalid JSON request body",
+			})
+
+			return
+		}
+
+		if validationErr := validateBatchSize(len(requestData.Journeys)); validationErr != nil {
+			respondJSON(w, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error:   validationErr.Error(),
+				Issues:  validationErr.Issues,
+			})
+
+			return
+		}
+
+		jobs := decodeBatchJobs(requestData.Journeys)
+
+		// A large batch takes longer than the server write timeout allows.
+		allowLongResponse(w)
+
+		if acceptsNDJSON(r.Header.Get("Accept")) {
+			streamBatch(w, r, client, cache, addressGeocoder, quotes, jobs)
+
+			return
+		}
+
+		response := BatchPlanResponse{Results: make([]BatchPlanResult, 0, len(jobs))}
+
+		planBatch(r.Context(), client, cache, addressGeocoder, quotes, jobs, int(configFromContext(r.Context()).BatchWorkers),
+			func(result BatchPlanResult) {
+				response.Results = append(response.Results, result)
+
+				if result.Success {
+					response.Succeeded++
+				} else {
+					response.Failed++
+				}
+			})
+
+		respondJSON(w, http.StatusOK, APIResponse{
+			Success: true,
+			Data:    response,
+		})
+	}
+}
+
+// acceptsNDJSON reports whether accept prefers NDJSON to JSON. JSON wins
+// ties, so wildcards and a missing header keep the plain response.
+func acceptsNDJSON(accept string) bool {
+	return acceptQuality(accept, ndjsonContentType) > acceptQuality(accept, "application/json")
+}
+
+// streamBatch writes one result per line as soon as it is in order, so
+// clients can process large batches incrementally.
+func streamBatch(
+	w http.ResponseWriter,
+	r *http.Request,
+	client *http.Client,
+	cache *upstreamCache,
+	addressGeocoder geocoder,
+	quotes *quoteStore,
+	jobs []batchJob,
+) {
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.WriteHeader(http.StatusOK)
+
+	controller := http.NewResponseController(w)
+	encoder := json.NewEncoder(w)
+
+	planBatch(r.Context(), client, cache, addressGeocoder, quotes, jobs, int(configFromContext(r.Context()).BatchWorkers),
+		func(result BatchPlanResult) {
+			_ = encoder.Encode(result)
+			_ = controller.Flush()
+		})
+}
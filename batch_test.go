@@ -0,0 +1,192 @@
+//nolint:package-comments,revive,mnd,exhaustruct
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// invalidBatchJobs returns jobs that all fail before any upstream call,
+// alternating between decode errors and journeys rejected by validation.
+func invalidBatchJobs(count int) []batchJob {
+	jobs := make([]batchJob, count)
+
+	for i := range jobs {
+		if i%2 == 0 {
+			jobs[i].err = fmt.Errorf("job %d: %w", i, errInvalidRequestBody)
+
+			continue
+		}
+
+		jobs[i].journey = Journey{Legs: []TripLeg{{PauseMinutes: -i}}}
+	}
+
+	return jobs
+}
+
+func TestPlanBatch_ResultsInOrder(t *testing.T) {
+	jobs := invalidBatchJobs(50)
+
+	var results []BatchPlanResult
+
+	planBatch(context.Background(), http.DefaultClient, nil, nil, nil, jobs, 4,
+		func(result BatchPlanResult) {
+			results = append(results, result)
+		})
+
+	if len(results) != len(jobs) {
+		t.Fatalf("Expected %d results but got %d", len(jobs), len(results))
+	}
+
+	for i, result := range results {
+		if result.Index != i {
+			t.Errorf("Expected result %d at position %d", result.Index, i)
+		}
+
+		if result.Success || len(result.Issues) == 0 {
+			t.Errorf("Expected result %d to fail with issues but got %+v", i, result)
+		}
+
+		if i%2 == 1 && result.Issues[0].Code != validationCodeMissingLocation {
+			t.Errorf("Expected result %d to fail validation but got %+v", i, result.Issues)
+		}
+	}
+}
+
+func TestPlanBatch_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	count := 0
+
+	planBatch(ctx, http.DefaultClient, nil, nil, nil, invalidBatchJobs(20), 2,
+		func(BatchPlanResult) {
+			count++
+		})
+
+	if count != 20 {
+		t.Errorf("Expected a result for every job but got %d", count)
+	}
+}
+
+func TestDecodeBatchJobs(t *testing.T) {
+	jobs := decodeBatchJobs([]json.RawMessage{
+		json.RawMessage(`{"legs": [{"startLocation": {"lat": 50.85, "lng": 4.35}, "endLocation": {"lat": 50.83, "lng": 4.37}}]}`),
+		json.RawMessage(`{"legs": [{"startLocation": {"lat": 95, "lng": 4.35}, "endLocation": {"lat": 50.83, "lng": 4.37}}]}`),
+		json.RawMessage(`"not a journey"`),
+	})
+
+	if jobs[0].err != nil || len(jobs[0].journey.Legs) != 1 {
+		t.Errorf("Expected the first journey to decode but got %+v", jobs[0])
+	}
+
+	var validationErr *ValidationError
+	if !errors.As(jobs[1].err, &validationErr) ||
+		validationErr.Issues[0].Code != validationCodeInvalidCoordinates {
+		t.Errorf("Expected invalid coordinates but got %v", jobs[1].err)
+	}
+
+	if jobs[2].err == nil {
+		t.Error("Expected a non-object journey to fail")
+	}
+}
+
+func TestPlanJourneysBatchHandler(t *testing.T) {
+	handler := withConfigContext(defaultConfig(), planJourneysBatchHandler(http.DefaultClient, nil, nil, nil))
+
+	tests := []struct {
+		name           string
+		body           string
+		accept         string
+		expectedStatus int
+		expectedLines  int
+	}{
+		{name: "Empty batch", body: `{"journeys": []}`, expectedStatus: http.StatusBadRequest},
+		{name: "Invalid JSON", body: `{`, expectedStatus: http.StatusBadRequest},
+		{
+			name:           "JSON",
+			body:           `{"journeys": [{"legs": []}, {"legs": [{"pauseMinutes": -1}]}]}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "NDJSON",
+			body:           `{"journeys": [{"legs": []}, {"legs": []}, {"legs": []}]}`,
+			accept:         ndjsonContentType,
+			expectedStatus: http.StatusOK,
+			expectedLines:  3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(
+				http.MethodPost,
+				"/api/v1/plan-journeys:batch",
+				strings.NewReader(tt.body),
+			)
+			request.Header.Set("Accept", tt.accept)
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d but got %d", tt.expectedStatus, recorder.Code)
+			}
+
+			if tt.expectedLines == 0 {
+				return
+			}
+
+			if contentType := recorder.Header().Get("Content-Type"); contentType != ndjsonContentType {
+				t.Errorf("Expected content type %s but got %s", ndjsonContentType, contentType)
+			}
+
+			scanner := bufio.NewScanner(recorder.Body)
+			lines := 0
+
+			for scanner.Scan() {
+				var result BatchPlanResult
+				if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+					t.Fatalf("Line %d is not a result: %v", lines, err)
+				}
+
+				if result.Index != lines {
+					t.Errorf("Expected index %d but got %d", lines, result.Index)
+				}
+
+				lines++
+			}
+
+			if lines != tt.expectedLines {
+				t.Errorf("Expected %d lines but got %d", tt.expectedLines, lines)
+			}
+		})
+	}
+}
+
+func TestAcceptsNDJSON(t *testing.T) {
+	tests := []struct {
+		accept   string
+		expected bool
+	}{
+		{accept: "", expected: false},
+		{accept: "*/*", expected: false},
+		{accept: ndjsonContentType, expected: true},
+		{accept: ndjsonContentType + ";q=0", expected: false},
+		{accept: "application/json, " + ndjsonContentType + ";q=0.5", expected: false},
+		{accept: "application/json;q=0.5, " + ndjsonContentType, expected: true},
+	}
+
+	for _, tt := range tests {
+		if actual := acceptsNDJSON(tt.accept); actual != tt.expected {
+			t.Errorf("%q: expected %v but got %v", tt.accept, tt.expected, actual)
+		}
+	}
+}
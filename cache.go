@@ -0,0 +1,186 @@
+//nolint:package-comments,revive,mnd,exhaustruct
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	vehiclesCacheTTL = 30 * time.Second
+	pricingCacheTTL  = time.Hour
+	geoZoneCacheTTL  = time.Hour
+	// cacheFetchTimeout bounds a shared fetch, which no longer ends with
+	// the request that started it.
+	cacheFetchTimeout = 15 * time.Second
+)
+
+type cacheEntry[T any] struct {
+	value     T
+	expiresAt time.Time
+}
+
+type cacheCall[T any] struct {
+	done  chan struct{}
+	value T
+	err   error
+}
+
+// ttlCache memoizes upstream responses per key. Concurrent misses for the
+// same key share a single fetch, which runs detached from the caller that
+// started it: a caller that gives up does not fail the others, and the
+// result is still cached. Lookups are counted under name.
+type ttlCache[T any] struct {
+	name    string
+	mu      sync.Mutex
+	ttl     time.Duration
+	now     func() time.Time
+	entries map[string]cacheEntry[T]
+	calls   map[string]*cacheCall[T]
+}
+
+func newTTLCache[T any](name string, ttl time.Duration) *ttlCache[T] {
+	return &ttlCache[T]{
+		name:    name,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: map[string]cacheEntry[T]{},
+		calls:   map[string]*cacheCall[T]{},
+	}
+}
+
+func (c *ttlCache[T]) get(
+	ctx context.Context,
+	key string,
+	fetch func(context.Context) (T, error),
+) (T, error) {
+	c.mu.Lock()
+
+	if entry, ok := c.entries[key]; ok && c.now().Before(entry.expiresAt) {
+		c.mu.Unlock()
+		metrics.cacheLookups.inc(c.name, "hit")
+
+		return entry.value, nil
+	}
+
+	call, shared := c.calls[key]
+	if !shared {
+		call = &cacheCall[T]{done: make(chan struct{})}
+		c.calls[key] = call
+
+		go c.fill(context.WithoutCancel(ctx), key, call, fetch)
+	}
+	c.mu.Unlock()
+
+	if shared {
+		metrics.cacheLookups.inc(c.name, "shared")
+	} else {
+		metrics.cacheLookups.inc(c.name, "miss")
+	}
+
+	select {
+	case <-call.done:
+		return call.value, call.err
+	case <-ctx.Done():
+		var zero T
+
+		return zero, ctx.Err()
+	}
+}
+
+// fill runs the shared fetch of key and caches its result.
+func (c *ttlCache[T]) fill(
+	ctx context.Context,
+	key string,
+	call *cacheCall[T],
+	fetch func(context.Context) (T, error),
+) {
+	ctx, cancel := context.WithTimeout(ctx, cacheFetchTimeout)
+	defer cancel()
+
+	call.value, call.err = fetch(ctx)
+
+	c.mu.Lock()
+	delete(c.calls, key)
+
+	if call.err == nil {
+		c.entries[key] = cacheEntry[T]{
+			value:     call.value,
+			expiresAt: c.now().Add(c.ttl),
+		}
+	}
+	c.mu.Unlock()
+
+	close(call.done)
+}
+
+// upstreamCache holds the Poppy data shared across requests. A nil
+// *upstreamCache is valid and fetches straight from upstream.
+type upstreamCache struct {
+	vehicles *ttlCache[[]Vehicle]
+	pricing  *ttlCache[*PricingResponse]
+	geozones *ttlCache[*zoneIndex]
+}
+
+func newUpstreamCache() *upstreamCache {
+	return &upstreamCache{
+		vehicles: newTTLCache[[]Vehicle]("vehicles", vehiclesCacheTTL),
+		pricing:  newTTLCache[*PricingResponse]("pricing", pricingCacheTTL),
+		geozones: newTTLCache[*zoneIndex]("geozones", geoZoneCacheTTL),
+	}
+}
+
+func (c *upstreamCache) fetchVehicles(
+	ctx context.Context,
+	client *http.Client,
+) ([]Vehicle, error) {
+	if c == nil {
+		return fetchVehicles(ctx, client)
+	}
+
+	return c.vehicles.get(ctx, brusselsUUID, func(ctx context.Context) ([]Vehicle, error) {
+		return fetchVehicles(ctx, client)
+	})
+}
+
+func (c *upstreamCache) fetchPricing(
+	ctx context.Context,
+	client *http.Client,
+	modelType vehicleModelType,
+	tier string,
+) (*PricingResponse, error) {
+	if c == nil {
+		return fetchPricing(ctx, client, modelType, tier)
+	}
+
+	key := string(modelType) + "/" + tier
+
+	return c.pricing.get(ctx, key, func(ctx context.Context) (*PricingResponse, error) {
+		return fetchPricing(ctx, client, modelType, tier)
+	})
+}
+
+// fetchZoneIndex caches compiled geozones per model type: Poppy serves them
+// per vehicle, but every vehicle of a type shares the same city-wide zones.
+func (c *upstreamCache) fetchZoneIndex(
+	ctx context.Context,
+	client *http.Client,
+	vehicle Vehicle,
+) (*zoneIndex, error) {
+	fetch := func(ctx context.Context) (*zoneIndex, error) {
+		geozone, err := fetchGeoZone(ctx, client, vehicle.UUID)
+		if err != nil {
+			return nil, err
+		}
+
+		return newZoneIndex(geozone), nil
+	}
+
+	if c == nil {
+		return fetch(ctx)
+	}
+
+	return c.geozones.get(ctx, string(vehicle.Model.Type), fetch)
+}
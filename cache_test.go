@@ -0,0 +1,137 @@
+//nolint:package-comments,revive,mnd,exhaustruct,err113
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTTLCache(t *testing.T) {
+	now := time.Date(2025, 9, 18, 12, 0, 0, 0, time.UTC)
+
+	cache := newTTLCache[int]("test", time.Minute)
+	cache.now = func() time.Time { return now }
+
+	var fetches int
+
+	fetch := func(context.Context) (int, error) {
+		fetches++
+
+		return fetches, nil
+	}
+
+	ctx := context.Background()
+
+	if value, _ := cache.get(ctx, "key", fetch); value != 1 {
+		t.Errorf("Expected first fetch to return 1 but got %d", value)
+	}
+
+	if value, _ := cache.get(ctx, "key", fetch); value != 1 {
+		t.Errorf("Expected cached value 1 but got %d", value)
+	}
+
+	now = now.Add(2 * time.Minute)
+
+	if value, _ := cache.get(ctx, "key", fetch); value != 2 {
+		t.Errorf("Expected expired entry to be refetched but got %d", value)
+	}
+
+	_, err := cache.get(ctx, "failing", func(context.Context) (int, error) {
+		return 0, errors.New("upstream down")
+	})
+	if err == nil {
+		t.Error("Expected fetch error to be returned")
+	}
+
+	if _, ok := cache.entries["failing"]; ok {
+		t.Error("Expected failed fetch not to be cached")
+	}
+}
+
+func TestTTLCache_ConcurrentMissesShareFetch(t *testing.T) {
+	cache := newTTLCache[int]("test", time.Minute)
+
+	var fetches atomic.Int32
+
+	release := make(chan struct{})
+	fetch := func(context.Context) (int, error) {
+		fetches.Add(1)
+		<-release
+
+		return 42, nil
+	}
+
+	var wg sync.WaitGroup
+
+	for range 10 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if value, _ := cache.get(context.Background(), "key", fetch); value != 42 {
+				t.Errorf("Expected 42 but got %d", value)
+			}
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if fetches.Load() != 1 {
+		t.Errorf("Expected a single upstream fetch but got %d", fetches.Load())
+	}
+}
+
+func TestTTLCache_CancelledCallerDoesNotFailOthers(t *testing.T) {
+	cache := newTTLCache[int]("test", time.Minute)
+
+	release := make(chan struct{})
+	fetch := func(ctx context.Context) (int, error) {
+		select {
+		case <-release:
+			return 42, nil
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+
+	first, cancel := context.WithCancel(context.Background())
+
+	firstErr := make(chan error, 1)
+
+	go func() {
+		_, err := cache.get(first, "key", fetch)
+		firstErr <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+
+	second := make(chan int, 1)
+
+	go func() {
+		value, _ := cache.get(context.Background(), "key", fetch)
+		second <- value
+	}()
+
+	cancel()
+
+	if err := <-firstErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the cancelled caller to get its own error but got %v", err)
+	}
+
+	close(release)
+
+	if value := <-second; value != 42 {
+		t.Errorf("Expected the other caller to get the shared value but got %d", value)
+	}
+
+	if value, err := cache.get(context.Background(), "key", fetch); value != 42 || err != nil {
+		t.Errorf("Expected the value to be cached but got %d, %v", value, err)
+	}
+}
@@ -0,0 +1,452 @@
+//nolint:package-comments,revive,mnd,exhaustruct,err113
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultFreeBookingMinutes = 15
+
+// Config is the runtime configuration. Each setting is read, from lowest
+// to highest precedence, from its default, the YAML config file, the
+// environment and the command line.
+type Config struct {
+	Port             string
+	DataDir          string
+	PoppyURL         string
+	ORSDirectionsURL string
+	ORSMatrixURL     string
+	ORSAPIKey        string
+	Geocoder         string
+	NominatimURL     string
+	// GeocoderUserAgent identifies the deployment to the geocoder, as the
+	// public Nominatim instance asks.
+	GeocoderUserAgent string
+	APIKeys           string
+	APIKeysFile       string
+	ClientIPHeader    string
+	// WebhookAllowPrivate lets watches call loopback and private addresses,
+	// for local receivers in development and tests.
+	WebhookAllowPrivate bool
+	ORSTimeout          time.Duration
+	UpstreamTimeout     time.Duration
+	ShutdownTimeout     time.Duration
+	MaxBodyBytes        int64
+	BatchWorkers        int64
+	WalkingSpeedKmh     float64
+	DrivingSpeedKmh     float64
+	FreeBookingMinutes  float64
+	LogLevel            string
+	LogFormat           string
+
+	// sources records where each setting came from, by file key.
+	sources map[string]string
+}
+
+func defaultConfig() Config {
+	return Config{
+		Port:               "8080",
+		DataDir:            defaultDataDir,
+		PoppyURL:           "https://poppy.red/api/v3",
+		ORSDirectionsURL:   "https://api.openrouteservice.org/v2/directions",
+		ORSMatrixURL:       "https://api.openrouteservice.org/v2/matrix",
+		NominatimURL:       nominatimDefaultURL,
+		GeocoderUserAgent:  geocoderUserAgent,
+		ORSTimeout:         5 * time.Second,
+		UpstreamTimeout:    10 * time.Second,
+		ShutdownTimeout:    25 * time.Second,
+		MaxBodyBytes:       1 << 20,
+		BatchWorkers:       8,
+		WalkingSpeedKmh:    5,
+		DrivingSpeedKmh:    25,
+		FreeBookingMinutes: defaultFreeBookingMinutes,
+		LogLevel:           "info",
+		LogFormat:          "text",
+	}
+}
+
+// configSetting ties a Config field to its file key, environment variable
+// and flag. Every source is parsed from text by set. The value of a secret
+// setting is never logged.
+type configSetting struct {
+	key    string
+	env    string
+	flag   string
+	usage  string
+	secret bool
+	set    func(*Config, string) error
+	get    func(Config) string
+}
+
+func stringSetting(key, env, flagName, usage string, field func(*Config) *string) configSetting {
+	return configSetting{
+		key: key, env: env, flag: flagName, usage: usage,
+		set: func(c *Config, value string) error {
+			*field(c) = strings.TrimSpace(value)
+
+			return nil
+		},
+		get: func(c Config) string { return *field(&c) },
+	}
+}
+
+func secretSetting(key, env, flagName, usage string, field func(*Config) *string) configSetting {
+	setting := stringSetting(key, env, flagName, usage, field)
+	setting.secret = true
+
+	return setting
+}
+
+// display is the value of setting as it may be logged.
+func (s configSetting) display(c Config) string {
+	value := s.get(c)
+	if s.secret && value != "" {
+		return "********"
+	}
+
+	return value
+}
+
+func durationSetting(key, env, flagName, usage string, field func(*Config) *time.Duration) configSetting {
+	return configSetting{
+		key: key, env: env, flag: flagName, usage: usage,
+		set: func(c *Config, value string) error {
+			parsed, err := time.ParseDuration(strings.TrimSpace(value))
+			if err != nil {
+				return err
+			}
+
+			*field(c) = parsed
+
+			return nil
+		},
+		get: func(c Config) string { return field(&c).String() },
+	}
+}
+
+func intSetting(key, env, flagName, usage string, field func(*Config) *int64) configSetting {
+	return configSetting{
+		key: key, env: env, flag: flagName, usage: usage,
+		set: func(c *Config, value string) error {
+			parsed, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			if err != nil {
+				return err
+			}
+
+			*field(c) = parsed
+
+			return nil
+		},
+		get: func(c Config) string { return strconv.FormatInt(*field(&c), 10) },
+	}
+}
+
+func boolSetting(key, env, flagName, usage string, field func(*Config) *bool) configSetting {
+	return configSetting{
+		key: key, env: env, flag: flagName, usage: usage,
+		set: func(c *Config, value string) error {
+			parsed, err := strconv.ParseBool(strings.TrimSpace(value))
+			if err != nil {
+				return err
+			}
+
+			*field(c) = parsed
+
+			return nil
+		},
+		get: func(c Config) string { return strconv.FormatBool(*field(&c)) },
+	}
+}
+
+func floatSetting(key, env, flagName, usage string, field func(*Config) *float64) configSetting {
+	return configSetting{
+		key: key, env: env, flag: flagName, usage: usage,
+		set: func(c *Config, value string) error {
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				return err
+			}
+
+			*field(c) = parsed
+
+			return nil
+		},
+		get: func(c Config) string { return formatFloat(*field(&c)) },
+	}
+}
+
+var configSettings = []configSetting{
+	stringSetting("port", "PORT", "port", "HTTP listen port",
+		func(c *Config) *string { return &c.Port }),
+	stringSetting("dataDir", "DATA_DIR", "data-dir", "directory of the JSON stores",
+		func(c *Config) *string { return &c.DataDir }),
+	stringSetting("poppyURL", "POPPY_API_URL", "poppy-url", "Poppy API base URL",
+		func(c *Config) *string { return &c.PoppyURL }),
+	stringSetting("orsDirectionsURL", "ORS_DIRECTIONS_URL", "ors-directions-url", "OpenRouteService directions URL",
+		func(c *Config) *string { return &c.ORSDirectionsURL }),
+	stringSetting("orsMatrixURL", "ORS_MATRIX_URL", "ors-matrix-url", "OpenRouteService matrix URL",
+		func(c *Config) *string { return &c.ORSMatrixURL }),
+	secretSetting("orsAPIKey", "ORS_API_KEY", "ors-api-key", "OpenRouteService API key, routing falls back without it",
+		func(c *Config) *string { return &c.ORSAPIKey }),
+	stringSetting("geocoder", "GEOCODER", "geocoder", "ors or nominatim, by default ORS when orsAPIKey is set",
+		func(c *Config) *string { return &c.Geocoder }),
+	stringSetting("nominatimURL", "NOMINATIM_URL", "nominatim-url", "Nominatim base URL",
+		func(c *Config) *string { return &c.NominatimURL }),
+	stringSetting("geocoderUserAgent", "GEOCODER_USER_AGENT", "geocoder-user-agent", "User-Agent of geocoder requests, name your deployment and a contact",
+		func(c *Config) *string { return &c.GeocoderUserAgent }),
+	secretSetting("apiKeys", "API_KEYS", "api-keys", "comma-separated name:key API keys",
+		func(c *Config) *string { return &c.APIKeys }),
+	stringSetting("apiKeysFile", "API_KEYS_FILE", "api-keys-file", "JSON file of API keys with per-key limits",
+		func(c *Config) *string { return &c.APIKeysFile }),
+	stringSetting("clientIPHeader", "CLIENT_IP_HEADER", "client-ip-header", "proxy header with the caller IP, such as Fly-Client-IP",
+		func(c *Config) *string { return &c.ClientIPHeader }),
+	boolSetting("webhookAllowPrivate", "WEBHOOK_ALLOW_PRIVATE", "webhook-allow-private", "let watch webhooks call loopback and private addresses",
+		func(c *Config) *bool { return &c.WebhookAllowPrivate }),
+	durationSetting("orsTimeout", "ORS_TIMEOUT", "ors-timeout", "timeout of a single ORS call",
+		func(c *Config) *time.Duration { return &c.ORSTimeout }),
+	durationSetting("upstreamTimeout", "UPSTREAM_TIMEOUT", "upstream-timeout", "timeout of the upstream HTTP client",
+		func(c *Config) *time.Duration { return &c.UpstreamTimeout }),
+	durationSetting("shutdownTimeout", "SHUTDOWN_TIMEOUT", "shutdown-timeout", "time to drain requests and workers on SIGTERM",
+		func(c *Config) *time.Duration { return &c.ShutdownTimeout }),
+	intSetting("maxBodyBytes", "MAX_BODY_BYTES", "max-body-bytes", "largest accepted request body",
+		func(c *Config) *int64 { return &c.MaxBodyBytes }),
+	intSetting("batchWorkers", "BATCH_WORKERS", "batch-workers", "journeys of a batch planned at once",
+		func(c *Config) *int64 { return &c.BatchWorkers }),
+	floatSetting("walkingSpeedKmh", "WALKING_SPEED_KMH", "walking-speed", "walking speed in km/h without ORS",
+		func(c *Config) *float64 { return &c.WalkingSpeedKmh }),
+	floatSetting("drivingSpeedKmh", "DRIVING_SPEED_KMH", "driving-speed", "driving speed in km/h without ORS",
+		func(c *Config) *float64 { return &c.DrivingSpeedKmh }),
+	floatSetting("freeBookingMinutes", "FREE_BOOKING_MINUTES", "free-booking-minutes", "booking minutes before the booking fee applies",
+		func(c *Config) *float64 { return &c.FreeBookingMinutes }),
+	stringSetting("logLevel", "LOG_LEVEL", "log-level", "debug, info, warn or error",
+		func(c *Config) *string { return &c.LogLevel }),
+	stringSetting("logFormat", "LOG_FORMAT", "log-format", "text or json",
+		func(c *Config) *string { return &c.LogFormat }),
+}
+
+// loadConfig builds the configuration from args and getenv. The config
+// file is named by the -config flag or CONFIG_FILE. -h prints the flags to
+// usage.
+func loadConfig(args []string, getenv func(string) string, usage io.Writer) (Config, error) {
+	config := defaultConfig()
+	config.sources = map[string]string{}
+
+	flags := flag.NewFlagSet("poppy", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	configFile := flags.String("config", getenv("CONFIG_FILE"), "YAML config file")
+
+	flagValues := map[string]*string{}
+	for _, setting := range configSettings {
+		flagValues[setting.flag] = flags.String(
+			setting.flag,
+			"",
+			fmt.Sprintf("%s (%s, default %q)", setting.usage, setting.env, setting.display(config)),
+		)
+	}
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			flags.SetOutput(usage)
+			flags.PrintDefaults()
+		}
+
+		return Config{}, fmt.Errorf("[loadConfig] %w", err)
+	}
+
+	if *configFile != "" {
+		if err := config.applyFile(*configFile); err != nil {
+			return Config{}, err
+		}
+	}
+
+	for _, setting := range configSettings {
+		if value := getenv(setting.env); value != "" {
+			if err := config.apply(setting, value, "env "+setting.env); err != nil {
+				return Config{}, err
+			}
+		}
+	}
+
+	var flagErr error
+
+	flags.Visit(func(visited *flag.Flag) {
+		for _, setting := range configSettings {
+			if setting.flag == visited.Name && flagErr == nil {
+				flagErr = config.apply(setting, *flagValues[setting.flag], "flag -"+setting.flag)
+			}
+		}
+	})
+
+	if flagErr != nil {
+		return Config{}, flagErr
+	}
+
+	if err := config.validate(); err != nil {
+		return Config{}, err
+	}
+
+	return config, nil
+}
+
+func (c *Config) apply(setting configSetting, value, source string) error {
+	if err := setting.set(c, value); err != nil {
+		return fmt.Errorf("[loadConfig] invalid %s from %s: %w", setting.key, source, err)
+	}
+
+	c.sources[setting.key] = source
+
+	return nil
+}
+
+// applyFile reads the YAML file at path. Unknown keys are rejected so a
+// typo does not silently fall back to a default.
+func (c *Config) applyFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("[loadConfig] could not read config file: %w", err)
+	}
+
+	values := map[string]any{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("[loadConfig] could not parse config file %s: %w", path, err)
+	}
+
+	for key, value := range values {
+		index := slices.IndexFunc(configSettings, func(setting configSetting) bool {
+			return setting.key == key
+		})
+		if index < 0 {
+			return fmt.Errorf("[loadConfig] unknown setting %q in %s", key, path)
+		}
+
+		if err := c.apply(configSettings[index], fmt.Sprint(value), "file "+path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateURL(name, value string) error {
+	parsed, err := url.Parse(value)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("%s must be an absolute http(s) URL, got %q", name, value)
+	}
+
+	return nil
+}
+
+func (c Config) validate() error {
+	var errs []error
+
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("port must be between 1 and 65535, got %q", c.Port))
+	}
+
+	if c.DataDir == "" {
+		errs = append(errs, errors.New("dataDir must not be empty"))
+	}
+
+	for _, check := range []struct{ name, value string }{
+		{"poppyURL", c.PoppyURL},
+		{"orsDirectionsURL", c.ORSDirectionsURL},
+		{"orsMatrixURL", c.ORSMatrixURL},
+		{"nominatimURL", c.NominatimURL},
+	} {
+		if err := validateURL(check.name, check.value); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if !slices.Contains([]string{"", "ors", "nominatim"}, strings.ToLower(c.Geocoder)) {
+		errs = append(errs, fmt.Errorf("geocoder must be ors or nominatim, got %q", c.Geocoder))
+	}
+
+	if c.ORSTimeout <= 0 || c.UpstreamTimeout <= 0 || c.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("orsTimeout, upstreamTimeout and shutdownTimeout must be positive"))
+	}
+
+	if c.MaxBodyBytes <= 0 {
+		errs = append(errs, errors.New("maxBodyBytes must be positive"))
+	}
+
+	if c.BatchWorkers <= 0 {
+		errs = append(errs, errors.New("batchWorkers must be positive"))
+	}
+
+	if c.WalkingSpeedKmh <= 0 || c.DrivingSpeedKmh <= 0 {
+		errs = append(errs, errors.New("walkingSpeedKmh and drivingSpeedKmh must be positive"))
+	}
+
+	if c.FreeBookingMinutes < 0 {
+		errs = append(errs, errors.New("freeBookingMinutes must not be negative"))
+	}
+
+	if _, err := newLogger(io.Discard, c.LogLevel, c.LogFormat); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("[loadConfig] invalid configuration: %w", err)
+	}
+
+	return nil
+}
+
+// logAttrs describes the effective configuration for the startup log, one
+// attribute per setting with where it came from. Secrets are masked.
+func (c Config) logAttrs() []any {
+	attrs := make([]any, 0, len(configSettings))
+
+	for _, setting := range configSettings {
+		source := c.sources[setting.key]
+		if source == "" {
+			source = "default"
+		}
+
+		attrs = append(attrs, slog.String(setting.key, setting.display(c)+" ("+source+")"))
+	}
+
+	return attrs
+}
+
+type configKey struct{}
+
+// withConfig makes config available to the planning code reached through
+// ctx, the same way the ORS budget and progress reporter are.
+func withConfig(ctx context.Context, config Config) context.Context {
+	return context.WithValue(ctx, configKey{}, config)
+}
+
+// configFromContext returns the configuration of ctx. It panics when there
+// is none: falling back to the defaults would silently ignore the settings,
+// e.g. call the production API from a mocked deployment.
+func configFromContext(ctx context.Context) Config {
+	config, ok := ctx.Value(configKey{}).(Config)
+	if !ok {
+		panic("[configFromContext] no config in context; wrap it with withConfig")
+	}
+
+	return config
+}
+
+func withConfigContext(config Config, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(withConfig(r.Context(), config)))
+	})
+}
@@ -0,0 +1,182 @@
+//nolint:package-comments,revive,mnd,exhaustruct
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMain keeps the test output to the test results: plans and requests
+// log through the default logger.
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+
+	os.Exit(m.Run())
+}
+
+func writeTestConfigFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "poppy.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Expected the config file to be written but got %v", err)
+	}
+
+	return path
+}
+
+func TestLoadConfig_Precedence(t *testing.T) {
+	path := writeTestConfigFile(t, `
+port: 9000
+poppyURL: https://staging.poppy.example/api/v3
+orsTimeout: 2s
+walkingSpeedKmh: 4.5
+freeBookingMinutes: 10
+`)
+
+	env := map[string]string{
+		"CONFIG_FILE":          path,
+		"PORT":                 "9100",
+		"FREE_BOOKING_MINUTES": "0",
+	}
+
+	config, err := loadConfig([]string{"-port", "9200"}, func(key string) string { return env[key] }, io.Discard)
+	if err != nil {
+		t.Fatalf("Expected a valid configuration but got %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		value    any
+		expected any
+		source   string
+	}{
+		{name: "port", value: config.Port, expected: "9200", source: "flag -port"},
+		{name: "poppyURL", value: config.PoppyURL, expected: "https://staging.poppy.example/api/v3", source: "file " + path},
+		{name: "orsTimeout", value: config.ORSTimeout, expected: 2 * time.Second, source: "file " + path},
+		{name: "walkingSpeedKmh", value: config.WalkingSpeedKmh, expected: 4.5, source: "file " + path},
+		{name: "freeBookingMinutes", value: config.FreeBookingMinutes, expected: 0.0, source: "env FREE_BOOKING_MINUTES"},
+		{name: "drivingSpeedKmh", value: config.DrivingSpeedKmh, expected: 25.0, source: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.value != tt.expected {
+				t.Errorf("Expected %v but got %v", tt.expected, tt.value)
+			}
+
+			if source := config.sources[tt.name]; source != tt.source {
+				t.Errorf("Expected the source %q but got %q", tt.source, source)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_Invalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		env      map[string]string
+		file     string
+		contains string
+	}{
+		{name: "Unknown flag", args: []string{"-colour"}, contains: "colour"},
+		{name: "Bad duration", env: map[string]string{"ORS_TIMEOUT": "5"}, contains: "orsTimeout"},
+		{name: "Bad port", args: []string{"-port", "http"}, contains: "port"},
+		{name: "Relative URL", env: map[string]string{"POPPY_API_URL": "/api"}, contains: "poppyURL"},
+		{name: "Zero speed", args: []string{"-driving-speed", "0"}, contains: "drivingSpeedKmh"},
+		{name: "Zero batch workers", env: map[string]string{"BATCH_WORKERS": "0"}, contains: "batchWorkers"},
+		{name: "Bad log format", env: map[string]string{"LOG_FORMAT": "xml"}, contains: "log format"},
+		{name: "Unknown geocoder", env: map[string]string{"GEOCODER": "google"}, contains: "geocoder"},
+		{name: "Help", args: []string{"-h"}, contains: "help requested"},
+		{name: "Unknown file key", file: "prot: 8080\n", contains: `unknown setting "prot"`},
+		{name: "Missing file", env: map[string]string{"CONFIG_FILE": "/nonexistent/poppy.yaml"}, contains: "could not read"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := map[string]string{}
+			for key, value := range tt.env {
+				env[key] = value
+			}
+
+			if tt.file != "" {
+				env["CONFIG_FILE"] = writeTestConfigFile(t, tt.file)
+			}
+
+			_, err := loadConfig(tt.args, func(key string) string { return env[key] }, io.Discard)
+			if err == nil || !strings.Contains(err.Error(), tt.contains) {
+				t.Errorf("Expected an error mentioning %q but got %v", tt.contains, err)
+			}
+		})
+	}
+}
+
+func TestConfig_LogAttrs(t *testing.T) {
+	env := map[string]string{"ORS_API_KEY": "ors-secret"}
+
+	config, _ := loadConfig([]string{"-log-level", "debug"}, func(key string) string { return env[key] }, io.Discard)
+
+	buffer := captureLogs(t)
+	slog.Info("configuration", config.logAttrs()...)
+
+	line := decodeLogLines(t, buffer)[0]
+
+	tests := []struct {
+		key      string
+		expected string
+	}{
+		{key: "logLevel", expected: "debug (flag -log-level)"},
+		{key: "orsTimeout", expected: "5s (default)"},
+		{key: "orsAPIKey", expected: "******** (env ORS_API_KEY)"},
+		{key: "apiKeys", expected: " (default)"},
+	}
+
+	for _, tt := range tests {
+		if line[tt.key] != tt.expected {
+			t.Errorf("Expected %s to be logged as %q but got %v", tt.key, tt.expected, line[tt.key])
+		}
+	}
+
+	if strings.Contains(buffer.String(), "ors-secret") {
+		t.Error("Expected the ORS API key to be masked")
+	}
+}
+
+func TestConfigFromContext_MockUpstream(t *testing.T) {
+	var requested string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.Path
+		_, _ = w.Write([]byte(`[{"uuid":"v1","model":{"type":"car"}}]`))
+	}))
+	defer server.Close()
+
+	config := defaultConfig()
+	config.PoppyURL = server.URL + "/api/v3"
+
+	vehicles, err := fetchVehicles(withConfig(context.Background(), config), server.Client())
+	if err != nil || len(vehicles) != 1 {
+		t.Fatalf("Expected one vehicle from the mock upstream but got %v, %v", vehicles, err)
+	}
+
+	if requested != "/api/v3/cities/"+brusselsUUID+"/vehicles" {
+		t.Errorf("Expected the configured Poppy URL to be used but got %s", requested)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a panic without a configuration in the context")
+		}
+	}()
+
+	configFromContext(context.Background())
+}
@@ -0,0 +1,440 @@
+//nolint:package-comments,revive,mnd,exhaustruct,errchkjson
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+type planExportFormat string
+
+const (
+	planExportJSON    planExportFormat = ""
+	planExportICS     planExportFormat = "ics"
+	planExportGeoJSON planExportFormat = "geojson"
+	planExportGPX     planExportFormat = "gpx"
+	planExportCSV     planExportFormat = "csv"
+	planExportHTML    planExportFormat = "html"
+)
+
+const (
+	gpxContentType     = "application/gpx+xml"
+	iCalendarMediaType = "text/calendar"
+)
+
+// planExportMediaTypes maps Accept header media types to export formats.
+var planExportMediaTypes = []struct {
+	mediaType string
+	format    planExportFormat
+}{
+	{iCalendarMediaType, planExportICS},
+	{geoJSONContentType, planExportGeoJSON},
+	{gpxContentType, planExportGPX},
+	{csvContentType, planExportCSV},
+	{"text/html", planExportHTML},
+}
+
+// requestedPlanExport picks the export format of a plan response: the
+// format query parameter wins over the Accept header, and JSON is the
+// default. Unknown formats fall back to JSON. Of the Accept header, the
+// supported type with the highest quality wins, JSON on a tie.
+func requestedPlanExport(r *http.Request) planExportFormat {
+	if format := planExportFormat(r.URL.Query().Get("format")); format != "" {
+		for _, known := range planExportMediaTypes {
+			if known.format == format {
+				return format
+			}
+		}
+
+		return planExportJSON
+	}
+
+	accept := r.Header.Get("Accept")
+	format, quality := planExportJSON, acceptQuality(accept, "application/json")
+
+	for _, known := range planExportMediaTypes {
+		if q := acceptQuality(accept, known.mediaType); q > quality {
+			format, quality = known.format, q
+		}
+	}
+
+	return format
+}
+
+// acceptQuality is the quality an Accept header gives mediaType, taken
+// from the most specific media range that matches it: the type itself,
+// then its type/*, then */*. Without a header every type has quality 1.
+func acceptQuality(accept, mediaType string) float64 {
+	if strings.TrimSpace(accept) == "" {
+		return 1
+	}
+
+	quality, specificity := 0.0, -1
+
+	for _, part := range strings.Split(accept, ",") {
+		mediaRange, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+
+		var match int
+
+		switch {
+		case mediaRange == mediaType:
+			match = 2
+		case mediaRange == "*/*":
+			match = 0
+		case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*")):
+			match = 1
+		default:
+			continue
+		}
+
+		if match <= specificity {
+			continue
+		}
+
+		q := 1.0
+
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+
+		quality, specificity = q, match
+	}
+
+	return quality
+}
+
+// respondPlanExport writes plan in the requested export format. It returns
+// false when JSON was asked for, leaving the response to the caller.
+func respondPlanExport(w http.ResponseWriter, r *http.Request, plan *JourneyPlan) bool {
+	format := requestedPlanExport(r)
+
+	w.Header().Add("Vary", "Accept")
+
+	attach := func(contentType string, extension string) {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", `attachment; filename="journey.`+extension+`"`)
+		w.WriteHeader(http.StatusOK)
+	}
+
+	switch format {
+	case planExportJSON:
+		return false
+	case planExportICS:
+		respondICalendar(w, plan)
+	case planExportGeoJSON:
+		attach(geoJSONContentType, "geojson")
+
+		_ = json.NewEncoder(w).Encode(planFeatureCollection(plan))
+	case planExportGPX:
+		attach(gpxContentType, "gpx")
+
+		_ = writePlanGPX(w, plan)
+	case planExportCSV:
+		attach(csvContentType+"; charset=utf-8", "csv")
+
+		_ = writePlanCSV(w, plan)
+	case planExportHTML:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+
+		legs, _ := planLegCosts(plan)
+
+		_ = PlanSummary(plan, legs, dayCapDiscount(plan)).Render(r.Context(), w)
+	}
+
+	return true
+}
+
+// planLegCost is a leg of a plan with its route and the share of the
+// travel and pause cost it accounts for. Unlock, booking and the day cap
+// apply to the whole journey.
+type planLegCost struct {
+	Index            int
+	Leg              TripLeg
+	DrivingMinutes   float64
+	DistanceKm       float64
+	EndInParkingZone bool
+	TravelCost       float64
+	PauseCost        float64
+}
+
+// planLegCosts pairs every leg of a plan with its route and the leg costs
+// of its breakdown. A plan priced without leg costs, such as a quote of an
+// older pricing engine, reports false and its legs carry no cost.
+func planLegCosts(plan *JourneyPlan) ([]planLegCost, bool) {
+	legs := make([]planLegCost, 0, len(plan.Journey.Legs))
+	priced := len(plan.CostBreakdown.Legs) == len(plan.Journey.Legs)
+
+	for i, leg := range plan.Journey.Legs {
+		cost := planLegCost{Index: i, Leg: leg}
+
+		if i < len(plan.route.Legs) {
+			route := plan.route.Legs[i]
+			cost.DrivingMinutes = route.DrivingMinutes
+			cost.DistanceKm = route.DistanceKm
+			cost.EndInParkingZone = route.EndInParkingZone
+		}
+
+		if priced {
+			cost.TravelCost = plan.CostBreakdown.Legs[i].TravelCost
+			cost.PauseCost = plan.CostBreakdown.Legs[i].PauseCost
+		}
+
+		legs = append(legs, cost)
+	}
+
+	return legs, priced
+}
+
+// dayCapDiscount is what the day cap took off the sum of the cost lines.
+func dayCapDiscount(plan *JourneyPlan) float64 {
+	breakdown := plan.CostBreakdown
+	sum := breakdown.UnlockFee + breakdown.BookingCost + breakdown.TravelCost + breakdown.PauseCost
+
+	return math.Max(0, sum-plan.TotalCost)
+}
+
+func pointFeature(location Location, kind string) *geojson.Feature {
+	feature := geojson.NewFeature(orb.Point{location.Lng, location.Lat})
+	feature.Properties["kind"] = kind
+
+	if location.Label != "" {
+		feature.Properties["label"] = location.Label
+	}
+
+	return feature
+}
+
+// planFeatureCollection maps a plan as the vehicle, one straight line per
+// leg and the points where the vehicle is parked.
+func planFeatureCollection(plan *JourneyPlan) *geojson.FeatureCollection {
+	collection := geojson.NewFeatureCollection()
+
+	vehicle := pointFeature(vehicleToLocation(plan.Vehicle), "vehicle")
+	vehicle.Properties["plate"] = plan.Vehicle.Plate
+	vehicle.Properties["model"] = plan.Vehicle.Model.Make + " " + plan.Vehicle.Model.Name
+	vehicle.Properties["pricingModel"] = plan.PricingModel
+	vehicle.Properties["totalCost"] = plan.TotalCost
+	collection.Append(vehicle)
+
+	legs, _ := planLegCosts(plan)
+
+	for _, leg := range legs {
+		line := geojson.NewFeature(orb.LineString{
+			{leg.Leg.StartLocation.Lng, leg.Leg.StartLocation.Lat},
+			{leg.Leg.EndLocation.Lng, leg.Leg.EndLocation.Lat},
+		})
+		line.Properties["kind"] = "leg"
+		line.Properties["legIndex"] = leg.Index
+		line.Properties["drivingMinutes"] = leg.DrivingMinutes
+		line.Properties["distanceKm"] = leg.DistanceKm
+		line.Properties["pauseMinutes"] = leg.Leg.PauseMinutes
+		line.Properties["travelCost"] = leg.TravelCost
+		line.Properties["pauseCost"] = leg.PauseCost
+		collection.Append(line)
+
+		parking := pointFeature(leg.Leg.EndLocation, "parking")
+		parking.Properties["legIndex"] = leg.Index
+		parking.Properties["inParkingZone"] = leg.EndInParkingZone
+		collection.Append(parking)
+	}
+
+	if suggestion := plan.ParkingSuggestion; suggestion != nil {
+		destination := pointFeature(suggestion.OriginalDestination, "destination")
+		destination.Properties["walkingMinutes"] = suggestion.WalkingMinutes
+		destination.Properties["message"] = suggestion.Message
+		collection.Append(destination)
+	}
+
+	return collection
+}
+
+type gpxDocument struct {
+	XMLName   xml.Name      `xml:"gpx"`
+	Version   string        `xml:"version,attr"`
+	Creator   string        `xml:"creator,attr"`
+	Namespace string        `xml:"xmlns,attr"`
+	Waypoints []gpxWaypoint `xml:"wpt"`
+	Route     gpxRoute      `xml:"rte"`
+}
+
+type gpxRoute struct {
+	Name   string        `xml:"name"`
+	Points []gpxWaypoint `xml:"rtept"`
+}
+
+type gpxWaypoint struct {
+	Lat         float64    `xml:"lat,attr"`
+	Lon         float64    `xml:"lon,attr"`
+	Time        *time.Time `xml:"time,omitempty"`
+	Name        string     `xml:"name"`
+	Description string     `xml:"desc,omitempty"`
+}
+
+func newGPXWaypoint(location Location, name string, at time.Time) gpxWaypoint {
+	waypoint := gpxWaypoint{Lat: location.Lat, Lon: location.Lng, Name: name}
+
+	if !at.IsZero() {
+		utc := at.UTC()
+		waypoint.Time = &utc
+	}
+
+	return waypoint
+}
+
+// writePlanGPX writes the plan as GPX 1.1: a waypoint for the vehicle and
+// every parking spot, and a route through the leg endpoints for
+// navigation apps.
+func writePlanGPX(w io.Writer, plan *JourneyPlan) error {
+	vehicle := newGPXWaypoint(
+		vehicleToLocation(plan.Vehicle),
+		fmt.Sprintf("%s %s (%s)", plan.Vehicle.Model.Make, plan.Vehicle.Model.Name, plan.Vehicle.Plate),
+		time.Time{},
+	)
+
+	document := gpxDocument{
+		Version:   "1.1",
+		Creator:   "Poppy Journey Planner",
+		Namespace: "http://www.topografix.com/GPX/1/1",
+		Waypoints: []gpxWaypoint{vehicle},
+		Route:     gpxRoute{Name: "Poppy journey"},
+	}
+
+	for i, leg := range plan.Journey.Legs {
+		if i == 0 || leg.StartLocation != plan.Journey.Legs[i-1].EndLocation {
+			document.Route.Points = append(document.Route.Points, newGPXWaypoint(
+				leg.StartLocation,
+				fmt.Sprintf("Start of leg %d: %s", i+1, locationName(leg.StartLocation)),
+				leg.StartTime,
+			))
+		}
+
+		end := newGPXWaypoint(
+			leg.EndLocation,
+			fmt.Sprintf("End of leg %d: %s", i+1, locationName(leg.EndLocation)),
+			leg.EndTime,
+		)
+		if leg.PauseMinutes > 0 {
+			end.Description = fmt.Sprintf("Pause %d min", leg.PauseMinutes)
+		}
+
+		document.Route.Points = append(document.Route.Points, end)
+		document.Waypoints = append(document.Waypoints, end)
+	}
+
+	if suggestion := plan.ParkingSuggestion; suggestion != nil {
+		destination := newGPXWaypoint(suggestion.OriginalDestination, "Destination", time.Time{})
+		destination.Description = suggestion.Message
+		document.Waypoints = append(document.Waypoints, destination)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return fmt.Errorf("[writePlanGPX] could not write GPX: %w", err)
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+
+	if err := encoder.Encode(document); err != nil {
+		return fmt.Errorf("[writePlanGPX] could not write GPX: %w", err)
+	}
+
+	return nil
+}
+
+var planCSVHeader = []string{
+	"line", "leg", "from", "to", "departure", "arrival", "drivingMinutes",
+	"distanceKm", "pauseMinutes", "endInParkingZone", "travelCost", "pauseCost", "amount",
+}
+
+// csvText neutralizes text a spreadsheet would run as a formula, such as a
+// user-supplied address starting with "=".
+func csvText(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+
+	return value
+}
+
+// writePlanCSV writes one row per leg followed by the journey-wide cost
+// lines and the total. The amounts of the rows above the total add up to it.
+func writePlanCSV(w io.Writer, plan *JourneyPlan) error {
+	money := func(amount float64) string {
+		return strconv.FormatFloat(amount, 'f', 2, 64)
+	}
+
+	timestamp := func(at time.Time) string {
+		if at.IsZero() {
+			return ""
+		}
+
+		return at.Format(time.RFC3339)
+	}
+
+	legs, priced := planLegCosts(plan)
+
+	writer := csv.NewWriter(w)
+	_ = writer.Write(planCSVHeader)
+
+	for _, leg := range legs {
+		_ = writer.Write([]string{
+			"leg",
+			strconv.Itoa(leg.Index + 1),
+			csvText(locationName(leg.Leg.StartLocation)),
+			csvText(locationName(leg.Leg.EndLocation)),
+			timestamp(leg.Leg.StartTime),
+			timestamp(leg.Leg.EndTime),
+			strconv.FormatFloat(leg.DrivingMinutes, 'f', 1, 64),
+			strconv.FormatFloat(leg.DistanceKm, 'f', 2, 64),
+			strconv.Itoa(leg.Leg.PauseMinutes),
+			strconv.FormatBool(leg.EndInParkingZone),
+			money(leg.TravelCost),
+			money(leg.PauseCost),
+			money(leg.TravelCost + leg.PauseCost),
+		})
+	}
+
+	costLine := func(line string, amount float64) {
+		_ = writer.Write([]string{line, "", "", "", "", "", "", "", "", "", "", "", money(amount)})
+	}
+
+	costLine("unlockFee", plan.CostBreakdown.UnlockFee)
+	costLine("bookingCost", plan.CostBreakdown.BookingCost)
+
+	if !priced {
+		costLine("travelCost", plan.CostBreakdown.TravelCost)
+		costLine("pauseCost", plan.CostBreakdown.PauseCost)
+	}
+
+	if discount := dayCapDiscount(plan); discount > 0 {
+		costLine("dayCap", -discount)
+	}
+
+	costLine("total", plan.TotalCost)
+
+	writer.Flush()
+
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("[writePlanCSV] could not write CSV: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,219 @@
+//nolint:package-comments,revive,mnd,exhaustruct
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestExportPlan(t *testing.T) *JourneyPlan {
+	t.Helper()
+
+	plan := newTestQuotedPlan(t)
+	applyTimeline(plan, time.Date(2025, 6, 2, 9, 0, 0, 0, time.UTC))
+
+	return plan
+}
+
+func TestRequestedPlanExport(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		accept   string
+		expected planExportFormat
+	}{
+		{name: "Default", expected: planExportJSON},
+		{name: "JSON accept", accept: "application/json", expected: planExportJSON},
+		{name: "Query", query: "?format=gpx", expected: planExportGPX},
+		{name: "Query wins", query: "?format=csv", accept: "text/html", expected: planExportCSV},
+		{name: "Unknown query", query: "?format=pdf", expected: planExportJSON},
+		{name: "Accept GeoJSON", accept: "application/geo+json", expected: planExportGeoJSON},
+		{name: "Accept calendar", accept: "text/calendar", expected: planExportICS},
+		{name: "Browser", accept: "text/html,application/xhtml+xml,*/*;q=0.8", expected: planExportHTML},
+		{name: "Quality", accept: "text/html;q=0.5, text/csv", expected: planExportCSV},
+		{name: "JSON preferred", accept: "text/html;q=0.9, application/json", expected: planExportJSON},
+		{name: "Refused", accept: "text/html;q=0", expected: planExportJSON},
+		{name: "Wildcard", accept: "*/*", expected: planExportJSON},
+		{name: "Specific range wins", accept: "text/*;q=0.1, text/csv;q=0.9, */*;q=0.5", expected: planExportCSV},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/api/v1/plan-journey"+tt.query, nil)
+			if tt.accept != "" {
+				request.Header.Set("Accept", tt.accept)
+			}
+
+			if format := requestedPlanExport(request); format != tt.expected {
+				t.Errorf("Expected %q but got %q", tt.expected, format)
+			}
+		})
+	}
+}
+
+func TestPlanLegCosts(t *testing.T) {
+	plan := newTestExportPlan(t)
+
+	legs, priced := planLegCosts(plan)
+	if !priced || len(legs) != 1 {
+		t.Fatalf("Expected one priced leg but got %+v", legs)
+	}
+
+	if math.Abs(legs[0].TravelCost-plan.CostBreakdown.TravelCost) > 1e-9 ||
+		math.Abs(legs[0].PauseCost-plan.CostBreakdown.PauseCost) > 1e-9 {
+		t.Errorf("Expected the leg costs to match the breakdown %+v but got %+v", plan.CostBreakdown, legs[0])
+	}
+
+	plan.CostBreakdown.Legs = nil
+
+	if legs, priced := planLegCosts(plan); priced || legs[0].TravelCost != 0 {
+		t.Errorf("Expected no leg costs without them in the breakdown but got %+v", legs)
+	}
+}
+
+func TestWritePlanCSV(t *testing.T) {
+	plan := newTestExportPlan(t)
+
+	var builder strings.Builder
+	if err := writePlanCSV(&builder, plan); err != nil {
+		t.Fatalf("Expected the CSV to be written but got %v", err)
+	}
+
+	rows, err := csv.NewReader(strings.NewReader(builder.String())).ReadAll()
+	if err != nil {
+		t.Fatalf("Expected valid CSV but got %v", err)
+	}
+
+	if len(rows) != 5 || rows[1][0] != "leg" || rows[len(rows)-1][0] != "total" {
+		t.Fatalf("Expected a header, a leg, two cost lines and the total but got %v", rows)
+	}
+
+	sum := 0.0
+
+	for _, row := range rows[1 : len(rows)-1] {
+		amount, _ := strconv.ParseFloat(row[len(row)-1], 64)
+		sum += amount
+	}
+
+	total, _ := strconv.ParseFloat(rows[len(rows)-1][len(planCSVHeader)-1], 64)
+	if math.Abs(sum-total) > 0.015 {
+		t.Errorf("Expected the amounts to add up to %.2f but got %.2f", total, sum)
+	}
+}
+
+func TestWritePlanCSV_EscapesFormulas(t *testing.T) {
+	plan := newTestExportPlan(t)
+	plan.Journey.Legs[0].StartLocation.Label = "=HYPERLINK(\"https://example.com\")"
+	plan.Journey.Legs[0].EndLocation.Label = "@SUM(A1:A9)"
+
+	var builder strings.Builder
+	if err := writePlanCSV(&builder, plan); err != nil {
+		t.Fatalf("Expected the CSV to be written but got %v", err)
+	}
+
+	rows, _ := csv.NewReader(strings.NewReader(builder.String())).ReadAll()
+
+	if rows[1][2] != `'=HYPERLINK("https://example.com")` || rows[1][3] != "'@SUM(A1:A9)" {
+		t.Errorf("Expected the formulas to be escaped but got %q and %q", rows[1][2], rows[1][3])
+	}
+
+	for _, row := range rows[1:] {
+		if strings.HasPrefix(row[len(row)-1], "'") {
+			t.Errorf("Expected the amounts to be left alone but got %q", row[len(row)-1])
+		}
+	}
+}
+
+func TestWritePlanGPX(t *testing.T) {
+	plan := newTestExportPlan(t)
+
+	var builder strings.Builder
+	if err := writePlanGPX(&builder, plan); err != nil {
+		t.Fatalf("Expected the GPX to be written but got %v", err)
+	}
+
+	var document gpxDocument
+	if err := xml.Unmarshal([]byte(builder.String()), &document); err != nil {
+		t.Fatalf("Expected valid GPX but got %v", err)
+	}
+
+	if document.Version != "1.1" || len(document.Waypoints) != 2 || len(document.Route.Points) != 2 {
+		t.Fatalf("Expected the vehicle and leg end waypoints and a two-point route but got %+v", document)
+	}
+
+	if end := document.Route.Points[1]; end.Lat != 50.86 || end.Time == nil {
+		t.Errorf("Expected the route to end at the destination on time but got %+v", end)
+	}
+}
+
+func TestRespondPlanExport(t *testing.T) {
+	tests := []struct {
+		format      string
+		contentType string
+		contains    string
+	}{
+		{format: "geojson", contentType: geoJSONContentType, contains: `"FeatureCollection"`},
+		{format: "gpx", contentType: gpxContentType, contains: "<gpx"},
+		{format: "csv", contentType: "text/csv; charset=utf-8", contains: "unlockFee"},
+		{format: "html", contentType: "text/html; charset=utf-8", contains: "1-ABC-123"},
+		{format: "ics", contentType: "text/calendar; charset=utf-8", contains: "BEGIN:VCALENDAR"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/api/v1/plan-journey?format="+tt.format, nil)
+
+			if !respondPlanExport(recorder, request, newTestExportPlan(t)) {
+				t.Fatal("Expected the export to be written")
+			}
+
+			if contentType := recorder.Header().Get("Content-Type"); contentType != tt.contentType {
+				t.Errorf("Expected content type %s but got %s", tt.contentType, contentType)
+			}
+
+			if !strings.Contains(recorder.Body.String(), tt.contains) {
+				t.Errorf("Expected the body to contain %q", tt.contains)
+			}
+		})
+	}
+
+	recorder := httptest.NewRecorder()
+	if respondPlanExport(recorder, httptest.NewRequest(http.MethodPost, "/api/v1/plan-journey", nil), newTestExportPlan(t)) {
+		t.Error("Expected JSON to be left to the caller")
+	}
+}
+
+func TestPlanFeatureCollection(t *testing.T) {
+	data, _ := json.Marshal(planFeatureCollection(newTestExportPlan(t)))
+
+	var collection struct {
+		Features []struct {
+			Geometry struct {
+				Type string `json:"type"`
+			} `json:"geometry"`
+			Properties map[string]any `json:"properties"`
+		} `json:"features"`
+	}
+
+	_ = json.Unmarshal(data, &collection)
+
+	kinds := []string{}
+	for _, feature := range collection.Features {
+		kind, _ := feature.Properties["kind"].(string)
+		kinds = append(kinds, feature.Geometry.Type+":"+kind)
+	}
+
+	expected := []string{"Point:vehicle", "LineString:leg", "Point:parking"}
+	if strings.Join(kinds, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected features %v but got %v", expected, kinds)
+	}
+}
@@ -0,0 +1,333 @@
+//nolint:package-comments,revive,mnd,exhaustruct,err113
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	orsGeocodeURL       = "https://api.openrouteservice.org/geocode/search"
+	nominatimDefaultURL = "https://nominatim.openstreetmap.org"
+	geocoderUserAgent   = "poppy-journey-planner"
+	// nominatimPublicInterval is the pause between requests to the public
+	// Nominatim instance, whose usage policy allows one request a second.
+	nominatimPublicInterval = time.Second
+)
+
+var errAddressNotFound = errors.New("address not found")
+
+// geocoder resolves a free-text address or place name to coordinates. The
+// returned location carries the label of the matched place.
+type geocoder interface {
+	Geocode(ctx context.Context, query string) (Location, error)
+}
+
+type orsGeocoder struct {
+	client    *http.Client
+	baseURL   string
+	apiKey    string
+	userAgent string
+}
+
+type orsGeocodeResponse struct {
+	Features []struct {
+		Geometry struct {
+			Coordinates []float64 `json:"coordinates"`
+		} `json:"geometry"`
+		Properties struct {
+			Label string `json:"label"`
+		} `json:"properties"`
+	} `json:"features"`
+}
+
+func (g *orsGeocoder) Geocode(ctx context.Context, query string) (Location, error) {
+	if err := spendORSBudget(ctx); err != nil {
+		return Location{}, fmt.Errorf("[orsGeocoder] %w", err)
+	}
+
+	parsedURL, err := url.Parse(g.baseURL)
+	if err != nil {
+		return Location{}, fmt.Errorf("[orsGeocoder] could not parse URL: %w", err)
+	}
+
+	params := parsedURL.Query()
+	params.Set("text", query)
+	params.Set("size", "1")
+	// Bias results towards Brussels, where the fleet operates.
+	params.Set("focus.point.lat", "50.8466")
+	params.Set("focus.point.lon", "4.3528")
+	parsedURL.RawQuery = params.Encode()
+
+	var response orsGeocodeResponse
+	if err := getGeocoderJSON(ctx, g.client, parsedURL.String(), g.apiKey, g.userAgent, &response); err != nil {
+		return Location{}, fmt.Errorf("[orsGeocoder] %w", err)
+	}
+
+	if len(response.Features) == 0 ||
+		len(response.Features[0].Geometry.Coordinates) < 2 {
+		return Location{}, fmt.Errorf("[orsGeocoder] %q: %w", query, errAddressNotFound)
+	}
+
+	feature := response.Features[0]
+
+	return Location{
+		Lat:   feature.Geometry.Coordinates[1],
+		Lng:   feature.Geometry.Coordinates[0],
+		Label: feature.Properties.Label,
+	}, nil
+}
+
+// nominatimGeocoder queries a Nominatim instance, either the public
+// OpenStreetMap one or a local deployment. Requests are spaced by
+// interval, when set, across all callers.
+type nominatimGeocoder struct {
+	client    *http.Client
+	baseURL   string
+	userAgent string
+	interval  time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+type nominatimPlace struct {
+	Lat         string `json:"lat"`
+	Lon         string `json:"lon"`
+	DisplayName string `json:"display_name"`
+}
+
+func (g *nominatimGeocoder) Geocode(ctx context.Context, query string) (Location, error) {
+	targetURL, err := url.JoinPath(g.baseURL, "search")
+	if err != nil {
+		return Location{}, fmt.Errorf("[nominatimGeocoder] could not parse URL: %w", err)
+	}
+
+	if err := g.wait(ctx); err != nil {
+		return Location{}, fmt.Errorf("[nominatimGeocoder] %w", err)
+	}
+
+	params := url.Values{}
+	params.Set("q", query)
+	params.Set("format", "jsonv2")
+	params.Set("limit", "1")
+
+	var places []nominatimPlace
+	if err := getGeocoderJSON(ctx, g.client, targetURL+"?"+params.Encode(), "", g.userAgent, &places); err != nil {
+		return Location{}, fmt.Errorf("[nominatimGeocoder] %w", err)
+	}
+
+	if len(places) == 0 {
+		return Location{}, fmt.Errorf("[nominatimGeocoder] %q: %w", query, errAddressNotFound)
+	}
+
+	lat, err := strconv.ParseFloat(places[0].Lat, 64)
+	if err != nil {
+		return Location{}, fmt.Errorf("[nominatimGeocoder] invalid latitude: %w", err)
+	}
+
+	lng, err := strconv.ParseFloat(places[0].Lon, 64)
+	if err != nil {
+		return Location{}, fmt.Errorf("[nominatimGeocoder] invalid longitude: %w", err)
+	}
+
+	return Location{Lat: lat, Lng: lng, Label: places[0].DisplayName}, nil
+}
+
+// wait takes the next request slot and sleeps until it comes, or until
+// ctx is done.
+func (g *nominatimGeocoder) wait(ctx context.Context) error {
+	if g.interval <= 0 {
+		return nil
+	}
+
+	g.mu.Lock()
+	now := time.Now()
+	slot := now
+
+	if g.next.After(now) {
+		slot = g.next
+	}
+
+	g.next = slot.Add(g.interval)
+	g.mu.Unlock()
+
+	if !slot.After(now) {
+		return nil
+	}
+
+	timer := time.NewTimer(slot.Sub(now))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// getGeocoderJSON fetches targetURL into target. A non-empty apiKey is
+// sent in the Authorization header, never in the URL, which ends up in
+// transport errors and therefore in the log. An empty userAgent sends
+// geocoderUserAgent.
+func getGeocoderJSON(
+	ctx context.Context,
+	client *http.Client,
+	targetURL string,
+	apiKey string,
+	userAgent string,
+	target any,
+) (err error) {
+	defer traceUpstream(ctx, "geocoder", time.Now(), &err)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, targetURL, nil)
+	if err != nil {
+		return fmt.Errorf("could not create request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", cmp.Or(userAgent, geocoderUserAgent))
+
+	if apiKey != "" {
+		req.Header.Set("Authorization", apiKey)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not perform request: %w", err)
+	}
+
+	defer func() { _ = res.Body.Close() }()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("API returned status %d", res.StatusCode)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(target); err != nil {
+		return fmt.Errorf("error decoding response: %w", err)
+	}
+
+	return nil
+}
+
+// newGeocoder picks the geocoder named by the geocoder setting ("ors" or
+// "nominatim"). Without it, ORS is used when an API key is set and
+// Nominatim otherwise. Requests to the public Nominatim instance are held
+// to its rate limit.
+func newGeocoder(client *http.Client, config Config) geocoder {
+	ors := &orsGeocoder{
+		client:    client,
+		baseURL:   orsGeocodeURL,
+		apiKey:    config.ORSAPIKey,
+		userAgent: config.GeocoderUserAgent,
+	}
+
+	nominatim := &nominatimGeocoder{
+		client:    client,
+		baseURL:   config.NominatimURL,
+		userAgent: config.GeocoderUserAgent,
+	}
+
+	if strings.TrimSuffix(config.NominatimURL, "/") == nominatimDefaultURL {
+		nominatim.interval = nominatimPublicInterval
+	}
+
+	switch strings.ToLower(config.Geocoder) {
+	case "ors":
+		return ors
+	case "nominatim":
+		return nominatim
+	}
+
+	if config.ORSAPIKey != "" {
+		return ors
+	}
+
+	return nominatim
+}
+
+// resolveJourneyAddresses geocodes every leg location given as an address
+// without coordinates. Each distinct address is looked up once.
+func resolveJourneyAddresses(
+	ctx context.Context,
+	geocoder geocoder,
+	journey Journey,
+) (Journey, *ValidationError) {
+	result := &ValidationError{}
+	resolved := map[string]Location{}
+
+	resolve := func(index int, field string, location *Location) {
+		if location.Address == "" || location.Lat != 0 || location.Lng != 0 {
+			return
+		}
+
+		if match, ok := resolved[location.Address]; ok {
+			*location = match
+
+			return
+		}
+
+		if geocoder == nil {
+			result.add(ValidationIssue{
+				Code:     validationCodeAddressNotFound,
+				LegIndex: legIndex(index),
+				Field:    field,
+				Message:  "Address lookup is not available",
+				Remedy:   "Enter a latitude and longitude instead",
+			})
+
+			return
+		}
+
+		match, err := geocoder.Geocode(ctx, location.Address)
+		if err != nil {
+			result.cause = err
+			result.add(ValidationIssue{
+				Code:     validationCodeAddressNotFound,
+				LegIndex: legIndex(index),
+				Field:    field,
+				Message:  fmt.Sprintf("Could not find address %q", location.Address),
+				Remedy:   "Check the spelling or enter a latitude and longitude instead",
+			})
+
+			return
+		}
+
+		match.Address = location.Address
+		resolved[location.Address] = match
+		*location = match
+	}
+
+	journey.Legs = slices.Clone(journey.Legs)
+
+	for i := range journey.Legs {
+		resolve(i, "startLocation", &journey.Legs[i].StartLocation)
+		resolve(i, "endLocation", &journey.Legs[i].EndLocation)
+	}
+
+	if len(result.Issues) > 0 {
+		return journey, result
+	}
+
+	return journey, nil
+}
+
+// locationName is the label of a geocoded location, or its coordinates.
+func locationName(location Location) string {
+	if location.Label != "" {
+		return location.Label
+	}
+
+	return fmt.Sprintf("%.5f, %.5f", location.Lat, location.Lng)
+}
@@ -0,0 +1,219 @@
+//nolint:package-comments,revive,mnd,exhaustruct,err113
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeGeocoder struct {
+	places  map[string]Location
+	lookups int
+}
+
+func (g *fakeGeocoder) Geocode(_ context.Context, query string) (Location, error) {
+	g.lookups++
+
+	location, ok := g.places[query]
+	if !ok {
+		return Location{}, errAddressNotFound
+	}
+
+	return location, nil
+}
+
+func TestORSGeocoder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("text") != "Place Flagey" || r.URL.Query().Has("api_key") {
+			t.Errorf("Unexpected query %s", r.URL.RawQuery)
+		}
+
+		if r.Header.Get("Authorization") != "secret" {
+			t.Errorf("Expected the API key in the Authorization header but got %q", r.Header.Get("Authorization"))
+		}
+
+		_, _ = w.Write([]byte(`{"features":[{"geometry":{"coordinates":[4.3745,50.8275]},` +
+			`"properties":{"label":"Place Flagey, Ixelles, Belgium"}}]}`))
+	}))
+	defer server.Close()
+
+	geocoder := &orsGeocoder{client: server.Client(), baseURL: server.URL, apiKey: "secret"}
+
+	location, err := geocoder.Geocode(context.Background(), "Place Flagey")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if location.Lat != 50.8275 || location.Lng != 4.3745 ||
+		location.Label != "Place Flagey, Ixelles, Belgium" {
+		t.Errorf("Unexpected location %+v", location)
+	}
+}
+
+func TestNewGeocoder(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   Config
+		expected string
+	}{
+		{name: "ORS with a key", config: Config{ORSAPIKey: "secret"}, expected: "ors"},
+		{name: "Nominatim without a key", config: Config{}, expected: "nominatim"},
+		{name: "Nominatim by choice", config: Config{ORSAPIKey: "secret", Geocoder: "Nominatim"}, expected: "nominatim"},
+		{name: "ORS by choice", config: Config{Geocoder: "ors"}, expected: "ors"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.NominatimURL = "http://localhost:8088"
+
+			switch geocoder := newGeocoder(http.DefaultClient, tt.config).(type) {
+			case *orsGeocoder:
+				if tt.expected != "ors" || geocoder.apiKey != tt.config.ORSAPIKey {
+					t.Errorf("Expected %s but got the ORS geocoder", tt.expected)
+				}
+			case *nominatimGeocoder:
+				if tt.expected != "nominatim" || geocoder.baseURL != "http://localhost:8088" {
+					t.Errorf("Expected %s but got the Nominatim geocoder at %s", tt.expected, geocoder.baseURL)
+				}
+
+				if geocoder.interval != 0 {
+					t.Errorf("Expected a local Nominatim instance not to be rate limited")
+				}
+			}
+		})
+	}
+
+	config := defaultConfig()
+	config.GeocoderUserAgent = "planner/1.0 (ops@example.com)"
+
+	public, ok := newGeocoder(http.DefaultClient, config).(*nominatimGeocoder)
+	if !ok || public.interval != nominatimPublicInterval || public.userAgent != config.GeocoderUserAgent {
+		t.Errorf("Expected the public Nominatim instance to be rate limited with the configured User-Agent but got %+v", public)
+	}
+}
+
+func TestNominatimGeocoder_RateLimit(t *testing.T) {
+	var userAgents []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents = append(userAgents, r.UserAgent())
+		_, _ = w.Write([]byte(`[{"lat":"50.8355","lon":"4.3573","display_name":"Bruxelles-Midi"}]`))
+	}))
+	defer server.Close()
+
+	geocoder := &nominatimGeocoder{
+		client:    server.Client(),
+		baseURL:   server.URL,
+		userAgent: "planner/1.0",
+		interval:  50 * time.Millisecond,
+	}
+
+	start := time.Now()
+
+	for range 3 {
+		if _, err := geocoder.Geocode(context.Background(), "Gare du Midi"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected 3 lookups to take at least 2 intervals but took %s", elapsed)
+	}
+
+	if len(userAgents) != 3 || userAgents[0] != "planner/1.0" {
+		t.Errorf("Expected the configured User-Agent on every lookup but got %v", userAgents)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := geocoder.Geocode(ctx, "Gare du Midi"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a cancelled lookup to stop waiting but got %v", err)
+	}
+}
+
+func TestNominatimGeocoder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/search" {
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+
+		if r.URL.Query().Get("q") == "nowhere" {
+			_, _ = w.Write([]byte(`[]`))
+
+			return
+		}
+
+		_, _ = w.Write([]byte(`[{"lat":"50.8355","lon":"4.3573","display_name":"Bruxelles-Midi"}]`))
+	}))
+	defer server.Close()
+
+	geocoder := &nominatimGeocoder{client: server.Client(), baseURL: server.URL}
+
+	location, err := geocoder.Geocode(context.Background(), "Gare du Midi")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if location.Lat != 50.8355 || location.Lng != 4.3573 || location.Label != "Bruxelles-Midi" {
+		t.Errorf("Unexpected location %+v", location)
+	}
+
+	if _, err := geocoder.Geocode(context.Background(), "nowhere"); !errors.Is(err, errAddressNotFound) {
+		t.Errorf("Expected errAddressNotFound but got %v", err)
+	}
+}
+
+func TestResolveJourneyAddresses(t *testing.T) {
+	geocoder := &fakeGeocoder{places: map[string]Location{
+		"Gare du Midi": {Lat: 50.8355, Lng: 4.3573, Label: "Bruxelles-Midi"},
+		"Place Flagey": {Lat: 50.8275, Lng: 4.3745, Label: "Place Flagey"},
+	}}
+
+	journey := Journey{
+		Legs: []TripLeg{
+			{
+				StartLocation: Location{Address: "Gare du Midi"},
+				EndLocation:   Location{Address: "Place Flagey"},
+			},
+			{
+				StartLocation: Location{Address: "Place Flagey"},
+				EndLocation:   Location{Lat: 50.8245, Lng: 4.3635},
+			},
+		},
+	}
+
+	resolved, err := resolveJourneyAddresses(context.Background(), geocoder, journey)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if geocoder.lookups != 2 {
+		t.Errorf("Expected each address to be looked up once but got %d lookups", geocoder.lookups)
+	}
+
+	start := resolved.Legs[0].StartLocation
+	if start.Lat != 50.8355 || start.Label != "Bruxelles-Midi" || start.Address != "Gare du Midi" {
+		t.Errorf("Unexpected resolved start %+v", start)
+	}
+
+	if resolved.Legs[1].StartLocation.Lat != 50.8275 {
+		t.Errorf("Expected repeated address to be resolved but got %+v", resolved.Legs[1].StartLocation)
+	}
+
+	if journey.Legs[0].StartLocation.Lat != 0 {
+		t.Error("Expected the request journey to be left untouched")
+	}
+
+	journey.Legs[1].EndLocation = Location{Address: "Atlantis"}
+
+	_, err = resolveJourneyAddresses(context.Background(), geocoder, journey)
+	if err == nil || err.Issues[0].Code != validationCodeAddressNotFound ||
+		*err.Issues[0].LegIndex != 1 || err.Issues[0].Field != "endLocation" {
+		t.Errorf("Expected an address_not_found issue on leg 2 but got %v", err)
+	}
+}
@@ -0,0 +1,271 @@
+//nolint:package-comments,revive,mnd,exhaustruct,errchkjson
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/paulmach/orb/geojson"
+)
+
+type zoneKind string
+
+const (
+	zoneKindParking   zoneKind = "parking"
+	zoneKindOperating zoneKind = "operating"
+	zoneKindForbidden zoneKind = "forbidden"
+	zoneKindOther     zoneKind = "other"
+)
+
+// The geofencing types Poppy geozone payloads carry.
+const (
+	geofencingTypeParking   = "parking"
+	geofencingTypeOperating = "operating"
+	geofencingTypeNoParking = "noParking"
+)
+
+// unknownGeofencingTypes are the types outside the known ones that were
+// already logged, so each is logged once.
+var unknownGeofencingTypes sync.Map
+
+// classifyGeofencingType maps the geofencing types Poppy sends onto the
+// zone kinds the planner enforces. Unknown types are logged once, then
+// still indexed and reported, but carry no rules.
+func classifyGeofencingType(geofencingType string) zoneKind {
+	switch geofencingType {
+	case geofencingTypeParking:
+		return zoneKindParking
+	case geofencingTypeOperating:
+		return zoneKindOperating
+	case geofencingTypeNoParking:
+		return zoneKindForbidden
+	}
+
+	if _, logged := unknownGeofencingTypes.LoadOrStore(geofencingType, true); !logged {
+		slog.Warn("unknown geofencing type, its zones carry no rules", "geofencing_type", geofencingType)
+	}
+
+	return zoneKindOther
+}
+
+type LegZones struct {
+	LegIndex   int      `json:"legIndex"`
+	StartZones []string `json:"startZones"`
+	EndZones   []string `json:"endZones"`
+}
+
+func describeLegZones(journey Journey, zones *zoneIndex) []LegZones {
+	if zones == nil {
+		return nil
+	}
+
+	legZones := make([]LegZones, 0, len(journey.Legs))
+
+	for i, leg := range journey.Legs {
+		legZones = append(legZones, LegZones{
+			LegIndex:   i,
+			StartZones: zones.zonesAt(leg.StartLocation),
+			EndZones:   zones.zonesAt(leg.EndLocation),
+		})
+	}
+
+	return legZones
+}
+
+// zoneList renders the zones of a leg endpoint for display.
+func zoneList(zones []string) string {
+	if len(zones) == 0 {
+		return "no zone"
+	}
+
+	return strings.Join(zones, ", ")
+}
+
+// checkJourneyZones rejects journeys that start or end outside the
+// operating area and returns warnings for legs ending in forbidden areas.
+// The operating area is only enforced when the geozone data defines one.
+func checkJourneyZones(
+	journey Journey,
+	zones *zoneIndex,
+) ([]ValidationIssue, *ValidationError) {
+	if zones == nil || len(journey.Legs) == 0 {
+		return nil, nil
+	}
+
+	if zones.hasZones(zoneKindOperating) {
+		result := &ValidationError{}
+
+		lastIndex := len(journey.Legs) - 1
+		start := journey.Legs[0].StartLocation
+		end := journey.Legs[lastIndex].EndLocation
+
+		if !zones.inZone(start, zoneKindOperating) {
+			result.add(ValidationIssue{
+				Code:     validationCodeStartOutsideOperatingArea,
+				LegIndex: legIndex(0),
+				Field:    "startLocation",
+				Message:  "Journey starts outside the Poppy operating area",
+				Remedy:   "Start the journey inside the operating area",
+			})
+		}
+
+		if !zones.inZone(end, zoneKindOperating) {
+			result.add(ValidationIssue{
+				Code:     validationCodeEndOutsideOperatingArea,
+				LegIndex: legIndex(lastIndex),
+				Field:    "endLocation",
+				Message:  "Journey ends outside the Poppy operating area",
+				Remedy:   "End the journey inside the operating area",
+			})
+		}
+
+		if len(result.Issues) > 0 {
+			return nil, result
+		}
+	}
+
+	var warnings []ValidationIssue
+
+	for i, leg := range journey.Legs {
+		if !zones.inZone(leg.EndLocation, zoneKindForbidden) {
+			continue
+		}
+
+		warnings = append(warnings, ValidationIssue{
+			Code:     validationCodeEndInForbiddenArea,
+			LegIndex: legIndex(i),
+			Field:    "endLocation",
+			Message: fmt.Sprintf(
+				"Leg ends in a no-parking area (%s)",
+				strings.Join(zones.zonesAt(leg.EndLocation), ", "),
+			),
+			Remedy: "Move the stop outside the no-parking area",
+		})
+	}
+
+	return warnings, nil
+}
+
+// zoneKinds are the zone kinds the geozones endpoint filters on.
+var zoneKinds = []zoneKind{zoneKindParking, zoneKindOperating, zoneKindForbidden, zoneKindOther}
+
+// geoZoneFeatureCollection converts the zones of modelType that classify
+// as kind into GeoJSON features. An empty kind matches every zone.
+func geoZoneFeatureCollection(
+	geozone *GeoZone,
+	modelType vehicleModelType,
+	kind zoneKind,
+) *geojson.FeatureCollection {
+	collection := geojson.NewFeatureCollection()
+
+	if geozone == nil {
+		return collection
+	}
+
+	for _, item := range *geozone {
+		if item.ModelType != string(modelType) {
+			continue
+		}
+
+		itemKind := classifyGeofencingType(item.GeofencingType)
+		if kind != "" && itemKind != kind {
+			continue
+		}
+
+		feature := geojson.NewFeature(item.Geom.Geometry.Geometry())
+		feature.Properties["geofencingType"] = item.GeofencingType
+		feature.Properties["zoneKind"] = itemKind
+		feature.Properties["modelType"] = item.ModelType
+
+		collection.Append(feature)
+	}
+
+	return collection
+}
+
+func geoZonesHandler(client *http.Client, cache *upstreamCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		modelType := vehicleModelType(r.URL.Query().Get("modelType"))
+		if modelType == "" {
+			modelType = vehicleModelTypeCar
+		}
+
+		// The fleet is fetched cars only, so other model types have no
+		// vehicle to resolve their zones through.
+		if modelType != vehicleModelTypeCar {
+			respondJSON(w, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error:   "Unsupported modelType " + strconv.Quote(string(modelType)) + ", only car is supported",
+			})
+
+			return
+		}
+
+		kind := zoneKind(r.URL.Query().Get("type"))
+		if kind != "" && !slices.Contains(zoneKinds, kind) {
+			respondJSON(w, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error:   "Unknown zone type " + strconv.Quote(string(kind)) + ", expected parking, operating, forbidden or other",
+			})
+
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		vehicles, err := cache.fetchVehicles(ctx, client)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+
+			return
+		}
+
+		var vehicle *Vehicle
+
+		for i := range vehicles {
+			if vehicles[i].Model.Type == modelType {
+				vehicle = &vehicles[i]
+
+				break
+			}
+		}
+
+		if vehicle == nil {
+			respondJSON(w, http.StatusNotFound, APIResponse{
+				Success: false,
+				Error:   "No vehicle of model type " + string(modelType) + " available to resolve geozones",
+			})
+
+			return
+		}
+
+		zones, err := cache.fetchZoneIndex(ctx, client, *vehicle)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+
+			return
+		}
+
+		collection := geoZoneFeatureCollection(zones.zone(), modelType, kind)
+
+		w.Header().Set("Content-Type", "application/geo+json")
+		w.WriteHeader(http.StatusOK)
+
+		_ = json.NewEncoder(w).Encode(collection)
+	}
+}
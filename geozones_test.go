@@ -0,0 +1,160 @@
+//nolint:package-comments,revive,mnd,exhaustruct
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/paulmach/orb"
+)
+
+func TestGeoZoneFeatureCollection(t *testing.T) {
+	square := orb.Polygon{{
+		{4.30, 50.80}, {4.40, 50.80}, {4.40, 50.90}, {4.30, 50.90}, {4.30, 50.80},
+	}}
+
+	van := newTestGeoZone("parking", square)
+	van.ModelType = "van"
+
+	geozone := GeoZone{
+		newTestGeoZone("parking", square),
+		newTestGeoZone("operating", square),
+		newTestGeoZone("noParking", square),
+		van,
+	}
+
+	collection := geoZoneFeatureCollection(&geozone, vehicleModelTypeCar, zoneKindParking)
+	if len(collection.Features) != 1 {
+		t.Fatalf("Expected 1 feature but got %d", len(collection.Features))
+	}
+
+	feature := collection.Features[0]
+	if feature.Properties["geofencingType"] != "parking" ||
+		feature.Properties["zoneKind"] != zoneKindParking ||
+		feature.Properties["modelType"] != "car" {
+		t.Errorf("Unexpected feature properties %v", feature.Properties)
+	}
+
+	if _, ok := feature.Geometry.(orb.Polygon); !ok {
+		t.Errorf("Expected polygon geometry but got %T", feature.Geometry)
+	}
+
+	forbidden := geoZoneFeatureCollection(&geozone, vehicleModelTypeCar, zoneKindForbidden)
+	if len(forbidden.Features) != 1 || forbidden.Features[0].Properties["geofencingType"] != "noParking" {
+		t.Errorf("Expected the noParking zone as forbidden but got %+v", forbidden.Features)
+	}
+
+	if collection := geoZoneFeatureCollection(&geozone, vehicleModelTypeCar, ""); len(collection.Features) != 3 {
+		t.Errorf("Expected 3 car features but got %d", len(collection.Features))
+	}
+
+	if collection := geoZoneFeatureCollection(nil, vehicleModelTypeCar, ""); len(collection.Features) != 0 {
+		t.Errorf("Expected empty collection for nil geozone")
+	}
+}
+
+func TestClassifyGeofencingType(t *testing.T) {
+	tests := map[string]zoneKind{
+		"parking":        zoneKindParking,
+		"operating":      zoneKindOperating,
+		"noParking":      zoneKindForbidden,
+		"no-parking":     zoneKindOther,
+		"operating_area": zoneKindOther,
+		"speedLimit":     zoneKindOther,
+	}
+
+	for geofencingType, expected := range tests {
+		if actual := classifyGeofencingType(geofencingType); actual != expected {
+			t.Errorf("%q: expected %s but got %s", geofencingType, expected, actual)
+		}
+	}
+}
+
+func TestCheckJourneyZones(t *testing.T) {
+	operating := orb.Polygon{{
+		{4.30, 50.80}, {4.40, 50.80}, {4.40, 50.90}, {4.30, 50.90}, {4.30, 50.80},
+	}}
+	noParking := orb.Polygon{{
+		{4.34, 50.84}, {4.36, 50.84}, {4.36, 50.86}, {4.34, 50.86}, {4.34, 50.84},
+	}}
+
+	geozone := GeoZone{
+		newTestGeoZone("operating", operating),
+		newTestGeoZone("parking", operating),
+		newTestGeoZone("noParking", noParking),
+	}
+	zones := newZoneIndex(&geozone)
+
+	journey := Journey{
+		Legs: []TripLeg{
+			{
+				StartLocation: Location{Lat: 50.82, Lng: 4.32},
+				EndLocation:   Location{Lat: 50.85, Lng: 4.35},
+				PauseMinutes:  30,
+			},
+			{
+				StartLocation: Location{Lat: 50.85, Lng: 4.35},
+				EndLocation:   Location{Lat: 50.88, Lng: 4.38},
+			},
+		},
+	}
+
+	warnings, err := checkJourneyZones(journey, zones)
+	if err != nil {
+		t.Fatalf("Expected journey to be accepted but got %v", err)
+	}
+
+	if len(warnings) != 1 || warnings[0].Code != validationCodeEndInForbiddenArea ||
+		*warnings[0].LegIndex != 0 {
+		t.Errorf("Expected a forbidden area warning on leg 1 but got %v", warnings)
+	}
+
+	legZones := describeLegZones(journey, zones)
+	if len(legZones) != 2 {
+		t.Fatalf("Expected zones for 2 legs but got %d", len(legZones))
+	}
+
+	if zoneList(legZones[0].EndZones) != "noParking, operating, parking" {
+		t.Errorf("Unexpected end zones for leg 1: %v", legZones[0].EndZones)
+	}
+
+	journey.Legs[1].EndLocation = Location{Lat: 50.95, Lng: 4.50}
+
+	_, err = checkJourneyZones(journey, zones)
+	if err == nil || err.Issues[0].Code != validationCodeEndOutsideOperatingArea {
+		t.Errorf("Expected journey ending outside the operating area to be rejected but got %v", err)
+	}
+
+	parkingOnly := GeoZone{newTestGeoZone("parking", operating)}
+
+	if _, err := checkJourneyZones(journey, newZoneIndex(&parkingOnly)); err != nil {
+		t.Errorf("Expected operating area not to be enforced without data but got %v", err)
+	}
+}
+
+func TestGeoZonesHandler_InvalidParameters(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		expected string
+	}{
+		{name: "Van", query: "?modelType=van", expected: "only car is supported"},
+		{name: "Raw geofencing type", query: "?type=noParking", expected: "Unknown zone type"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			geoZonesHandler(http.DefaultClient, nil).ServeHTTP(
+				recorder,
+				httptest.NewRequest(http.MethodGet, "/api/v1/geozones"+tt.query, nil),
+			)
+
+			if recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), tt.expected) {
+				t.Errorf("Expected a 400 mentioning %q but got %d %s", tt.expected, recorder.Code, recorder.Body.String())
+			}
+		})
+	}
+}
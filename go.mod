@@ -2,10 +2,11 @@ module poppy
 
 go 1.24.6
 
-require github.com/paulmach/orb v0.11.1
-
 require (
-	github.com/a-h/templ v0.3.943 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
-	go.mongodb.org/mongo-driver v1.11.4 // indirect
+	github.com/a-h/templ v0.3.943
+	github.com/joho/godotenv v1.5.1
+	github.com/paulmach/orb v0.11.1
+	gopkg.in/yaml.v3 v3.0.1
 )
+
+require go.mongodb.org/mongo-driver v1.11.4 // indirect
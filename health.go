@@ -0,0 +1,387 @@
+//nolint:package-comments,revive,mnd,exhaustruct
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+const (
+	readinessCacheTTL = 30 * time.Second
+	readinessTimeout  = 5 * time.Second
+
+	// orsProbeInterval is how long an ORS answer vouches for routing. The
+	// readiness probe only calls ORS itself when nothing else has in that
+	// time, so at most 96 calls of the daily quota go to probes.
+	orsProbeInterval = 15 * time.Minute
+)
+
+// version, commit and buildDate are set at link time:
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%FT%TZ)"
+//
+// Unset values fall back to the build info Go embeds in the binary.
+var (
+	version   = ""
+	commit    = ""
+	buildDate = ""
+)
+
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"buildDate,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"goVersion"`
+}
+
+var currentBuildInfo = sync.OnceValue(func() BuildInfo {
+	info := BuildInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+	}
+
+	embedded, ok := debug.ReadBuildInfo()
+	if !ok {
+		embedded = &debug.BuildInfo{}
+	}
+
+	if info.Version == "" {
+		info.Version = embedded.Main.Version
+	}
+
+	for _, setting := range embedded.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = setting.Value
+			}
+		case "vcs.time":
+			if info.BuildDate == "" {
+				info.BuildDate = setting.Value
+			}
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+
+	if info.Version == "" || info.Version == "(devel)" {
+		info.Version = "dev"
+	}
+
+	return info
+})
+
+type readinessStatus string
+
+const (
+	readinessReady       readinessStatus = "ready"
+	readinessDegraded    readinessStatus = "degraded"
+	readinessUnavailable readinessStatus = "unavailable"
+)
+
+type dependencyStatus string
+
+const (
+	dependencyUp       dependencyStatus = "up"
+	dependencyDown     dependencyStatus = "down"
+	dependencyDisabled dependencyStatus = "disabled"
+	dependencySkipped  dependencyStatus = "skipped"
+)
+
+type DependencyCheck struct {
+	Name      string           `json:"name"`
+	Status    dependencyStatus `json:"status"`
+	LatencyMs int64            `json:"latencyMs"`
+	Error     string           `json:"error,omitempty"`
+}
+
+// ReadinessReport is the outcome of probing every upstream dependency.
+// Degraded lists what the planner does without the dependencies that are
+// down; the service stays ready as long as it can still price journeys.
+type ReadinessReport struct {
+	Status    readinessStatus   `json:"status"`
+	Service   string            `json:"service"`
+	Build     BuildInfo         `json:"build"`
+	CheckedAt time.Time         `json:"checkedAt"`
+	Checks    []DependencyCheck `json:"checks"`
+	Degraded  []string          `json:"degraded"`
+}
+
+// upstreamOutcome remembers how the last call to an upstream went.
+type upstreamOutcome struct {
+	now func() time.Time
+
+	mu      sync.Mutex
+	at      time.Time
+	latency time.Duration
+	err     error
+}
+
+// orsOutcome is fed by every ORS call through traceUpstream, those of
+// plans and of the readiness probe alike.
+var orsOutcome = &upstreamOutcome{now: time.Now}
+
+func (o *upstreamOutcome) observe(latency time.Duration, err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.at, o.latency, o.err = o.now(), latency, err
+}
+
+// recent returns the last outcome as a check of name, if it is younger
+// than maxAge.
+func (o *upstreamOutcome) recent(name string, maxAge time.Duration) (DependencyCheck, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.at.IsZero() || o.now().Sub(o.at) >= maxAge {
+		return DependencyCheck{}, false
+	}
+
+	check := DependencyCheck{Name: name, Status: dependencyUp, LatencyMs: o.latency.Milliseconds()}
+	if o.err != nil {
+		check.Status = dependencyDown
+		check.Error = o.err.Error()
+	}
+
+	return check, true
+}
+
+// readinessProbe checks Poppy and ORS and keeps the report for a while, so
+// frequent readiness checks do not hammer the upstreams.
+type readinessProbe struct {
+	client *http.Client
+	config Config
+	ttl    time.Duration
+	now    func() time.Time
+
+	mu     sync.Mutex
+	report *ReadinessReport
+	// refreshing is closed when the probe in flight, if any, completes.
+	refreshing chan struct{}
+}
+
+func newReadinessProbe(client *http.Client, config Config) *readinessProbe {
+	return &readinessProbe{client: client, config: config, ttl: readinessCacheTTL, now: time.Now}
+}
+
+func timeCheck(name string, probe func() error) DependencyCheck {
+	start := time.Now()
+	err := probe()
+
+	check := DependencyCheck{
+		Name:      name,
+		Status:    dependencyUp,
+		LatencyMs: time.Since(start).Milliseconds(),
+	}
+
+	if err != nil {
+		check.Status = dependencyDown
+		check.Error = err.Error()
+	}
+
+	return check
+}
+
+// check returns the cached report, or waits for a fresh one. Concurrent
+// callers share a single probe, which runs without holding the lock and
+// outlives a caller that gives up. The probe runs on a context of its own,
+// so it carries no request ID or API key budget of the caller that
+// started it.
+func (p *readinessProbe) check(ctx context.Context) ReadinessReport {
+	p.mu.Lock()
+
+	if p.report != nil && p.now().Sub(p.report.CheckedAt) < p.ttl {
+		report := *p.report
+		p.mu.Unlock()
+
+		return report
+	}
+
+	if p.refreshing == nil {
+		p.refreshing = make(chan struct{})
+
+		go p.refresh(withConfig(context.Background(), p.config), p.refreshing)
+	}
+
+	refreshing := p.refreshing
+	p.mu.Unlock()
+
+	select {
+	case <-refreshing:
+	case <-ctx.Done():
+		return ReadinessReport{
+			Status:    readinessUnavailable,
+			Service:   "poppy-journey-planner",
+			Build:     currentBuildInfo(),
+			CheckedAt: p.now().UTC(),
+			Checks:    []DependencyCheck{},
+			Degraded:  []string{},
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return *p.report
+}
+
+func (p *readinessProbe) refresh(ctx context.Context, done chan struct{}) {
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+
+	report := p.probe(ctx)
+
+	p.mu.Lock()
+	p.report = &report
+	p.refreshing = nil
+	p.mu.Unlock()
+
+	close(done)
+}
+
+// probe calls every Poppy upstream once. Pricing and geozones are probed
+// for the first vehicle of the fleet. ORS is judged by its recent answers.
+func (p *readinessProbe) probe(ctx context.Context) ReadinessReport {
+	var (
+		vehicles []Vehicle
+		wg       sync.WaitGroup
+	)
+
+	checks := make([]DependencyCheck, 4)
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		checks[3] = p.probeORS(ctx)
+	}()
+
+	checks[0] = timeCheck("poppy_vehicles", func() error {
+		var err error
+
+		vehicles, err = fetchVehicles(ctx, p.client)
+		if err == nil && len(vehicles) == 0 {
+			err = errors.New("no vehicles available")
+		}
+
+		return err
+	})
+
+	if checks[0].Status == dependencyUp {
+		vehicle := vehicles[0]
+
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+
+			checks[1] = timeCheck("poppy_pricing", func() error {
+				_, err := fetchPricing(ctx, p.client, vehicle.Model.Type, vehicle.Model.Tier)
+
+				return err
+			})
+		}()
+
+		go func() {
+			defer wg.Done()
+
+			checks[2] = timeCheck("poppy_geozones", func() error {
+				_, err := fetchGeoZone(ctx, p.client, vehicle.UUID)
+
+				return err
+			})
+		}()
+	} else {
+		checks[1] = DependencyCheck{Name: "poppy_pricing", Status: dependencySkipped}
+		checks[2] = DependencyCheck{Name: "poppy_geozones", Status: dependencySkipped}
+	}
+
+	wg.Wait()
+
+	return summarizeReadiness(checks, p.now().UTC())
+}
+
+func (p *readinessProbe) probeORS(ctx context.Context) DependencyCheck {
+	if p.config.ORSAPIKey == "" {
+		return DependencyCheck{Name: "openrouteservice", Status: dependencyDisabled}
+	}
+
+	if check, ok := orsOutcome.recent("openrouteservice", orsProbeInterval); ok {
+		return check
+	}
+
+	// Two points in central Brussels, a short and cheap route. Its outcome
+	// is recorded by traceUpstream like that of any other ORS call.
+	return timeCheck("openrouteservice", func() error {
+		_, err := fetchORSRoute(ctx, p.client, 50.8466, 4.3528, 50.8427, 4.3601, "driving-car")
+
+		return err
+	})
+}
+
+// summarizeReadiness derives the overall status: without vehicles or
+// pricing nothing can be planned, while missing geozones or routing only
+// degrade the plans.
+func summarizeReadiness(checks []DependencyCheck, checkedAt time.Time) ReadinessReport {
+	report := ReadinessReport{
+		Status:    readinessReady,
+		Service:   "poppy-journey-planner",
+		Build:     currentBuildInfo(),
+		CheckedAt: checkedAt,
+		Checks:    checks,
+		Degraded:  []string{},
+	}
+
+	for _, check := range checks {
+		if check.Status == dependencyUp {
+			continue
+		}
+
+		switch check.Name {
+		case "poppy_vehicles", "poppy_pricing":
+			report.Status = readinessUnavailable
+		case "poppy_geozones":
+			report.Degraded = append(report.Degraded, "parking zones not enforced")
+		case "openrouteservice":
+			report.Degraded = append(report.Degraded, "routing on fallback")
+		}
+	}
+
+	if report.Status == readinessReady && len(report.Degraded) > 0 {
+		report.Status = readinessDegraded
+	}
+
+	return report
+}
+
+// readinessHandler answers 503 while the planner cannot price journeys, so
+// load balancers route around the instance.
+func readinessHandler(probe *readinessProbe) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := probe.check(r.Context())
+
+		status := http.StatusOK
+		if report.Status == readinessUnavailable {
+			status = http.StatusServiceUnavailable
+		}
+
+		respondJSON(w, status, APIResponse{
+			Success: report.Status != readinessUnavailable,
+			Data:    report,
+		})
+	}
+}
@@ -0,0 +1,280 @@
+//nolint:package-comments,revive,mnd,exhaustruct
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// newTestPoppyClient answers the Poppy endpoints from fixtures, failing
+// the paths containing failing.
+func newTestPoppyClient(calls *atomic.Int32, failing string) *http.Client {
+	return &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		calls.Add(1)
+
+		if failing != "" && strings.Contains(r.URL.Path, failing) {
+			return nil, errors.New("connection refused")
+		}
+
+		body := "{}"
+		if strings.HasSuffix(r.URL.Path, "/vehicles") {
+			body = `[{"uuid":"v1","model":{"type":"car","tier":"S"}}]`
+		}
+
+		if strings.HasSuffix(r.URL.Path, "/pay-per-use") {
+			body = `{"pricingPerMinute":{"type":"pricingPlanPerMinute","unlockFee":1000,"minutePrice":290}}`
+		}
+
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(body)),
+		}, nil
+	})}
+}
+
+func TestSummarizeReadiness(t *testing.T) {
+	up := func(name string) DependencyCheck { return DependencyCheck{Name: name, Status: dependencyUp} }
+	down := func(name string) DependencyCheck { return DependencyCheck{Name: name, Status: dependencyDown} }
+
+	tests := []struct {
+		name     string
+		checks   []DependencyCheck
+		expected readinessStatus
+		degraded []string
+	}{
+		{
+			name:     "All up",
+			checks:   []DependencyCheck{up("poppy_vehicles"), up("poppy_pricing"), up("poppy_geozones"), up("openrouteservice")},
+			expected: readinessReady,
+			degraded: []string{},
+		},
+		{
+			name: "ORS disabled",
+			checks: []DependencyCheck{
+				up("poppy_vehicles"), up("poppy_pricing"), up("poppy_geozones"),
+				{Name: "openrouteservice", Status: dependencyDisabled},
+			},
+			expected: readinessDegraded,
+			degraded: []string{"routing on fallback"},
+		},
+		{
+			name:     "Geozones down",
+			checks:   []DependencyCheck{up("poppy_vehicles"), up("poppy_pricing"), down("poppy_geozones"), up("openrouteservice")},
+			expected: readinessDegraded,
+			degraded: []string{"parking zones not enforced"},
+		},
+		{
+			name:     "Pricing down",
+			checks:   []DependencyCheck{up("poppy_vehicles"), down("poppy_pricing"), up("poppy_geozones"), down("openrouteservice")},
+			expected: readinessUnavailable,
+			degraded: []string{"routing on fallback"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := summarizeReadiness(tt.checks, time.Now())
+
+			if report.Status != tt.expected {
+				t.Errorf("Expected status %s but got %s", tt.expected, report.Status)
+			}
+
+			if strings.Join(report.Degraded, ",") != strings.Join(tt.degraded, ",") {
+				t.Errorf("Expected degraded modes %v but got %v", tt.degraded, report.Degraded)
+			}
+		})
+	}
+}
+
+func TestReadinessProbe_Check(t *testing.T) {
+	var calls atomic.Int32
+
+	now := time.Date(2025, 6, 2, 9, 0, 0, 0, time.UTC)
+	probe := newReadinessProbe(newTestPoppyClient(&calls, ""), defaultConfig())
+	probe.now = func() time.Time { return now }
+
+	report := probe.check(t.Context())
+	if report.Status != readinessDegraded || len(report.Checks) != 4 || calls.Load() != 3 {
+		t.Fatalf("Expected three Poppy calls and routing on fallback but got %d calls and %+v", calls.Load(), report)
+	}
+
+	if ors := report.Checks[3]; ors.Status != dependencyDisabled {
+		t.Errorf("Expected ORS to be disabled without a key but got %+v", ors)
+	}
+
+	now = now.Add(readinessCacheTTL / 2)
+	probe.check(t.Context())
+
+	if calls.Load() != 3 {
+		t.Errorf("Expected the cached report to be reused but got %d calls", calls.Load())
+	}
+
+	now = now.Add(readinessCacheTTL)
+	probe.check(t.Context())
+
+	if calls.Load() != 6 {
+		t.Errorf("Expected the report to be refreshed after the TTL but got %d calls", calls.Load())
+	}
+}
+
+func TestReadinessProbe_ORSFromRecentOutcomes(t *testing.T) {
+	var orsCalls atomic.Int32
+
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		body := "{}"
+
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/vehicles"):
+			body = `[{"uuid":"v1","model":{"type":"car","tier":"S"}}]`
+		case strings.HasSuffix(r.URL.Path, "/pay-per-use"):
+			body = `{"pricingPerMinute":{"type":"pricingPlanPerMinute","unlockFee":1000,"minutePrice":290}}`
+		case strings.Contains(r.URL.Path, "/directions/"):
+			orsCalls.Add(1)
+			body = `{"routes":[{"summary":{"duration":120}}]}`
+		}
+
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body))}, nil
+	})}
+
+	config := defaultConfig()
+	config.ORSAPIKey = "ors-key"
+
+	now := time.Date(2025, 6, 2, 9, 0, 0, 0, time.UTC)
+	probe := newReadinessProbe(client, config)
+	probe.now = func() time.Time { return now }
+
+	previous := orsOutcome
+	orsOutcome = &upstreamOutcome{now: probe.now}
+	t.Cleanup(func() { orsOutcome = previous })
+
+	orsOutcome.observe(time.Second, errors.New("[fetchORSRoute] API returned status 503"))
+
+	ctx := t.Context()
+
+	if ors := probe.check(ctx).Checks[3]; ors.Status != dependencyDown || orsCalls.Load() != 0 {
+		t.Fatalf("Expected the failed plan call to be reported without probing but got %+v and %d calls", ors, orsCalls.Load())
+	}
+
+	now = now.Add(orsProbeInterval)
+
+	if ors := probe.check(ctx).Checks[3]; ors.Status != dependencyUp || orsCalls.Load() != 1 {
+		t.Fatalf("Expected ORS to be probed once the outcome is stale but got %+v and %d calls", ors, orsCalls.Load())
+	}
+
+	now = now.Add(readinessCacheTTL)
+
+	if ors := probe.check(ctx).Checks[3]; ors.Status != dependencyUp || orsCalls.Load() != 1 {
+		t.Errorf("Expected the probe's own outcome to be reused but got %+v and %d calls", ors, orsCalls.Load())
+	}
+}
+
+func TestReadinessProbe_OwnContext(t *testing.T) {
+	var leaked atomic.Int32
+
+	config := defaultConfig()
+	config.PoppyURL = "http://poppy.test/api/v3"
+
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		ctx := r.Context()
+		if requestIDFromContext(ctx) != "" || apiClientFromContext(ctx) != nil || r.URL.Host != "poppy.test" {
+			leaked.Add(1)
+		}
+
+		return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: io.NopCloser(strings.NewReader("{}"))}, nil
+	})}
+
+	ctx := context.WithValue(t.Context(), requestIDKey{}, "caller")
+	ctx = withORSBudget(withConfig(ctx, defaultConfig()), &apiClient{key: APIKey{Name: "alice"}})
+
+	newReadinessProbe(client, config).check(ctx)
+
+	if leaked.Load() != 0 {
+		t.Errorf("Expected the probe to run on its own context and config but %d calls did not", leaked.Load())
+	}
+}
+
+func TestReadinessProbe_SharedProbe(t *testing.T) {
+	var calls atomic.Int32
+
+	probe := newReadinessProbe(newTestPoppyClient(&calls, ""), defaultConfig())
+
+	cancelled, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	if report := probe.check(cancelled); report.Status != readinessUnavailable {
+		t.Errorf("Expected a caller that gave up to be told unavailable but got %s", report.Status)
+	}
+
+	var wg sync.WaitGroup
+
+	for range 5 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if report := probe.check(t.Context()); report.Status != readinessDegraded {
+				t.Errorf("Expected the shared report but got %s", report.Status)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if calls.Load() != 3 {
+		t.Errorf("Expected a single probe of three Poppy calls but got %d calls", calls.Load())
+	}
+}
+
+func TestReadinessHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		failing  string
+		expected int
+		status   readinessStatus
+	}{
+		{name: "Degraded", expected: http.StatusOK, status: readinessDegraded},
+		{name: "Geozones down", failing: "/geozones/", expected: http.StatusOK, status: readinessDegraded},
+		{name: "Vehicles down", failing: "/vehicles", expected: http.StatusServiceUnavailable, status: readinessUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+
+			recorder := httptest.NewRecorder()
+			handler := readinessHandler(newReadinessProbe(newTestPoppyClient(&calls, tt.failing), defaultConfig()))
+			handler(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/health/ready", nil))
+
+			if recorder.Code != tt.expected {
+				t.Fatalf("Expected status %d but got %d", tt.expected, recorder.Code)
+			}
+
+			var response struct {
+				Data ReadinessReport `json:"data"`
+			}
+
+			_ = json.NewDecoder(recorder.Body).Decode(&response)
+
+			if response.Data.Status != tt.status || response.Data.Build.Version == "" {
+				t.Errorf("Expected a %s report with a version but got %+v", tt.status, response.Data)
+			}
+		})
+	}
+}
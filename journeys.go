@@ -0,0 +1,373 @@
+//nolint:package-comments,revive,mnd,exhaustruct
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxQuoteHistory bounds the quotes kept per saved journey; the oldest are
+// dropped first.
+const maxQuoteHistory = 100
+
+type SavedJourneyInput struct {
+	Name    string   `json:"name"`
+	Tags    []string `json:"tags"`
+	Journey Journey  `json:"journey"`
+}
+
+// SavedJourney is a journey saved under a name. Its Owner is the API key
+// that created it.
+type SavedJourney struct {
+	ID        string         `json:"id"`
+	Name      string         `json:"name"`
+	Owner     string         `json:"owner"`
+	Tags      []string       `json:"tags"`
+	Journey   Journey        `json:"journey"`
+	CreatedAt time.Time      `json:"createdAt"`
+	UpdatedAt time.Time      `json:"updatedAt"`
+	Quotes    []JourneyQuote `json:"quotes"`
+}
+
+// JourneyQuote is the outcome of planning a saved journey at a point in
+// time, kept to compare prices across re-quotes.
+type JourneyQuote struct {
+	QuoteID             string        `json:"quoteId,omitempty"`
+	QuotedAt            time.Time     `json:"quotedAt"`
+	TotalCost           float64       `json:"totalCost"`
+	PricingModel        pricingPlan   `json:"pricingModel"`
+	CostBreakdown       CostBreakdown `json:"costBreakdown"`
+	VehiclePlate        string        `json:"vehiclePlate"`
+	UsedFallbackRouting bool          `json:"usedFallbackRouting"`
+}
+
+type RequoteResult struct {
+	Plan     *JourneyPlan  `json:"plan"`
+	Quote    JourneyQuote  `json:"quote"`
+	Previous *JourneyQuote `json:"previous,omitempty"`
+	// CostChange is the difference with the previous quote in euros.
+	CostChange *float64 `json:"costChange,omitempty"`
+}
+
+type journeyStore = jsonStore[SavedJourney]
+
+func newJourneyQuote(plan *JourneyPlan, now time.Time) JourneyQuote {
+	return JourneyQuote{
+		QuoteID:             plan.QuoteID,
+		QuotedAt:            now,
+		TotalCost:           plan.TotalCost,
+		PricingModel:        plan.PricingModel,
+		CostBreakdown:       plan.CostBreakdown,
+		VehiclePlate:        plan.Vehicle.Plate,
+		UsedFallbackRouting: plan.UsedFallbackRouting,
+	}
+}
+
+// normalizeTags trims, deduplicates and sorts tags so filters match
+// regardless of how they were entered.
+func normalizeTags(tags []string) []string {
+	normalized := []string{}
+
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+
+	slices.Sort(normalized)
+
+	return normalized
+}
+
+func validateSavedJourneyInput(input SavedJourneyInput) *ValidationError {
+	if strings.TrimSpace(input.Name) != "" {
+		return nil
+	}
+
+	return newValidationError(nil, ValidationIssue{
+		Code:    validationCodeInvalidField,
+		Field:   "name",
+		Message: "Name is empty",
+		Remedy:  "Give the journey a name, e.g. \"Monday commute\"",
+	})
+}
+
+// decodeSavedJourneyInput decodes and validates a create or update body
+// and responds on failure.
+func decodeSavedJourneyInput(
+	w http.ResponseWriter,
+	r *http.Request,
+	operationPath string,
+) (SavedJourneyInput, bool) {
+	var input SavedJourneyInput
+
+	err := apiSpec.decodeRequest(r, operationPath, &input)
+	if err == nil {
+		if validationErr := validateSavedJourneyInput(input); validationErr != nil {
+			err = validationErr
+		}
+	}
+
+	if err == nil {
+		return input, true
+	}
+
+	if respondBodyTooLarge(w, r, err) {
+		return input, false
+	}
+
+	if errors.Is(err, errInvalidRequestBody) {
+		respondJSON(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid JSON request body",
+		})
+
+		return input, false
+	}
+
+	respondJSON(w, http.StatusBadRequest, APIResponse{
+		Success: false,
+		Error:   err.Error(),
+		Issues:  validationIssues(err),
+	})
+
+	return input, false
+}
+
+func respondJourneyNotFound(w http.ResponseWriter) {
+	respondJSON(w, http.StatusNotFound, APIResponse{
+		Success: false,
+		Error:   "Journey not found",
+	})
+}
+
+func respondStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errNotFound) {
+		respondJourneyNotFound(w)
+
+		return
+	}
+
+	respondJSON(w, http.StatusInternalServerError, APIResponse{
+		Success: false,
+		Error:   err.Error(),
+	})
+}
+
+func createJourneyHandler(store *journeyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		input, ok := decodeSavedJourneyInput(w, r, "/api/v1/journeys")
+		if !ok {
+			return
+		}
+
+		now := time.Now().UTC()
+		saved := SavedJourney{
+			ID:        newID(),
+			Name:      strings.TrimSpace(input.Name),
+			Owner:     requestOwner(r.Context()),
+			Tags:      normalizeTags(input.Tags),
+			Journey:   input.Journey,
+			CreatedAt: now,
+			UpdatedAt: now,
+			Quotes:    []JourneyQuote{},
+		}
+
+		if err := store.put(saved.ID, saved); err != nil {
+			respondStoreError(w, err)
+
+			return
+		}
+
+		respondJSON(w, http.StatusCreated, APIResponse{
+			Success: true,
+			Data:    saved,
+		})
+	}
+}
+
+// listJourneysHandler lists the saved journeys the caller may see by name,
+// optionally filtered on owner and tag.
+func listJourneysHandler(store *journeyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := r.URL.Query().Get("owner")
+		tag := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("tag")))
+
+		journeys := slices.DeleteFunc(store.list(), func(saved SavedJourney) bool {
+			return !canAccessOwner(r.Context(), saved.Owner) ||
+				(owner != "" && saved.Owner != owner) ||
+				(tag != "" && !slices.Contains(saved.Tags, tag))
+		})
+
+		slices.SortFunc(journeys, func(a, b SavedJourney) int {
+			if byName := strings.Compare(a.Name, b.Name); byName != 0 {
+				return byName
+			}
+
+			return strings.Compare(a.ID, b.ID)
+		})
+
+		respondJSON(w, http.StatusOK, APIResponse{
+			Success: true,
+			Data:    journeys,
+		})
+	}
+}
+
+func getJourneyHandler(store *journeyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		saved, ok := store.get(r.PathValue("id"))
+		if !ok || !canAccessOwner(r.Context(), saved.Owner) {
+			respondJourneyNotFound(w)
+
+			return
+		}
+
+		respondJSON(w, http.StatusOK, APIResponse{
+			Success: true,
+			Data:    saved,
+		})
+	}
+}
+
+// updateJourneyHandler replaces the journey, its name and tags. The owner
+// and quote history are kept, so later quotes can be compared with earlier
+// ones.
+func updateJourneyHandler(store *journeyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		input, ok := decodeSavedJourneyInput(w, r, "/api/v1/journeys/{id}")
+		if !ok {
+			return
+		}
+
+		saved, err := store.update(r.PathValue("id"), func(saved *SavedJourney) error {
+			if !canAccessOwner(r.Context(), saved.Owner) {
+				return errNotFound
+			}
+
+			saved.Name = strings.TrimSpace(input.Name)
+			saved.Tags = normalizeTags(input.Tags)
+			saved.Journey = input.Journey
+			saved.UpdatedAt = time.Now().UTC()
+
+			return nil
+		})
+		if err != nil {
+			respondStoreError(w, err)
+
+			return
+		}
+
+		respondJSON(w, http.StatusOK, APIResponse{
+			Success: true,
+			Data:    saved,
+		})
+	}
+}
+
+func deleteJourneyHandler(store *journeyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if saved, ok := store.get(r.PathValue("id")); !ok || !canAccessOwner(r.Context(), saved.Owner) {
+			respondJourneyNotFound(w)
+
+			return
+		}
+
+		if err := store.delete(r.PathValue("id")); err != nil {
+			respondStoreError(w, err)
+
+			return
+		}
+
+		respondJSON(w, http.StatusOK, APIResponse{Success: true})
+	}
+}
+
+// requoteJourneyHandler plans a saved journey against the current fleet
+// and pricing, and records the outcome in its quote history.
+func requoteJourneyHandler(
+	client *http.Client,
+	cache *upstreamCache,
+	addressGeocoder geocoder,
+	quotes *quoteStore,
+	store *journeyStore,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+
+		saved, ok := store.get(id)
+		if !ok || !canAccessOwner(r.Context(), saved.Owner) {
+			respondJourneyNotFound(w)
+
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		plan, err := planJourney(ctx, client, cache, addressGeocoder, saved.Journey)
+		if err != nil {
+			respondJSON(w, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error:   err.Error(),
+				Issues:  validationIssues(err),
+			})
+
+			return
+		}
+
+		quotes.recordOrWarn(ctx, plan)
+
+		result := RequoteResult{
+			Plan:  plan,
+			Quote: newJourneyQuote(plan, time.Now().UTC()),
+		}
+
+		_, err = store.update(id, func(saved *SavedJourney) error {
+			if len(saved.Quotes) > 0 {
+				previous := saved.Quotes[len(saved.Quotes)-1]
+				change := result.Quote.TotalCost - previous.TotalCost
+				result.Previous = &previous
+				result.CostChange = &change
+			}
+
+			saved.Quotes = append(saved.Quotes, result.Quote)
+			if len(saved.Quotes) > maxQuoteHistory {
+				saved.Quotes = slices.Clone(saved.Quotes[len(saved.Quotes)-maxQuoteHistory:])
+			}
+
+			return nil
+		})
+		if err != nil {
+			respondStoreError(w, err)
+
+			return
+		}
+
+		respondJSON(w, http.StatusOK, APIResponse{
+			Success: true,
+			Data:    result,
+		})
+	}
+}
+
+func journeyQuotesHandler(store *journeyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		saved, ok := store.get(r.PathValue("id"))
+		if !ok || !canAccessOwner(r.Context(), saved.Owner) {
+			respondJourneyNotFound(w)
+
+			return
+		}
+
+		respondJSON(w, http.StatusOK, APIResponse{
+			Success: true,
+			Data:    saved.Quotes,
+		})
+	}
+}
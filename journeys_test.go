@@ -0,0 +1,304 @@
+//nolint:package-comments,revive,mnd,exhaustruct,errchkjson
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const savedJourneyBody = `{
+	"name": "Monday commute",
+	"tags": [" Work ", "work", "daily"],
+	"journey": {"legs": [{
+		"startLocation": {"lat": 50.8466, "lng": 4.3528},
+		"endLocation": {"lat": 50.8275, "lng": 4.3745}
+	}]}
+}`
+
+// newJourneyTestServer serves the journey handlers as client, or without
+// API keys when client is nil.
+func newJourneyTestServer(t *testing.T, client *apiClient) (*httptest.Server, *journeyStore) {
+	t.Helper()
+
+	store, err := openJSONStore[SavedJourney]("")
+	if err != nil {
+		t.Fatalf("Expected an in-memory store but got %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/journeys", listJourneysHandler(store))
+	mux.HandleFunc("POST /api/v1/journeys", createJourneyHandler(store))
+	mux.HandleFunc("GET /api/v1/journeys/{id}", getJourneyHandler(store))
+	mux.HandleFunc("PUT /api/v1/journeys/{id}", updateJourneyHandler(store))
+	mux.HandleFunc("DELETE /api/v1/journeys/{id}", deleteJourneyHandler(store))
+	mux.HandleFunc("GET /api/v1/journeys/{id}/quotes", journeyQuotesHandler(store))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if client != nil {
+			r = r.WithContext(withORSBudget(r.Context(), client))
+		}
+
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, store
+}
+
+func doJourneyRequest(
+	t *testing.T,
+	method string,
+	url string,
+	body string,
+	data any,
+) (int, APIResponse) {
+	t.Helper()
+
+	request, _ := http.NewRequest(method, url, strings.NewReader(body))
+
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("Expected %s %s to succeed but got %v", method, url, err)
+	}
+	defer resp.Body.Close()
+
+	var envelope struct {
+		APIResponse
+
+		Data json.RawMessage `json:"data"`
+	}
+
+	_ = json.NewDecoder(resp.Body).Decode(&envelope)
+
+	if data != nil && len(envelope.Data) > 0 {
+		_ = json.Unmarshal(envelope.Data, data)
+	}
+
+	return resp.StatusCode, envelope.APIResponse
+}
+
+func TestJourneyHandlers_CRUD(t *testing.T) {
+	server, _ := newJourneyTestServer(t, nil)
+
+	var created SavedJourney
+
+	status, _ := doJourneyRequest(t, http.MethodPost, server.URL+"/api/v1/journeys", savedJourneyBody, &created)
+	if status != http.StatusCreated {
+		t.Fatalf("Expected 201 but got %d", status)
+	}
+
+	if created.ID == "" || created.CreatedAt.IsZero() {
+		t.Errorf("Expected an ID and creation time but got %+v", created)
+	}
+
+	if !slices.Equal(created.Tags, []string{"daily", "work"}) {
+		t.Errorf("Expected normalized tags but got %v", created.Tags)
+	}
+
+	journeyURL := server.URL + "/api/v1/journeys/" + created.ID
+
+	var fetched SavedJourney
+
+	status, _ = doJourneyRequest(t, http.MethodGet, journeyURL, "", &fetched)
+	if status != http.StatusOK || fetched.Name != "Monday commute" {
+		t.Errorf("Expected the saved journey but got %d %+v", status, fetched)
+	}
+
+	updatedBody := strings.Replace(savedJourneyBody, "Monday commute", "Tuesday commute", 1)
+
+	var updated SavedJourney
+
+	status, _ = doJourneyRequest(t, http.MethodPut, journeyURL, updatedBody, &updated)
+	if status != http.StatusOK || updated.Name != "Tuesday commute" {
+		t.Errorf("Expected the updated journey but got %d %+v", status, updated)
+	}
+
+	if !updated.CreatedAt.Equal(created.CreatedAt) {
+		t.Errorf("Expected the creation time to be kept but got %v", updated.CreatedAt)
+	}
+
+	status, _ = doJourneyRequest(t, http.MethodDelete, journeyURL, "", nil)
+	if status != http.StatusOK {
+		t.Errorf("Expected 200 on delete but got %d", status)
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		status, _ = doJourneyRequest(t, method, journeyURL, "", nil)
+		if status != http.StatusNotFound {
+			t.Errorf("Expected 404 on %s after delete but got %d", method, status)
+		}
+	}
+
+	status, _ = doJourneyRequest(t, http.MethodPut, journeyURL, savedJourneyBody, nil)
+	if status != http.StatusNotFound {
+		t.Errorf("Expected 404 on PUT after delete but got %d", status)
+	}
+}
+
+func TestJourneyHandlers_List(t *testing.T) {
+	server, store := newJourneyTestServer(t, &apiClient{key: APIKey{Name: "ops", Admin: true}})
+
+	for _, saved := range []SavedJourney{
+		{ID: "1", Name: "Zoo", Owner: "sam", Tags: []string{"weekend"}},
+		{ID: "2", Name: "Airport", Owner: "alex", Tags: []string{"work"}},
+		{ID: "3", Name: "Office", Owner: "sam", Tags: []string{"work"}},
+	} {
+		_ = store.put(saved.ID, saved)
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		expected []string
+	}{
+		{name: "All by name", query: "", expected: []string{"Airport", "Office", "Zoo"}},
+		{name: "By owner", query: "?owner=sam", expected: []string{"Office", "Zoo"}},
+		{name: "By tag", query: "?tag=WORK", expected: []string{"Airport", "Office"}},
+		{name: "By owner and tag", query: "?owner=sam&tag=work", expected: []string{"Office"}},
+		{name: "No match", query: "?owner=nobody", expected: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var journeys []SavedJourney
+
+			doJourneyRequest(t, http.MethodGet, server.URL+"/api/v1/journeys"+tt.query, "", &journeys)
+
+			names := []string{}
+			for _, saved := range journeys {
+				names = append(names, saved.Name)
+			}
+
+			if !slices.Equal(names, tt.expected) {
+				t.Errorf("Expected %v but got %v", tt.expected, names)
+			}
+		})
+	}
+}
+
+func TestJourneyHandlers_Owner(t *testing.T) {
+	server, store := newJourneyTestServer(t, &apiClient{key: APIKey{Name: "bob"}})
+
+	_ = store.put("1", SavedJourney{ID: "1", Name: "Office", Owner: "alice", Quotes: []JourneyQuote{}})
+
+	var created SavedJourney
+
+	status, _ := doJourneyRequest(t, http.MethodPost, server.URL+"/api/v1/journeys", savedJourneyBody, &created)
+	if status != http.StatusCreated || created.Owner != "bob" {
+		t.Fatalf("Expected the journey to belong to bob but got %d %+v", status, created)
+	}
+
+	var journeys []SavedJourney
+
+	doJourneyRequest(t, http.MethodGet, server.URL+"/api/v1/journeys", "", &journeys)
+
+	if len(journeys) != 1 || journeys[0].ID != created.ID {
+		t.Errorf("Expected only bob's journey to be listed but got %+v", journeys)
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		status, _ = doJourneyRequest(t, method, server.URL+"/api/v1/journeys/1", savedJourneyBody, nil)
+		if status != http.StatusNotFound {
+			t.Errorf("Expected 404 on %s of another key's journey but got %d", method, status)
+		}
+	}
+
+	status, _ = doJourneyRequest(t, http.MethodGet, server.URL+"/api/v1/journeys/1/quotes", "", nil)
+	if status != http.StatusNotFound {
+		t.Errorf("Expected 404 on the quotes of another key's journey but got %d", status)
+	}
+
+	if saved, _ := store.get("1"); saved.Name != "Office" {
+		t.Errorf("Expected alice's journey to be left alone but got %+v", saved)
+	}
+}
+
+func TestJourneyHandlers_Validation(t *testing.T) {
+	server, _ := newJourneyTestServer(t, nil)
+
+	tests := []struct {
+		name     string
+		body     string
+		expected validationCode
+	}{
+		{name: "Not JSON", body: "{", expected: ""},
+		{name: "Missing name", body: `{"journey": {"legs": []}}`, expected: validationCodeInvalidField},
+		{
+			name:     "Blank name",
+			body:     strings.Replace(savedJourneyBody, "Monday commute", "  ", 1),
+			expected: validationCodeInvalidField,
+		},
+		{name: "No legs", body: `{"name": "Empty", "journey": {"legs": []}}`, expected: validationCodeNoLegs},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, response := doJourneyRequest(t, http.MethodPost, server.URL+"/api/v1/journeys", tt.body, nil)
+			if status != http.StatusBadRequest {
+				t.Errorf("Expected 400 but got %d", status)
+			}
+
+			if tt.expected == "" {
+				return
+			}
+
+			codes := []validationCode{}
+			for _, issue := range response.Issues {
+				codes = append(codes, issue.Code)
+			}
+
+			if !slices.Contains(codes, tt.expected) {
+				t.Errorf("Expected issue %s but got %v", tt.expected, codes)
+			}
+		})
+	}
+}
+
+func TestJourneyHandlers_Quotes(t *testing.T) {
+	server, store := newJourneyTestServer(t, nil)
+
+	_ = store.put("1", SavedJourney{
+		ID:   "1",
+		Name: "Office",
+		Quotes: []JourneyQuote{
+			{TotalCost: 4.2, PricingModel: pricingPlanPerMinute},
+			{TotalCost: 3.9, PricingModel: pricingPlanSmart},
+		},
+	})
+
+	var quotes []JourneyQuote
+
+	status, _ := doJourneyRequest(t, http.MethodGet, server.URL+"/api/v1/journeys/1/quotes", "", &quotes)
+	if status != http.StatusOK || len(quotes) != 2 || quotes[1].TotalCost != 3.9 {
+		t.Errorf("Expected the quote history but got %d %+v", status, quotes)
+	}
+
+	status, _ = doJourneyRequest(t, http.MethodGet, server.URL+"/api/v1/journeys/2/quotes", "", nil)
+	if status != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown journey but got %d", status)
+	}
+}
+
+func TestNormalizeTags(t *testing.T) {
+	tests := []struct {
+		name     string
+		tags     []string
+		expected []string
+	}{
+		{name: "Nil", tags: nil, expected: []string{}},
+		{name: "Trims and lowers", tags: []string{" Work", "HOME "}, expected: []string{"home", "work"}},
+		{name: "Drops blanks and duplicates", tags: []string{"a", "", " ", "A"}, expected: []string{"a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeTags(tt.tags); !slices.Equal(got, tt.expected) {
+				t.Errorf("Expected %v but got %v", tt.expected, got)
+			}
+		})
+	}
+}
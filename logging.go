@@ -0,0 +1,179 @@
+//nolint:package-comments,revive,mnd,exhaustruct
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// newLogger builds the handler named by format ("json" or "text") at the
+// named level ("debug", "info", "warn" or "error").
+func newLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	var logLevel slog.Level
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("[newLogger] invalid log level %q: %w", level, err)
+	}
+
+	options := &slog.HandlerOptions{Level: logLevel}
+
+	var handler slog.Handler
+
+	switch strings.ToLower(format) {
+	case "json":
+		handler = slog.NewJSONHandler(w, options)
+	case "text":
+		handler = slog.NewTextHandler(w, options)
+	default:
+		return nil, fmt.Errorf("[newLogger] invalid log format %q, expected json or text", format)
+	}
+
+	return slog.New(requestIDHandler{handler}), nil
+}
+
+// setupLogging installs the default logger at the configured level and
+// format. Logs go to stderr.
+func setupLogging(config Config) error {
+	logger, err := newLogger(os.Stderr, config.LogLevel, config.LogFormat)
+	if err != nil {
+		return err
+	}
+
+	slog.SetDefault(logger)
+
+	return nil
+}
+
+// requestIDHandler adds the request ID from the context to every record,
+// so any *Context logging call is correlated with its request.
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, record slog.Record) error {
+	if requestID := requestIDFromContext(ctx); requestID != "" {
+		record.AddAttrs(slog.String("request_id", requestID))
+	}
+
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+// requestIDTransport forwards the request ID of the context to upstream
+// services, so their logs can be matched with ours.
+type requestIDTransport struct {
+	next http.RoundTripper
+}
+
+func (t requestIDTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	requestID := requestIDFromContext(r.Context())
+	if requestID == "" || r.Header.Get(requestIDHeader) != "" {
+		return t.next.RoundTrip(r)
+	}
+
+	// A RoundTripper must not modify the request it is given.
+	r = r.Clone(r.Context())
+	r.Header.Set(requestIDHeader, requestID)
+
+	return t.next.RoundTrip(r)
+}
+
+// traceUpstream records an upstream call started at start in the metrics
+// and the log. Fetchers defer it with a pointer to their named error
+// result and the fields that identify the call.
+func traceUpstream(ctx context.Context, fetcher string, start time.Time, err *error, attrs ...any) {
+	metrics.observeUpstream(fetcher, start, err)
+
+	if strings.HasPrefix(fetcher, "ors_") {
+		orsOutcome.observe(time.Since(start), *err)
+	}
+
+	attrs = append(attrs, "fetcher", fetcher, "duration_ms", time.Since(start).Milliseconds())
+
+	if *err != nil {
+		slog.WarnContext(ctx, "upstream call failed", append(attrs, "error", *err)...)
+
+		return
+	}
+
+	slog.DebugContext(ctx, "upstream call", attrs...)
+}
+
+// withAccessLog logs one line per request once it is answered. It sits
+// outside the auth middleware so rejected keys are logged as well.
+func withAccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(recorder, r)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		level := slog.LevelInfo
+		if recorder.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+
+		slog.Log(r.Context(), level, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.status,
+			"duration_ms", time.Since(start).Milliseconds(),
+			"remote_addr", r.RemoteAddr,
+		)
+	})
+}
+
+// logRoutingFallback notes a walking or driving time estimated from the
+// crow-flies distance because ORS could not answer.
+func logRoutingFallback(ctx context.Context, profile string, from, to Location, err error) {
+	slog.InfoContext(ctx, "routing fell back to crow-flies distance",
+		"profile", profile,
+		"from", fmt.Sprintf("%.5f,%.5f", from.Lat, from.Lng),
+		"to", fmt.Sprintf("%.5f,%.5f", to.Lat, to.Lng),
+		"error", err,
+	)
+}
+
+// logPlan logs the outcome of planning journey, with the issue codes of a
+// rejected plan.
+func logPlan(ctx context.Context, journey Journey, plan *JourneyPlan, err error) {
+	if err != nil {
+		codes := []string{}
+		for _, issue := range validationIssues(err) {
+			codes = append(codes, string(issue.Code))
+		}
+
+		slog.InfoContext(ctx, "journey rejected",
+			"legs", len(journey.Legs),
+			"codes", codes,
+			"error", err,
+		)
+
+		return
+	}
+
+	slog.InfoContext(ctx, "journey planned",
+		"legs", len(journey.Legs),
+		"vehicle_uuid", plan.Vehicle.UUID,
+		"plate", plan.Vehicle.Plate,
+		"pricing_plan", plan.PricingModel,
+		"total_cost", plan.TotalCost,
+		"fallback_routing", plan.UsedFallbackRouting,
+	)
+}
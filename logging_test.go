@@ -0,0 +1,162 @@
+//nolint:package-comments,revive,mnd,exhaustruct
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewLogger(t *testing.T) {
+	tests := []struct {
+		name    string
+		level   string
+		format  string
+		wantErr bool
+	}{
+		{name: "JSON", level: "info", format: "json"},
+		{name: "Text debug", level: "DEBUG", format: "text"},
+		{name: "Unknown level", level: "verbose", format: "json", wantErr: true},
+		{name: "Unknown format", level: "info", format: "logfmt", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newLogger(io.Discard, tt.level, tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v but got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// captureLogs installs a JSON logger at debug level for the duration of
+// the test and returns its output.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buffer bytes.Buffer
+
+	logger, _ := newLogger(&buffer, "debug", "json")
+	previous := slog.Default()
+	slog.SetDefault(logger)
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	return &buffer
+}
+
+func decodeLogLines(t *testing.T, buffer *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	lines := []map[string]any{}
+
+	for _, line := range strings.Split(strings.TrimSpace(buffer.String()), "\n") {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Expected a JSON log line but got %q", line)
+		}
+
+		lines = append(lines, record)
+	}
+
+	return lines
+}
+
+func TestRequestIDHandler(t *testing.T) {
+	buffer := captureLogs(t)
+
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "abc")
+	slog.InfoContext(ctx, "with id")
+	slog.Info("without id")
+
+	lines := decodeLogLines(t, buffer)
+	if lines[0]["request_id"] != "abc" {
+		t.Errorf("Expected the request ID from the context but got %v", lines[0])
+	}
+
+	if _, ok := lines[1]["request_id"]; ok {
+		t.Errorf("Expected no request ID without one in the context but got %v", lines[1])
+	}
+}
+
+func TestRequestIDTransport(t *testing.T) {
+	var received string
+
+	transport := requestIDTransport{next: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		received = r.Header.Get(requestIDHeader)
+
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})}
+
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "abc")
+	request, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://poppy.example/vehicles", nil)
+
+	if _, err := transport.RoundTrip(request); err != nil {
+		t.Fatalf("Expected the request to pass through but got %v", err)
+	}
+
+	if received != "abc" {
+		t.Errorf("Expected the request ID upstream but got %q", received)
+	}
+
+	if request.Header.Get(requestIDHeader) != "" {
+		t.Error("Expected the original request to be left untouched")
+	}
+}
+
+func TestTraceUpstream(t *testing.T) {
+	buffer := captureLogs(t)
+	errorsBefore := metrics.upstreamErrors.value("test_fetcher")
+
+	err := errors.New("connection refused")
+	traceUpstream(context.Background(), "test_fetcher", time.Now(), &err, "tier", "S")
+
+	lines := decodeLogLines(t, buffer)
+	if lines[0]["level"] != "WARN" || lines[0]["fetcher"] != "test_fetcher" ||
+		lines[0]["tier"] != "S" || lines[0]["error"] != "connection refused" {
+		t.Errorf("Expected a warning with the call fields but got %v", lines[0])
+	}
+
+	if metrics.upstreamErrors.value("test_fetcher") != errorsBefore+1 {
+		t.Error("Expected the failure to be counted")
+	}
+}
+
+func TestWithAccessLog(t *testing.T) {
+	buffer := captureLogs(t)
+
+	handler := withRequestID(withAccessLog(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})))
+
+	request := httptest.NewRequest(http.MethodGet, "/api/v1/vehicles", nil)
+	request.Header.Set(requestIDHeader, "abc")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	lines := decodeLogLines(t, buffer)
+	if lines[0]["level"] != "ERROR" || lines[0]["status"] != float64(http.StatusBadGateway) ||
+		lines[0]["path"] != "/api/v1/vehicles" || lines[0]["request_id"] != "abc" {
+		t.Errorf("Expected an error access log with the request ID but got %v", lines[0])
+	}
+}
+
+func TestLogPlan(t *testing.T) {
+	buffer := captureLogs(t)
+
+	logPlan(context.Background(), Journey{}, nil, validateJourney(Journey{}))
+
+	lines := decodeLogLines(t, buffer)
+
+	codes, _ := lines[0]["codes"].([]any)
+	if lines[0]["msg"] != "journey rejected" || len(codes) == 0 || codes[0] != string(validationCodeNoLegs) {
+		t.Errorf("Expected the rejection with its issue codes but got %v", lines[0])
+	}
+}
@@ -6,14 +6,20 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
 	"math"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -52,8 +58,10 @@ const (
 )
 
 type Location struct {
-	Lat float64 `json:"lat"`
-	Lng float64 `json:"lng"`
+	Lat     float64 `json:"lat"`
+	Lng     float64 `json:"lng"`
+	Address string  `json:"address,omitempty"`
+	Label   string  `json:"label,omitempty"`
 }
 
 type Vehicle struct {
@@ -103,6 +111,21 @@ type PricingResponse struct {
 	SmartPricing        PricingModel `json:"smartPricing"`
 }
 
+// errEmptyTariff is returned when Poppy answers without any price, as it
+// does for unknown tiers.
+var errEmptyTariff = errors.New("no tariff")
+
+// empty reports whether none of the pricing models carries a price.
+func (p *PricingResponse) empty() bool {
+	for _, model := range []PricingModel{p.PricingPerMinute, p.PricingPerKilometer, p.SmartPricing} {
+		if model.UnlockFee != 0 || model.MinutePrice != 0 || model.KilometerPrice != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
 type GeoZoneItem struct {
 	GeofencingType string     `json:"geofencingType"`
 	ModelType      string     `json:"modelType"`
@@ -125,17 +148,41 @@ type TripLeg struct {
 }
 
 type Journey struct {
-	Legs []TripLeg `json:"legs"`
+	Legs          []TripLeg  `json:"legs"`
+	DepartureTime *time.Time `json:"departureTime,omitempty"`
+}
+
+type PlanJourneyRequest struct {
+	Journey Journey `json:"journey"`
 }
 
 type JourneyPlan struct {
-	Vehicle             Vehicle       `json:"vehicle"`
-	Journey             Journey       `json:"journey"`
-	TotalCost           float64       `json:"totalCost"`
-	CostBreakdown       CostBreakdown `json:"costBreakdown"`
-	PricingModel        pricingPlan   `json:"pricingModel"`
-	UsedFallbackRouting bool          `json:"usedFallbackRouting"`
-	RoutingWarning      string        `json:"routingWarning,omitempty"`
+	Vehicle             Vehicle            `json:"vehicle"`
+	Journey             Journey            `json:"journey"`
+	TotalCost           float64            `json:"totalCost"`
+	CostBreakdown       CostBreakdown      `json:"costBreakdown"`
+	PricingModel        pricingPlan        `json:"pricingModel"`
+	UsedFallbackRouting bool               `json:"usedFallbackRouting"`
+	RoutingWarning      string             `json:"routingWarning,omitempty"`
+	ParkingSuggestion   *ParkingSuggestion `json:"parkingSuggestion,omitempty"`
+	LegZones            []LegZones         `json:"legZones,omitempty"`
+	Warnings            []ValidationIssue  `json:"warnings,omitempty"`
+	Timeline            []TimelineEvent    `json:"timeline,omitempty"`
+	QuoteID             string             `json:"quoteId,omitempty"`
+	QuoteExpiresAt      *time.Time         `json:"quoteExpiresAt,omitempty"`
+
+	route journeyRoute
+	// request and data are the journey as submitted and the upstream data
+	// it was planned with, kept for the quote snapshot.
+	request Journey
+	data    *planningData
+}
+
+type ParkingSuggestion struct {
+	Location            Location `json:"location"`
+	OriginalDestination Location `json:"originalDestination"`
+	WalkingMinutes      float64  `json:"walkingMinutes"`
+	Message             string   `json:"message"`
 }
 
 type CostBreakdown struct {
@@ -144,6 +191,14 @@ type CostBreakdown struct {
 	TravelCost  float64 `json:"travelCost"`
 	PauseCost   float64 `json:"pauseCost"`
 	WalkingTime float64 `json:"walkingTimeMinutes"`
+	// Legs splits the travel and pause cost over the legs of the journey.
+	// Unlock, booking and the day cap apply to the whole journey.
+	Legs []LegCost `json:"legs,omitempty"`
+}
+
+type LegCost struct {
+	TravelCost float64 `json:"travelCost"`
+	PauseCost  float64 `json:"pauseCost"`
 }
 
 type orsResponse struct {
@@ -159,21 +214,19 @@ type orsSummary struct {
 }
 
 const (
-	averageWalkingSpeedKmh = 5.0
-	averageDrivingSpeedKmh = 25.0
-	freeBookingMinutes     = 15
-	priceUnitFactor        = 1000.0
-	brusselsUUID           = "a88ea9d0-3d5e-4002-8bbf-775313a5973c"
-	apiURL                 = "https://poppy.red/api/v3"
-	orsBaseURL             = "https://api.openrouteservice.org/v2/directions"
-	orsTimeout             = 5 * time.Second
+	priceUnitFactor = 1000.0
+	brusselsUUID    = "a88ea9d0-3d5e-4002-8bbf-775313a5973c"
 )
 
+const approximateRoutingWarning = "Using estimated travel times (OpenRouteService unavailable)"
+
 func fetchVehicles(
 	ctx context.Context,
 	client *http.Client,
-) ([]Vehicle, error) {
-	targetURL, err := url.JoinPath(apiURL, "cities", brusselsUUID, "vehicles")
+) (_ []Vehicle, err error) {
+	defer traceUpstream(ctx, "poppy_vehicles", time.Now(), &err)
+
+	targetURL, err := url.JoinPath(configFromContext(ctx).PoppyURL, "cities", brusselsUUID, "vehicles")
 	if err != nil {
 		return nil, fmt.Errorf("[fetchVehicles] could not parse URL: %w", err)
 	}
@@ -223,8 +276,10 @@ func fetchPricing(
 	client *http.Client,
 	modelType vehicleModelType,
 	tier string,
-) (*PricingResponse, error) {
-	targetURL, err := url.JoinPath(apiURL, "pricing", "pay-per-use")
+) (_ *PricingResponse, err error) {
+	defer traceUpstream(ctx, "poppy_pricing", time.Now(), &err, "model_type", modelType, "tier", tier)
+
+	targetURL, err := url.JoinPath(configFromContext(ctx).PoppyURL, "pricing", "pay-per-use")
 	if err != nil {
 		return nil, fmt.Errorf("[fetchPricing] could not parse URL: %w", err)
 	}
@@ -262,12 +317,20 @@ func fetchPricing(
 
 	defer func() { _ = res.Body.Close() }()
 
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, fmt.Errorf("[fetchPricing] API returned status %d", res.StatusCode)
+	}
+
 	var pricing PricingResponse
 
 	if err := json.NewDecoder(res.Body).Decode(&pricing); err != nil {
 		return nil, fmt.Errorf("[fetchPricing] error decoding pricing: %w", err)
 	}
 
+	if pricing.empty() {
+		return nil, fmt.Errorf("[fetchPricing] %w for %s tier %q", errEmptyTariff, modelType, tier)
+	}
+
 	return &pricing, nil
 }
 
@@ -275,8 +338,10 @@ func fetchGeoZone(
 	ctx context.Context,
 	client *http.Client,
 	vehicleUUID string,
-) (*GeoZone, error) {
-	targetURL, err := url.JoinPath(apiURL, "geozones", vehicleUUID)
+) (_ *GeoZone, err error) {
+	defer traceUpstream(ctx, "poppy_geozones", time.Now(), &err, "vehicle_uuid", vehicleUUID)
+
+	targetURL, err := url.JoinPath(configFromContext(ctx).PoppyURL, "geozones", vehicleUUID)
 	if err != nil {
 		return nil, fmt.Errorf("[fetchGeoZone] could not parse URL: %w", err)
 	}
@@ -316,13 +381,21 @@ func fetchORSRoute(
 	toLat float64,
 	toLng float64,
 	profile string,
-) (float64, error) {
-	apiKey := os.Getenv("ORS_API_KEY")
+) (_ float64, err error) {
+	apiKey := configFromContext(ctx).ORSAPIKey
 	if apiKey == "" {
-		return 0, errors.New("ORS_API_KEY not set")
+		return 0, errors.New("ORS API key not set")
 	}
 
-	targetURL, err := url.JoinPath(orsBaseURL, profile, "json")
+	if err := spendORSBudget(ctx); err != nil {
+		return 0, err
+	}
+
+	defer traceUpstream(ctx, "ors_directions", time.Now(), &err, "profile", profile)
+
+	config := configFromContext(ctx)
+
+	targetURL, err := url.JoinPath(config.ORSDirectionsURL, profile, "json")
 	if err != nil {
 		return 0, fmt.Errorf("[fetchORSRoute] could not parse URL: %w", err)
 	}
@@ -339,7 +412,7 @@ func fetchORSRoute(
 		return 0, fmt.Errorf("[fetchORSRoute] error marshaling request: %w", err)
 	}
 
-	ctxWithTimeout, cancel := context.WithTimeout(ctx, orsTimeout)
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, config.ORSTimeout)
 	defer cancel()
 
 	req, err := http.NewRequestWithContext(
@@ -397,33 +470,148 @@ func calculateDistance(lat1, lng1, lat2, lng2 float64) float64 {
 	return earthRadiusKm * centralAngle
 }
 
-func isInParkingZone(location Location, geozone *GeoZone) bool {
+func parkingPolygons(geozone *GeoZone) []orb.Polygon {
 	if geozone == nil {
-		return false
+		return nil
 	}
 
-	point := orb.Point{location.Lng, location.Lat}
+	var polygons []orb.Polygon
 
 	for _, item := range *geozone {
-		if item.GeofencingType != "parking" || item.ModelType != "car" {
+		if classifyGeofencingType(item.GeofencingType) != zoneKindParking ||
+			item.ModelType != string(vehicleModelTypeCar) {
 			continue
 		}
 
 		switch geom := item.Geom.Geometry.Geometry().(type) {
 		case orb.Polygon:
-			if planar.PolygonContains(geom, point) {
-				return true
-			}
+			polygons = append(polygons, geom)
 		case orb.MultiPolygon:
-			for _, polygon := range geom {
-				if planar.PolygonContains(polygon, point) {
-					return true
+			polygons = append(polygons, geom...)
+		}
+	}
+
+	return polygons
+}
+
+// findNearestParkingSpot returns the point inside a parking zone that is
+// closest to location. Distances are compared in a local equirectangular
+// projection, which is accurate enough at city scale.
+func findNearestParkingSpot(location Location, zones *zoneIndex) (Location, bool) {
+	lngScale := math.Cos(location.Lat * math.Pi / 180)
+	origin := orb.Point{location.Lng * lngScale, location.Lat}
+
+	var (
+		closest orb.Point
+		found   bool
+	)
+
+	minDistance := math.Inf(1)
+
+	for _, polygon := range parkingPolygons(zones.zone()) {
+		for _, ring := range polygon {
+			for i := 1; i < len(ring); i++ {
+				point := closestPointOnSegment(
+					origin,
+					orb.Point{ring[i-1][0] * lngScale, ring[i-1][1]},
+					orb.Point{ring[i][0] * lngScale, ring[i][1]},
+				)
+
+				distance := planar.DistanceSquared(origin, point)
+				if distance >= minDistance {
+					continue
 				}
+
+				minDistance = distance
+				closest = point
+				found = true
 			}
 		}
 	}
 
-	return false
+	if !found {
+		return Location{}, false
+	}
+
+	// A point on the boundary is ambiguous for containment tests, so step
+	// slightly past it (roughly 10cm, 1m, then 10m) into the zone.
+	directionX := closest[0] - origin[0]
+	directionY := closest[1] - origin[1]
+	norm := math.Hypot(directionX, directionY)
+
+	if norm == 0 {
+		return location, true
+	}
+
+	for _, step := range []float64{1e-6, 1e-5, 1e-4} {
+		candidate := Location{
+			Lat: closest[1] + directionY/norm*step,
+			Lng: (closest[0] + directionX/norm*step) / lngScale,
+		}
+
+		if zones.inParkingZone(candidate) {
+			return candidate, true
+		}
+	}
+
+	return Location{}, false
+}
+
+func closestPointOnSegment(point, start, end orb.Point) orb.Point {
+	segmentX := end[0] - start[0]
+	segmentY := end[1] - start[1]
+
+	lengthSquared := segmentX*segmentX + segmentY*segmentY
+	if lengthSquared == 0 {
+		return start
+	}
+
+	t := ((point[0]-start[0])*segmentX + (point[1]-start[1])*segmentY) / lengthSquared
+	t = math.Max(0, math.Min(1, t))
+
+	return orb.Point{start[0] + t*segmentX, start[1] + t*segmentY}
+}
+
+// suggestParkingSpot moves the final destination of a journey to the nearest
+// legal parking spot when it lies outside every parking zone. The returned
+// suggestion is nil when the destination is already valid or no spot exists.
+func suggestParkingSpot(
+	ctx context.Context,
+	client *http.Client,
+	journey Journey,
+	zones *zoneIndex,
+) (Journey, *ParkingSuggestion, bool) {
+	if zones == nil || len(journey.Legs) == 0 {
+		return journey, nil, false
+	}
+
+	destination := journey.Legs[len(journey.Legs)-1].EndLocation
+	if zones.inParkingZone(destination) {
+		return journey, nil, false
+	}
+
+	spot, found := findNearestParkingSpot(destination, zones)
+	if !found {
+		return journey, nil, false
+	}
+
+	walkingTime, isApproximate := calculateWalkingTime(ctx, client, spot, destination)
+
+	adjusted := journey
+	adjusted.Legs = slices.Clone(journey.Legs)
+	adjusted.Legs[len(adjusted.Legs)-1].EndLocation = spot
+
+	return adjusted, &ParkingSuggestion{
+		Location:            spot,
+		OriginalDestination: destination,
+		WalkingMinutes:      walkingTime,
+		Message: fmt.Sprintf(
+			"Park at %.5f, %.5f and walk %.0f minutes to your destination",
+			spot.Lat,
+			spot.Lng,
+			math.Ceil(walkingTime),
+		),
+	}, isApproximate
 }
 
 func findClosestVehicle(location Location, vehicles []Vehicle) *Vehicle {
@@ -465,7 +653,7 @@ func calculateWalkingTime(
 	fromLocation Location,
 	toLocation Location,
 ) (walkingTime float64, isApproximate bool) {
-	if duration, err := fetchORSRoute(
+	duration, err := fetchORSRoute(
 		ctx,
 		client,
 		fromLocation.Lat,
@@ -473,10 +661,16 @@ func calculateWalkingTime(
 		toLocation.Lat,
 		toLocation.Lng,
 		"foot-walking",
-	); err == nil {
+	)
+	if err == nil {
+		metrics.observeRouting("foot-walking", false)
+
 		return duration, false
 	}
 
+	metrics.observeRouting("foot-walking", true)
+	logRoutingFallback(ctx, "foot-walking", fromLocation, toLocation, err)
+
 	distance := calculateDistance(
 		fromLocation.Lat,
 		fromLocation.Lng,
@@ -484,7 +678,7 @@ func calculateWalkingTime(
 		toLocation.Lng,
 	)
 
-	return (distance / averageWalkingSpeedKmh) * 60, true
+	return (distance / configFromContext(ctx).WalkingSpeedKmh) * 60, true
 }
 
 func calculateDrivingTime(
@@ -493,7 +687,7 @@ func calculateDrivingTime(
 	fromLocation Location,
 	toLocation Location,
 ) (drivingTime float64, isApproximate bool) {
-	if duration, err := fetchORSRoute(
+	duration, err := fetchORSRoute(
 		ctx,
 		client,
 		fromLocation.Lat,
@@ -501,10 +695,16 @@ func calculateDrivingTime(
 		toLocation.Lat,
 		toLocation.Lng,
 		"driving-car",
-	); err == nil {
+	)
+	if err == nil {
+		metrics.observeRouting("driving-car", false)
+
 		return duration, false
 	}
 
+	metrics.observeRouting("driving-car", true)
+	logRoutingFallback(ctx, "driving-car", fromLocation, toLocation, err)
+
 	distance := calculateDistance(
 		fromLocation.Lat,
 		fromLocation.Lng,
@@ -512,60 +712,211 @@ func calculateDrivingTime(
 		toLocation.Lng,
 	)
 
-	return (distance / averageDrivingSpeedKmh) * 60, true
+	return (distance / configFromContext(ctx).DrivingSpeedKmh) * 60, true
+}
+
+// legRoute holds the routed figures of a single leg. Together with
+// journeyRoute it is everything the pricing engine needs besides the tariff.
+type legRoute struct {
+	WalkToStartMinutes float64 `json:"walkToStartMinutes"`
+	DrivingMinutes     float64 `json:"drivingMinutes"`
+	DistanceKm         float64 `json:"distanceKm"`
+	EndInParkingZone   bool    `json:"endInParkingZone"`
+	// WaitMinutes is the time spent waiting for the leg start time. Before
+	// the first leg the car is not booked yet; before a later one it stays
+	// parked at the end of the previous leg and is priced as a pause.
+	WaitMinutes float64 `json:"waitMinutes,omitempty"`
+}
+
+type journeyRoute struct {
+	WalkToVehicleMinutes   float64    `json:"walkToVehicleMinutes"`
+	Legs                   []legRoute `json:"legs"`
+	HasGeoZones            bool       `json:"hasGeoZones"`
+	UsedApproximateRouting bool       `json:"usedApproximateRouting"`
+	FreeBookingMinutes     float64    `json:"freeBookingMinutes"`
+}
+
+func routeJourney(
+	ctx context.Context,
+	client *http.Client,
+	journey Journey,
+	vehicle Vehicle,
+	zones *zoneIndex,
+) journeyRoute {
+	route := journeyRoute{
+		Legs:               make([]legRoute, 0, len(journey.Legs)),
+		HasGeoZones:        zones != nil,
+		FreeBookingMinutes: configFromContext(ctx).FreeBookingMinutes,
+	}
+
+	if len(journey.Legs) == 0 {
+		return route
+	}
+
+	startLocation := journey.Legs[0].StartLocation
+	vehicleLocation := vehicleToLocation(vehicle)
+	walkingTime, isApproximate := calculateWalkingTime(ctx, client, startLocation, vehicleLocation)
+	route.WalkToVehicleMinutes = walkingTime
+	route.UsedApproximateRouting = isApproximate
+
+	currentLocation := vehicleLocation
+
+	for _, leg := range journey.Legs {
+		walkToVehicleTime, isApproximate := calculateWalkingTime(
+			ctx,
+			client,
+			currentLocation,
+			leg.StartLocation,
+		)
+		route.UsedApproximateRouting = route.UsedApproximateRouting || isApproximate
+
+		drivingTime, isApproximate := calculateDrivingTime(ctx, client, leg.StartLocation, leg.EndLocation)
+		route.UsedApproximateRouting = route.UsedApproximateRouting || isApproximate
+
+		distance := calculateDistance(
+			leg.StartLocation.Lat,
+			leg.StartLocation.Lng,
+			leg.EndLocation.Lat,
+			leg.EndLocation.Lng,
+		)
+
+		routed := legRoute{
+			WalkToStartMinutes: walkToVehicleTime,
+			DrivingMinutes:     drivingTime,
+			DistanceKm:         distance,
+			EndInParkingZone:   zones.inParkingZone(leg.EndLocation),
+		}
+		route.Legs = append(route.Legs, routed)
+
+		reportProgress(ctx, PlanProgress{
+			Stage:    planStageLegRouted,
+			LegIndex: legIndex(len(route.Legs) - 1),
+			Message: fmt.Sprintf(
+				"Leg %d routed: %.0f min, %.1f km",
+				len(route.Legs),
+				drivingTime,
+				distance,
+			),
+			Leg: &routed,
+		})
+
+		currentLocation = leg.EndLocation
+	}
+
+	return route
 }
 
 func calculateCost(
 	ctx context.Context,
 	client *http.Client,
 	journey Journey,
+	departure time.Time,
+	vehicle Vehicle,
+	pricing *PricingResponse,
+	zones *zoneIndex,
+) (*JourneyPlan, error) {
+	journey, parkingSuggestion, isApproximate := suggestParkingSpot(
+		ctx,
+		client,
+		journey,
+		zones,
+	)
+
+	route := routeJourney(ctx, client, journey, vehicle, zones)
+	scheduleLegWaits(&route, journey, departure)
+
+	plan, err := cheapestPlan(journey, route, vehicle, pricing)
+	if err != nil {
+		return nil, err
+	}
+
+	applyParkingSuggestion(plan, parkingSuggestion, isApproximate)
+
+	reportProgress(ctx, PlanProgress{
+		Stage: planStagePricingComputed,
+		Message: fmt.Sprintf(
+			"Cheapest pricing is %s at €%.2f",
+			plan.PricingModel.DisplayName(),
+			plan.TotalCost,
+		),
+		PricingModel: plan.PricingModel,
+		TotalCost:    plan.TotalCost,
+	})
+
+	return plan, nil
+}
+
+// applyParkingSuggestion adds the walk from the suggested parking spot to
+// the plan. It is a no-op without a suggestion.
+func applyParkingSuggestion(
+	plan *JourneyPlan,
+	parkingSuggestion *ParkingSuggestion,
+	isApproximate bool,
+) {
+	if parkingSuggestion == nil {
+		return
+	}
+
+	plan.ParkingSuggestion = parkingSuggestion
+	plan.CostBreakdown.WalkingTime += parkingSuggestion.WalkingMinutes
+
+	if isApproximate {
+		plan.UsedFallbackRouting = true
+		plan.RoutingWarning = approximateRoutingWarning
+	}
+}
+
+// cheapestPlan prices a routed journey under every pricing model and
+// returns the cheapest one. It performs no I/O.
+func cheapestPlan(
+	journey Journey,
+	route journeyRoute,
 	vehicle Vehicle,
 	pricing *PricingResponse,
-	geozone *GeoZone,
 ) (*JourneyPlan, error) {
 	plans := []JourneyPlan{}
 
 	perMinutePlan := calculateCostForPricingPlan(
-		ctx,
-		client,
 		journey,
+		route,
 		vehicle,
 		pricing.PricingPerMinute,
 		pricingPlanPerMinute,
-		geozone,
 	)
 	if perMinutePlan != nil {
 		plans = append(plans, *perMinutePlan)
 	}
 
 	perKilometerPlan := calculateCostForPricingPlan(
-		ctx,
-		client,
 		journey,
+		route,
 		vehicle,
 		pricing.PricingPerKilometer,
 		pricingPlanPerKilometer,
-		geozone,
 	)
 	if perKilometerPlan != nil {
 		plans = append(plans, *perKilometerPlan)
 	}
 
 	smartPlan := calculateCostForPricingPlan(
-		ctx,
-		client,
 		journey,
+		route,
 		vehicle,
 		pricing.SmartPricing,
 		pricingPlanSmart,
-		geozone,
 	)
 	if smartPlan != nil {
 		plans = append(plans, *smartPlan)
 	}
 
 	if len(plans) == 0 {
-		return nil, errors.New("[calculateCost] no valid pricing plans found")
+		return nil, newValidationError(nil, ValidationIssue{
+			Code:     validationCodeEndOutsideParkingZone,
+			LegIndex: legIndex(len(journey.Legs) - 1),
+			Field:    "endLocation",
+			Message:  "Destination is outside every parking zone and no nearby parking spot was found",
+			Remedy:   "Choose a destination inside the Poppy operating area",
+		})
 	}
 
 	cheapest := plans[0]
@@ -581,121 +932,91 @@ func calculateCost(
 }
 
 func calculateCostForPricingPlan(
-	ctx context.Context,
-	client *http.Client,
 	journey Journey,
+	route journeyRoute,
 	vehicle Vehicle,
 	pricing PricingModel,
 	plan pricingPlan,
-	geozone *GeoZone,
 ) *JourneyPlan {
-	if len(journey.Legs) == 0 {
+	if len(journey.Legs) == 0 || len(route.Legs) != len(journey.Legs) {
 		return nil
 	}
 
-	breakdown := CostBreakdown{}
+	breakdown := CostBreakdown{Legs: make([]LegCost, 0, len(journey.Legs))}
 
 	unlockFee := float64(pricing.UnlockFee) / priceUnitFactor
 	breakdown.UnlockFee = unlockFee
+	breakdown.WalkingTime = route.WalkToVehicleMinutes
 
-	var (
-		totalBookingMinutes float64
-		totalTravelMinutes  float64
-		totalPauseMinutes   float64
-		totalDistanceKm     float64
-		walkingTime         float64
-		usedApproximateRouting bool
-	)
+	var totalBookingMinutes float64
 
-	startLocation := journey.Legs[0].StartLocation
-	vehicleLocation := vehicleToLocation(vehicle)
-	walkingTime, isApproximate := calculateWalkingTime(ctx, client, startLocation, vehicleLocation)
-	breakdown.WalkingTime = walkingTime
-	usedApproximateRouting = usedApproximateRouting || isApproximate
+	// Included kilometers are used up by the first legs.
+	includedKm := float64(pricing.IncludedKilometers)
 
-	currentLocation := vehicleLocation
+	for i, leg := range journey.Legs {
+		legRoute := route.Legs[i]
 
-	for _, leg := range journey.Legs {
-		walkToVehicleTime, isApproximate := calculateWalkingTime(
-			ctx,
-			client,
-			currentLocation,
-			leg.StartLocation,
-		)
-		totalBookingMinutes += walkToVehicleTime
-		usedApproximateRouting = usedApproximateRouting || isApproximate
+		totalBookingMinutes += legRoute.WalkToStartMinutes
 
-		drivingTime, isApproximate := calculateDrivingTime(ctx, client, leg.StartLocation, leg.EndLocation)
-		usedApproximateRouting = usedApproximateRouting || isApproximate
-		totalTravelMinutes += drivingTime
+		var pauseMinutes float64
 
-		distance := calculateDistance(
-			leg.StartLocation.Lat,
-			leg.StartLocation.Lng,
-			leg.EndLocation.Lat,
-			leg.EndLocation.Lng,
-		)
-		totalDistanceKm += distance
+		if i > 0 && legRoute.WaitMinutes > 0 {
+			if route.Legs[i-1].EndInParkingZone {
+				pauseMinutes += legRoute.WaitMinutes
+			} else {
+				pauseMinutes += legRoute.WaitMinutes * 1.5
+			}
+		}
 
 		if leg.PauseMinutes > 0 {
-			pauseMinutes := float64(leg.PauseMinutes)
-
-			if isInParkingZone(leg.EndLocation, geozone) {
-				totalPauseMinutes += pauseMinutes
+			if legRoute.EndInParkingZone {
+				pauseMinutes += float64(leg.PauseMinutes)
 			} else {
-				totalPauseMinutes += pauseMinutes * 1.5
+				pauseMinutes += float64(leg.PauseMinutes) * 1.5
 			}
 		}
 
-		currentLocation = leg.EndLocation
-	}
+		chargeableKm := math.Max(0, legRoute.DistanceKm-includedKm)
+		includedKm = math.Max(0, includedKm-legRoute.DistanceKm)
+
+		minuteCost := legRoute.DrivingMinutes * float64(pricing.MinutePrice) / priceUnitFactor
+		kmCost := chargeableKm * float64(pricing.KilometerPrice) / priceUnitFactor
+
+		legCost := LegCost{
+			PauseCost: pauseMinutes * float64(pricing.PauseUnitPrice) / priceUnitFactor,
+		}
+
+		switch pricing.Type {
+		case pricingPlanPerMinute:
+			legCost.TravelCost = minuteCost
+
+		case pricingPlanPerKilometer:
+			legCost.TravelCost = kmCost
+
+		case pricingPlanSmart:
+			fallthrough
 
-	finalLocation := journey.Legs[len(journey.Legs)-1].EndLocation
+		default:
+			legCost.TravelCost = minuteCost + kmCost
+		}
+
+		breakdown.Legs = append(breakdown.Legs, legCost)
+		breakdown.TravelCost += legCost.TravelCost
+		breakdown.PauseCost += legCost.PauseCost
+	}
 
-	if geozone != nil && !isInParkingZone(finalLocation, geozone) {
+	if route.HasGeoZones && !route.Legs[len(route.Legs)-1].EndInParkingZone {
 		return nil
 	}
 
 	bookingMinutesToCharge := math.Max(
 		0,
-		totalBookingMinutes-freeBookingMinutes,
+		totalBookingMinutes-route.FreeBookingMinutes,
 	)
 	breakdown.BookingCost = bookingMinutesToCharge * float64(
 		pricing.BookUnitPrice,
 	) / priceUnitFactor
 
-	switch pricing.Type {
-	case pricingPlanPerMinute:
-		breakdown.TravelCost = totalTravelMinutes * float64(
-			pricing.MinutePrice,
-		) / priceUnitFactor
-
-	case pricingPlanPerKilometer:
-		includedKm := float64(pricing.IncludedKilometers)
-		chargeableKm := math.Max(0, totalDistanceKm-includedKm)
-		breakdown.TravelCost = chargeableKm * float64(
-			pricing.KilometerPrice,
-		) / priceUnitFactor
-
-	case pricingPlanSmart:
-		fallthrough
-
-	default:
-		minuteCost := totalTravelMinutes * float64(
-			pricing.MinutePrice,
-		) / priceUnitFactor
-		includedKm := float64(pricing.IncludedKilometers)
-		chargeableKm := math.Max(0, totalDistanceKm-includedKm)
-		kmCost := chargeableKm * float64(
-			pricing.KilometerPrice,
-		) / priceUnitFactor
-		breakdown.TravelCost = minuteCost + kmCost
-	}
-
-	breakdown.PauseCost = totalPauseMinutes * float64(
-		pricing.PauseUnitPrice,
-	) / priceUnitFactor
-
 	totalCost := breakdown.UnlockFee + breakdown.BookingCost + breakdown.TravelCost + breakdown.PauseCost
 
 	dayCapCost := float64(pricing.DayCapPrice) / priceUnitFactor
@@ -704,8 +1025,8 @@ func calculateCostForPricingPlan(
 	}
 
 	var routingWarning string
-	if usedApproximateRouting {
-		routingWarning = "Using estimated travel times (OpenRouteService unavailable)"
+	if route.UsedApproximateRouting {
+		routingWarning = approximateRoutingWarning
 	}
 
 	return &JourneyPlan{
@@ -714,66 +1035,155 @@ func calculateCostForPricingPlan(
 		TotalCost:           totalCost,
 		CostBreakdown:       breakdown,
 		PricingModel:        plan,
-		UsedFallbackRouting: usedApproximateRouting,
+		UsedFallbackRouting: route.UsedApproximateRouting,
 		RoutingWarning:      routingWarning,
+		route:               route,
 	}
 }
 
-func planJourney(
+// planningData is the upstream data a journey is planned with.
+type planningData struct {
+	vehicle Vehicle
+	pricing *PricingResponse
+	zones   *zoneIndex
+}
+
+// fetchPlanningData picks the vehicle closest to start and fetches its
+// pricing and geozones. Missing geozones are tolerated: the plan is then
+// computed without zone checks.
+func fetchPlanningData(
 	ctx context.Context,
 	client *http.Client,
-	journey Journey,
-) (*JourneyPlan, error) {
-	vehicles, err := fetchVehicles(ctx, client)
+	cache *upstreamCache,
+	start Location,
+) (*planningData, error) {
+	vehicles, err := cache.fetchVehicles(ctx, client)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch vehicles: %w", err)
-	}
-
-	if len(vehicles) == 0 {
-		return nil, errors.New("[planJourney] no vehicles available")
-	}
-
-	if len(journey.Legs) == 0 {
-		return nil, errors.New("[planJourney] journey has no legs")
+		return nil, newValidationError(err, ValidationIssue{
+			Code:    validationCodeVehiclesUnavailable,
+			Message: "Could not fetch available vehicles from Poppy",
+			Remedy:  "Try again in a few moments",
+		})
 	}
 
-	startLocation := journey.Legs[0].StartLocation
+	reportProgress(ctx, PlanProgress{
+		Stage:   planStageVehiclesFetched,
+		Message: fmt.Sprintf("Found %d available vehicles", len(vehicles)),
+	})
 
-	closestVehicle := findClosestVehicle(startLocation, vehicles)
+	closestVehicle := findClosestVehicle(start, vehicles)
 	if closestVehicle == nil {
-		return nil, errors.New("[planJourney] no vehicle found")
+		return nil, newValidationError(nil, ValidationIssue{
+			Code:     validationCodeNoVehicle,
+			LegIndex: legIndex(0),
+			Field:    "startLocation",
+			Message:  "No vehicle is available",
+			Remedy:   "Try again later, vehicles become available as trips end",
+		})
 	}
 
-	pricing, err := fetchPricing(
+	reportProgress(ctx, PlanProgress{
+		Stage: planStageVehicleChosen,
+		Message: fmt.Sprintf(
+			"Closest vehicle is the %s %s (%s)",
+			closestVehicle.Model.Make,
+			closestVehicle.Model.Name,
+			closestVehicle.Plate,
+		),
+		Vehicle: closestVehicle,
+	})
+
+	pricing, err := cache.fetchPricing(
 		ctx,
 		client,
 		closestVehicle.Model.Type,
 		closestVehicle.Model.Tier,
 	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch pricing: %w", err)
+		return nil, newValidationError(err, ValidationIssue{
+			Code: validationCodePricingUnavailable,
+			Message: fmt.Sprintf(
+				"Could not fetch pricing for %s tier %s",
+				closestVehicle.Model.Type,
+				closestVehicle.Model.Tier,
+			),
+			Remedy: "Try again in a few moments",
+		})
 	}
 
-	geozone, err := fetchGeoZone(ctx, client, closestVehicle.UUID)
+	zones, err := cache.fetchZoneIndex(ctx, client, *closestVehicle)
 	if err != nil {
-		fmt.Printf(
-			"Warning: failed to fetch geozone for vehicle %s: %v\n",
-			closestVehicle.UUID,
-			err,
+		slog.WarnContext(ctx, "planning without geozones",
+			"vehicle_uuid", closestVehicle.UUID,
+			"error", err,
 		)
 
-		geozone = nil
+		zones = nil
 	}
 
-	plan, err := calculateCost(ctx, client, journey, *closestVehicle, pricing, geozone)
+	return &planningData{
+		vehicle: *closestVehicle,
+		pricing: pricing,
+		zones:   zones,
+	}, nil
+}
+
+func planJourney(
+	ctx context.Context,
+	client *http.Client,
+	cache *upstreamCache,
+	addressGeocoder geocoder,
+	journey Journey,
+) (plan *JourneyPlan, err error) {
+	defer func() {
+		metrics.observePlan(plan, err)
+		logPlan(ctx, journey, plan, err)
+	}()
+
+	request := journey
+
+	journey, geocodeErr := resolveJourneyAddresses(ctx, addressGeocoder, journey)
+	if geocodeErr != nil {
+		return nil, geocodeErr
+	}
+
+	if validationErr := validateJourney(journey); validationErr != nil {
+		return nil, validationErr
+	}
+
+	data, err := fetchPlanningData(ctx, client, cache, journey.Legs[0].StartLocation)
+	if err != nil {
+		return nil, err
+	}
+
+	warnings, zoneErr := checkJourneyZones(journey, data.zones)
+	if zoneErr != nil {
+		return nil, zoneErr
+	}
+
+	departure := journeyDeparture(journey, time.Now())
+
+	plan, err = calculateCost(ctx, client, journey, departure, data.vehicle, data.pricing, data.zones)
 	if err != nil {
-		return nil, fmt.Errorf("failed to calculate cost: %w", err)
+		return nil, fmt.Errorf("[planJourney] failed to calculate cost: %w", err)
 	}
 
+	plan.LegZones = describeLegZones(journey, data.zones)
+	plan.Warnings = warnings
+	plan.request = request
+	plan.data = data
+
+	applyTimeline(plan, departure)
+
 	return plan, nil
 }
 
-func planJourneyHandler(client *http.Client) http.HandlerFunc {
+func planJourneyHandler(
+	client *http.Client,
+	cache *upstreamCache,
+	addressGeocoder geocoder,
+	quotes *quoteStore,
+) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			respondJSON(w, http.StatusMethodNotAllowed, APIResponse{
@@ -784,14 +1194,26 @@ func planJourneyHandler(client *http.Client) http.HandlerFunc {
 			return
 		}
 
-		var requestData struct {
-			Journey Journey `json:"journey"`
-		}
+		var requestData PlanJourneyRequest
+
+		if err := apiSpec.decodeRequest(r, "/api/v1/plan-journey", &requestData); err != nil {
+			if respondBodyTooLarge(w, r, err) {
+				return
+			}
+
+			if errors.Is(err, errInvalidRequestBody) {
+				respondJSON(w, http.StatusBadRequest, APIResponse{
+					Success: false,
+					Error:   "Invalid JSON request body",
+				})
+
+				return
+			}
 
-		if err := json.NewDecoder(r.Body).Decode(&requestData); err != nil {
 			respondJSON(w, http.StatusBadRequest, APIResponse{
 				Success: false,
-				Error:   "Invalid JSON request body",
+				Error:   err.Error(),
+				Issues:  validationIssues(err),
 			})
 
 			return
@@ -800,16 +1222,29 @@ func planJourneyHandler(client *http.Client) http.HandlerFunc {
 		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 		defer cancel()
 
-		plan, err := planJourney(ctx, client, requestData.Journey)
+		plan, err := planJourney(
+			ctx,
+			client,
+			cache,
+			addressGeocoder,
+			requestData.Journey,
+		)
 		if err != nil {
 			respondJSON(w, http.StatusBadRequest, APIResponse{
 				Success: false,
 				Error:   err.Error(),
+				Issues:  validationIssues(err),
 			})
 
 			return
 		}
 
+		quotes.recordOrWarn(ctx, plan)
+
+		if respondPlanExport(w, r, plan) {
+			return
+		}
+
 		respondJSON(w, http.StatusOK, APIResponse{
 			Success: true,
 			Data:    plan,
@@ -817,7 +1252,10 @@ func planJourneyHandler(client *http.Client) http.HandlerFunc {
 	}
 }
 
-func vehiclesHandler(client *http.Client) http.HandlerFunc {
+func vehiclesHandler(
+	client *http.Client,
+	cache *upstreamCache,
+) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			respondJSON(w, http.StatusMethodNotAllowed, APIResponse{
@@ -828,10 +1266,21 @@ func vehiclesHandler(client *http.Client) http.HandlerFunc {
 			return
 		}
 
+		search, validationErr := parseVehicleSearch(r.URL.Query(), configFromContext(r.Context()).WalkingSpeedKmh)
+		if validationErr != nil {
+			respondJSON(w, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error:   validationErr.Error(),
+				Issues:  validationErr.Issues,
+			})
+
+			return
+		}
+
 		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 		defer cancel()
 
-		vehicles, err := fetchVehicles(ctx, client)
+		vehicles, err := cache.fetchVehicles(ctx, client)
 		if err != nil {
 			respondJSON(w, http.StatusInternalServerError, APIResponse{
 				Success: false,
@@ -841,13 +1290,14 @@ func vehiclesHandler(client *http.Client) http.HandlerFunc {
 			return
 		}
 
-		respondJSON(w, http.StatusOK, APIResponse{
-			Success: true,
-			Data:    vehicles,
-		})
+		results, total := searchVehicles(vehicles, search)
+
+		respondVehicles(w, r, results, total)
 	}
 }
 
+// healthHandler is the liveness check: it only tells the process is
+// serving requests and never calls upstream.
 func healthHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
@@ -863,8 +1313,9 @@ func healthHandler() http.HandlerFunc {
 			Success: true,
 			Data: map[string]any{
 				"status":  "healthy",
-				"version": "1.0.0",
+				"version": currentBuildInfo().Version,
 				"service": "poppy-journey-planner",
+				"build":   currentBuildInfo(),
 			},
 		})
 	}
@@ -876,87 +1327,118 @@ func indexHandler() http.HandlerFunc {
 	}
 }
 
-func planHandler(client *http.Client) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if err := r.ParseForm(); err != nil {
-			_ = ErrorResult("Failed to parse form data").Render(r.Context(), w)
+var errInvalidDepartureTime = errors.New("invalid departure time")
 
-			return
+// journeyFromForm reads the legs of the web form. Legs left entirely empty
+// are skipped.
+func journeyFromForm(form url.Values) (Journey, error) {
+	journey := Journey{Legs: []TripLeg{}}
+
+	if value := form.Get("departureTime"); value != "" {
+		departure, err := time.ParseInLocation(
+			"2006-01-02T15:04",
+			value,
+			plannerLocation,
+		)
+		if err != nil {
+			return Journey{}, errInvalidDepartureTime
 		}
 
-		journey := Journey{Legs: []TripLeg{}}
+		journey.DepartureTime = &departure
+	}
 
-		for key, values := range r.Form {
-			if len(values) == 0 {
-				continue
-			}
+	for key, values := range form {
+		if len(values) == 0 {
+			continue
+		}
 
-			if !strings.Contains(key, "legs[") || !strings.Contains(key, "]") {
-				continue
-			}
+		if !strings.Contains(key, "legs[") || !strings.Contains(key, "]") {
+			continue
+		}
 
-			parts := strings.Split(key, "].")
-			if len(parts) != 2 {
-				continue
-			}
+		parts := strings.Split(key, "].")
+		if len(parts) != 2 {
+			continue
+		}
 
-			legIndexStr := strings.TrimPrefix(parts[0], "legs[")
-			fieldName := parts[1]
+		legIndexStr := strings.TrimPrefix(parts[0], "legs[")
+		fieldName := parts[1]
 
-			legIndex, err := strconv.Atoi(legIndexStr)
-			if err != nil {
-				continue
-			}
+		legIndex, err := strconv.Atoi(legIndexStr)
+		if err != nil {
+			continue
+		}
 
-			for len(journey.Legs) <= legIndex {
-				journey.Legs = append(journey.Legs, TripLeg{})
-			}
+		for len(journey.Legs) <= legIndex {
+			journey.Legs = append(journey.Legs, TripLeg{})
+		}
 
-			value := values[0]
+		value := values[0]
 
-			switch fieldName {
-			case "startLat":
-				if lat, err := strconv.ParseFloat(value, 64); err == nil {
-					journey.Legs[legIndex].StartLocation.Lat = lat
-				}
-			case "startLng":
-				if lng, err := strconv.ParseFloat(value, 64); err == nil {
-					journey.Legs[legIndex].StartLocation.Lng = lng
-				}
-			case "endLat":
-				if lat, err := strconv.ParseFloat(value, 64); err == nil {
-					journey.Legs[legIndex].EndLocation.Lat = lat
-				}
-			case "endLng":
-				if lng, err := strconv.ParseFloat(value, 64); err == nil {
-					journey.Legs[legIndex].EndLocation.Lng = lng
-				}
-			case "pauseMinutes":
-				if pause, err := strconv.Atoi(value); err == nil {
-					journey.Legs[legIndex].PauseMinutes = pause
-				}
+		switch fieldName {
+		case "startLat":
+			if lat, err := strconv.ParseFloat(value, 64); err == nil {
+				journey.Legs[legIndex].StartLocation.Lat = lat
+			}
+		case "startLng":
+			if lng, err := strconv.ParseFloat(value, 64); err == nil {
+				journey.Legs[legIndex].StartLocation.Lng = lng
+			}
+		case "endLat":
+			if lat, err := strconv.ParseFloat(value, 64); err == nil {
+				journey.Legs[legIndex].EndLocation.Lat = lat
+			}
+		case "endLng":
+			if lng, err := strconv.ParseFloat(value, 64); err == nil {
+				journey.Legs[legIndex].EndLocation.Lng = lng
+			}
+		case "startAddress":
+			journey.Legs[legIndex].StartLocation.Address = strings.TrimSpace(value)
+		case "endAddress":
+			journey.Legs[legIndex].EndLocation.Address = strings.TrimSpace(value)
+		case "pauseMinutes":
+			if pause, err := strconv.Atoi(value); err == nil {
+				journey.Legs[legIndex].PauseMinutes = pause
 			}
 		}
+	}
 
-		validLegs := []TripLeg{}
-
-		for _, leg := range journey.Legs {
-			if leg.StartLocation.Lat == 0 &&
-				leg.StartLocation.Lng == 0 &&
-				leg.EndLocation.Lat == 0 &&
-				leg.EndLocation.Lng == 0 {
-				continue
-			}
+	validLegs := []TripLeg{}
 
-			validLegs = append(validLegs, leg)
+	for _, leg := range journey.Legs {
+		if leg.StartLocation.Lat == 0 &&
+			leg.StartLocation.Lng == 0 &&
+			leg.StartLocation.Address == "" &&
+			leg.EndLocation.Lat == 0 &&
+			leg.EndLocation.Lng == 0 &&
+			leg.EndLocation.Address == "" {
+			continue
 		}
 
-		journey.Legs = validLegs
+		validLegs = append(validLegs, leg)
+	}
 
-		if len(journey.Legs) == 0 {
-			_ = ErrorResult(
-				"Please add at least one valid journey leg",
-			).Render(r.Context(), w)
+	journey.Legs = validLegs
+
+	return journey, nil
+}
+
+func planHandler(
+	client *http.Client,
+	cache *upstreamCache,
+	addressGeocoder geocoder,
+	quotes *quoteStore,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			_ = ErrorResult("Failed to parse form data", nil).Render(r.Context(), w)
+
+			return
+		}
+
+		journey, err := journeyFromForm(r.Form)
+		if err != nil {
+			_ = ErrorResult("Invalid departure time", nil).Render(r.Context(), w)
 
 			return
 		}
@@ -964,19 +1446,44 @@ func planHandler(client *http.Client) http.HandlerFunc {
 		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 		defer cancel()
 
-		plan, err := planJourney(ctx, client, journey)
+		plan, err := planJourney(ctx, client, cache, addressGeocoder, journey)
 		if err != nil {
 			_ = ErrorResult(
-				"Planning failed: "+err.Error(),
+				"Planning failed",
+				validationIssues(err),
 			).Render(r.Context(), w)
 
 			return
 		}
 
+		quotes.recordOrWarn(ctx, plan)
+
 		_ = JourneyResult(plan).Render(r.Context(), w)
 	}
 }
 
+// calendarHandler exports the timeline of a quoted web plan as an
+// iCalendar file. It serves the stored plan, so a download neither plans
+// again nor spends ORS calls, and its events keep the quote ID as UID.
+func calendarHandler(quotes *quoteStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		quote, err := quotes.get(r.URL.Query().Get("quote"), time.Now())
+		if errors.Is(err, errNotFound) {
+			http.Error(w, "Quote not found", http.StatusNotFound)
+
+			return
+		}
+
+		if err != nil {
+			http.Error(w, "Could not load the quote", http.StatusInternalServerError)
+
+			return
+		}
+
+		respondICalendar(w, &quote.Plan)
+	}
+}
+
 func newHTTPClient(timeout time.Duration) *http.Client {
 	dialer := net.Dialer{
 		Timeout:   timeout,
@@ -1000,7 +1507,7 @@ func newHTTPClient(timeout time.Duration) *http.Client {
 	}
 
 	client := &http.Client{
-		Transport: transport,
+		Transport: requestIDTransport{next: transport},
 		Timeout:   timeout,
 	}
 
@@ -1008,9 +1515,10 @@ func newHTTPClient(timeout time.Duration) *http.Client {
 }
 
 type APIResponse struct {
-	Success bool   `json:"success"`
-	Data    any    `json:"data,omitempty"`
-	Error   string `json:"error,omitempty"`
+	Success bool              `json:"success"`
+	Data    any               `json:"data,omitempty"`
+	Error   string            `json:"error,omitempty"`
+	Issues  []ValidationIssue `json:"issues,omitempty"`
 }
 
 func respondJSON(w http.ResponseWriter, status int, response APIResponse) {
@@ -1021,30 +1529,136 @@ func respondJSON(w http.ResponseWriter, status int, response APIResponse) {
 }
 
 func main() {
+	os.Exit(run())
+}
+
+// run starts the server and returns the exit status: 0 after -h or a
+// clean shutdown, 2 for an invalid configuration and 1 for any other
+// failure, so an orchestrator does not mistake a bad deploy for a stop.
+func run() int {
 	_ = godotenv.Load()
-	
-	client := newHTTPClient(10 * time.Second)
 
-	mux := http.NewServeMux()
+	config, err := loadConfig(os.Args[1:], os.Getenv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
 
-	mux.HandleFunc("GET /", indexHandler())
-	mux.HandleFunc("POST /plan", planHandler(client))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
 
-	mux.HandleFunc("POST /api/v1/plan-journey", planJourneyHandler(client))
-	mux.HandleFunc("GET /api/v1/vehicles", vehiclesHandler(client))
-	mux.HandleFunc("GET /api/v1/health", healthHandler())
+		return 2
+	}
 
-	port := "8080"
-	fmt.Printf("Starting Poppy Journey Planner on port %s\n", port)
-	fmt.Println("Frontend:")
-	fmt.Println("  GET  / (Web UI)")
-	fmt.Println("  POST /plan (HTMX endpoint)")
-	fmt.Println("API Endpoints:")
-	fmt.Println("  POST /api/v1/plan-journey")
-	fmt.Println("  GET  /api/v1/vehicles")
-	fmt.Println("  GET  /api/v1/health")
+	if err := setupLogging(config); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid logging configuration: %v\n", err)
 
-	if err := http.ListenAndServe(":"+port, mux); err != nil {
-		fmt.Printf("Failed to start server: %v\n", err)
+		return 2
 	}
+
+	slog.Info("configuration", config.logAttrs()...)
+
+	// The first SIGINT or SIGTERM starts a graceful shutdown; after it, the
+	// default handling is restored so a second one stops at once.
+	ctx, stop := signal.NotifyContext(withConfig(context.Background(), config), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	context.AfterFunc(ctx, stop)
+
+	client := newHTTPClient(config.UpstreamTimeout)
+	cache := newUpstreamCache()
+	addressGeocoder := newGeocoder(client, config)
+
+	dataDir := config.DataDir
+	quotes := newQuoteStore(dataDir)
+
+	journeys, err := openJSONStore[SavedJourney](filepath.Join(dataDir, "journeys"))
+	if err != nil {
+		slog.Error("failed to open journey store", "error", err)
+
+		return 1
+	}
+
+	watches, err := openJSONStore[Watch](filepath.Join(dataDir, "watches"))
+	if err != nil {
+		slog.Error("failed to open watch store", "error", err)
+
+		return 1
+	}
+
+	tariffs, err := openJSONStore[TariffHistory](filepath.Join(dataDir, "tariffs"))
+	if err != nil {
+		slog.Error("failed to open tariff store", "error", err)
+
+		return 1
+	}
+
+	apiKeys, err := loadAPIKeys(config)
+	if err != nil {
+		slog.Error("failed to load API keys", "error", err)
+
+		return 1
+	}
+
+	auth, err := newAPIKeyAuth(apiKeys, time.Now())
+	if err != nil {
+		slog.Error("failed to load API keys", "error", err)
+
+		return 1
+	}
+
+	webhooks := newWebhookSender(newWebhookClient(config.WebhookAllowPrivate))
+	poller := &watchPoller{client: client, cache: cache, store: watches, sender: webhooks}
+
+	monitor := &tariffMonitor{client: client, cache: cache, store: tariffs}
+	journeyTokens := newJourneyTokens()
+
+	var workers workerGroup
+
+	workers.start(ctx, webhooks.run)
+	workers.start(ctx, func(ctx context.Context) { poller.run(ctx, watchPollInterval) })
+	workers.start(ctx, func(ctx context.Context) { monitor.run(ctx, tariffSnapshotInterval) })
+	workers.start(ctx, func(ctx context.Context) { quotes.run(ctx, quotePruneInterval) })
+	workers.start(ctx, func(ctx context.Context) { journeyTokens.run(ctx, journeyTokenTTL) })
+
+	mux := newRouter(services{
+		config:          config,
+		client:          client,
+		cache:           cache,
+		addressGeocoder: addressGeocoder,
+		quotes:          quotes,
+		journeys:        journeys,
+		watches:         watches,
+		tariffs:         tariffs,
+		auth:            auth,
+		webhooks:        webhooks,
+		journeyTokens:   journeyTokens,
+	})
+
+	port := config.Port
+
+	if auth.enabled() {
+		slog.Info("API key authentication enabled", "keys", len(apiKeys))
+	} else {
+		slog.Warn("no API keys configured, the API is open to everyone and the metrics and admin endpoints to localhost")
+	}
+
+	server := newServer(config, withRequestID(withAccessLog(withMetrics(mux, withBodyLimit(config.MaxBodyBytes,
+		withConfigContext(config, auth.middleware(mux)))))))
+
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		slog.Error("failed to start server", "error", err)
+
+		return 1
+	}
+
+	slog.Info("server listening", "port", port, "version", currentBuildInfo().Version)
+
+	if err := serve(ctx, server, listener, &workers, config.ShutdownTimeout); err != nil {
+		slog.Error("server did not shut down cleanly", "error", err)
+
+		return 1
+	}
+
+	return 0
 }
@@ -6,6 +6,9 @@ import (
 	"math"
 	"testing"
 	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
 )
 
 func getIntegrationTestScenarios() []struct {
@@ -35,15 +38,15 @@ func getIntegrationTestScenarios() []struct {
 				},
 			},
 			expected: expectedResult{
-				shouldSucceed:    true,
-				minCost:          25.0,
-				maxCost:          40.0,
-				expectedPauses:   120,
-				hasWalkingTime:   true,
+				shouldSucceed:  true,
+				minCost:        25.0,
+				maxCost:        40.0,
+				expectedPauses: 120,
+				hasWalkingTime: true,
 			},
 		},
 		{
-			name: "John: Brussels Center → Dilbeek (1h pause) → Airport", 
+			name: "John: Brussels Center → Dilbeek (1h pause) → Airport",
 			journey: Journey{
 				Legs: []TripLeg{
 					{
@@ -59,11 +62,12 @@ func getIntegrationTestScenarios() []struct {
 				},
 			},
 			expected: expectedResult{
-				shouldSucceed:    false,
-				minCost:          0.0,
-				maxCost:          0.0,
-				expectedPauses:   60,
-				hasWalkingTime:   true,
+				shouldSucceed:           true,
+				minCost:                 15.0,
+				maxCost:                 70.0,
+				expectedPauses:          60,
+				hasWalkingTime:          true,
+				expectParkingSuggestion: true,
 			},
 		},
 		{
@@ -78,11 +82,11 @@ func getIntegrationTestScenarios() []struct {
 				},
 			},
 			expected: expectedResult{
-				shouldSucceed:    true,
-				minCost:          3.0,
-				maxCost:          8.0,
-				expectedPauses:   0,
-				hasWalkingTime:   true,
+				shouldSucceed:  true,
+				minCost:        3.0,
+				maxCost:        8.0,
+				expectedPauses: 0,
+				hasWalkingTime: true,
 			},
 		},
 	}
@@ -94,18 +98,21 @@ type expectedResult struct {
 	maxCost        float64
 	expectedPauses int
 	hasWalkingTime bool
+
+	expectParkingSuggestion bool
 }
 
 func TestPlanJourney_IntegrationScenarios(t *testing.T) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(withConfig(context.Background(), defaultConfig()), 30*time.Second)
 	defer cancel()
 
 	client := newHTTPClient(10 * time.Second)
+	cache := newUpstreamCache()
 	scenarios := getIntegrationTestScenarios()
 
 	for _, scenario := range scenarios {
 		t.Run(scenario.name, func(t *testing.T) {
-			plan, err := planJourney(ctx, client, scenario.journey)
+			plan, err := planJourney(ctx, client, cache, nil, scenario.journey)
 
 			if scenario.expected.shouldSucceed {
 				if err != nil {
@@ -119,7 +126,7 @@ func TestPlanJourney_IntegrationScenarios(t *testing.T) {
 				}
 
 				if plan.TotalCost < scenario.expected.minCost || plan.TotalCost > scenario.expected.maxCost {
-					t.Errorf("Cost %.2f not in expected range [%.2f, %.2f]", 
+					t.Errorf("Cost %.2f not in expected range [%.2f, %.2f]",
 						plan.TotalCost, scenario.expected.minCost, scenario.expected.maxCost)
 				}
 
@@ -128,7 +135,7 @@ func TestPlanJourney_IntegrationScenarios(t *testing.T) {
 				}
 
 				if len(plan.Journey.Legs) != len(scenario.journey.Legs) {
-					t.Errorf("Expected %d legs but got %d", 
+					t.Errorf("Expected %d legs but got %d",
 						len(scenario.journey.Legs), len(plan.Journey.Legs))
 				}
 
@@ -137,10 +144,14 @@ func TestPlanJourney_IntegrationScenarios(t *testing.T) {
 					totalPauses += leg.PauseMinutes
 				}
 				if totalPauses != scenario.expected.expectedPauses {
-					t.Errorf("Expected %d total pause minutes but got %d", 
+					t.Errorf("Expected %d total pause minutes but got %d",
 						scenario.expected.expectedPauses, totalPauses)
 				}
 
+				if scenario.expected.expectParkingSuggestion && plan.ParkingSuggestion == nil {
+					t.Error("Expected a parking suggestion but got none")
+				}
+
 			} else {
 				if err == nil {
 					t.Error("Expected error but got success")
@@ -193,7 +204,7 @@ func TestCalculateDistance(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			result := calculateDistance(test.lat1, test.lng1, test.lat2, test.lng2)
 			if math.Abs(result-test.expected) > test.delta {
-				t.Errorf("Expected %.2f ± %.2f but got %.2f", 
+				t.Errorf("Expected %.2f ± %.2f but got %.2f",
 					test.expected, test.delta, result)
 			}
 		})
@@ -202,11 +213,11 @@ func TestCalculateDistance(t *testing.T) {
 
 func TestCalculateWalkingTime(t *testing.T) {
 	tests := []struct {
-		name         string
-		from         Location
-		to           Location
-		expectedMin  float64
-		expectedMax  float64
+		name        string
+		from        Location
+		to          Location
+		expectedMin float64
+		expectedMax float64
 	}{
 		{
 			name:        "Short walk",
@@ -226,7 +237,7 @@ func TestCalculateWalkingTime(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			result, _ := calculateWalkingTime(nil, nil, test.from, test.to)
+			result, _ := calculateWalkingTime(withConfig(context.Background(), defaultConfig()), nil, test.from, test.to)
 			if result < test.expectedMin || result > test.expectedMax {
 				t.Errorf("Expected walking time in range [%.2f, %.2f] but got %.2f",
 					test.expectedMin, test.expectedMax, result)
@@ -237,25 +248,25 @@ func TestCalculateWalkingTime(t *testing.T) {
 
 func TestFindClosestVehicle(t *testing.T) {
 	location := Location{Lat: 50.8466, Lng: 4.3528}
-	
+
 	vehicles := []Vehicle{
 		{
-			UUID: "vehicle1",
+			UUID:              "vehicle1",
 			LocationLatitude:  50.8500,
 			LocationLongitude: 4.3600,
-			Model: Model{Type: "car", Tier: "S"},
+			Model:             Model{Type: "car", Tier: "S"},
 		},
 		{
-			UUID: "vehicle2", 
+			UUID:              "vehicle2",
 			LocationLatitude:  50.8450,
 			LocationLongitude: 4.3500,
-			Model: Model{Type: "car", Tier: "S"},
+			Model:             Model{Type: "car", Tier: "S"},
 		},
 		{
-			UUID: "vehicle3",
+			UUID:              "vehicle3",
 			LocationLatitude:  50.9000,
 			LocationLongitude: 4.4000,
-			Model: Model{Type: "car", Tier: "S"},
+			Model:             Model{Type: "car", Tier: "S"},
 		},
 	}
 
@@ -281,14 +292,100 @@ func TestVehicleToLocation(t *testing.T) {
 	}
 
 	location := vehicleToLocation(vehicle)
-	
+
 	if location.Lat != vehicle.LocationLatitude {
-		t.Errorf("Expected lat %.6f but got %.6f", 
+		t.Errorf("Expected lat %.6f but got %.6f",
 			vehicle.LocationLatitude, location.Lat)
 	}
-	
+
 	if location.Lng != vehicle.LocationLongitude {
-		t.Errorf("Expected lng %.6f but got %.6f", 
-			vehicle.LocationLongitude, location.Lng)  
+		t.Errorf("Expected lng %.6f but got %.6f",
+			vehicle.LocationLongitude, location.Lng)
+	}
+}
+
+func newTestGeoZone(geofencingType string, geometry orb.Geometry) GeoZoneItem {
+	return GeoZoneItem{
+		GeofencingType: geofencingType,
+		ModelType:      "car",
+		Geom: GeoFeature{
+			Type:     "Feature",
+			Geometry: *geojson.NewGeometry(geometry),
+		},
+	}
+}
+
+func TestFindNearestParkingSpot(t *testing.T) {
+	geozone := GeoZone{
+		newTestGeoZone("parking", orb.Polygon{{
+			{4.30, 50.80}, {4.40, 50.80}, {4.40, 50.90}, {4.30, 50.90}, {4.30, 50.80},
+		}}),
+	}
+
+	destination := Location{Lat: 50.85, Lng: 4.45}
+
+	spot, found := findNearestParkingSpot(destination, newZoneIndex(&geozone))
+	if !found {
+		t.Fatal("Expected to find a parking spot but got none")
+	}
+
+	if !newZoneIndex(&geozone).inParkingZone(spot) {
+		t.Errorf("Expected spot %+v to be inside the parking zone", spot)
+	}
+
+	if math.Abs(spot.Lat-50.85) > 0.0001 || math.Abs(spot.Lng-4.40) > 0.0001 {
+		t.Errorf("Expected spot near (50.85, 4.40) but got (%.5f, %.5f)",
+			spot.Lat, spot.Lng)
 	}
-}
\ No newline at end of file
+
+	if _, found := findNearestParkingSpot(destination, newZoneIndex(&GeoZone{})); found {
+		t.Error("Expected no parking spot for empty geozone")
+	}
+}
+
+func TestSuggestParkingSpot(t *testing.T) {
+	geozone := GeoZone{
+		newTestGeoZone("parking", orb.Polygon{{
+			{4.30, 50.80}, {4.40, 50.80}, {4.40, 50.90}, {4.30, 50.90}, {4.30, 50.80},
+		}}),
+	}
+
+	journey := Journey{
+		Legs: []TripLeg{
+			{
+				StartLocation: Location{Lat: 50.85, Lng: 4.35},
+				EndLocation:   Location{Lat: 50.85, Lng: 4.41},
+			},
+		},
+	}
+
+	adjusted, suggestion, _ := suggestParkingSpot(
+		withConfig(context.Background(), defaultConfig()),
+		nil,
+		journey,
+		newZoneIndex(&geozone),
+	)
+	if suggestion == nil {
+		t.Fatal("Expected a parking suggestion but got nil")
+	}
+
+	if adjusted.Legs[0].EndLocation != suggestion.Location {
+		t.Errorf("Expected adjusted leg to end at %+v but got %+v",
+			suggestion.Location, adjusted.Legs[0].EndLocation)
+	}
+
+	if journey.Legs[0].EndLocation.Lng != 4.41 {
+		t.Error("Expected original journey to be left untouched")
+	}
+
+	if suggestion.WalkingMinutes <= 0 {
+		t.Error("Expected walking time from the parking spot")
+	}
+
+	inside := journey
+	inside.Legs = []TripLeg{{EndLocation: Location{Lat: 50.85, Lng: 4.35}}}
+
+	if _, suggestion, _ := suggestParkingSpot(withConfig(context.Background(), defaultConfig()), nil, inside, newZoneIndex(&geozone)); suggestion != nil {
+		t.Error("Expected no suggestion for a destination inside a zone")
+	}
+}
@@ -0,0 +1,360 @@
+//nolint:package-comments,revive,mnd,exhaustruct
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// latencyBuckets are the histogram upper bounds in seconds, from a cache
+// hit to an upstream call close to its timeout.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// labelSeparator joins label values into map keys; it cannot appear in a
+// valid UTF-8 label value.
+const labelSeparator = "\xff"
+
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: map[string]float64{}}
+}
+
+func (c *counterVec) inc(labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.values[strings.Join(labelValues, labelSeparator)]++
+}
+
+func (c *counterVec) value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.values[strings.Join(labelValues, labelSeparator)]
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	writeMetricHeader(w, c.name, c.help, "counter")
+
+	for _, key := range slices.Sorted(maps.Keys(c.values)) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, key, ""), formatFloat(c.values[key]))
+	}
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	values map[string]*histogram
+}
+
+func newHistogramVec(name, help string, labels ...string) *histogramVec {
+	return &histogramVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: latencyBuckets,
+		values:  map[string]*histogram{},
+	}
+}
+
+func (h *histogramVec) observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := strings.Join(labelValues, labelSeparator)
+
+	observed, ok := h.values[key]
+	if !ok {
+		observed = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = observed
+	}
+
+	for i, bound := range h.buckets {
+		if value <= bound {
+			observed.counts[i]++
+		}
+	}
+
+	observed.sum += value
+	observed.count++
+}
+
+func (h *histogramVec) count(labelValues ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if observed, ok := h.values[strings.Join(labelValues, labelSeparator)]; ok {
+		return observed.count
+	}
+
+	return 0
+}
+
+// write prints the buckets cumulatively, as Prometheus expects.
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	writeMetricHeader(w, h.name, h.help, "histogram")
+
+	for _, key := range slices.Sorted(maps.Keys(h.values)) {
+		observed := h.values[key]
+
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, formatFloat(bound)), observed.counts[i])
+		}
+
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, "+Inf"), observed.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, key, ""), formatFloat(observed.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, key, ""), observed.count)
+	}
+}
+
+func writeMetricHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels renders {name="value",...} from a joined key, adding the
+// le label of histogram buckets when given.
+func formatLabels(names []string, key, le string) string {
+	pairs := []string{}
+
+	if len(names) > 0 {
+		for i, value := range strings.Split(key, labelSeparator) {
+			pairs = append(pairs, names[i]+`="`+labelValueEscaper.Replace(value)+`"`)
+		}
+	}
+
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// plannerMetrics is everything /metrics exposes. Labels are bounded: routes
+// are mux patterns, not raw paths, and methods are folded by metricsMethod.
+type plannerMetrics struct {
+	httpRequests     *counterVec
+	httpDuration     *histogramVec
+	upstreamDuration *histogramVec
+	upstreamErrors   *counterVec
+	routingLookups   *counterVec
+	cacheLookups     *counterVec
+	plannedJourneys  *counterVec
+	rejectedJourneys *counterVec
+}
+
+func newPlannerMetrics() *plannerMetrics {
+	return &plannerMetrics{
+		httpRequests: newCounterVec(
+			"poppy_http_requests_total",
+			"HTTP requests by route, method and status code.",
+			"route", "method", "status",
+		),
+		httpDuration: newHistogramVec(
+			"poppy_http_request_duration_seconds",
+			"HTTP request latency by route and method.",
+			"route", "method",
+		),
+		upstreamDuration: newHistogramVec(
+			"poppy_upstream_request_duration_seconds",
+			"Latency of calls to Poppy, OpenRouteService and the geocoder by fetcher.",
+			"fetcher",
+		),
+		upstreamErrors: newCounterVec(
+			"poppy_upstream_errors_total",
+			"Failed calls to Poppy, OpenRouteService and the geocoder by fetcher.",
+			"fetcher",
+		),
+		routingLookups: newCounterVec(
+			"poppy_routing_lookups_total",
+			"Walking and driving time lookups by profile, answered by ORS or the crow-flies fallback.",
+			"profile", "source",
+		),
+		cacheLookups: newCounterVec(
+			"poppy_cache_lookups_total",
+			"Upstream cache lookups by cache and result (hit, miss or shared in-flight fetch).",
+			"cache", "result",
+		),
+		plannedJourneys: newCounterVec(
+			"poppy_journeys_planned_total",
+			"Planned journeys by the cheapest pricing plan and the routing used.",
+			"pricing_plan", "routing",
+		),
+		rejectedJourneys: newCounterVec(
+			"poppy_journeys_rejected_total",
+			"Journeys that could not be planned by reason, the code of the first issue.",
+			"reason",
+		),
+	}
+}
+
+// metrics is shared by every handler and fetcher in the process.
+var metrics = newPlannerMetrics()
+
+func (m *plannerMetrics) write(w io.Writer) {
+	m.httpRequests.write(w)
+	m.httpDuration.write(w)
+	m.upstreamDuration.write(w)
+	m.upstreamErrors.write(w)
+	m.routingLookups.write(w)
+	m.cacheLookups.write(w)
+	m.plannedJourneys.write(w)
+	m.rejectedJourneys.write(w)
+}
+
+// observeUpstream records a call started at start that failed when *err
+// is set.
+func (m *plannerMetrics) observeUpstream(fetcher string, start time.Time, err *error) {
+	m.upstreamDuration.observe(time.Since(start).Seconds(), fetcher)
+
+	if *err != nil {
+		m.upstreamErrors.inc(fetcher)
+	}
+}
+
+func (m *plannerMetrics) observeRouting(profile string, isApproximate bool) {
+	source := "ors"
+	if isApproximate {
+		source = "fallback"
+	}
+
+	m.routingLookups.inc(profile, source)
+}
+
+func (m *plannerMetrics) observePlan(plan *JourneyPlan, err error) {
+	if err != nil {
+		reason := validationCodePlanningFailed
+		if issues := validationIssues(err); len(issues) > 0 {
+			reason = issues[0].Code
+		}
+
+		m.rejectedJourneys.inc(string(reason))
+
+		return
+	}
+
+	routing := "ors"
+	if plan.UsedFallbackRouting {
+		routing = "fallback"
+	}
+
+	m.plannedJourneys.inc(string(plan.PricingModel), routing)
+}
+
+// statusRecorder remembers the status code written by a handler. Unwrap
+// keeps http.ResponseController working for the streaming endpoints.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(data []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+
+	return s.ResponseWriter.Write(data)
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// withMetrics counts and times every request answered by next, grouped by
+// the pattern of mux the request matches. It sits outside the auth
+// middleware, so rejected keys and rate-limited requests are counted under
+// their route as well.
+func withMetrics(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+
+		next.ServeHTTP(recorder, r)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		method := metricsMethod(r.Method)
+		metrics.httpRequests.inc(route, method, strconv.Itoa(recorder.status))
+		metrics.httpDuration.observe(time.Since(start).Seconds(), route, method)
+	})
+}
+
+// metricsMethod maps the request method onto the standard methods so a
+// client sending made-up methods cannot create new series.
+func metricsMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	default:
+		return "other"
+	}
+}
+
+func metricsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", metricsContentType)
+
+		buffered := bufio.NewWriter(w)
+		metrics.write(buffered)
+		_ = buffered.Flush()
+	}
+}
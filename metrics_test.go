@@ -0,0 +1,169 @@
+//nolint:package-comments,revive,mnd,exhaustruct
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCounterVec_Write(t *testing.T) {
+	counter := newCounterVec("test_total", "A test counter.", "route", "status")
+	counter.inc("GET /a", "200")
+	counter.inc("GET /a", "200")
+	counter.inc(`say "hi"`, "500")
+
+	var builder strings.Builder
+	counter.write(&builder)
+
+	expected := `# HELP test_total A test counter.
+# TYPE test_total counter
+test_total{route="GET /a",status="200"} 2
+test_total{route="say \"hi\"",status="500"} 1
+`
+	if builder.String() != expected {
+		t.Errorf("Expected\n%s\nbut got\n%s", expected, builder.String())
+	}
+}
+
+func TestHistogramVec_Write(t *testing.T) {
+	histogram := newHistogramVec("test_seconds", "A test histogram.", "fetcher")
+	histogram.observe(0.02, "poppy")
+	histogram.observe(3, "poppy")
+
+	var builder strings.Builder
+	histogram.write(&builder)
+
+	for _, line := range []string{
+		`test_seconds_bucket{fetcher="poppy",le="0.01"} 0`,
+		`test_seconds_bucket{fetcher="poppy",le="0.025"} 1`,
+		`test_seconds_bucket{fetcher="poppy",le="2.5"} 1`,
+		`test_seconds_bucket{fetcher="poppy",le="5"} 2`,
+		`test_seconds_bucket{fetcher="poppy",le="+Inf"} 2`,
+		`test_seconds_sum{fetcher="poppy"} 3.02`,
+		`test_seconds_count{fetcher="poppy"} 2`,
+	} {
+		if !strings.Contains(builder.String(), line+"\n") {
+			t.Errorf("Expected the line %q in\n%s", line, builder.String())
+		}
+	}
+}
+
+func TestPlannerMetrics_ObservePlan(t *testing.T) {
+	before := metrics.rejectedJourneys.value(string(validationCodeNoLegs))
+	planningFailed := metrics.rejectedJourneys.value(string(validationCodePlanningFailed))
+
+	metrics.observePlan(nil, validateJourney(Journey{}))
+	metrics.observePlan(nil, errors.New("boom"))
+
+	if metrics.rejectedJourneys.value(string(validationCodeNoLegs)) != before+1 {
+		t.Error("Expected the rejection to be counted under its first issue code")
+	}
+
+	if metrics.rejectedJourneys.value(string(validationCodePlanningFailed)) != planningFailed+1 {
+		t.Error("Expected other errors to be counted as planning_failed")
+	}
+
+	planned := metrics.plannedJourneys.value(string(pricingPlanSmart), "fallback")
+
+	metrics.observePlan(&JourneyPlan{PricingModel: pricingPlanSmart, UsedFallbackRouting: true}, nil)
+
+	if metrics.plannedJourneys.value(string(pricingPlanSmart), "fallback") != planned+1 {
+		t.Error("Expected the plan to be counted under its pricing plan and fallback routing")
+	}
+}
+
+func TestTTLCache_CountsLookups(t *testing.T) {
+	cache := newTTLCache[int]("metrics-test", time.Minute)
+	fetch := func(context.Context) (int, error) { return 1, nil }
+
+	_, _ = cache.get(context.Background(), "key", fetch)
+	_, _ = cache.get(context.Background(), "key", fetch)
+	_, _ = cache.get(context.Background(), "key", fetch)
+
+	if hits, misses := metrics.cacheLookups.value("metrics-test", "hit"),
+		metrics.cacheLookups.value("metrics-test", "miss"); hits != 2 || misses != 1 {
+		t.Errorf("Expected 2 hits and 1 miss but got %v and %v", hits, misses)
+	}
+}
+
+func TestWithMetrics(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/things/{id}", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	mux.HandleFunc("GET /metrics", metricsHandler())
+
+	handler := withMetrics(mux, mux)
+
+	before := metrics.httpRequests.value("GET /api/v1/things/{id}", http.MethodGet, "418")
+	unmatched := metrics.httpDuration.count("unmatched", http.MethodGet)
+
+	for _, path := range []string{"/api/v1/things/1", "/api/v1/things/2", "/nowhere"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	if metrics.httpRequests.value("GET /api/v1/things/{id}", http.MethodGet, "418") != before+2 {
+		t.Error("Expected both requests to be counted under the route pattern")
+	}
+
+	if metrics.httpDuration.count("unmatched", http.MethodGet) != unmatched+1 {
+		t.Error("Expected the unknown path to be timed as unmatched")
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if contentType := recorder.Header().Get("Content-Type"); contentType != metricsContentType {
+		t.Errorf("Expected content type %s but got %s", metricsContentType, contentType)
+	}
+
+	if !strings.Contains(recorder.Body.String(), `poppy_http_requests_total{route="GET /api/v1/things/{id}",method="GET",status="418"}`) {
+		t.Errorf("Expected the route in the exposition but got\n%s", recorder.Body.String())
+	}
+}
+
+func TestWithMetrics_FoldsUnknownMethods(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/anything", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	handler := withMetrics(mux, mux)
+
+	before := metrics.httpRequests.value("/api/v1/anything", "other", "200")
+
+	for _, method := range []string{"BREW", "WHEN"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/api/v1/anything", nil))
+	}
+
+	if metrics.httpRequests.value("/api/v1/anything", "other", "200") != before+2 {
+		t.Error("Expected unknown methods to be counted as other")
+	}
+
+	if metrics.httpRequests.value("/api/v1/anything", "BREW", "200") != 0 {
+		t.Error("Expected no series for the raw method")
+	}
+}
+
+func TestWithMetrics_CountsRejections(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/protected/{id}", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	auth, _ := newAPIKeyAuth([]APIKey{{Name: "alice", Key: "alice-key"}}, time.Now())
+	handler := withMetrics(mux, auth.middleware(mux))
+
+	before := metrics.httpRequests.value("GET /api/v1/protected/{id}", http.MethodGet, "401")
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/protected/1", nil))
+
+	if metrics.httpRequests.value("GET /api/v1/protected/{id}", http.MethodGet, "401") != before+1 {
+		t.Error("Expected the rejected request to be counted under its route")
+	}
+}
@@ -0,0 +1,339 @@
+//nolint:package-comments,revive,mnd,exhaustruct,err113
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const openAPISchemaRefPrefix = "#/components/schemas/"
+
+//go:embed openapi.json
+var openAPIDocumentJSON []byte
+
+var apiSpec = mustLoadOpenAPIDocument(openAPIDocumentJSON)
+
+// openAPIDocument holds the parts of the OpenAPI document the request
+// validator needs. Everything else is served as is.
+type openAPIDocument struct {
+	Paths      map[string]map[string]openAPIOperation `json:"paths"`
+	Components struct {
+		Schemas map[string]*openAPISchema `json:"schemas"`
+	} `json:"components"`
+}
+
+type openAPIOperation struct {
+	RequestBody *struct {
+		Content map[string]struct {
+			Schema *openAPISchema `json:"schema"`
+		} `json:"content"`
+	} `json:"requestBody"`
+}
+
+// openAPIIssue maps a failed schema keyword to a validation issue code, so
+// schema errors read like the ones validateJourney reports.
+type openAPIIssue struct {
+	Code   validationCode `json:"code"`
+	Remedy string         `json:"remedy"`
+}
+
+// openAPISchema is the subset of the OpenAPI 3.0 schema object the
+// validator understands.
+type openAPISchema struct {
+	Ref        string                    `json:"$ref"`
+	Type       string                    `json:"type"`
+	Format     string                    `json:"format"`
+	Nullable   bool                      `json:"nullable"`
+	Properties map[string]*openAPISchema `json:"properties"`
+	Required   []string                  `json:"required"`
+	Items      *openAPISchema            `json:"items"`
+	AllOf      []*openAPISchema          `json:"allOf"`
+	Enum       []any                     `json:"enum"`
+	Minimum    *float64                  `json:"minimum"`
+	Maximum    *float64                  `json:"maximum"`
+	MinItems   *int                      `json:"minItems"`
+	MaxItems   *int                      `json:"maxItems"`
+	Issues     map[string]openAPIIssue   `json:"x-issues"`
+}
+
+func mustLoadOpenAPIDocument(data []byte) *openAPIDocument {
+	var document openAPIDocument
+	if err := json.Unmarshal(data, &document); err != nil {
+		panic(fmt.Sprintf("invalid embedded OpenAPI document: %v", err))
+	}
+
+	return &document
+}
+
+func (d *openAPIDocument) resolve(schema *openAPISchema) *openAPISchema {
+	for schema != nil && schema.Ref != "" {
+		schema = d.Components.Schemas[strings.TrimPrefix(schema.Ref, openAPISchemaRefPrefix)]
+	}
+
+	return schema
+}
+
+func (d *openAPIDocument) requestSchema(method, path string) *openAPISchema {
+	operation, ok := d.Paths[path][strings.ToLower(method)]
+	if !ok || operation.RequestBody == nil {
+		return nil
+	}
+
+	return operation.RequestBody.Content["application/json"].Schema
+}
+
+// schemaValidation accumulates issues while walking a value. Paths inside a
+// journey leg are reported relative to the leg, like validateJourney does.
+type schemaValidation struct {
+	document *openAPIDocument
+	result   *ValidationError
+}
+
+func (v *schemaValidation) fail(
+	schema *openAPISchema,
+	keyword string,
+	leg *int,
+	field string,
+	message string,
+) {
+	issue := ValidationIssue{
+		Code:     validationCodeInvalidField,
+		LegIndex: leg,
+		Field:    field,
+		Message:  message,
+	}
+
+	if mapped, ok := schema.Issues[keyword]; ok {
+		issue.Code = mapped.Code
+		issue.Remedy = mapped.Remedy
+	}
+
+	v.result.add(issue)
+}
+
+func (v *schemaValidation) validate(
+	schema *openAPISchema,
+	value any,
+	leg *int,
+	field string,
+) {
+	schema = v.document.resolve(schema)
+	if schema == nil {
+		return
+	}
+
+	for _, part := range schema.AllOf {
+		v.validate(part, value, leg, field)
+	}
+
+	if value == nil {
+		if schema.Type != "" && !schema.Nullable {
+			v.fail(schema, "type", leg, field, "Must not be null")
+		}
+
+		return
+	}
+
+	if !v.validateType(schema, value, leg, field) {
+		return
+	}
+
+	if len(schema.Enum) > 0 && !slices.Contains(schema.Enum, value) {
+		v.fail(schema, "enum", leg, field, fmt.Sprintf("Must be one of %v", schema.Enum))
+	}
+
+	switch typed := value.(type) {
+	case float64:
+		if schema.Minimum != nil && typed < *schema.Minimum {
+			v.fail(schema, "minimum", leg, field, fmt.Sprintf(
+				"%v is below the minimum of %v", typed, *schema.Minimum,
+			))
+		}
+
+		if schema.Maximum != nil && typed > *schema.Maximum {
+			v.fail(schema, "maximum", leg, field, fmt.Sprintf(
+				"%v is above the maximum of %v", typed, *schema.Maximum,
+			))
+		}
+
+	case []any:
+		if schema.MinItems != nil && len(typed) < *schema.MinItems {
+			v.fail(schema, "minItems", leg, field, fmt.Sprintf(
+				"Must have at least %d items", *schema.MinItems,
+			))
+		}
+
+		if schema.MaxItems != nil && len(typed) > *schema.MaxItems {
+			v.fail(schema, "maxItems", leg, field, fmt.Sprintf(
+				"Has %d items, the maximum is %d", len(typed), *schema.MaxItems,
+			))
+		}
+
+		for i, item := range typed {
+			if field == "legs" || strings.HasSuffix(field, ".legs") {
+				v.validate(schema.Items, item, legIndex(i), "")
+
+				continue
+			}
+
+			v.validate(schema.Items, item, leg, fmt.Sprintf("%s[%d]", field, i))
+		}
+
+	case map[string]any:
+		for _, name := range schema.Required {
+			if _, ok := typed[name]; !ok {
+				v.fail(schema, "required", leg, joinFieldPath(field, name), "Is required")
+			}
+		}
+
+		for name, property := range schema.Properties {
+			if propertyValue, ok := typed[name]; ok {
+				v.validate(property, propertyValue, leg, joinFieldPath(field, name))
+			}
+		}
+	}
+}
+
+func (v *schemaValidation) validateType(
+	schema *openAPISchema,
+	value any,
+	leg *int,
+	field string,
+) bool {
+	valid := true
+
+	switch schema.Type {
+	case "object":
+		_, valid = value.(map[string]any)
+	case "array":
+		_, valid = value.([]any)
+	case "boolean":
+		_, valid = value.(bool)
+	case "number":
+		_, valid = value.(float64)
+	case "integer":
+		number, ok := value.(float64)
+		valid = ok && number == math.Trunc(number)
+	case "string":
+		text, ok := value.(string)
+		valid = ok
+
+		if ok && schema.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, text); err != nil {
+				v.fail(schema, "format", leg, field, "Must be an RFC 3339 date-time")
+
+				return false
+			}
+		}
+	}
+
+	if !valid {
+		v.fail(schema, "type", leg, field, "Must be of type "+schema.Type)
+	}
+
+	return valid
+}
+
+func joinFieldPath(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+
+	return parent + "." + name
+}
+
+// validateRequest checks a decoded JSON body against the request schema of
+// the operation.
+func (d *openAPIDocument) validateRequest(method, path string, body any) *ValidationError {
+	return d.validateValue(d.requestSchema(method, path), body)
+}
+
+// validateComponent checks a decoded JSON value against a named component
+// schema, e.g. a single journey of a batch.
+func (d *openAPIDocument) validateComponent(name string, value any) *ValidationError {
+	return d.validateValue(&openAPISchema{Ref: openAPISchemaRefPrefix + name}, value)
+}
+
+func (d *openAPIDocument) validateValue(schema *openAPISchema, value any) *ValidationError {
+	validation := &schemaValidation{document: d, result: &ValidationError{}}
+	validation.validate(schema, value, nil, "")
+
+	if len(validation.result.Issues) == 0 {
+		return nil
+	}
+
+	slices.SortStableFunc(validation.result.Issues, compareIssues)
+
+	return validation.result
+}
+
+// compareIssues orders issues by leg and field, since properties are
+// visited in map order.
+func compareIssues(a, b ValidationIssue) int {
+	aLeg, bLeg := -1, -1
+
+	if a.LegIndex != nil {
+		aLeg = *a.LegIndex
+	}
+
+	if b.LegIndex != nil {
+		bLeg = *b.LegIndex
+	}
+
+	if aLeg != bLeg {
+		return aLeg - bLeg
+	}
+
+	return strings.Compare(a.Field, b.Field)
+}
+
+// errInvalidRequestBody is returned by decodeRequest for bodies that are
+// not JSON at all, as opposed to JSON that violates the schema.
+var errInvalidRequestBody = errors.New("invalid JSON request body")
+
+// decodeRequest reads the request body, validates it against the schema
+// of the given operation and decodes it into target. Schema violations
+// are returned as a *ValidationError, and a body over the limit of
+// withBodyLimit as an *http.MaxBytesError.
+func (d *openAPIDocument) decodeRequest(r *http.Request, operationPath string, target any) error {
+	data, err := io.ReadAll(r.Body)
+	if errors.As(err, new(*http.MaxBytesError)) {
+		return fmt.Errorf("[decodeRequest] %w", err)
+	}
+
+	if err != nil {
+		return fmt.Errorf("[decodeRequest] %w: %w", errInvalidRequestBody, err)
+	}
+
+	var body any
+	if err := json.Unmarshal(data, &body); err != nil {
+		return fmt.Errorf("[decodeRequest] %w: %w", errInvalidRequestBody, err)
+	}
+
+	if validationErr := d.validateRequest(r.Method, operationPath, body); validationErr != nil {
+		return validationErr
+	}
+
+	if err := json.Unmarshal(data, target); err != nil {
+		return fmt.Errorf("[decodeRequest] %w: %w", errInvalidRequestBody, err)
+	}
+
+	return nil
+}
+
+func openAPIHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		_, _ = w.Write(openAPIDocumentJSON)
+	}
+}
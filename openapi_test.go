@@ -0,0 +1,450 @@
+//nolint:package-comments,revive,mnd,exhaustruct
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openAPISchemaTypes lists the Go type behind every component schema that
+// mirrors one. Schemas missing from here fail the drift test.
+var openAPISchemaTypes = map[string]reflect.Type{
+	"PlanJourneyRequest":  reflect.TypeFor[PlanJourneyRequest](),
+	"Journey":             reflect.TypeFor[Journey](),
+	"TripLeg":             reflect.TypeFor[TripLeg](),
+	"Location":            reflect.TypeFor[Location](),
+	"APIResponse":         reflect.TypeFor[APIResponse](),
+	"ValidationIssue":     reflect.TypeFor[ValidationIssue](),
+	"Vehicle":             reflect.TypeFor[Vehicle](),
+	"Model":               reflect.TypeFor[Model](),
+	"JourneyPlan":         reflect.TypeFor[JourneyPlan](),
+	"CostBreakdown":       reflect.TypeFor[CostBreakdown](),
+	"LegCost":             reflect.TypeFor[LegCost](),
+	"ParkingSuggestion":   reflect.TypeFor[ParkingSuggestion](),
+	"LegZones":            reflect.TypeFor[LegZones](),
+	"TimelineEvent":       reflect.TypeFor[TimelineEvent](),
+	"BatchPlanRequest":    reflect.TypeFor[BatchPlanRequest](),
+	"BatchPlanResult":     reflect.TypeFor[BatchPlanResult](),
+	"BatchPlanResponse":   reflect.TypeFor[BatchPlanResponse](),
+	"SavedJourneyInput":   reflect.TypeFor[SavedJourneyInput](),
+	"SavedJourney":        reflect.TypeFor[SavedJourney](),
+	"JourneyQuote":        reflect.TypeFor[JourneyQuote](),
+	"RequoteResult":       reflect.TypeFor[RequoteResult](),
+	"PricingModel":        reflect.TypeFor[PricingModel](),
+	"PricingResponse":     reflect.TypeFor[PricingResponse](),
+	"LegRoute":            reflect.TypeFor[legRoute](),
+	"JourneyRoute":        reflect.TypeFor[journeyRoute](),
+	"Quote":               reflect.TypeFor[Quote](),
+	"QuoteOutcome":        reflect.TypeFor[QuoteOutcome](),
+	"QuoteReplay":         reflect.TypeFor[QuoteReplay](),
+	"KeyUsage":            reflect.TypeFor[KeyUsage](),
+	"VehicleFilter":       reflect.TypeFor[VehicleFilter](),
+	"WatchInput":          reflect.TypeFor[WatchInput](),
+	"Watch":               reflect.TypeFor[Watch](),
+	"WatchNotification":   reflect.TypeFor[WatchNotification](),
+	"VehicleResult":       reflect.TypeFor[VehicleResult](),
+	"VehicleDetail":       reflect.TypeFor[VehicleDetail](),
+	"GeozoneSummary":      reflect.TypeFor[GeozoneSummary](),
+	"PlanTariff":          reflect.TypeFor[PlanTariff](),
+	"Tariff":              reflect.TypeFor[Tariff](),
+	"TariffChange":        reflect.TypeFor[TariffChange](),
+	"TariffHistory":       reflect.TypeFor[TariffHistory](),
+	"BuildInfo":           reflect.TypeFor[BuildInfo](),
+	"DependencyCheck":     reflect.TypeFor[DependencyCheck](),
+	"ReadinessReport":     reflect.TypeFor[ReadinessReport](),
+	"Stop":                reflect.TypeFor[Stop](),
+	"OptimizationRequest": reflect.TypeFor[OptimizationRequest](),
+	"OptimizationResult":  reflect.TypeFor[OptimizationResult](),
+	"Problem":             reflect.TypeFor[Problem](),
+}
+
+// openAPIUntypedSchemas are built from maps rather than structs.
+var openAPIUntypedSchemas = []string{"Health", "HealthV2"}
+
+func jsonFieldNames(typ reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+
+	for i := range typ.NumField() {
+		field := typ.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+
+		// Embedded structs without a name are flattened, like encoding/json
+		// does.
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			maps.Copy(fields, jsonFieldNames(field.Type))
+
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		fields[name] = field.Type
+	}
+
+	return fields
+}
+
+// checkSchemaType reports whether a Go type can carry values of schema.
+func checkSchemaType(t *testing.T, context string, schema *openAPISchema, typ reflect.Type) {
+	t.Helper()
+
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+
+	if schema.Ref != "" {
+		name := strings.TrimPrefix(schema.Ref, openAPISchemaRefPrefix)
+		if expected, ok := openAPISchemaTypes[name]; !ok || expected != typ {
+			t.Errorf("%s: $ref %s does not match Go type %s", context, name, typ)
+		}
+
+		return
+	}
+
+	if typ == reflect.TypeFor[time.Time]() {
+		if schema.Type != "string" || schema.Format != "date-time" {
+			t.Errorf("%s: expected a date-time string for %s", context, typ)
+		}
+
+		return
+	}
+
+	var expected string
+
+	switch typ.Kind() {
+	case reflect.String:
+		expected = "string"
+	case reflect.Bool:
+		expected = "boolean"
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		expected = "integer"
+	case reflect.Float32, reflect.Float64:
+		expected = "number"
+	case reflect.Slice:
+		expected = "array"
+	case reflect.Struct, reflect.Map:
+		expected = "object"
+	case reflect.Interface:
+		expected = ""
+	default:
+		t.Fatalf("%s: unsupported Go type %s", context, typ)
+	}
+
+	if schema.Type != expected {
+		t.Errorf("%s: expected schema type %q for %s but got %q", context, expected, typ, schema.Type)
+
+		return
+	}
+
+	if expected == "array" {
+		if schema.Items == nil {
+			t.Errorf("%s: array schema has no items", context)
+
+			return
+		}
+
+		checkSchemaType(t, context+"[]", schema.Items, typ.Elem())
+	}
+}
+
+func TestOpenAPIDocument_MatchesGoTypes(t *testing.T) {
+	for name, schema := range apiSpec.Components.Schemas {
+		typ, ok := openAPISchemaTypes[name]
+		if !ok {
+			if !slices.Contains(openAPIUntypedSchemas, name) {
+				t.Errorf("Schema %s has no Go type", name)
+			}
+
+			continue
+		}
+
+		fields := jsonFieldNames(typ)
+
+		for property, propertySchema := range schema.Properties {
+			fieldType, ok := fields[property]
+			if !ok {
+				t.Errorf("Schema %s property %s has no field in %s", name, property, typ)
+
+				continue
+			}
+
+			checkSchemaType(t, name+"."+property, propertySchema, fieldType)
+		}
+
+		for field := range fields {
+			if _, ok := schema.Properties[field]; !ok {
+				t.Errorf("Field %s of %s is missing from schema %s", field, typ, name)
+			}
+		}
+
+		for _, required := range schema.Required {
+			if _, ok := fields[required]; !ok {
+				t.Errorf("Schema %s requires unknown property %s", name, required)
+			}
+		}
+	}
+
+	for name := range openAPISchemaTypes {
+		if _, ok := apiSpec.Components.Schemas[name]; !ok {
+			t.Errorf("Go type for %s has no schema", name)
+		}
+	}
+}
+
+// openAPIUndocumentedRoutes are /api/ paths that are not operations: the
+// v2 catch-all answers unknown routes with a problem.
+var openAPIUndocumentedRoutes = []string{"/api/v2/"}
+
+func TestOpenAPIDocument_CoversRoutes(t *testing.T) {
+	for _, route := range routes(services{}) {
+		method, path, ok := strings.Cut(route.pattern, " ")
+		if !ok {
+			method, path = "", route.pattern
+		}
+
+		if !strings.HasPrefix(path, "/api/") || slices.Contains(openAPIUndocumentedRoutes, path) {
+			continue
+		}
+
+		if _, ok := apiSpec.Paths[path][strings.ToLower(method)]; !ok {
+			t.Errorf("Route %s is missing from openapi.json", route.pattern)
+		}
+	}
+}
+
+func TestOpenAPIDocument_MatchesValidationLimits(t *testing.T) {
+	legs := apiSpec.Components.Schemas["Journey"].Properties["legs"]
+	if legs.MaxItems == nil || *legs.MaxItems != maxJourneyLegs {
+		t.Errorf("Expected legs maxItems %d in the spec", maxJourneyLegs)
+	}
+
+	journeys := apiSpec.Components.Schemas["BatchPlanRequest"].Properties["journeys"]
+	if journeys.MaxItems == nil || *journeys.MaxItems != maxBatchJourneys {
+		t.Errorf("Expected journeys maxItems %d in the spec", maxBatchJourneys)
+	}
+
+	stops := apiSpec.Components.Schemas["OptimizationRequest"].Properties["stops"]
+	if stops.MaxItems == nil || *stops.MaxItems != maxOptimizationStops {
+		t.Errorf("Expected stops maxItems %d in the spec", maxOptimizationStops)
+	}
+
+	for _, issue := range []openAPIIssue{
+		legs.Issues["minItems"],
+		legs.Issues["maxItems"],
+		stops.Issues["minItems"],
+		stops.Issues["maxItems"],
+	} {
+		if issue.Code == "" {
+			t.Error("Expected the legs limits to map to issue codes")
+		}
+	}
+}
+
+func TestOpenAPIDocument_RequestValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected []ValidationIssue
+	}{
+		{
+			name: "Valid",
+			body: `{"journey": {"legs": [{
+				"startLocation": {"lat": 50.85, "lng": 4.35},
+				"endLocation": {"address": "Place Flagey"},
+				"pauseMinutes": 10
+			}], "departureTime": "2025-06-02T09:00:00+02:00"}}`,
+		},
+		{
+			name: "Null departure time",
+			body: `{"journey": {"legs": [{
+				"startLocation": {"lat": 50.85, "lng": 4.35},
+				"endLocation": {"lat": 50.83, "lng": 4.37}
+			}], "departureTime": null}}`,
+		},
+		{
+			name: "Missing journey",
+			body: `{}`,
+			expected: []ValidationIssue{
+				{Code: validationCodeInvalidField, Field: "journey"},
+			},
+		},
+		{
+			name: "No legs",
+			body: `{"journey": {"legs": []}}`,
+			expected: []ValidationIssue{
+				{Code: validationCodeNoLegs, Field: "journey.legs"},
+			},
+		},
+		{
+			name: "Out of range coordinates and negative pause",
+			body: `{"journey": {"legs": [
+				{"startLocation": {"lat": 50.85, "lng": 4.35}, "endLocation": {"lat": 50.83, "lng": 4.37}},
+				{"startLocation": {"lat": 91, "lng": 4.35}, "endLocation": {"lat": 50.83, "lng": -181}, "pauseMinutes": -5}
+			]}}`,
+			expected: []ValidationIssue{
+				{Code: validationCodeInvalidCoordinates, LegIndex: legIndex(1), Field: "endLocation.lng"},
+				{Code: validationCodeNegativePause, LegIndex: legIndex(1), Field: "pauseMinutes"},
+				{Code: validationCodeInvalidCoordinates, LegIndex: legIndex(1), Field: "startLocation.lat"},
+			},
+		},
+		{
+			name: "Wrong types",
+			body: `{"journey": {"legs": [
+				{"startLocation": {"lat": "50.85", "lng": 4.35}, "endLocation": {"lat": 50.83, "lng": 4.37}, "pauseMinutes": 1.5}
+			], "departureTime": "tomorrow"}}`,
+			expected: []ValidationIssue{
+				{Code: validationCodeInvalidField, Field: "journey.departureTime"},
+				{Code: validationCodeInvalidField, LegIndex: legIndex(0), Field: "pauseMinutes"},
+				{Code: validationCodeInvalidField, LegIndex: legIndex(0), Field: "startLocation.lat"},
+			},
+		},
+		{
+			name: "Missing end location",
+			body: `{"journey": {"legs": [{"startLocation": {"lat": 50.85, "lng": 4.35}}]}}`,
+			expected: []ValidationIssue{
+				{Code: validationCodeInvalidField, LegIndex: legIndex(0), Field: "endLocation"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body any
+			if err := json.Unmarshal([]byte(tt.body), &body); err != nil {
+				t.Fatalf("Invalid test body: %v", err)
+			}
+
+			err := apiSpec.validateRequest(http.MethodPost, "/api/v1/plan-journey", body)
+
+			if len(tt.expected) == 0 {
+				if err != nil {
+					t.Errorf("Expected no issues but got %v", err)
+				}
+
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("Expected %d issues but got none", len(tt.expected))
+			}
+
+			if len(err.Issues) != len(tt.expected) {
+				t.Fatalf("Expected %d issues but got %+v", len(tt.expected), err.Issues)
+			}
+
+			for i, issue := range err.Issues {
+				expected := tt.expected[i]
+
+				if issue.Code != expected.Code ||
+					issue.Field != expected.Field ||
+					!reflect.DeepEqual(issue.LegIndex, expected.LegIndex) {
+					t.Errorf(
+						"Issue %d: expected %s at %q but got %s at %q",
+						i,
+						expected.Code,
+						expected.Location(),
+						issue.Code,
+						issue.Location(),
+					)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenAPIDocument_TooManyLegs(t *testing.T) {
+	legs := make([]TripLeg, maxJourneyLegs+1)
+	for i := range legs {
+		legs[i] = TripLeg{
+			StartLocation: Location{Lat: 50.85, Lng: 4.35},
+			EndLocation:   Location{Lat: 50.83, Lng: 4.37},
+		}
+	}
+
+	data, _ := json.Marshal(PlanJourneyRequest{Journey: Journey{Legs: legs}})
+
+	request := httptest.NewRequest(http.MethodPost, "/api/v1/plan-journey", strings.NewReader(string(data)))
+
+	var decoded PlanJourneyRequest
+
+	err := apiSpec.decodeRequest(request, "/api/v1/plan-journey", &decoded)
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Issues[0].Code != validationCodeTooManyLegs {
+		t.Errorf("Expected %s but got %v", validationCodeTooManyLegs, err)
+	}
+}
+
+func TestOpenAPIDocument_DecodeRequest(t *testing.T) {
+	request := httptest.NewRequest(http.MethodPost, "/api/v1/plan-journey", strings.NewReader("{"))
+
+	var decoded PlanJourneyRequest
+	if err := apiSpec.decodeRequest(request, "/api/v1/plan-journey", &decoded); !errors.Is(err, errInvalidRequestBody) {
+		t.Errorf("Expected errInvalidRequestBody but got %v", err)
+	}
+
+	request = httptest.NewRequest(
+		http.MethodPost,
+		"/api/v1/plan-journey",
+		strings.NewReader(`{"journey": {"legs": [{
+			"startLocation": {"lat": 50.85, "lng": 4.35},
+			"endLocation": {"lat": 50.83, "lng": 4.37},
+			"pauseMinutes": 5
+		}]}}`),
+	)
+
+	if err := apiSpec.decodeRequest(request, "/api/v1/plan-journey", &decoded); err != nil {
+		t.Fatalf("Expected no error but got %v", err)
+	}
+
+	if len(decoded.Journey.Legs) != 1 || decoded.Journey.Legs[0].PauseMinutes != 5 {
+		t.Errorf("Unexpected decoded request %+v", decoded)
+	}
+}
+
+func TestOpenAPIDocument_DecodeRequestTooLarge(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		handler     http.HandlerFunc
+		contentType string
+	}{
+		{name: "v1", path: "/api/v1/plan-journey", handler: planJourneyHandler(nil, nil, nil, nil), contentType: "application/json"},
+		{name: "v2", path: "/api/v2/plan-journey", handler: planJourneyV2Handler(nil, nil, nil, nil), contentType: problemContentType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(`{"journey": {"legs": []}}`))
+			request.ContentLength = -1
+
+			recorder := httptest.NewRecorder()
+			withBodyLimit(16, tt.handler).ServeHTTP(recorder, request)
+
+			if recorder.Code != http.StatusRequestEntityTooLarge {
+				t.Errorf("Expected status 413 but got %d", recorder.Code)
+			}
+
+			if contentType := recorder.Header().Get("Content-Type"); contentType != tt.contentType {
+				t.Errorf("Expected content type %s but got %s", tt.contentType, contentType)
+			}
+		})
+	}
+}
@@ -0,0 +1,612 @@
+//nolint:package-comments,revive,mnd,exhaustruct,err113,errchkjson
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// maxExactOptimizationStops is the largest stop count searched
+	// exhaustively: 8! orders, each priced in microseconds.
+	maxExactOptimizationStops = 8
+	maxOptimizationStops      = 25
+
+	optimizationMethodExact     = "exact"
+	optimizationMethodHeuristic = "heuristic"
+)
+
+type Stop struct {
+	Location     Location `json:"location"`
+	PauseMinutes int      `json:"pauseMinutes"`
+}
+
+type OptimizationRequest struct {
+	Start         Location   `json:"start"`
+	End           Location   `json:"end"`
+	Stops         []Stop     `json:"stops"`
+	DepartureTime *time.Time `json:"departureTime,omitempty"`
+}
+
+type OptimizationResult struct {
+	Plan          *JourneyPlan `json:"plan"`
+	StopOrder     []int        `json:"stopOrder"`
+	Method        string       `json:"method"`
+	OriginalCost  float64      `json:"originalCost"`
+	OptimizedCost float64      `json:"optimizedCost"`
+	Savings       float64      `json:"savings"`
+}
+
+// journeyForStopOrder builds the legs start → stops in order → end. The
+// pause of each stop is taken on the leg arriving there.
+func journeyForStopOrder(request OptimizationRequest, order []int) Journey {
+	journey := Journey{
+		Legs:          make([]TripLeg, 0, len(order)+1),
+		DepartureTime: request.DepartureTime,
+	}
+
+	current := request.Start
+
+	for _, stopIndex := range order {
+		stop := request.Stops[stopIndex]
+
+		journey.Legs = append(journey.Legs, TripLeg{
+			StartLocation: current,
+			EndLocation:   stop.Location,
+			PauseMinutes:  stop.PauseMinutes,
+		})
+
+		current = stop.Location
+	}
+
+	journey.Legs = append(journey.Legs, TripLeg{
+		StartLocation: current,
+		EndLocation:   request.End,
+	})
+
+	return journey
+}
+
+func identityOrder(count int) []int {
+	order := make([]int, count)
+	for i := range order {
+		order[i] = i
+	}
+
+	return order
+}
+
+// stopOrderCoster prices stop orders from a precomputed driving matrix, so
+// the search itself performs no I/O. Matrix index 0 is the start, 1..n the
+// stops and n+1 the end.
+type stopOrderCoster struct {
+	request            OptimizationRequest
+	vehicle            Vehicle
+	pricing            *PricingResponse
+	drivingTimes       [][]float64
+	walkMinutes        float64
+	inParking          []bool
+	hasGeoZones        bool
+	approximate        bool
+	freeBookingMinutes float64
+}
+
+func (c *stopOrderCoster) route(order []int) journeyRoute {
+	route := journeyRoute{
+		WalkToVehicleMinutes:   c.walkMinutes,
+		Legs:                   make([]legRoute, 0, len(order)+1),
+		HasGeoZones:            c.hasGeoZones,
+		UsedApproximateRouting: c.approximate,
+		FreeBookingMinutes:     c.freeBookingMinutes,
+	}
+
+	points := make([]int, 0, len(order)+2)
+	points = append(points, 0)
+
+	for _, stopIndex := range order {
+		points = append(points, stopIndex+1)
+	}
+
+	points = append(points, len(c.request.Stops)+1)
+
+	locations := c.locations()
+
+	for i := 1; i < len(points); i++ {
+		from, to := points[i-1], points[i]
+
+		leg := legRoute{
+			DrivingMinutes: c.drivingTimes[from][to],
+			DistanceKm: calculateDistance(
+				locations[from].Lat,
+				locations[from].Lng,
+				locations[to].Lat,
+				locations[to].Lng,
+			),
+			EndInParkingZone: c.inParking[to],
+		}
+
+		if i == 1 {
+			// The walk back from the vehicle mirrors the walk to it.
+			leg.WalkToStartMinutes = c.walkMinutes
+		}
+
+		route.Legs = append(route.Legs, leg)
+	}
+
+	return route
+}
+
+func (c *stopOrderCoster) locations() []Location {
+	locations := make([]Location, 0, len(c.request.Stops)+2)
+	locations = append(locations, c.request.Start)
+
+	for _, stop := range c.request.Stops {
+		locations = append(locations, stop.Location)
+	}
+
+	return append(locations, c.request.End)
+}
+
+func (c *stopOrderCoster) cost(order []int) float64 {
+	plan, err := cheapestPlan(
+		journeyForStopOrder(c.request, order),
+		c.route(order),
+		c.vehicle,
+		c.pricing,
+	)
+	if err != nil {
+		return math.Inf(1)
+	}
+
+	return plan.TotalCost
+}
+
+// searchExact tries every stop order using Heap's algorithm.
+func (c *stopOrderCoster) searchExact() ([]int, float64) {
+	order := identityOrder(len(c.request.Stops))
+	best := append([]int(nil), order...)
+	bestCost := c.cost(order)
+
+	counters := make([]int, len(order))
+
+	for i := 1; i < len(order); {
+		if counters[i] >= i {
+			counters[i] = 0
+			i++
+
+			continue
+		}
+
+		if i%2 == 0 {
+			order[0], order[i] = order[i], order[0]
+		} else {
+			order[counters[i]], order[i] = order[i], order[counters[i]]
+		}
+
+		if cost := c.cost(order); cost < bestCost {
+			bestCost = cost
+			best = append(best[:0], order...)
+		}
+
+		counters[i]++
+		i = 1
+	}
+
+	return best, bestCost
+}
+
+// searchHeuristic builds a nearest-neighbour order on driving time and
+// improves it with 2-opt reversals under the real cost function until no
+// reversal helps.
+func (c *stopOrderCoster) searchHeuristic() ([]int, float64) {
+	remaining := identityOrder(len(c.request.Stops))
+	order := make([]int, 0, len(remaining))
+	current := 0
+
+	for len(remaining) > 0 {
+		nearest := 0
+
+		for i, stopIndex := range remaining {
+			if c.drivingTimes[current][stopIndex+1] <
+				c.drivingTimes[current][remaining[nearest]+1] {
+				nearest = i
+			}
+		}
+
+		current = remaining[nearest] + 1
+		order = append(order, remaining[nearest])
+		remaining = append(remaining[:nearest], remaining[nearest+1:]...)
+	}
+
+	bestCost := c.cost(order)
+
+	for improved := true; improved; {
+		improved = false
+
+		for i := 0; i < len(order)-1; i++ {
+			for j := i + 1; j < len(order); j++ {
+				reverseStops(order, i, j)
+
+				if cost := c.cost(order); cost < bestCost-1e-9 {
+					bestCost = cost
+					improved = true
+
+					continue
+				}
+
+				reverseStops(order, i, j)
+			}
+		}
+	}
+
+	return order, bestCost
+}
+
+func reverseStops(order []int, from, to int) {
+	for from < to {
+		order[from], order[to] = order[to], order[from]
+		from++
+		to--
+	}
+}
+
+type orsMatrixResponse struct {
+	Durations [][]*float64 `json:"durations"`
+}
+
+// fetchORSMatrix returns the driving minutes between every pair of
+// locations in a single ORS matrix request.
+func fetchORSMatrix(
+	ctx context.Context,
+	client *http.Client,
+	locations []Location,
+	profile string,
+) (_ [][]float64, err error) {
+	apiKey := configFromContext(ctx).ORSAPIKey
+	if apiKey == "" {
+		return nil, errors.New("ORS API key not set")
+	}
+
+	if err := spendORSBudget(ctx); err != nil {
+		return nil, err
+	}
+
+	defer traceUpstream(ctx, "ors_matrix", time.Now(), &err, "profile", profile, "locations", len(locations))
+
+	config := configFromContext(ctx)
+
+	targetURL, err := url.JoinPath(config.ORSMatrixURL, profile)
+	if err != nil {
+		return nil, fmt.Errorf("[fetchORSMatrix] could not parse URL: %w", err)
+	}
+
+	coordinates := make([][]float64, 0, len(locations))
+	for _, location := range locations {
+		coordinates = append(coordinates, []float64{location.Lng, location.Lat})
+	}
+
+	jsonData, err := json.Marshal(map[string]any{
+		"locations": coordinates,
+		"metrics":   []string{"duration"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[fetchORSMatrix] error marshaling request: %w", err)
+	}
+
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, config.ORSTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(
+		ctxWithTimeout,
+		http.MethodPost,
+		targetURL,
+		strings.NewReader(string(jsonData)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("[fetchORSMatrix] error creating request: %w", err)
+	}
+
+	req.Header.Set("Authorization", apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("[fetchORSMatrix] request failed: %w", err)
+	}
+
+	defer func() { _ = res.Body.Close() }()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("[fetchORSMatrix] API returned status %d", res.StatusCode)
+	}
+
+	var matrix orsMatrixResponse
+	if err := json.NewDecoder(res.Body).Decode(&matrix); err != nil {
+		return nil, fmt.Errorf("[fetchORSMatrix] error decoding response: %w", err)
+	}
+
+	if len(matrix.Durations) != len(locations) {
+		return nil, errors.New("[fetchORSMatrix] incomplete matrix")
+	}
+
+	minutes := make([][]float64, len(locations))
+
+	for i, row := range matrix.Durations {
+		if len(row) != len(locations) {
+			return nil, errors.New("[fetchORSMatrix] incomplete matrix")
+		}
+
+		minutes[i] = make([]float64, len(row))
+
+		for j, seconds := range row {
+			if seconds == nil {
+				return nil, fmt.Errorf("[fetchORSMatrix] no route from %d to %d", i, j)
+			}
+
+			minutes[i][j] = *seconds / 60
+		}
+	}
+
+	return minutes, nil
+}
+
+// calculateDrivingMatrix falls back to crow-flies estimates for the whole
+// matrix when ORS is unavailable, like calculateDrivingTime does per leg.
+func calculateDrivingMatrix(
+	ctx context.Context,
+	client *http.Client,
+	locations []Location,
+) (drivingTimes [][]float64, isApproximate bool) {
+	if matrix, err := fetchORSMatrix(ctx, client, locations, "driving-car"); err == nil {
+		return matrix, false
+	}
+
+	drivingTimes = make([][]float64, len(locations))
+	speedKmh := configFromContext(ctx).DrivingSpeedKmh
+
+	for i, from := range locations {
+		drivingTimes[i] = make([]float64, len(locations))
+
+		for j, to := range locations {
+			distance := calculateDistance(from.Lat, from.Lng, to.Lat, to.Lng)
+			drivingTimes[i][j] = (distance / speedKmh) * 60
+		}
+	}
+
+	return drivingTimes, true
+}
+
+func validateOptimizationRequest(request OptimizationRequest) *ValidationError {
+	result := &ValidationError{}
+
+	if len(request.Stops) == 0 {
+		result.add(ValidationIssue{
+			Code:    validationCodeNoStops,
+			Field:   "stops",
+			Message: "No stops to order",
+			Remedy:  "Add at least one stop, or plan the journey directly",
+		})
+	}
+
+	if len(request.Stops) > maxOptimizationStops {
+		result.add(ValidationIssue{
+			Code:  validationCodeTooManyStops,
+			Field: "stops",
+			Message: fmt.Sprintf(
+				"%d stops exceed the maximum of %d",
+				len(request.Stops),
+				maxOptimizationStops,
+			),
+			Remedy: "Split the day into several journeys",
+		})
+	}
+
+	if len(result.Issues) == 0 {
+		return nil
+	}
+
+	return result
+}
+
+// optimizeJourney searches the stop order that minimizes the total cost
+// under the real pricing model on a shared driving matrix, then plans the
+// request order and the winning order in full. The reported costs are
+// those of the two plans, so the savings match the plan returned.
+func optimizeJourney(
+	ctx context.Context,
+	client *http.Client,
+	cache *upstreamCache,
+	addressGeocoder geocoder,
+	request OptimizationRequest,
+) (*OptimizationResult, error) {
+	if validationErr := validateOptimizationRequest(request); validationErr != nil {
+		return nil, validationErr
+	}
+
+	original, geocodeErr := resolveJourneyAddresses(
+		ctx,
+		addressGeocoder,
+		journeyForStopOrder(request, identityOrder(len(request.Stops))),
+	)
+	if geocodeErr != nil {
+		return nil, geocodeErr
+	}
+
+	if validationErr := validateJourney(original); validationErr != nil {
+		return nil, validationErr
+	}
+
+	// Geocoded coordinates are copied back so every order reuses them. The
+	// stops are copied first, as the caller's slice is not ours to change.
+	request.Start = original.Legs[0].StartLocation
+	request.Stops = slices.Clone(request.Stops)
+
+	for i := range request.Stops {
+		request.Stops[i].Location = original.Legs[i].EndLocation
+	}
+
+	request.End = original.Legs[len(original.Legs)-1].EndLocation
+
+	data, err := fetchPlanningData(ctx, client, cache, request.Start)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, zoneErr := checkJourneyZones(original, data.zones); zoneErr != nil {
+		return nil, zoneErr
+	}
+
+	if adjusted, suggestion, _ := suggestParkingSpot(ctx, client, original, data.zones); suggestion != nil {
+		request.End = adjusted.Legs[len(adjusted.Legs)-1].EndLocation
+	}
+
+	coster := &stopOrderCoster{
+		request:            request,
+		vehicle:            data.vehicle,
+		pricing:            data.pricing,
+		hasGeoZones:        data.zones != nil,
+		freeBookingMinutes: configFromContext(ctx).FreeBookingMinutes,
+	}
+
+	locations := coster.locations()
+	coster.drivingTimes, coster.approximate = calculateDrivingMatrix(ctx, client, locations)
+
+	walkMinutes, isApproximate := calculateWalkingTime(
+		ctx,
+		client,
+		request.Start,
+		vehicleToLocation(data.vehicle),
+	)
+	coster.walkMinutes = walkMinutes
+	coster.approximate = coster.approximate || isApproximate
+
+	coster.inParking = make([]bool, len(locations))
+	for i, location := range locations {
+		coster.inParking[i] = data.zones.inParkingZone(location)
+	}
+
+	var (
+		order  []int
+		cost   float64
+		method string
+	)
+
+	if len(request.Stops) <= maxExactOptimizationStops {
+		order, cost = coster.searchExact()
+		method = optimizationMethodExact
+	} else {
+		order, cost = coster.searchHeuristic()
+		method = optimizationMethodHeuristic
+	}
+
+	if math.IsInf(cost, 1) {
+		return nil, newValidationError(nil, ValidationIssue{
+			Code:    validationCodeEndOutsideParkingZone,
+			Field:   "end",
+			Message: "No stop order ends inside a parking zone",
+			Remedy:  "Choose an end location inside the Poppy operating area",
+		})
+	}
+
+	baseline, err := planJourney(ctx, client, cache, addressGeocoder, original)
+	if err != nil {
+		return nil, fmt.Errorf("[optimizeJourney] failed to plan request order: %w", err)
+	}
+
+	plan := baseline
+
+	if !slices.Equal(order, identityOrder(len(order))) {
+		optimized := journeyForStopOrder(request, order)
+		optimized.Legs[len(optimized.Legs)-1].EndLocation = original.Legs[len(original.Legs)-1].EndLocation
+
+		plan, err = planJourney(ctx, client, cache, addressGeocoder, optimized)
+		if err != nil {
+			return nil, fmt.Errorf("[optimizeJourney] failed to plan optimized order: %w", err)
+		}
+	}
+
+	return newOptimizationResult(baseline, plan, order, method), nil
+}
+
+// newOptimizationResult compares the plans of the request order and of
+// order. The matrix the search runs on only approximates the routing of
+// a plan, so when the optimized plan turns out dearer the request order is
+// kept.
+func newOptimizationResult(baseline, plan *JourneyPlan, order []int, method string) *OptimizationResult {
+	if plan.TotalCost > baseline.TotalCost {
+		plan, order = baseline, identityOrder(len(order))
+	}
+
+	return &OptimizationResult{
+		Plan:          plan,
+		StopOrder:     order,
+		Method:        method,
+		OriginalCost:  baseline.TotalCost,
+		OptimizedCost: plan.TotalCost,
+		Savings:       math.Round((baseline.TotalCost-plan.TotalCost)*priceUnitFactor) / priceUnitFactor,
+	}
+}
+
+func optimizeJourneyHandler(
+	client *http.Client,
+	cache *upstreamCache,
+	addressGeocoder geocoder,
+	quotes *quoteStore,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request OptimizationRequest
+
+		if err := apiSpec.decodeRequest(r, "/api/v1/optimize-journey", &request); err != nil {
+			if respondBodyTooLarge(w, r, err) {
+				return
+			}
+
+			if errors.Is(err, errInvalidRequestBody) {
+				respondJSON(w, http.StatusBadRequest, APIResponse{
+					Success: false,
+					Error:   "Invalid JSON request body",
+				})
+
+				return
+			}
+
+			respondJSON(w, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error:   err.Error(),
+				Issues:  validationIssues(err),
+			})
+
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 20*time.Second)
+		defer cancel()
+
+		result, err := optimizeJourney(ctx, client, cache, addressGeocoder, request)
+		if err != nil {
+			respondJSON(w, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error:   err.Error(),
+				Issues:  validationIssues(err),
+			})
+
+			return
+		}
+
+		quotes.recordOrWarn(ctx, result.Plan)
+
+		respondJSON(w, http.StatusOK, APIResponse{
+			Success: true,
+			Data:    result,
+		})
+	}
+}
@@ -0,0 +1,301 @@
+//nolint:package-comments,revive,mnd,exhaustruct
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// geocoderFunc adapts a function to the geocoder interface.
+type geocoderFunc func(ctx context.Context, query string) (Location, error)
+
+func (f geocoderFunc) Geocode(ctx context.Context, query string) (Location, error) {
+	return f(ctx, query)
+}
+
+func newTestStopOrderCoster(locations []Location) *stopOrderCoster {
+	request := OptimizationRequest{
+		Start: locations[0],
+		End:   locations[len(locations)-1],
+	}
+
+	for _, location := range locations[1 : len(locations)-1] {
+		request.Stops = append(request.Stops, Stop{Location: location})
+	}
+
+	pricing := PricingModel{UnlockFee: 1000, MinutePrice: 300, DayCapPrice: 100000}
+
+	coster := &stopOrderCoster{
+		request: request,
+		vehicle: Vehicle{
+			LocationLatitude:  locations[0].Lat,
+			LocationLongitude: locations[0].Lng,
+		},
+		pricing: &PricingResponse{
+			PricingPerMinute:    pricing,
+			PricingPerKilometer: pricing,
+			SmartPricing:        pricing,
+		},
+		inParking: make([]bool, len(locations)),
+	}
+
+	coster.drivingTimes = make([][]float64, len(locations))
+	for i, from := range locations {
+		coster.drivingTimes[i] = make([]float64, len(locations))
+
+		for j, to := range locations {
+			coster.drivingTimes[i][j] = calculateDistance(from.Lat, from.Lng, to.Lat, to.Lng) * 3
+		}
+	}
+
+	return coster
+}
+
+func TestJourneyForStopOrder(t *testing.T) {
+	request := OptimizationRequest{
+		Start: Location{Lat: 50.80, Lng: 4.30},
+		End:   Location{Lat: 50.90, Lng: 4.40},
+		Stops: []Stop{
+			{Location: Location{Lat: 50.82, Lng: 4.32}, PauseMinutes: 10},
+			{Location: Location{Lat: 50.84, Lng: 4.34}, PauseMinutes: 20},
+		},
+	}
+
+	journey := journeyForStopOrder(request, []int{1, 0})
+
+	if len(journey.Legs) != 3 {
+		t.Fatalf("Expected 3 legs but got %d", len(journey.Legs))
+	}
+
+	expected := []TripLeg{
+		{StartLocation: request.Start, EndLocation: request.Stops[1].Location, PauseMinutes: 20},
+		{StartLocation: request.Stops[1].Location, EndLocation: request.Stops[0].Location, PauseMinutes: 10},
+		{StartLocation: request.Stops[0].Location, EndLocation: request.End},
+	}
+
+	for i, leg := range journey.Legs {
+		if leg.StartLocation != expected[i].StartLocation ||
+			leg.EndLocation != expected[i].EndLocation ||
+			leg.PauseMinutes != expected[i].PauseMinutes {
+			t.Errorf("Leg %d: expected %+v but got %+v", i, expected[i], leg)
+		}
+	}
+}
+
+func TestStopOrderCoster_SearchExact(t *testing.T) {
+	// Stops along a line, submitted out of order.
+	coster := newTestStopOrderCoster([]Location{
+		{Lat: 50.80, Lng: 4.30},
+		{Lat: 50.83, Lng: 4.30},
+		{Lat: 50.81, Lng: 4.30},
+		{Lat: 50.84, Lng: 4.30},
+		{Lat: 50.82, Lng: 4.30},
+		{Lat: 50.85, Lng: 4.30},
+	})
+
+	order, cost := coster.searchExact()
+
+	if expected := []int{1, 3, 0, 2}; !slices.Equal(order, expected) {
+		t.Errorf("Expected order %v but got %v", expected, order)
+	}
+
+	if original := coster.cost(identityOrder(4)); cost >= original {
+		t.Errorf("Expected optimized cost below %.2f but got %.2f", original, cost)
+	}
+}
+
+func TestStopOrderCoster_SearchHeuristic(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	for run := range 5 {
+		locations := make([]Location, 8)
+		for i := range locations {
+			locations[i] = Location{
+				Lat: 50.80 + random.Float64()*0.1,
+				Lng: 4.30 + random.Float64()*0.1,
+			}
+		}
+
+		coster := newTestStopOrderCoster(locations)
+
+		_, exactCost := coster.searchExact()
+		_, heuristicCost := coster.searchHeuristic()
+
+		if heuristicCost > coster.cost(identityOrder(6))+1e-9 {
+			t.Errorf("Run %d: heuristic cost %.2f is worse than the original order", run, heuristicCost)
+		}
+
+		if heuristicCost < exactCost-1e-9 {
+			t.Errorf("Run %d: heuristic cost %.2f beats the exact optimum %.2f", run, heuristicCost, exactCost)
+		}
+	}
+}
+
+func TestStopOrderCoster_UnparkableEnd(t *testing.T) {
+	coster := newTestStopOrderCoster([]Location{
+		{Lat: 50.80, Lng: 4.30},
+		{Lat: 50.81, Lng: 4.30},
+		{Lat: 50.82, Lng: 4.30},
+	})
+	coster.hasGeoZones = true
+
+	if cost := coster.cost(identityOrder(1)); !math.IsInf(cost, 1) {
+		t.Errorf("Expected infinite cost when the end is outside parking zones but got %.2f", cost)
+	}
+}
+
+func TestValidateOptimizationRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		stops    int
+		expected validationCode
+	}{
+		{name: "No stops", stops: 0, expected: validationCodeNoStops},
+		{name: "Too many stops", stops: maxOptimizationStops + 1, expected: validationCodeTooManyStops},
+		{name: "Valid", stops: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateOptimizationRequest(OptimizationRequest{
+				Stops: make([]Stop, tt.stops),
+			})
+
+			if tt.expected == "" {
+				if err != nil {
+					t.Errorf("Expected no error but got %v", err)
+				}
+
+				return
+			}
+
+			if err == nil || err.Issues[0].Code != tt.expected {
+				t.Errorf("Expected %s but got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestOptimizeJourneyHandlers_ValidateAgainstSpec(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected validationCode
+	}{
+		{name: "Invalid JSON", body: "{"},
+		{
+			name:     "No stops",
+			body:     `{"start": {"lat": 50.85, "lng": 4.35}, "end": {"lat": 50.83, "lng": 4.37}, "stops": []}`,
+			expected: validationCodeNoStops,
+		},
+		{
+			name: "Out of range stop",
+			body: `{"start": {"lat": 50.85, "lng": 4.35}, "end": {"lat": 50.83, "lng": 4.37},
+				"stops": [{"location": {"lat": 91, "lng": 4.35}}]}`,
+			expected: validationCodeInvalidCoordinates,
+		},
+		{
+			name: "Negative pause",
+			body: `{"start": {"lat": 50.85, "lng": 4.35}, "end": {"lat": 50.83, "lng": 4.37},
+				"stops": [{"location": {"lat": 50.84, "lng": 4.36}, "pauseMinutes": -5}]}`,
+			expected: validationCodeNegativePause,
+		},
+	}
+
+	v1 := optimizeJourneyHandler(http.DefaultClient, nil, nil, nil)
+	v2 := optimizeJourneyV2Handler(http.DefaultClient, nil, nil, nil)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			v1.ServeHTTP(recorder, httptest.NewRequest(
+				http.MethodPost, "/api/v1/optimize-journey", strings.NewReader(tt.body),
+			))
+
+			var response APIResponse
+			_ = json.NewDecoder(recorder.Body).Decode(&response)
+
+			if recorder.Code != http.StatusBadRequest {
+				t.Errorf("Expected status 400 from v1 but got %d", recorder.Code)
+			}
+
+			if tt.expected != "" && (len(response.Issues) == 0 || response.Issues[0].Code != tt.expected) {
+				t.Errorf("Expected the issue %s from v1 but got %+v", tt.expected, response.Issues)
+			}
+
+			recorder = httptest.NewRecorder()
+			v2.ServeHTTP(recorder, httptest.NewRequest(
+				http.MethodPost, "/api/v2/optimize-journey", strings.NewReader(tt.body),
+			))
+
+			var problem Problem
+			_ = json.NewDecoder(recorder.Body).Decode(&problem)
+
+			if tt.expected != "" && (len(problem.Issues) == 0 || problem.Issues[0].Code != tt.expected) {
+				t.Errorf("Expected the issue %s from v2 but got %+v", tt.expected, problem)
+			}
+		})
+	}
+}
+
+func TestOptimizeJourney_LeavesRequestStopsAlone(t *testing.T) {
+	geocode := geocoderFunc(func(context.Context, string) (Location, error) {
+		return Location{Lat: 50.84, Lng: 4.36}, nil
+	})
+
+	client := &http.Client{Transport: roundTripFunc(func(*http.Request) (*http.Response, error) {
+		return nil, errors.New("offline")
+	})}
+
+	request := OptimizationRequest{
+		Start: Location{Lat: 50.85, Lng: 4.35},
+		End:   Location{Lat: 50.83, Lng: 4.37},
+		Stops: []Stop{{Location: Location{Address: "Place Flagey"}}},
+	}
+
+	if _, err := optimizeJourney(withConfig(context.Background(), defaultConfig()), client, nil, geocode, request); err == nil {
+		t.Fatal("Expected the offline upstream to fail the optimization")
+	}
+
+	if stop := request.Stops[0].Location; stop.Lat != 0 || stop.Address != "Place Flagey" {
+		t.Errorf("Expected the caller's stops to be left untouched but got %+v", stop)
+	}
+}
+
+func TestNewOptimizationResult(t *testing.T) {
+	baseline := &JourneyPlan{TotalCost: 12.4}
+
+	tests := []struct {
+		name          string
+		optimized     float64
+		expectedOrder []int
+		expectedCost  float64
+		savings       float64
+	}{
+		{name: "Cheaper", optimized: 10.1, expectedOrder: []int{1, 0}, expectedCost: 10.1, savings: 2.3},
+		{name: "Dearer keeps the request order", optimized: 13, expectedOrder: []int{0, 1}, expectedCost: 12.4, savings: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := newOptimizationResult(baseline, &JourneyPlan{TotalCost: tt.optimized}, []int{1, 0}, optimizationMethodExact)
+
+			if !slices.Equal(result.StopOrder, tt.expectedOrder) || result.Plan.TotalCost != tt.expectedCost {
+				t.Errorf("Expected order %v at %.2f but got %v at %.2f",
+					tt.expectedOrder, tt.expectedCost, result.StopOrder, result.Plan.TotalCost)
+			}
+
+			if result.OriginalCost != 12.4 || result.OptimizedCost != result.Plan.TotalCost || result.Savings != tt.savings {
+				t.Errorf("Expected costs agreeing with the plans and savings of %.2f but got %+v", tt.savings, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,358 @@
+//nolint:package-comments,revive,mnd,exhaustruct
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	tariffSnapshotInterval = 6 * time.Hour
+	maxTariffChanges       = 500
+)
+
+// PlanTariff is a pricing model in euros rather than the thousandths of a
+// euro Poppy uses.
+type PlanTariff struct {
+	Plan               pricingPlan `json:"plan"`
+	Name               string      `json:"name"`
+	UnlockFee          float64     `json:"unlockFee"`
+	PerMinute          float64     `json:"perMinute"`
+	PerKilometer       float64     `json:"perKilometer"`
+	PausePerMinute     float64     `json:"pausePerMinute"`
+	BookingPerMinute   float64     `json:"bookingPerMinute"`
+	IncludedKilometers int         `json:"includedKilometers"`
+	OverKilometer      float64     `json:"overKilometer"`
+	MoveUnitPrice      float64     `json:"moveUnitPrice"`
+	HourCap            float64     `json:"hourCap"`
+	DayCap             float64     `json:"dayCap"`
+}
+
+type Tariff struct {
+	ModelType vehicleModelType `json:"modelType"`
+	Tier      string           `json:"tier"`
+	Currency  string           `json:"currency"`
+	Plans     []PlanTariff     `json:"plans"`
+}
+
+// TariffChange is one field of one pricing model that changed between two
+// snapshots. Prices are in euros.
+type TariffChange struct {
+	DetectedAt time.Time   `json:"detectedAt"`
+	Plan       pricingPlan `json:"plan"`
+	Field      string      `json:"field"`
+	Old        float64     `json:"old"`
+	New        float64     `json:"new"`
+	Change     float64     `json:"change"`
+}
+
+// TariffHistory is the last snapshot of a model type and tier and every
+// change seen since the first one, newest first.
+type TariffHistory struct {
+	ModelType     vehicleModelType `json:"modelType"`
+	Tier          string           `json:"tier"`
+	FirstSeenAt   time.Time        `json:"firstSeenAt"`
+	LastCheckedAt time.Time        `json:"lastCheckedAt"`
+	Current       PricingResponse  `json:"current"`
+	Changes       []TariffChange   `json:"changes"`
+}
+
+type tariffStore = jsonStore[TariffHistory]
+
+func tariffKey(modelType vehicleModelType, tier string) string {
+	return string(modelType) + "/" + tier
+}
+
+func toEuros(price int) float64 {
+	return float64(price) / priceUnitFactor
+}
+
+func newPlanTariff(model PricingModel) PlanTariff {
+	return PlanTariff{
+		Plan:               model.Type,
+		Name:               model.Type.DisplayName(),
+		UnlockFee:          toEuros(model.UnlockFee),
+		PerMinute:          toEuros(model.MinutePrice),
+		PerKilometer:       toEuros(model.KilometerPrice),
+		PausePerMinute:     toEuros(model.PauseUnitPrice),
+		BookingPerMinute:   toEuros(model.BookUnitPrice),
+		IncludedKilometers: model.IncludedKilometers,
+		OverKilometer:      toEuros(model.OverKilometerPrice),
+		MoveUnitPrice:      toEuros(model.MoveUnitPrice),
+		HourCap:            toEuros(model.HourCapPrice),
+		DayCap:             toEuros(model.DayCapPrice),
+	}
+}
+
+func newTariff(modelType vehicleModelType, tier string, pricing *PricingResponse) Tariff {
+	return Tariff{
+		ModelType: modelType,
+		Tier:      tier,
+		Currency:  "EUR",
+		Plans: []PlanTariff{
+			newPlanTariff(pricing.PricingPerMinute),
+			newPlanTariff(pricing.PricingPerKilometer),
+			newPlanTariff(pricing.SmartPricing),
+		},
+	}
+}
+
+// tariffFields lists the fields compared between snapshots, in the units
+// of PlanTariff.
+func tariffFields(tariff PlanTariff) []struct {
+	name  string
+	value float64
+} {
+	return []struct {
+		name  string
+		value float64
+	}{
+		{"unlockFee", tariff.UnlockFee},
+		{"perMinute", tariff.PerMinute},
+		{"perKilometer", tariff.PerKilometer},
+		{"pausePerMinute", tariff.PausePerMinute},
+		{"bookingPerMinute", tariff.BookingPerMinute},
+		{"includedKilometers", float64(tariff.IncludedKilometers)},
+		{"overKilometer", tariff.OverKilometer},
+		{"moveUnitPrice", tariff.MoveUnitPrice},
+		{"hourCap", tariff.HourCap},
+		{"dayCap", tariff.DayCap},
+	}
+}
+
+// diffTariffs lists every field that differs between two snapshots of the
+// same model type and tier.
+func diffTariffs(previous, current *PricingResponse, detectedAt time.Time) []TariffChange {
+	changes := []TariffChange{}
+
+	before := newTariff("", "", previous).Plans
+	after := newTariff("", "", current).Plans
+
+	for i := range after {
+		oldFields := tariffFields(before[i])
+
+		for j, field := range tariffFields(after[i]) {
+			if field.value == oldFields[j].value {
+				continue
+			}
+
+			changes = append(changes, TariffChange{
+				DetectedAt: detectedAt,
+				Plan:       after[i].Plan,
+				Field:      field.name,
+				Old:        oldFields[j].value,
+				New:        field.value,
+				Change:     math.Round((field.value-oldFields[j].value)*priceUnitFactor) / priceUnitFactor,
+			})
+		}
+	}
+
+	return changes
+}
+
+// tariffMonitor snapshots the tariff of every model type and tier Poppy
+// prices and keeps a history of what changed.
+type tariffMonitor struct {
+	client *http.Client
+	cache  *upstreamCache
+	store  *tariffStore
+}
+
+func (m *tariffMonitor) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.snapshot(ctx, time.Now().UTC()); err != nil {
+			slog.WarnContext(ctx, "tariff snapshot failed", "error", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// snapshot fetches the current tariffs straight from Poppy, bypassing the
+// pricing cache, and records the changes against the last snapshot. It
+// asks for every known model type and tier, those in the fleet and those
+// snapshotted before, so a tier without vehicles right now keeps its
+// history. Tiers Poppy has no tariff for are skipped.
+func (m *tariffMonitor) snapshot(ctx context.Context, now time.Time) error {
+	type tariffID struct {
+		modelType vehicleModelType
+		tier      string
+	}
+
+	var ids []tariffID
+
+	seen := map[string]bool{}
+	add := func(modelType vehicleModelType, tier string) {
+		if key := tariffKey(modelType, tier); !seen[key] {
+			seen[key] = true
+			ids = append(ids, tariffID{modelType, tier})
+		}
+	}
+
+	for _, modelType := range []vehicleModelType{vehicleModelTypeCar, vehicleModelTypeVan} {
+		for _, tier := range slices.Sorted(maps.Keys(tierRanks)) {
+			add(modelType, tier)
+		}
+	}
+
+	for _, history := range m.store.list() {
+		add(history.ModelType, history.Tier)
+	}
+
+	var errs []error
+
+	vehicles, err := m.cache.fetchVehicles(ctx, m.client)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("[tariffMonitor] could not fetch vehicles: %w", err))
+	}
+
+	for _, vehicle := range vehicles {
+		add(vehicle.Model.Type, vehicle.Model.Tier)
+	}
+
+	for _, id := range ids {
+		pricing, err := fetchPricing(ctx, m.client, id.modelType, id.tier)
+		if errors.Is(err, errEmptyTariff) {
+			continue
+		}
+
+		if err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+
+		if err := m.record(id.modelType, id.tier, pricing, now); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (m *tariffMonitor) record(
+	modelType vehicleModelType,
+	tier string,
+	pricing *PricingResponse,
+	now time.Time,
+) error {
+	key := tariffKey(modelType, tier)
+
+	_, err := m.store.update(key, func(history *TariffHistory) error {
+		changes := diffTariffs(&history.Current, pricing, now)
+
+		history.Changes = append(changes, history.Changes...)
+		history.Changes = history.Changes[:min(len(history.Changes), maxTariffChanges)]
+		history.Current = *pricing
+		history.LastCheckedAt = now
+
+		return nil
+	})
+	if !errors.Is(err, errNotFound) {
+		return err
+	}
+
+	return m.store.put(key, TariffHistory{
+		ModelType:     modelType,
+		Tier:          tier,
+		FirstSeenAt:   now,
+		LastCheckedAt: now,
+		Current:       *pricing,
+		Changes:       []TariffChange{},
+	})
+}
+
+func pricingQuery(r *http.Request) (vehicleModelType, string) {
+	modelType := vehicleModelType(r.URL.Query().Get("modelType"))
+	if modelType == "" {
+		modelType = vehicleModelTypeCar
+	}
+
+	return modelType, strings.TrimSpace(r.URL.Query().Get("tier"))
+}
+
+// pricingHandler shows the current tariff of a model type and tier.
+func pricingHandler(client *http.Client, cache *upstreamCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		modelType, tier := pricingQuery(r)
+		if tier == "" {
+			respondJSON(w, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error:   "The tier query parameter is required",
+				Issues: []ValidationIssue{{
+					Code:    validationCodeInvalidField,
+					Field:   "tier",
+					Message: "The tier query parameter is required",
+					Remedy:  "Pass the tier of a vehicle, e.g. tier=S",
+				}},
+			})
+
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		pricing, err := cache.fetchPricing(ctx, client, modelType, tier)
+		if errors.Is(err, errEmptyTariff) {
+			respondJSON(w, http.StatusNotFound, APIResponse{
+				Success: false,
+				Error:   fmt.Sprintf("No tariff for %s tier %s", modelType, tier),
+			})
+
+			return
+		}
+
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+
+			return
+		}
+
+		respondJSON(w, http.StatusOK, APIResponse{
+			Success: true,
+			Data:    newTariff(modelType, tier, pricing),
+		})
+	}
+}
+
+// pricingHistoryHandler lists the tariff histories, optionally filtered on
+// model type and tier.
+func pricingHistoryHandler(store *tariffStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		modelType := vehicleModelType(r.URL.Query().Get("modelType"))
+		tier := r.URL.Query().Get("tier")
+
+		histories := slices.DeleteFunc(store.list(), func(history TariffHistory) bool {
+			return (modelType != "" && history.ModelType != modelType) ||
+				(tier != "" && history.Tier != tier)
+		})
+
+		slices.SortFunc(histories, func(a, b TariffHistory) int {
+			return strings.Compare(tariffKey(a.ModelType, a.Tier), tariffKey(b.ModelType, b.Tier))
+		})
+
+		respondJSON(w, http.StatusOK, APIResponse{
+			Success: true,
+			Data:    histories,
+		})
+	}
+}
@@ -0,0 +1,253 @@
+//nolint:package-comments,revive,mnd,exhaustruct
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestPricing() *PricingResponse {
+	return &PricingResponse{
+		PricingPerMinute: PricingModel{
+			Type: pricingPlanPerMinute, UnlockFee: 1000, MinutePrice: 290, PauseUnitPrice: 100, DayCapPrice: 69000,
+		},
+		PricingPerKilometer: PricingModel{
+			Type: pricingPlanPerKilometer, UnlockFee: 1000, KilometerPrice: 350, IncludedKilometers: 0,
+		},
+		SmartPricing: PricingModel{
+			Type: pricingPlanSmart, UnlockFee: 1000, MinutePrice: 190, KilometerPrice: 190,
+		},
+	}
+}
+
+func TestNewTariff(t *testing.T) {
+	tariff := newTariff(vehicleModelTypeCar, "S", newTestPricing())
+
+	if len(tariff.Plans) != 3 || tariff.Currency != "EUR" {
+		t.Fatalf("Expected three plans in euros but got %+v", tariff)
+	}
+
+	perMinute := tariff.Plans[0]
+	if perMinute.Name != "Per Minute" || perMinute.UnlockFee != 1 || perMinute.PerMinute != 0.29 ||
+		perMinute.PausePerMinute != 0.1 || perMinute.DayCap != 69 {
+		t.Errorf("Expected the per-minute tariff in euros but got %+v", perMinute)
+	}
+}
+
+func TestDiffTariffs(t *testing.T) {
+	now := time.Date(2025, 6, 2, 9, 0, 0, 0, time.UTC)
+
+	changed := newTestPricing()
+	changed.PricingPerMinute.MinutePrice = 310
+	changed.SmartPricing.UnlockFee = 0
+
+	changes := diffTariffs(newTestPricing(), changed, now)
+
+	expected := []TariffChange{
+		{DetectedAt: now, Plan: pricingPlanPerMinute, Field: "perMinute", Old: 0.29, New: 0.31, Change: 0.02},
+		{DetectedAt: now, Plan: pricingPlanSmart, Field: "unlockFee", Old: 1, New: 0, Change: -1},
+	}
+
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected %d changes but got %+v", len(expected), changes)
+	}
+
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Errorf("Expected %+v but got %+v", expected[i], changes[i])
+		}
+	}
+
+	if changes := diffTariffs(newTestPricing(), newTestPricing(), now); len(changes) != 0 {
+		t.Errorf("Expected no changes between equal tariffs but got %+v", changes)
+	}
+}
+
+func TestTariffMonitor_Record(t *testing.T) {
+	store, _ := openJSONStore[TariffHistory]("")
+	monitor := &tariffMonitor{store: store}
+
+	first := time.Date(2025, 6, 2, 9, 0, 0, 0, time.UTC)
+	second := first.Add(tariffSnapshotInterval)
+	third := second.Add(tariffSnapshotInterval)
+
+	changed := newTestPricing()
+	changed.PricingPerKilometer.KilometerPrice = 400
+
+	for _, snapshot := range []struct {
+		at      time.Time
+		pricing *PricingResponse
+	}{
+		{first, newTestPricing()},
+		{second, newTestPricing()},
+		{third, changed},
+	} {
+		if err := monitor.record(vehicleModelTypeCar, "S", snapshot.pricing, snapshot.at); err != nil {
+			t.Fatalf("Expected the snapshot to be recorded but got %v", err)
+		}
+	}
+
+	history, ok := store.get(tariffKey(vehicleModelTypeCar, "S"))
+	if !ok {
+		t.Fatal("Expected a history for car/S")
+	}
+
+	if !history.FirstSeenAt.Equal(first) || !history.LastCheckedAt.Equal(third) {
+		t.Errorf("Expected the history to span both snapshots but got %v to %v", history.FirstSeenAt, history.LastCheckedAt)
+	}
+
+	if len(history.Changes) != 1 || history.Changes[0].Field != "perKilometer" ||
+		!history.Changes[0].DetectedAt.Equal(third) || history.Changes[0].Change != 0.05 {
+		t.Errorf("Expected one kilometer price change but got %+v", history.Changes)
+	}
+
+	if history.Current.PricingPerKilometer.KilometerPrice != 400 {
+		t.Errorf("Expected the latest tariff to be current but got %+v", history.Current)
+	}
+}
+
+func TestFetchPricing_RejectsErrorsAndEmptyTariffs(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		empty  bool
+	}{
+		{name: "Error status", status: http.StatusBadGateway, body: `{"message":"upstream down"}`},
+		{name: "Empty tariff", status: http.StatusOK, body: `{"message":"unknown tier"}`, empty: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			config := defaultConfig()
+			config.PoppyURL = server.URL
+
+			pricing, err := fetchPricing(withConfig(context.Background(), config), server.Client(), vehicleModelTypeCar, "XL")
+			if err == nil {
+				t.Fatalf("Expected an error but got %+v", pricing)
+			}
+
+			if errors.Is(err, errEmptyTariff) != tt.empty {
+				t.Errorf("Expected empty tariff %t but got %v", tt.empty, err)
+			}
+		})
+	}
+}
+
+func TestPricingHandlers(t *testing.T) {
+	cache := newUpstreamCache()
+	_, _ = cache.pricing.get(context.Background(), "car/S", func(context.Context) (*PricingResponse, error) {
+		return newTestPricing(), nil
+	})
+
+	store, _ := openJSONStore[TariffHistory]("")
+	monitor := &tariffMonitor{store: store}
+	_ = monitor.record(vehicleModelTypeCar, "S", newTestPricing(), time.Now())
+	_ = monitor.record(vehicleModelTypeVan, "L", newTestPricing(), time.Now())
+
+	mux := http.NewServeMux()
+	unknownTier := &http.Client{Transport: roundTripFunc(func(*http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("{}"))}, nil
+	})}
+
+	mux.HandleFunc("GET /api/v1/pricing", pricingHandler(unknownTier, cache))
+	mux.HandleFunc("GET /api/v1/pricing/history", pricingHistoryHandler(store))
+
+	tests := []struct {
+		name     string
+		path     string
+		expected int
+		count    int
+	}{
+		{name: "Tariff", path: "/api/v1/pricing?tier=S", expected: http.StatusOK},
+		{name: "Missing tier", path: "/api/v1/pricing?modelType=car", expected: http.StatusBadRequest},
+		{name: "Unknown tier", path: "/api/v1/pricing?tier=XL", expected: http.StatusNotFound},
+		{name: "All histories", path: "/api/v1/pricing/history", expected: http.StatusOK, count: 2},
+		{name: "Filtered histories", path: "/api/v1/pricing/history?modelType=van", expected: http.StatusOK, count: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			withConfigContext(defaultConfig(), mux).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if recorder.Code != tt.expected {
+				t.Fatalf("Expected status %d but got %d", tt.expected, recorder.Code)
+			}
+
+			if tt.count == 0 {
+				return
+			}
+
+			var response struct {
+				Data []TariffHistory `json:"data"`
+			}
+
+			_ = json.NewDecoder(recorder.Body).Decode(&response)
+
+			if len(response.Data) != tt.count {
+				t.Errorf("Expected %d histories but got %d", tt.count, len(response.Data))
+			}
+		})
+	}
+}
+
+func TestTariffMonitor_SnapshotsEveryTier(t *testing.T) {
+	ctx := withConfig(context.Background(), defaultConfig())
+
+	cache := newUpstreamCache()
+	_, _ = cache.vehicles.get(ctx, brusselsUUID, func(context.Context) ([]Vehicle, error) {
+		return []Vehicle{{UUID: "a", Model: Model{Type: vehicleModelTypeCar, Tier: "XXL"}}}, nil
+	})
+
+	store, _ := openJSONStore[TariffHistory]("")
+	_ = store.put(tariffKey(vehicleModelTypeVan, "retired"), TariffHistory{ModelType: vehicleModelTypeVan, Tier: "retired"})
+
+	priced := map[string]bool{"car/S": true, "van/L": true, "car/XXL": true, "van/retired": true}
+	requested := map[string]bool{}
+
+	body, _ := json.Marshal(newTestPricing())
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		key := r.URL.Query().Get("modelType") + "/" + r.URL.Query().Get("tier")
+		requested[key] = true
+
+		response := "{}"
+		if priced[key] {
+			response = string(body)
+		}
+
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(response))}, nil
+	})}
+
+	monitor := &tariffMonitor{client: client, cache: cache, store: store}
+	if err := monitor.snapshot(ctx, time.Now()); err != nil {
+		t.Fatalf("Expected tiers without a tariff to be skipped but got %v", err)
+	}
+
+	if !requested["van/XS"] || !requested["car/XL"] {
+		t.Errorf("Expected every known tier to be fetched but got %v", requested)
+	}
+
+	for key := range priced {
+		if history, ok := store.get(key); !ok || history.Current.PricingPerMinute.MinutePrice != 290 {
+			t.Errorf("Expected a snapshot of %s but got %+v", key, history)
+		}
+	}
+
+	if histories := store.list(); len(histories) != len(priced) {
+		t.Errorf("Expected %d snapshots but got %d", len(priced), len(histories))
+	}
+}
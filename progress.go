@@ -0,0 +1,345 @@
+//nolint:package-comments,revive,mnd,exhaustruct,errchkjson
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/a-h/templ"
+)
+
+type planStage string
+
+const (
+	planStageVehiclesFetched planStage = "vehicles_fetched"
+	planStageVehicleChosen   planStage = "vehicle_chosen"
+	planStageLegRouted       planStage = "leg_routed"
+	planStagePricingComputed planStage = "pricing_computed"
+)
+
+// PlanProgress is a step of a plan in the making, with the partial result
+// it produced.
+type PlanProgress struct {
+	Stage        planStage   `json:"stage"`
+	Message      string      `json:"message"`
+	LegIndex     *int        `json:"legIndex,omitempty"`
+	Vehicle      *Vehicle    `json:"vehicle,omitempty"`
+	Leg          *legRoute   `json:"leg,omitempty"`
+	PricingModel pricingPlan `json:"pricingModel,omitempty"`
+	TotalCost    float64     `json:"totalCost,omitempty"`
+}
+
+type planProgressKey struct{}
+
+// withPlanProgress returns a context under which planning reports each step
+// to report. Planning without it reports nothing.
+func withPlanProgress(ctx context.Context, report func(PlanProgress)) context.Context {
+	return context.WithValue(ctx, planProgressKey{}, report)
+}
+
+func reportProgress(ctx context.Context, progress PlanProgress) {
+	if report, ok := ctx.Value(planProgressKey{}).(func(PlanProgress)); ok {
+		report(progress)
+	}
+}
+
+// sseStream writes Server-Sent Events, flushing after each one.
+type sseStream struct {
+	mu         sync.Mutex
+	w          http.ResponseWriter
+	controller *http.ResponseController
+}
+
+func newSSEStream(w http.ResponseWriter) *sseStream {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	allowLongResponse(w)
+
+	return &sseStream{w: w, controller: http.NewResponseController(w)}
+}
+
+// send writes one event. Multi-line data is split over several data
+// fields, which clients join back with newlines.
+func (s *sseStream) send(event string, data string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var builder strings.Builder
+
+	builder.WriteString("event: " + event + "\n")
+
+	for _, line := range strings.Split(data, "\n") {
+		builder.WriteString("data: " + line + "\n")
+	}
+
+	builder.WriteString("\n")
+
+	_, _ = s.w.Write([]byte(builder.String()))
+	_ = s.controller.Flush()
+}
+
+func (s *sseStream) sendJSON(event string, value any) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+
+	s.send(event, string(data))
+}
+
+func (s *sseStream) sendComponent(ctx context.Context, event string, component templ.Component) {
+	var builder strings.Builder
+	if err := component.Render(ctx, &builder); err != nil {
+		return
+	}
+
+	s.send(event, builder.String())
+}
+
+// planJourneyStreamHandler is the streaming variant of planJourneyHandler.
+// It emits a progress event per planning step, then a plan or an error
+// event. The request is validated before the stream starts, so invalid
+// requests still get a plain 400 response.
+func planJourneyStreamHandler(
+	client *http.Client,
+	cache *upstreamCache,
+	addressGeocoder geocoder,
+	quotes *quoteStore,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var requestData PlanJourneyRequest
+
+		if err := apiSpec.decodeRequest(r, "/api/v1/plan-journey", &requestData); err != nil {
+			if respondBodyTooLarge(w, r, err) {
+				return
+			}
+
+			message := err.Error()
+			if errors.Is(err, errInvalidRequestBody) {
+				message = "Invalid JSON request body"
+			}
+
+			respondJSON(w, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error:   message,
+				Issues:  validationIssues(err),
+			})
+
+			return
+		}
+
+		stream := newSSEStream(w)
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		ctx = withPlanProgress(ctx, func(progress PlanProgress) {
+			stream.sendJSON("progress", progress)
+		})
+
+		plan, err := planJourney(ctx, client, cache, addressGeocoder, requestData.Journey)
+		if err != nil {
+			stream.sendJSON("error", APIResponse{
+				Success: false,
+				Error:   err.Error(),
+				Issues:  validationIssues(err),
+			})
+
+			return
+		}
+
+		quotes.recordOrWarn(ctx, plan)
+		stream.sendJSON("plan", plan)
+	}
+}
+
+const (
+	// journeyTokenTTL is how long the events URL handed to the browser
+	// stays valid. The browser connects right away.
+	journeyTokenTTL = time.Minute
+	// maxPendingJourneys caps the journeys waiting for their stream, so a
+	// flood of form posts cannot grow the map without limit.
+	maxPendingJourneys = 10000
+)
+
+type pendingJourney struct {
+	journey Journey
+	expires time.Time
+}
+
+// journeyTokens hands the journey of a web form from planStreamHandler to
+// the event stream that plans it. Tokens are single-use and short-lived, so
+// a prefetcher, a crawler or a reconnecting EventSource replaying the GET
+// cannot plan again.
+type journeyTokens struct {
+	mu       sync.Mutex
+	journeys map[string]pendingJourney
+}
+
+func newJourneyTokens() *journeyTokens {
+	return &journeyTokens{journeys: map[string]pendingJourney{}}
+}
+
+// issue stores journey and returns its token. It reports false while
+// maxPendingJourneys are waiting.
+func (t *journeyTokens) issue(journey Journey, now time.Time) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.journeys) >= maxPendingJourneys {
+		return "", false
+	}
+
+	token := newID()
+	t.journeys[token] = pendingJourney{journey: journey, expires: now.Add(journeyTokenTTL)}
+
+	return token, true
+}
+
+// run drops the expired tokens every interval.
+func (t *journeyTokens) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			t.sweep(now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (t *journeyTokens) sweep(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for token, pending := range t.journeys {
+		if now.After(pending.expires) {
+			delete(t.journeys, token)
+		}
+	}
+}
+
+// redeem returns the journey of token and invalidates it. It reports false
+// for an unknown, used or expired token.
+func (t *journeyTokens) redeem(token string, now time.Time) (Journey, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pending, ok := t.journeys[token]
+	if !ok {
+		return Journey{}, false
+	}
+
+	delete(t.journeys, token)
+
+	if now.After(pending.expires) {
+		return Journey{}, false
+	}
+
+	return pending.journey, true
+}
+
+// planStreamHandler answers the web form with a container that connects
+// to planEventsHandler through the htmx SSE extension.
+func planStreamHandler(tokens *journeyTokens) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			_ = ErrorResult("Failed to parse form data", nil).Render(r.Context(), w)
+
+			return
+		}
+
+		journey, err := journeyFromForm(r.Form)
+		if err != nil {
+			_ = ErrorResult("Invalid departure time", nil).Render(r.Context(), w)
+
+			return
+		}
+
+		token, ok := tokens.issue(journey, time.Now())
+		if !ok {
+			_ = ErrorResult("Too many journeys are being planned, please try again in a minute", nil).Render(r.Context(), w)
+
+			return
+		}
+
+		eventsURL := "/plan/events?" + url.Values{"token": {token}}.Encode()
+
+		_ = PlanStream(eventsURL).Render(r.Context(), w)
+	}
+}
+
+// planEventsHandler streams the planning of a web form journey as HTML
+// fragments: a progress item per step, then the result. The final done
+// event tells the browser not to reconnect. The journey comes from a token
+// issued by planStreamHandler; a token is planned once, so a repeated GET
+// only gets an error result.
+func planEventsHandler(
+	client *http.Client,
+	cache *upstreamCache,
+	addressGeocoder geocoder,
+	quotes *quoteStore,
+	tokens *journeyTokens,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stream := newSSEStream(w)
+		defer stream.send("done", "")
+
+		journey, ok := tokens.redeem(r.URL.Query().Get("token"), time.Now())
+		if !ok {
+			stream.sendComponent(r.Context(), "result", ErrorResult(
+				"This plan has expired or was already sent, please submit the form again",
+				nil,
+			))
+
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		ctx = withPlanProgress(ctx, func(progress PlanProgress) {
+			stream.sendComponent(r.Context(), "progress", ProgressItem(progress))
+		})
+
+		plan, err := planJourney(ctx, client, cache, addressGeocoder, journey)
+		if err != nil {
+			stream.sendComponent(r.Context(), "result", ErrorResult(
+				"Planning failed",
+				validationIssues(err),
+			))
+
+			return
+		}
+
+		quotes.recordOrWarn(ctx, plan)
+		stream.sendComponent(r.Context(), "result", JourneyResult(plan))
+	}
+}
+
+func progressIcon(stage planStage) string {
+	switch stage {
+	case planStageVehiclesFetched:
+		return "🔎"
+	case planStageVehicleChosen:
+		return "🚗"
+	case planStageLegRouted:
+		return "🗺️"
+	case planStagePricingComputed:
+		return "💶"
+	default:
+		return "•"
+	}
+}
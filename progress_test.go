@@ -0,0 +1,243 @@
+//nolint:package-comments,revive,mnd,exhaustruct
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sseEvents returns the event names of a Server-Sent Events body in order.
+func sseEvents(body string) []string {
+	var events []string
+
+	for _, line := range strings.Split(body, "\n") {
+		if name, ok := strings.CutPrefix(line, "event: "); ok {
+			events = append(events, name)
+		}
+	}
+
+	return events
+}
+
+func TestReportProgress(t *testing.T) {
+	reportProgress(context.Background(), PlanProgress{Stage: planStageLegRouted})
+
+	var stages []planStage
+
+	ctx := withPlanProgress(withConfig(context.Background(), defaultConfig()), func(progress PlanProgress) {
+		stages = append(stages, progress.Stage)
+	})
+
+	journey := Journey{Legs: []TripLeg{
+		{
+			StartLocation: Location{Lat: 50.8466, Lng: 4.3528},
+			EndLocation:   Location{Lat: 50.8275, Lng: 4.3745},
+		},
+		{
+			StartLocation: Location{Lat: 50.8275, Lng: 4.3745},
+			EndLocation:   Location{Lat: 50.8355, Lng: 4.3573},
+		},
+	}}
+
+	routeJourney(ctx, http.DefaultClient, journey, Vehicle{
+		LocationLatitude:  50.8470,
+		LocationLongitude: 4.3530,
+	}, nil)
+
+	if len(stages) != 2 || stages[0] != planStageLegRouted || stages[1] != planStageLegRouted {
+		t.Errorf("Expected a leg_routed event per leg but got %v", stages)
+	}
+}
+
+func TestSSEStream_Send(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	stream := newSSEStream(recorder)
+
+	stream.send("result", "<div>\n<p>done</p>\n</div>")
+
+	expected := "event: result\ndata: <div>\ndata: <p>done</p>\ndata: </div>\n\n"
+	if recorder.Body.String() != expected {
+		t.Errorf("Expected %q but got %q", expected, recorder.Body.String())
+	}
+
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("Expected content type text/event-stream but got %s", contentType)
+	}
+}
+
+func TestPlanJourneyStreamHandler(t *testing.T) {
+	handler := planJourneyStreamHandler(http.DefaultClient, nil, nil, nil)
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		expectedEvents []string
+	}{
+		{
+			name:           "Invalid request",
+			body:           `{"journey": {"legs": []}}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Planning error",
+			body:           `{"journey": {"legs": [{"startLocation": {}, "endLocation": {}}]}}`,
+			expectedStatus: http.StatusOK,
+			expectedEvents: []string{"error"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(
+				http.MethodPost,
+				"/api/v1/plan-journey:stream",
+				strings.NewReader(tt.body),
+			)
+			recorder := httptest.NewRecorder()
+
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d but got %d", tt.expectedStatus, recorder.Code)
+			}
+
+			events := sseEvents(recorder.Body.String())
+			if strings.Join(events, ",") != strings.Join(tt.expectedEvents, ",") {
+				t.Errorf("Expected events %v but got %v", tt.expectedEvents, events)
+			}
+		})
+	}
+}
+
+func TestPlanStreamHandler(t *testing.T) {
+	form := url.Values{
+		"legs[0].startAddress": {"Gare du Midi"},
+		"legs[0].endLat":       {"50.8275"},
+		"legs[0].endLng":       {"4.3745"},
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/plan/stream", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	tokens := newJourneyTokens()
+	recorder := httptest.NewRecorder()
+	planStreamHandler(tokens).ServeHTTP(recorder, request)
+
+	body := recorder.Body.String()
+	if !strings.Contains(body, `sse-connect="/plan/events?token=`) ||
+		!strings.Contains(body, `sse-close="done"`) {
+		t.Errorf("Expected an SSE container but got %s", body)
+	}
+
+	if strings.Contains(body, "Gare du Midi") {
+		t.Error("Expected the journey to stay on the server")
+	}
+
+	if len(tokens.journeys) != 1 {
+		t.Errorf("Expected one pending journey but got %d", len(tokens.journeys))
+	}
+}
+
+func TestJourneyTokens_Redeem(t *testing.T) {
+	now := time.Now()
+	tokens := newJourneyTokens()
+	journey := Journey{Legs: []TripLeg{{PauseMinutes: 15}}}
+
+	token, _ := tokens.issue(journey, now)
+	expiring, _ := tokens.issue(journey, now)
+
+	tests := []struct {
+		name     string
+		token    string
+		at       time.Time
+		expected bool
+	}{
+		{name: "Unknown", token: "nope", at: now, expected: false},
+		{name: "First use", token: token, at: now, expected: true},
+		{name: "Second use", token: token, at: now, expected: false},
+		{name: "Expired", token: expiring, at: now.Add(journeyTokenTTL + time.Second), expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redeemed, ok := tokens.redeem(tt.token, tt.at)
+			if ok != tt.expected {
+				t.Fatalf("Expected %t but got %t", tt.expected, ok)
+			}
+
+			if ok && redeemed.Legs[0].PauseMinutes != 15 {
+				t.Errorf("Expected the issued journey but got %+v", redeemed)
+			}
+		})
+	}
+}
+
+func TestJourneyTokens_Bounded(t *testing.T) {
+	now := time.Now()
+	tokens := newJourneyTokens()
+
+	for range maxPendingJourneys {
+		if _, ok := tokens.issue(Journey{}, now); !ok {
+			t.Fatal("Expected a token below the cap")
+		}
+	}
+
+	if _, ok := tokens.issue(Journey{}, now); ok {
+		t.Fatal("Expected no token past the cap")
+	}
+
+	tokens.sweep(now.Add(journeyTokenTTL + time.Second))
+
+	if len(tokens.journeys) != 0 {
+		t.Errorf("Expected the expired tokens to be swept but got %d", len(tokens.journeys))
+	}
+
+	if _, ok := tokens.issue(Journey{}, now); !ok {
+		t.Error("Expected a token once the expired ones are swept")
+	}
+}
+
+func TestPlanEventsHandler(t *testing.T) {
+	tokens := newJourneyTokens()
+	handler := planEventsHandler(http.DefaultClient, nil, nil, nil, tokens)
+
+	token, _ := tokens.issue(Journey{}, time.Now())
+
+	tests := []struct {
+		name     string
+		token    string
+		contains string
+	}{
+		{name: "Unknown token", token: "nope", contains: "expired or was already sent"},
+		{name: "Planning error", token: token, contains: "Planning Failed"},
+		{name: "Reconnect", token: token, contains: "expired or was already sent"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(
+				http.MethodGet,
+				"/plan/events?"+url.Values{"token": {tt.token}}.Encode(),
+				nil,
+			)
+			recorder := httptest.NewRecorder()
+
+			handler.ServeHTTP(recorder, request)
+
+			events := sseEvents(recorder.Body.String())
+			if strings.Join(events, ",") != "result,done" {
+				t.Errorf("Expected result and done events but got %v", events)
+			}
+
+			if !strings.Contains(recorder.Body.String(), tt.contains) {
+				t.Errorf("Expected the result to mention %q but got %s", tt.contains, recorder.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,482 @@
+//nolint:package-comments,revive,mnd,exhaustruct
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// pricingEngineVersion identifies how a routed journey is priced. Bump
+	// it whenever cheapestPlan or calculateCostForPricingPlan change, so a
+	// replay can tell a pricing change from a data mismatch. Version 2 reads
+	// the free booking minutes from the route; version 1 always gave 15.
+	// Version 3 prices the wait before a leg with a later start time.
+	// Version 4 prices every leg on its own and reports the leg costs.
+	pricingEngineVersion = "4"
+
+	// quoteValidity is how long the price of a quote is guaranteed. Expired
+	// quotes stay retrievable for audits.
+	quoteValidity = 15 * time.Minute
+
+	// Quotes are kept for quoteRetention, and at most maxStoredQuotes of them,
+	// so a stream of batch plans cannot fill the disk. The oldest go first.
+	quoteRetention     = 30 * 24 * time.Hour
+	maxStoredQuotes    = 100_000
+	quotePruneInterval = time.Hour
+)
+
+var (
+	quoteIDPattern    = regexp.MustCompile(`^[0-9a-f]{24}$`)
+	errNoPlanningData = errors.New("plan has no planning data")
+)
+
+// Quote is the immutable record of a plan: what was asked, the exact
+// upstream data and routing it was computed from, and the result. The
+// geozones are stored once per distinct set and referenced by GeozoneID.
+type Quote struct {
+	ID            string          `json:"id"`
+	EngineVersion string          `json:"engineVersion"`
+	CreatedAt     time.Time       `json:"createdAt"`
+	ExpiresAt     time.Time       `json:"expiresAt"`
+	Expired       bool            `json:"expired"`
+	Request       Journey         `json:"request"`
+	Vehicle       Vehicle         `json:"vehicle"`
+	Pricing       PricingResponse `json:"pricing"`
+	GeozoneID     string          `json:"geozoneId,omitempty"`
+	Route         journeyRoute    `json:"route"`
+	Plan          JourneyPlan     `json:"plan"`
+}
+
+type QuoteOutcome struct {
+	TotalCost     float64       `json:"totalCost"`
+	PricingModel  pricingPlan   `json:"pricingModel"`
+	CostBreakdown CostBreakdown `json:"costBreakdown"`
+}
+
+// QuoteReplay compares a quote with the cost engine re-run on its stored
+// data.
+type QuoteReplay struct {
+	QuoteID             string       `json:"quoteId"`
+	Matches             bool         `json:"matches"`
+	QuotedEngineVersion string       `json:"quotedEngineVersion"`
+	EngineVersion       string       `json:"engineVersion"`
+	Quoted              QuoteOutcome `json:"quoted"`
+	Replayed            QuoteOutcome `json:"replayed"`
+	Differences         []string     `json:"differences,omitempty"`
+}
+
+// quoteStore keeps every quote in its own file, written once. A nil store
+// records nothing.
+type quoteStore struct {
+	dir string
+
+	mu sync.Mutex
+	// savedGeozones are the geozone files known to exist, so recording a
+	// quote does not touch the disk for them.
+	savedGeozones map[string]bool
+}
+
+func newQuoteStore(dir string) *quoteStore {
+	return &quoteStore{dir: dir, savedGeozones: map[string]bool{}}
+}
+
+func (s *quoteStore) quotePath(id string) string {
+	return filepath.Join(s.dir, "quotes", id+".json")
+}
+
+func (s *quoteStore) geozonePath(id string) string {
+	return filepath.Join(s.dir, "geozones", id+".json")
+}
+
+// record persists plan as a new quote and stamps the plan with the quote
+// ID and expiry. Plans that were not produced by planJourney carry no
+// planning data and cannot be recorded.
+func (s *quoteStore) record(plan *JourneyPlan) (*Quote, error) {
+	if s == nil {
+		return nil, nil
+	}
+
+	if plan.data == nil || plan.data.pricing == nil {
+		return nil, errNoPlanningData
+	}
+
+	geozoneID, err := s.saveGeozone(plan.data.zones)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	expiresAt := now.Add(quoteValidity)
+
+	plan.QuoteID = newID()
+	plan.QuoteExpiresAt = &expiresAt
+
+	quote := &Quote{
+		ID:            plan.QuoteID,
+		EngineVersion: pricingEngineVersion,
+		CreatedAt:     now,
+		ExpiresAt:     expiresAt,
+		Request:       plan.request,
+		Vehicle:       plan.data.vehicle,
+		Pricing:       *plan.data.pricing,
+		GeozoneID:     geozoneID,
+		Route:         plan.route,
+		Plan:          *plan,
+	}
+
+	data, err := json.Marshal(quote)
+	if err != nil {
+		return nil, fmt.Errorf("[quoteStore.record] could not encode: %w", err)
+	}
+
+	if err := writeFileAtomic(s.quotePath(quote.ID), data); err != nil {
+		plan.QuoteID = ""
+		plan.QuoteExpiresAt = nil
+
+		return nil, err
+	}
+
+	return quote, nil
+}
+
+// recordOrWarn records plan and only logs failures: a plan is still worth
+// returning when its quote could not be stored.
+func (s *quoteStore) recordOrWarn(ctx context.Context, plan *JourneyPlan) {
+	if _, err := s.record(plan); err != nil {
+		slog.WarnContext(ctx, "failed to record quote", "error", err)
+	}
+}
+
+// saveGeozone stores the geozones of zones under the hash of their content,
+// so the many quotes planned against the same geozones share one file.
+// The file is only written the first time a hash is seen.
+func (s *quoteStore) saveGeozone(zones *zoneIndex) (string, error) {
+	if zones.zone() == nil {
+		return "", nil
+	}
+
+	id, err := zones.storageID()
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	saved := s.savedGeozones[id]
+	s.mu.Unlock()
+
+	if saved {
+		return id, nil
+	}
+
+	if _, err := os.Stat(s.geozonePath(id)); err != nil {
+		data, err := json.Marshal(zones.zone())
+		if err != nil {
+			return "", fmt.Errorf("[quoteStore.saveGeozone] could not encode: %w", err)
+		}
+
+		if err := writeFileAtomic(s.geozonePath(id), data); err != nil {
+			return "", err
+		}
+	}
+
+	s.mu.Lock()
+	s.savedGeozones[id] = true
+	s.mu.Unlock()
+
+	return id, nil
+}
+
+func (s *quoteStore) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.prune(time.Now(), quoteRetention, maxStoredQuotes); err != nil {
+			slog.WarnContext(ctx, "quote pruning failed", "error", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// prune deletes the quotes written before now minus retention, then the
+// oldest ones beyond limit. Geozone files are shared and left alone.
+func (s *quoteStore) prune(now time.Time, retention time.Duration, limit int) error {
+	entries, err := os.ReadDir(filepath.Join(s.dir, "quotes"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("[quoteStore.prune] could not list quotes: %w", err)
+	}
+
+	type storedQuote struct {
+		path      string
+		writtenAt time.Time
+	}
+
+	quotes := make([]storedQuote, 0, len(entries))
+
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || !quoteIDPattern.MatchString(id) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		quotes = append(quotes, storedQuote{path: s.quotePath(id), writtenAt: info.ModTime()})
+	}
+
+	slices.SortFunc(quotes, func(a, b storedQuote) int {
+		return b.writtenAt.Compare(a.writtenAt)
+	})
+
+	var errs []error
+
+	for i, quote := range quotes {
+		if i < limit && now.Sub(quote.writtenAt) < retention {
+			continue
+		}
+
+		if err := os.Remove(quote.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, fmt.Errorf("[quoteStore.prune] could not delete quote: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s *quoteStore) get(id string, now time.Time) (*Quote, error) {
+	if !quoteIDPattern.MatchString(id) {
+		return nil, errNotFound
+	}
+
+	data, err := os.ReadFile(s.quotePath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errNotFound
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("[quoteStore.get] could not read quote %s: %w", id, err)
+	}
+
+	var quote Quote
+	if err := json.Unmarshal(data, &quote); err != nil {
+		return nil, fmt.Errorf("[quoteStore.get] could not decode quote %s: %w", id, err)
+	}
+
+	quote.Expired = !now.Before(quote.ExpiresAt)
+
+	return &quote, nil
+}
+
+func (s *quoteStore) geozone(quote *Quote) (*GeoZone, error) {
+	if quote.GeozoneID == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(s.geozonePath(quote.GeozoneID))
+	if err != nil {
+		return nil, fmt.Errorf("[quoteStore.geozone] could not read geozones %s: %w", quote.GeozoneID, err)
+	}
+
+	var geozone GeoZone
+	if err := json.Unmarshal(data, &geozone); err != nil {
+		return nil, fmt.Errorf("[quoteStore.geozone] could not decode geozones %s: %w", quote.GeozoneID, err)
+	}
+
+	return &geozone, nil
+}
+
+func quoteOutcome(plan *JourneyPlan) QuoteOutcome {
+	return QuoteOutcome{
+		TotalCost:     plan.TotalCost,
+		PricingModel:  plan.PricingModel,
+		CostBreakdown: plan.CostBreakdown,
+	}
+}
+
+// replayQuote re-runs the zone checks and the cost engine on the data
+// stored with a quote. Routing is not repeated: the stored route is the
+// input under audit, and ORS answers change over time. A differing engine
+// version is reported but is not a mismatch by itself.
+func replayQuote(quote *Quote, geozone *GeoZone) *QuoteReplay {
+	replay := &QuoteReplay{
+		QuoteID:             quote.ID,
+		QuotedEngineVersion: quote.EngineVersion,
+		EngineVersion:       pricingEngineVersion,
+		Quoted:              quoteOutcome(&quote.Plan),
+		Differences:         []string{},
+	}
+
+	journey := quote.Plan.Journey
+	route := quote.Route
+	route.Legs = append([]legRoute{}, quote.Route.Legs...)
+
+	if geozone != nil && len(route.Legs) == len(journey.Legs) {
+		zones := newZoneIndex(geozone)
+
+		for i, leg := range journey.Legs {
+			inParkingZone := zones.inParkingZone(leg.EndLocation)
+			if inParkingZone != route.Legs[i].EndInParkingZone {
+				replay.Differences = append(replay.Differences, fmt.Sprintf(
+					"leg %d endInParkingZone: quoted %t, replayed %t",
+					i+1,
+					route.Legs[i].EndInParkingZone,
+					inParkingZone,
+				))
+				route.Legs[i].EndInParkingZone = inParkingZone
+			}
+		}
+	}
+
+	plan, err := cheapestPlan(journey, route, quote.Vehicle, &quote.Pricing)
+	if err != nil {
+		replay.Differences = append(replay.Differences, "no pricing model applies: "+err.Error())
+
+		return replay
+	}
+
+	applyParkingSuggestion(plan, quote.Plan.ParkingSuggestion, false)
+	replay.Replayed = quoteOutcome(plan)
+	replay.Differences = append(replay.Differences, outcomeDifferences(replay.Quoted, replay.Replayed)...)
+	replay.Matches = len(replay.Differences) == 0
+
+	return replay
+}
+
+func outcomeDifferences(quoted, replayed QuoteOutcome) []string {
+	differences := []string{}
+
+	if quoted.PricingModel != replayed.PricingModel {
+		differences = append(differences, fmt.Sprintf(
+			"pricingModel: quoted %s, replayed %s", quoted.PricingModel, replayed.PricingModel,
+		))
+	}
+
+	for _, amount := range []struct {
+		name             string
+		quoted, replayed float64
+	}{
+		{"totalCost", quoted.TotalCost, replayed.TotalCost},
+		{"costBreakdown.unlockFee", quoted.CostBreakdown.UnlockFee, replayed.CostBreakdown.UnlockFee},
+		{"costBreakdown.bookingCost", quoted.CostBreakdown.BookingCost, replayed.CostBreakdown.BookingCost},
+		{"costBreakdown.travelCost", quoted.CostBreakdown.TravelCost, replayed.CostBreakdown.TravelCost},
+		{"costBreakdown.pauseCost", quoted.CostBreakdown.PauseCost, replayed.CostBreakdown.PauseCost},
+		{"costBreakdown.walkingTimeMinutes", quoted.CostBreakdown.WalkingTime, replayed.CostBreakdown.WalkingTime},
+	} {
+		if math.Abs(amount.quoted-amount.replayed) > 1e-9 {
+			differences = append(differences, fmt.Sprintf(
+				"%s: quoted %.4f, replayed %.4f", amount.name, amount.quoted, amount.replayed,
+			))
+		}
+	}
+
+	return differences
+}
+
+func respondQuoteError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errNotFound) {
+		respondJSON(w, http.StatusNotFound, APIResponse{
+			Success: false,
+			Error:   "Quote not found",
+		})
+
+		return
+	}
+
+	respondJSON(w, http.StatusInternalServerError, APIResponse{
+		Success: false,
+		Error:   err.Error(),
+	})
+}
+
+func getQuoteHandler(quotes *quoteStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		quote, err := quotes.get(r.PathValue("id"), time.Now())
+		if err != nil {
+			respondQuoteError(w, err)
+
+			return
+		}
+
+		respondJSON(w, http.StatusOK, APIResponse{
+			Success: true,
+			Data:    quote,
+		})
+	}
+}
+
+// quoteGeozonesHandler serves the geozones a quote was planned with, as
+// received from Poppy.
+func quoteGeozonesHandler(quotes *quoteStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		quote, err := quotes.get(r.PathValue("id"), time.Now())
+		if err != nil {
+			respondQuoteError(w, err)
+
+			return
+		}
+
+		geozone, err := quotes.geozone(quote)
+		if err != nil {
+			respondQuoteError(w, err)
+
+			return
+		}
+
+		if geozone == nil {
+			geozone = &GeoZone{}
+		}
+
+		respondJSON(w, http.StatusOK, APIResponse{
+			Success: true,
+			Data:    geozone,
+		})
+	}
+}
+
+func replayQuoteHandler(quotes *quoteStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		quote, err := quotes.get(r.PathValue("id"), time.Now())
+		if err != nil {
+			respondQuoteError(w, err)
+
+			return
+		}
+
+		geozone, err := quotes.geozone(quote)
+		if err != nil {
+			respondQuoteError(w, err)
+
+			return
+		}
+
+		respondJSON(w, http.StatusOK, APIResponse{
+			Success: true,
+			Data:    replayQuote(quote, geozone),
+		})
+	}
+}
@@ -0,0 +1,373 @@
+//nolint:package-comments,revive,mnd,exhaustruct
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/paulmach/orb"
+)
+
+func newTestQuoteGeoZone() *GeoZone {
+	return &GeoZone{
+		newTestGeoZone("parking", orb.Polygon{{
+			{4.30, 50.80}, {4.40, 50.80}, {4.40, 50.90}, {4.30, 50.90}, {4.30, 50.80},
+		}}),
+	}
+}
+
+// newTestQuotedPlan prices a one-leg journey the way planJourney does,
+// without any upstream call.
+func newTestQuotedPlan(t *testing.T) *JourneyPlan {
+	t.Helper()
+
+	journey := Journey{Legs: []TripLeg{{
+		StartLocation: Location{Lat: 50.85, Lng: 4.35},
+		EndLocation:   Location{Lat: 50.86, Lng: 4.38},
+		PauseMinutes:  20,
+	}}}
+
+	vehicle := Vehicle{UUID: "vehicle-1", Plate: "1-ABC-123", LocationLatitude: 50.851, LocationLongitude: 4.351}
+	pricing := &PricingResponse{
+		PricingPerMinute: PricingModel{
+			Type: pricingPlanPerMinute, UnlockFee: 100, MinutePrice: 30, PauseUnitPrice: 10, DayCapPrice: 10000,
+		},
+		PricingPerKilometer: PricingModel{
+			Type: pricingPlanPerKilometer, UnlockFee: 100, KilometerPrice: 35, PauseUnitPrice: 10, DayCapPrice: 10000,
+		},
+		SmartPricing: PricingModel{
+			Type: pricingPlanSmart, UnlockFee: 100, MinutePrice: 20, KilometerPrice: 20, DayCapPrice: 10000,
+		},
+	}
+	zones := newZoneIndex(newTestQuoteGeoZone())
+
+	route := journeyRoute{
+		WalkToVehicleMinutes: 2,
+		HasGeoZones:          true,
+		Legs: []legRoute{{
+			DrivingMinutes:   12,
+			DistanceKm:       3.4,
+			EndInParkingZone: zones.inParkingZone(journey.Legs[0].EndLocation),
+		}},
+	}
+
+	plan, err := cheapestPlan(journey, route, vehicle, pricing)
+	if err != nil {
+		t.Fatalf("Expected a plan but got %v", err)
+	}
+
+	plan.request = journey
+	plan.data = &planningData{vehicle: vehicle, pricing: pricing, zones: zones}
+
+	return plan
+}
+
+func TestQuoteStore_RecordAndGet(t *testing.T) {
+	dir := t.TempDir()
+	store := newQuoteStore(dir)
+
+	plan := newTestQuotedPlan(t)
+
+	quote, err := store.record(plan)
+	if err != nil {
+		t.Fatalf("Expected the quote to be recorded but got %v", err)
+	}
+
+	if plan.QuoteID == "" || plan.QuoteID != quote.ID || plan.QuoteExpiresAt == nil {
+		t.Errorf("Expected the plan to carry the quote ID and expiry but got %q %v", plan.QuoteID, plan.QuoteExpiresAt)
+	}
+
+	stored, err := store.get(quote.ID, quote.CreatedAt)
+	if err != nil {
+		t.Fatalf("Expected the quote to be found but got %v", err)
+	}
+
+	if stored.Expired {
+		t.Error("Expected a fresh quote not to be expired")
+	}
+
+	if stored.EngineVersion != pricingEngineVersion || stored.GeozoneID == "" {
+		t.Errorf("Expected the engine version and geozone ID to be stored but got %+v", stored)
+	}
+
+	if stored.Plan.TotalCost != plan.TotalCost || stored.Pricing != *plan.data.pricing {
+		t.Errorf("Expected the plan and pricing to round-trip but got %+v", stored)
+	}
+
+	if len(stored.Route.Legs) != 1 || stored.Route.Legs[0].DistanceKm != 3.4 {
+		t.Errorf("Expected the route to round-trip but got %+v", stored.Route)
+	}
+
+	expired, _ := store.get(quote.ID, quote.ExpiresAt)
+	if !expired.Expired {
+		t.Error("Expected the quote to be expired at its expiry time")
+	}
+
+	if _, err := store.record(newTestQuotedPlan(t)); err != nil {
+		t.Fatalf("Expected a second quote to be recorded but got %v", err)
+	}
+
+	quoteFiles, _ := os.ReadDir(filepath.Join(dir, "quotes"))
+	geozoneFiles, _ := os.ReadDir(filepath.Join(dir, "geozones"))
+
+	if len(quoteFiles) != 2 || len(geozoneFiles) != 1 {
+		t.Errorf("Expected 2 quotes sharing 1 geozone file but got %d and %d", len(quoteFiles), len(geozoneFiles))
+	}
+}
+
+func TestQuoteStore_NotFound(t *testing.T) {
+	store := newQuoteStore(t.TempDir())
+
+	for _, id := range []string{"0123456789abcdef01234567", "../journeys", ""} {
+		if _, err := store.get(id, time.Now()); !errors.Is(err, errNotFound) {
+			t.Errorf("Expected errNotFound for %q but got %v", id, err)
+		}
+	}
+}
+
+func TestQuoteStore_Nil(t *testing.T) {
+	var store *quoteStore
+
+	plan := newTestQuotedPlan(t)
+
+	if quote, err := store.record(plan); quote != nil || err != nil {
+		t.Errorf("Expected a nil store to record nothing but got %v %v", quote, err)
+	}
+
+	if plan.QuoteID != "" {
+		t.Errorf("Expected no quote ID but got %q", plan.QuoteID)
+	}
+}
+
+func TestQuoteStore_Prune(t *testing.T) {
+	store := newQuoteStore(t.TempDir())
+	now := time.Now()
+
+	var ids []string
+
+	for age := range 4 {
+		quote, err := store.record(newTestQuotedPlan(t))
+		if err != nil {
+			t.Fatalf("Expected the quote to be recorded but got %v", err)
+		}
+
+		writtenAt := now.Add(-time.Duration(age) * 24 * time.Hour)
+		_ = os.Chtimes(store.quotePath(quote.ID), writtenAt, writtenAt)
+
+		ids = append(ids, quote.ID)
+	}
+
+	if err := store.prune(now, 60*time.Hour, 2); err != nil {
+		t.Fatalf("Expected the quotes to be pruned but got %v", err)
+	}
+
+	for i, id := range ids {
+		_, err := store.get(id, now)
+		if kept := i < 2; kept != (err == nil) {
+			t.Errorf("Expected quote %d kept %t but got %v", i, kept, err)
+		}
+	}
+
+	if err := store.prune(now, 12*time.Hour, 2); err != nil {
+		t.Fatalf("Expected the quotes to be pruned but got %v", err)
+	}
+
+	if _, err := store.get(ids[1], now); !errors.Is(err, errNotFound) {
+		t.Errorf("Expected the day-old quote to be past retention but got %v", err)
+	}
+
+	if _, err := store.get(ids[0], now); err != nil {
+		t.Errorf("Expected the newest quote to be kept but got %v", err)
+	}
+}
+
+func TestReplayQuote(t *testing.T) {
+	tests := []struct {
+		name        string
+		tamper      func(quote *Quote, geozone *GeoZone) *GeoZone
+		matches     bool
+		differences []string
+	}{
+		{
+			name:    "Unchanged",
+			tamper:  func(_ *Quote, geozone *GeoZone) *GeoZone { return geozone },
+			matches: true,
+		},
+		{
+			name: "Edited total",
+			tamper: func(quote *Quote, geozone *GeoZone) *GeoZone {
+				quote.Plan.TotalCost += 1
+
+				return geozone
+			},
+			differences: []string{"totalCost"},
+		},
+		{
+			name: "Different tariff",
+			tamper: func(quote *Quote, geozone *GeoZone) *GeoZone {
+				quote.Pricing.PricingPerMinute.UnlockFee = 0
+				quote.Pricing.PricingPerKilometer.UnlockFee = 0
+				quote.Pricing.SmartPricing.UnlockFee = 0
+
+				return geozone
+			},
+			differences: []string{"totalCost", "costBreakdown.unlockFee"},
+		},
+		{
+			name: "Different geozones",
+			tamper: func(_ *Quote, _ *GeoZone) *GeoZone {
+				return &GeoZone{newTestGeoZone("parking", orb.Polygon{{
+					{5.30, 51.80}, {5.40, 51.80}, {5.40, 51.90}, {5.30, 51.90}, {5.30, 51.80},
+				}})}
+			},
+			differences: []string{"leg 1 endInParkingZone", "no pricing model applies"},
+		},
+		{
+			name: "Older engine",
+			tamper: func(quote *Quote, geozone *GeoZone) *GeoZone {
+				quote.EngineVersion = "0"
+
+				return geozone
+			},
+			matches: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newQuoteStore(t.TempDir())
+
+			quote, err := store.record(newTestQuotedPlan(t))
+			if err != nil {
+				t.Fatalf("Expected the quote to be recorded but got %v", err)
+			}
+
+			geozone, err := store.geozone(quote)
+			if err != nil {
+				t.Fatalf("Expected the geozones to be stored but got %v", err)
+			}
+
+			replay := replayQuote(quote, tt.tamper(quote, geozone))
+
+			if replay.Matches != tt.matches {
+				t.Errorf("Expected matches %t but got %t: %v", tt.matches, replay.Matches, replay.Differences)
+			}
+
+			if len(replay.Differences) != len(tt.differences) {
+				t.Fatalf("Expected %d differences but got %v", len(tt.differences), replay.Differences)
+			}
+
+			for i, prefix := range tt.differences {
+				if !strings.HasPrefix(replay.Differences[i], prefix) {
+					t.Errorf("Expected difference %d to start with %q but got %q", i, prefix, replay.Differences[i])
+				}
+			}
+		})
+	}
+}
+
+func TestQuoteHandlers(t *testing.T) {
+	store := newQuoteStore(t.TempDir())
+
+	quote, err := store.record(newTestQuotedPlan(t))
+	if err != nil {
+		t.Fatalf("Expected the quote to be recorded but got %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/quotes/{id}", getQuoteHandler(store))
+	mux.HandleFunc("GET /api/v1/quotes/{id}/geozones", quoteGeozonesHandler(store))
+	mux.HandleFunc("GET /api/v1/quotes/{id}/replay", replayQuoteHandler(store))
+
+	tests := []struct {
+		name     string
+		path     string
+		expected int
+	}{
+		{name: "Quote", path: "/api/v1/quotes/" + quote.ID, expected: http.StatusOK},
+		{name: "Geozones", path: "/api/v1/quotes/" + quote.ID + "/geozones", expected: http.StatusOK},
+		{name: "Replay", path: "/api/v1/quotes/" + quote.ID + "/replay", expected: http.StatusOK},
+		{name: "Unknown", path: "/api/v1/quotes/0123456789abcdef01234567", expected: http.StatusNotFound},
+		{name: "Unknown replay", path: "/api/v1/quotes/nope/replay", expected: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if recorder.Code != tt.expected {
+				t.Errorf("Expected status %d but got %d", tt.expected, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestQuoteStore_GeozoneWrittenOnce(t *testing.T) {
+	dir := t.TempDir()
+	store := newQuoteStore(dir)
+
+	plan := newTestQuotedPlan(t)
+	first, err := store.record(plan)
+	if err != nil {
+		t.Fatalf("Expected the quote to be recorded but got %v", err)
+	}
+
+	// Once known, the geozone file is not looked at again.
+	path := store.geozonePath(first.GeozoneID)
+	_ = os.Remove(path)
+
+	second, err := store.record(plan)
+	if err != nil || second.GeozoneID != first.GeozoneID {
+		t.Fatalf("Expected the same geozone ID but got %v, %v", second, err)
+	}
+
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the geozone not to be written twice but got %v", err)
+	}
+}
+
+func TestCalendarHandler(t *testing.T) {
+	store := newQuoteStore(t.TempDir())
+
+	plan := newTestQuotedPlan(t)
+	applyTimeline(plan, time.Date(2025, 9, 18, 9, 0, 0, 0, time.UTC))
+
+	if _, err := store.record(plan); err != nil {
+		t.Fatalf("Expected the quote to be recorded but got %v", err)
+	}
+
+	handler := calendarHandler(store)
+
+	tests := []struct {
+		name     string
+		quote    string
+		status   int
+		contains string
+	}{
+		{name: "Quoted plan", quote: plan.QuoteID, status: http.StatusOK, contains: "UID:" + plan.QuoteID + "-0@"},
+		{name: "Unknown quote", quote: "0123456789abcdef01234567", status: http.StatusNotFound},
+		{name: "Missing quote", quote: "", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/plan.ics?quote="+tt.quote, nil))
+
+			if recorder.Code != tt.status {
+				t.Fatalf("Expected status %d but got %d", tt.status, recorder.Code)
+			}
+
+			if !strings.Contains(recorder.Body.String(), tt.contains) {
+				t.Errorf("Expected the calendar to contain %q but got %s", tt.contains, recorder.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+//nolint:package-comments,revive,mnd,exhaustruct
+package main
+
+import "net/http"
+
+// services are the shared clients and stores the handlers are built from.
+type services struct {
+	config          Config
+	client          *http.Client
+	cache           *upstreamCache
+	addressGeocoder geocoder
+	quotes          *quoteStore
+	journeys        *journeyStore
+	watches         *watchStore
+	tariffs         *tariffStore
+	auth            *apiKeyAuth
+	webhooks        *webhookSender
+	journeyTokens   *journeyTokens
+}
+
+type route struct {
+	pattern string
+	handler http.HandlerFunc
+}
+
+// routes lists every endpoint of the server. Each /api/ route must be
+// described in openapi.json, which TestOpenAPIDocument_CoversRoutes checks.
+func routes(s services) []route {
+	known := []route{
+		{"GET /", indexHandler()},
+		{"POST /plan", planHandler(s.client, s.cache, s.addressGeocoder, s.quotes)},
+		{"GET /plan.ics", calendarHandler(s.quotes)},
+		{"POST /plan/stream", planStreamHandler(s.journeyTokens)},
+		{"GET /plan/events", planEventsHandler(s.client, s.cache, s.addressGeocoder, s.quotes, s.journeyTokens)},
+
+		{"POST /api/v1/plan-journey", planJourneyHandler(s.client, s.cache, s.addressGeocoder, s.quotes)},
+		{
+			"POST /api/v1/plan-journey:stream",
+			planJourneyStreamHandler(s.client, s.cache, s.addressGeocoder, s.quotes),
+		},
+		{
+			"POST /api/v1/plan-journeys:batch",
+			planJourneysBatchHandler(s.client, s.cache, s.addressGeocoder, s.quotes),
+		},
+		{
+			"POST /api/v1/optimize-journey",
+			optimizeJourneyHandler(s.client, s.cache, s.addressGeocoder, s.quotes),
+		},
+		{"GET /api/v1/vehicles", vehiclesHandler(s.client, s.cache)},
+		{"GET /api/v1/vehicles/{uuid}", vehicleDetailHandler(s.client, s.cache)},
+		{"GET /api/v1/geozones", geoZonesHandler(s.client, s.cache)},
+		{"GET /api/v1/pricing", pricingHandler(s.client, s.cache)},
+		{"GET /api/v1/pricing/history", pricingHistoryHandler(s.tariffs)},
+		{"GET /api/v1/journeys", listJourneysHandler(s.journeys)},
+		{"POST /api/v1/journeys", createJourneyHandler(s.journeys)},
+		{"GET /api/v1/journeys/{id}", getJourneyHandler(s.journeys)},
+		{"PUT /api/v1/journeys/{id}", updateJourneyHandler(s.journeys)},
+		{"DELETE /api/v1/journeys/{id}", deleteJourneyHandler(s.journeys)},
+		{"GET /api/v1/journeys/{id}/quotes", journeyQuotesHandler(s.journeys)},
+		{
+			"POST /api/v1/journeys/{id}/quotes",
+			requoteJourneyHandler(s.client, s.cache, s.addressGeocoder, s.quotes, s.journeys),
+		},
+		{"GET /api/v1/quotes/{id}", getQuoteHandler(s.quotes)},
+		{"GET /api/v1/quotes/{id}/geozones", quoteGeozonesHandler(s.quotes)},
+		{"GET /api/v1/quotes/{id}/replay", replayQuoteHandler(s.quotes)},
+		{"GET /api/v1/watches", listWatchesHandler(s.watches)},
+		{"POST /api/v1/watches", createWatchHandler(s.watches)},
+		{"GET /api/v1/watches/{id}", getWatchHandler(s.watches)},
+		{"DELETE /api/v1/watches/{id}", deleteWatchHandler(s.watches)},
+		{"POST /api/v1/watches/{id}/test", testWatchHandler(s.watches, s.webhooks)},
+		{"GET /api/v1/admin/usage", usageHandler(s.auth)},
+		{"GET /api/v1/health", healthHandler()},
+		{"GET /api/v1/health/live", healthHandler()},
+		{"GET /api/v1/health/ready", readinessHandler(newReadinessProbe(s.client, s.config))},
+		{"GET /api/openapi.json", openAPIHandler()},
+		{"GET /metrics", metricsHandler()},
+
+		{"POST /api/v2/plan-journey", planJourneyV2Handler(s.client, s.cache, s.addressGeocoder, s.quotes)},
+		{
+			"POST /api/v2/optimize-journey",
+			optimizeJourneyV2Handler(s.client, s.cache, s.addressGeocoder, s.quotes),
+		},
+		{"GET /api/v2/vehicles", vehiclesV2Handler(s.client, s.cache)},
+		{"GET /api/v2/health", healthV2Handler()},
+	}
+
+	notFound := notFoundV2Handler(known)
+	for _, method := range apiV2Methods {
+		known = append(known, route{method + " /api/v2/", notFound})
+	}
+
+	return known
+}
+
+func newRouter(s services) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	for _, route := range routes(s) {
+		mux.HandleFunc(route.pattern, route.handler)
+	}
+
+	return mux
+}
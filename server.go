@@ -0,0 +1,157 @@
+//nolint:package-comments,revive,mnd,exhaustruct
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	serverReadHeaderTimeout = 10 * time.Second
+	serverReadTimeout       = 30 * time.Second
+	// serverWriteTimeout bounds ordinary responses. The streaming
+	// endpoints and batch planning lift it with allowLongResponse.
+	serverWriteTimeout = time.Minute
+	serverIdleTimeout  = 2 * time.Minute
+	serverMaxHeader    = 64 << 10
+)
+
+// newServer builds the HTTP server for handler. Request contexts derive
+// from the background context rather than the signal context, so an
+// in-flight plan is drained on shutdown instead of cancelled.
+func newServer(config Config, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              ":" + config.Port,
+		Handler:           handler,
+		ReadHeaderTimeout: serverReadHeaderTimeout,
+		ReadTimeout:       serverReadTimeout,
+		WriteTimeout:      serverWriteTimeout,
+		IdleTimeout:       serverIdleTimeout,
+		MaxHeaderBytes:    serverMaxHeader,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+}
+
+// allowLongResponse lifts the server write timeout for a response that
+// streams or may take minutes, such as a large batch.
+func allowLongResponse(w http.ResponseWriter) {
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+}
+
+// withBodyLimit cuts request bodies off at limit bytes, whether their
+// length is declared or not. Handlers answer the failed read with 413
+// through respondBodyTooLarge.
+func withBodyLimit(limit int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// respondBodyTooLarge answers 413 in the format of the API version of r
+// when err comes from a body cut off by withBodyLimit, and reports whether
+// it did.
+func respondBodyTooLarge(w http.ResponseWriter, r *http.Request, err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	if !errors.As(err, &maxBytesErr) {
+		return false
+	}
+
+	respondAPIError(w, r, apiErrorPayloadTooLarge,
+		fmt.Sprintf("Request body exceeds the limit of %d bytes", maxBytesErr.Limit))
+
+	return true
+}
+
+// workerGroup tracks the background workers, such as the watch poller and
+// the tariff monitor, so shutdown can wait for them to stop.
+type workerGroup struct {
+	wg sync.WaitGroup
+}
+
+// start runs worker in its own goroutine. The worker must return once ctx
+// is cancelled.
+func (g *workerGroup) start(ctx context.Context, worker func(context.Context)) {
+	g.wg.Add(1)
+
+	go func() {
+		defer g.wg.Done()
+
+		worker(ctx)
+	}()
+}
+
+// wait blocks until every worker has returned or ctx is done.
+func (g *workerGroup) wait(ctx context.Context) error {
+	done := make(chan struct{})
+
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// serve answers requests on listener until ctx is cancelled, typically by
+// SIGTERM. It then stops accepting connections and gives in-flight
+// requests and the workers, which share ctx, shutdownTimeout to finish
+// before closing what is left.
+func serve(
+	ctx context.Context,
+	server *http.Server,
+	listener net.Listener,
+	workers *workerGroup,
+	shutdownTimeout time.Duration,
+) error {
+	serveErr := make(chan error, 1)
+
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("[serve] server stopped: %w", err)
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down", "timeout", shutdownTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shutdownTimeout)
+	defer cancel()
+
+	var errs []error
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		errs = append(errs, fmt.Errorf("[serve] requests still in flight: %w", err))
+
+		_ = server.Close()
+	}
+
+	if err := workers.wait(shutdownCtx); err != nil {
+		errs = append(errs, fmt.Errorf("[serve] background workers did not stop: %w", err))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	slog.Info("shutdown complete")
+
+	return nil
+}
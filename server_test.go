@@ -0,0 +1,197 @@
+//nolint:package-comments,revive,mnd,exhaustruct
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithBodyLimit(t *testing.T) {
+	handler := withBodyLimit(16, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			if !respondBodyTooLarge(w, r, err) {
+				w.WriteHeader(http.StatusBadRequest)
+			}
+
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name        string
+		path        string
+		body        string
+		chunked     bool
+		status      int
+		contentType string
+	}{
+		{name: "Within the limit", path: "/api/v1/plan-journey", body: `{"legs":[]}`, status: http.StatusNoContent},
+		{name: "Declared too large", path: "/api/v1/plan-journey", body: strings.Repeat("x", 17), status: http.StatusRequestEntityTooLarge, contentType: "application/json"},
+		{name: "Declared too large v2", path: "/api/v2/plan-journey", body: strings.Repeat("x", 17), status: http.StatusRequestEntityTooLarge, contentType: problemContentType},
+		{name: "Chunked too large", path: "/api/v1/plan-journey", body: strings.Repeat("x", 17), chunked: true, status: http.StatusRequestEntityTooLarge, contentType: "application/json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			if tt.chunked {
+				request.ContentLength = -1
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.status {
+				t.Errorf("Expected status %d but got %d", tt.status, recorder.Code)
+			}
+
+			if tt.contentType != "" && recorder.Header().Get("Content-Type") != tt.contentType {
+				t.Errorf("Expected content type %s but got %s", tt.contentType, recorder.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+// startTestServer serves handler through serve on a local port and returns
+// its URL, the function that triggers the shutdown and the result of serve.
+func startTestServer(
+	t *testing.T,
+	handler http.Handler,
+	workers *workerGroup,
+	shutdownTimeout time.Duration,
+) (string, context.CancelFunc, <-chan error) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected a local listener but got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	server := newServer(defaultConfig(), handler)
+	result := make(chan error, 1)
+
+	go func() {
+		result <- serve(ctx, server, listener, workers, shutdownTimeout)
+	}()
+
+	return "http://" + listener.Addr().String(), cancel, result
+}
+
+func TestServe_DrainsInFlightRequests(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		close(started)
+		<-release
+
+		respondJSON(w, http.StatusOK, APIResponse{Success: true})
+	})
+
+	workerStopped := make(chan struct{})
+
+	var workers workerGroup
+
+	workersCtx, cancelWorkers := context.WithCancel(context.Background())
+	workers.start(workersCtx, func(ctx context.Context) {
+		<-ctx.Done()
+		close(workerStopped)
+	})
+
+	url, shutdown, result := startTestServer(t, handler, &workers, 5*time.Second)
+
+	response := make(chan *http.Response, 1)
+
+	go func() {
+		resp, err := http.Post(url+"/api/v1/plan-journey", "application/json", strings.NewReader(`{}`))
+		if err != nil {
+			t.Errorf("Expected the in-flight request to complete but got %v", err)
+			close(response)
+
+			return
+		}
+
+		response <- resp
+	}()
+
+	<-started
+	shutdown()
+	cancelWorkers()
+
+	select {
+	case err := <-result:
+		t.Fatalf("Expected serve to wait for the in-flight request but it returned %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+
+	resp := <-response
+	if resp == nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	var body APIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || !body.Success {
+		t.Errorf("Expected the drained request to succeed but got %v, %v", body, err)
+	}
+
+	if err := <-result; err != nil {
+		t.Errorf("Expected a clean shutdown but got %v", err)
+	}
+
+	select {
+	case <-workerStopped:
+	default:
+		t.Error("Expected the worker to be stopped")
+	}
+
+	if _, err := http.Get(url + "/api/v1/health"); err == nil {
+		t.Error("Expected no new connections after the shutdown")
+	}
+}
+
+func TestServe_ShutdownTimeout(t *testing.T) {
+	started := make(chan struct{})
+
+	handler := http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	})
+
+	var workers workerGroup
+
+	url, shutdown, result := startTestServer(t, handler, &workers, 50*time.Millisecond)
+
+	go func() {
+		resp, err := http.Get(url + "/api/v1/stream")
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+
+	<-started
+	shutdown()
+
+	select {
+	case err := <-result:
+		if err == nil || !strings.Contains(err.Error(), "requests still in flight") {
+			t.Errorf("Expected the stuck request to be reported but got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected serve to give up after the shutdown timeout")
+	}
+}
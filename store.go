@@ -0,0 +1,250 @@
+//nolint:package-comments,revive,mnd,exhaustruct
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const defaultDataDir = "data"
+
+var errNotFound = errors.New("not found")
+
+// jsonStore keeps records in memory and persists each in its own JSON file
+// under a directory, rewritten atomically when the record changes, so a
+// change costs one small write however many records there are. An empty
+// directory keeps the store in memory.
+type jsonStore[T any] struct {
+	mu    sync.RWMutex
+	dir   string
+	items map[string]T
+}
+
+// openJSONStore loads the records under dir. A single store file written
+// by earlier versions, dir with a .json suffix, is split into record files
+// and removed.
+func openJSONStore[T any](dir string) (*jsonStore[T], error) {
+	store := &jsonStore[T]{dir: dir, items: map[string]T{}}
+
+	if dir == "" {
+		return store, nil
+	}
+
+	if err := store.migrate(dir + ".json"); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("[openJSONStore] could not list %s: %w", dir, err)
+	}
+
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() {
+			continue
+		}
+
+		id, err := url.PathUnescape(name)
+		if err != nil {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("[openJSONStore] could not read %s: %w", path, err)
+		}
+
+		var item T
+		if err := json.Unmarshal(data, &item); err != nil {
+			return nil, fmt.Errorf("[openJSONStore] could not decode %s: %w", path, err)
+		}
+
+		store.items[id] = item
+	}
+
+	return store, nil
+}
+
+// migrate writes every record of the single store file at path to its own
+// file and removes path once they are all written.
+func (s *jsonStore[T]) migrate(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("[openJSONStore] could not read %s: %w", path, err)
+	}
+
+	var items map[string]T
+	if err := json.Unmarshal(data, &items); err != nil {
+		return fmt.Errorf("[openJSONStore] could not decode %s: %w", path, err)
+	}
+
+	for id, item := range items {
+		if err := s.save(id, item); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("[openJSONStore] could not remove %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func (s *jsonStore[T]) get(id string) (T, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	item, ok := s.items[id]
+
+	return item, ok
+}
+
+func (s *jsonStore[T]) list() []T {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	items := make([]T, 0, len(s.items))
+	for _, item := range s.items {
+		items = append(items, item)
+	}
+
+	return items
+}
+
+func (s *jsonStore[T]) put(id string, item T) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.save(id, item); err != nil {
+		return err
+	}
+
+	s.items[id] = item
+
+	return nil
+}
+
+// update applies change to the record under the store lock, so concurrent
+// read-modify-write cycles on the same record cannot lose updates.
+func (s *jsonStore[T]) update(id string, change func(*T) error) (T, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, ok := s.items[id]
+	if !ok {
+		var zero T
+
+		return zero, errNotFound
+	}
+
+	previous := item
+
+	if err := change(&item); err != nil {
+		return previous, err
+	}
+
+	if err := s.save(id, item); err != nil {
+		return previous, err
+	}
+
+	s.items[id] = item
+
+	return item, nil
+}
+
+func (s *jsonStore[T]) delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.items[id]; !ok {
+		return errNotFound
+	}
+
+	if s.dir != "" {
+		if err := os.Remove(s.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("[jsonStore] could not delete %s: %w", id, err)
+		}
+	}
+
+	delete(s.items, id)
+
+	return nil
+}
+
+// path is the file of the record id. IDs are escaped, as tariff keys hold
+// a slash.
+func (s *jsonStore[T]) path(id string) string {
+	return filepath.Join(s.dir, url.PathEscape(id)+".json")
+}
+
+// save writes the file of the record id.
+func (s *jsonStore[T]) save(id string, item T) error {
+	if s.dir == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(item, "", "  ")
+	if err != nil {
+		return fmt.Errorf("[jsonStore] could not encode %s: %w", id, err)
+	}
+
+	return writeFileAtomic(s.path(id), data)
+}
+
+// writeFileAtomic writes data to a temporary file and renames it over
+// path, so a crash never leaves a truncated file behind.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return fmt.Errorf("[writeFileAtomic] could not create directory: %w", err)
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("[writeFileAtomic] could not create temporary file: %w", err)
+	}
+
+	defer func() { _ = os.Remove(temp.Name()) }()
+
+	if _, err := temp.Write(data); err != nil {
+		_ = temp.Close()
+
+		return fmt.Errorf("[writeFileAtomic] could not write: %w", err)
+	}
+
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("[writeFileAtomic] could not write: %w", err)
+	}
+
+	if err := os.Rename(temp.Name(), path); err != nil {
+		return fmt.Errorf("[writeFileAtomic] could not replace %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func newID() string {
+	buffer := make([]byte, 12)
+	_, _ = rand.Read(buffer)
+
+	return hex.EncodeToString(buffer)
+}
@@ -0,0 +1,138 @@
+//nolint:package-comments,revive,mnd,exhaustruct,err113
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type storeRecord struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func TestJSONStore_Persists(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "nested", "records")
+
+	store, err := openJSONStore[storeRecord](dir)
+	if err != nil {
+		t.Fatalf("Expected an empty store but got %v", err)
+	}
+
+	if err := store.put("a", storeRecord{Name: "first"}); err != nil {
+		t.Fatalf("Expected put to succeed but got %v", err)
+	}
+
+	if err := store.put("car/S", storeRecord{Name: "second"}); err != nil {
+		t.Fatalf("Expected put to succeed but got %v", err)
+	}
+
+	if _, err := store.update("a", func(record *storeRecord) error {
+		record.Count++
+
+		return nil
+	}); err != nil {
+		t.Fatalf("Expected update to succeed but got %v", err)
+	}
+
+	if err := store.put("c", storeRecord{Name: "third"}); err != nil {
+		t.Fatalf("Expected put to succeed but got %v", err)
+	}
+
+	if err := store.delete("c"); err != nil {
+		t.Fatalf("Expected delete to succeed but got %v", err)
+	}
+
+	reopened, err := openJSONStore[storeRecord](dir)
+	if err != nil {
+		t.Fatalf("Expected the store to reopen but got %v", err)
+	}
+
+	if records := reopened.list(); len(records) != 2 {
+		t.Fatalf("Expected 2 records but got %d", len(records))
+	}
+
+	record, ok := reopened.get("a")
+	if !ok || record.Name != "first" || record.Count != 1 {
+		t.Errorf("Expected the updated record but got %+v", record)
+	}
+
+	if record, ok := reopened.get("car/S"); !ok || record.Name != "second" {
+		t.Errorf("Expected the record with a slash in its ID but got %+v", record)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("Expected one file per record and no temporary files but got %d entries", len(entries))
+	}
+}
+
+func TestJSONStore_MigratesSingleFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "records")
+	_ = os.WriteFile(dir+".json", []byte(`{"a": {"name": "first"}, "car/S": {"name": "second"}}`), 0o600)
+
+	store, err := openJSONStore[storeRecord](dir)
+	if err != nil {
+		t.Fatalf("Expected the single file to be migrated but got %v", err)
+	}
+
+	if len(store.list()) != 2 {
+		t.Fatalf("Expected 2 records but got %d", len(store.list()))
+	}
+
+	if _, err := os.Stat(dir + ".json"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the single file to be removed but got %v", err)
+	}
+
+	reopened, _ := openJSONStore[storeRecord](dir)
+	if record, ok := reopened.get("car/S"); !ok || record.Name != "second" {
+		t.Errorf("Expected the migrated record to persist but got %+v", record)
+	}
+}
+
+func TestJSONStore_NotFound(t *testing.T) {
+	store, _ := openJSONStore[storeRecord]("")
+
+	if _, ok := store.get("missing"); ok {
+		t.Error("Expected get to miss")
+	}
+
+	if _, err := store.update("missing", func(*storeRecord) error { return nil }); !errors.Is(err, errNotFound) {
+		t.Errorf("Expected errNotFound from update but got %v", err)
+	}
+
+	if err := store.delete("missing"); !errors.Is(err, errNotFound) {
+		t.Errorf("Expected errNotFound from delete but got %v", err)
+	}
+}
+
+func TestJSONStore_UpdateError(t *testing.T) {
+	store, _ := openJSONStore[storeRecord]("")
+	_ = store.put("a", storeRecord{Name: "first"})
+
+	failure := errors.New("rejected")
+
+	_, err := store.update("a", func(record *storeRecord) error {
+		record.Name = "changed"
+
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Errorf("Expected the change error but got %v", err)
+	}
+
+	if record, _ := store.get("a"); record.Name != "first" {
+		t.Errorf("Expected a failed update to leave the record alone but got %+v", record)
+	}
+}
+
+func TestJSONStore_CorruptFile(t *testing.T) {
+	dir := t.TempDir()
+	_ = os.WriteFile(filepath.Join(dir, "a.json"), []byte("{not json"), 0o600)
+
+	if _, err := openJSONStore[storeRecord](dir); err == nil {
+		t.Error("Expected an error for a corrupt file")
+	}
+}
@@ -0,0 +1,366 @@
+//nolint:package-comments,revive,mnd,exhaustruct
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	_ "time/tzdata" // Embedded so the planner timezone also loads in slim images.
+)
+
+// plannerTimezone is the timezone used for times entered in the web form.
+const plannerTimezone = "Europe/Brussels"
+
+// timelineConflictTolerance absorbs rounding in routed durations before a
+// user-supplied leg time is reported as unreachable.
+const timelineConflictTolerance = time.Minute
+
+var plannerLocation = loadPlannerLocation()
+
+type timelineEventKind string
+
+const (
+	timelineEventWalk   timelineEventKind = "walk"
+	timelineEventUnlock timelineEventKind = "unlock"
+	timelineEventDrive  timelineEventKind = "drive"
+	timelineEventPause  timelineEventKind = "pause"
+	timelineEventWait   timelineEventKind = "wait"
+	timelineEventLock   timelineEventKind = "lock"
+)
+
+type TimelineEvent struct {
+	Kind     timelineEventKind `json:"kind"`
+	Label    string            `json:"label"`
+	LegIndex *int              `json:"legIndex,omitempty"`
+	Location Location          `json:"location"`
+	Start    time.Time         `json:"start"`
+	End      time.Time         `json:"end"`
+}
+
+func minutesToDuration(minutes float64) time.Duration {
+	return time.Duration(minutes * float64(time.Minute)).Round(time.Second)
+}
+
+// scheduleLegWaits sets the wait before every leg whose start time is later
+// than the time it can start at, following the timeline from departure the
+// way buildTimeline lays it out.
+func scheduleLegWaits(route *journeyRoute, journey Journey, departure time.Time) {
+	if len(route.Legs) != len(journey.Legs) {
+		return
+	}
+
+	cursor := departure.Add(minutesToDuration(route.WalkToVehicleMinutes))
+
+	for i, leg := range journey.Legs {
+		if i > 0 {
+			cursor = cursor.Add(minutesToDuration(route.Legs[i].WalkToStartMinutes))
+		}
+
+		route.Legs[i].WaitMinutes = 0
+
+		if !leg.StartTime.IsZero() && leg.StartTime.After(cursor) {
+			route.Legs[i].WaitMinutes = leg.StartTime.Sub(cursor).Minutes()
+			cursor = leg.StartTime
+		}
+
+		cursor = cursor.Add(minutesToDuration(route.Legs[i].DrivingMinutes)).
+			Add(time.Duration(leg.PauseMinutes) * time.Minute)
+	}
+}
+
+// buildTimeline lays out every step of a planned journey from departure,
+// using the routed durations and waits the plan was priced with.
+func buildTimeline(departure time.Time, plan *JourneyPlan) []TimelineEvent {
+	legs := plan.Journey.Legs
+	route := plan.route
+
+	if len(legs) == 0 || len(route.Legs) != len(legs) {
+		return nil
+	}
+
+	vehicleLocation := vehicleToLocation(plan.Vehicle)
+	cursor := departure
+
+	events := []TimelineEvent{}
+	add := func(event TimelineEvent, duration time.Duration) {
+		event.Start = cursor
+		event.End = cursor.Add(duration)
+		events = append(events, event)
+		cursor = event.End
+	}
+
+	if wait := minutesToDuration(route.Legs[0].WaitMinutes); wait > 0 {
+		add(TimelineEvent{
+			Kind:     timelineEventWait,
+			Label:    "Wait before setting off",
+			LegIndex: legIndex(0),
+			Location: legs[0].StartLocation,
+		}, wait)
+	}
+
+	add(TimelineEvent{
+		Kind: timelineEventWalk,
+		Label: fmt.Sprintf(
+			"Walk to %s %s (%s)",
+			plan.Vehicle.Model.Make,
+			plan.Vehicle.Model.Name,
+			plan.Vehicle.Plate,
+		),
+		Location: vehicleLocation,
+	}, minutesToDuration(route.WalkToVehicleMinutes))
+
+	add(TimelineEvent{
+		Kind:     timelineEventUnlock,
+		Label:    "Unlock " + plan.Vehicle.Plate,
+		Location: vehicleLocation,
+	}, 0)
+
+	for i, leg := range legs {
+		if wait := minutesToDuration(route.Legs[i].WaitMinutes); i > 0 && wait > 0 {
+			add(TimelineEvent{
+				Kind:     timelineEventWait,
+				Label:    fmt.Sprintf("Wait before leg %d", i+1),
+				LegIndex: legIndex(i),
+				Location: legs[i-1].EndLocation,
+			}, wait)
+		}
+
+		if walk := minutesToDuration(route.Legs[i].WalkToStartMinutes); i > 0 && walk > 0 {
+			add(TimelineEvent{
+				Kind:     timelineEventWalk,
+				Label:    fmt.Sprintf("Walk to start of leg %d", i+1),
+				LegIndex: legIndex(i),
+				Location: leg.StartLocation,
+			}, walk)
+		}
+
+		add(TimelineEvent{
+			Kind:     timelineEventDrive,
+			Label:    fmt.Sprintf("Drive leg %d", i+1),
+			LegIndex: legIndex(i),
+			Location: leg.EndLocation,
+		}, minutesToDuration(route.Legs[i].DrivingMinutes))
+
+		if leg.PauseMinutes > 0 {
+			add(TimelineEvent{
+				Kind:     timelineEventPause,
+				Label:    fmt.Sprintf("Pause after leg %d", i+1),
+				LegIndex: legIndex(i),
+				Location: leg.EndLocation,
+			}, time.Duration(leg.PauseMinutes)*time.Minute)
+		}
+	}
+
+	finalLocation := legs[len(legs)-1].EndLocation
+
+	add(TimelineEvent{
+		Kind:     timelineEventLock,
+		Label:    "Lock and end trip",
+		Location: finalLocation,
+	}, 0)
+
+	if suggestion := plan.ParkingSuggestion; suggestion != nil {
+		add(TimelineEvent{
+			Kind:     timelineEventWalk,
+			Label:    "Walk from parking spot to destination",
+			Location: suggestion.OriginalDestination,
+		}, minutesToDuration(suggestion.WalkingMinutes))
+	}
+
+	return events
+}
+
+// timelineConflicts compares the planned drive of each leg with the start
+// and end times supplied for it, if any.
+func timelineConflicts(journey Journey, timeline []TimelineEvent) []ValidationIssue {
+	var conflicts []ValidationIssue
+
+	for _, event := range timeline {
+		if event.Kind != timelineEventDrive || event.LegIndex == nil {
+			continue
+		}
+
+		leg := journey.Legs[*event.LegIndex]
+
+		if !leg.StartTime.IsZero() &&
+			event.Start.After(leg.StartTime.Add(timelineConflictTolerance)) {
+			conflicts = append(conflicts, ValidationIssue{
+				Code:     validationCodeTimelineConflict,
+				LegIndex: legIndex(*event.LegIndex),
+				Field:    "startTime",
+				Message: fmt.Sprintf(
+					"Leg cannot start at %s, the earliest start is %s",
+					leg.StartTime.In(event.Start.Location()).Format("15:04"),
+					event.Start.Format("15:04"),
+				),
+				Remedy: "Leave earlier or move the leg start time",
+			})
+		}
+
+		if !leg.EndTime.IsZero() &&
+			event.End.After(leg.EndTime.Add(timelineConflictTolerance)) {
+			conflicts = append(conflicts, ValidationIssue{
+				Code:     validationCodeTimelineConflict,
+				LegIndex: legIndex(*event.LegIndex),
+				Field:    "endTime",
+				Message: fmt.Sprintf(
+					"Leg cannot end by %s, the earliest arrival is %s",
+					leg.EndTime.In(event.End.Location()).Format("15:04"),
+					event.End.Format("15:04"),
+				),
+				Remedy: "Leave earlier, shorten previous pauses or move the leg end time",
+			})
+		}
+	}
+
+	return conflicts
+}
+
+// applyTimeline attaches the timeline to plan, flags conflicts with the
+// user-supplied leg times and fills in the leg times that were left empty.
+func applyTimeline(plan *JourneyPlan, departure time.Time) {
+	plan.Timeline = buildTimeline(departure, plan)
+	plan.Warnings = append(plan.Warnings, timelineConflicts(plan.Journey, plan.Timeline)...)
+
+	plan.Journey.Legs = slices.Clone(plan.Journey.Legs)
+
+	for _, event := range plan.Timeline {
+		if event.Kind != timelineEventDrive || event.LegIndex == nil {
+			continue
+		}
+
+		leg := &plan.Journey.Legs[*event.LegIndex]
+
+		if leg.StartTime.IsZero() {
+			leg.StartTime = event.Start
+		}
+
+		if leg.EndTime.IsZero() {
+			leg.EndTime = event.End
+		}
+	}
+}
+
+func journeyDeparture(journey Journey, now time.Time) time.Time {
+	if journey.DepartureTime != nil {
+		return *journey.DepartureTime
+	}
+
+	return now.In(plannerLocation)
+}
+
+func loadPlannerLocation() *time.Location {
+	location, err := time.LoadLocation(plannerTimezone)
+	if err != nil {
+		return time.UTC
+	}
+
+	return location
+}
+
+func respondICalendar(w http.ResponseWriter, plan *JourneyPlan) {
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="journey.ics"`)
+	w.WriteHeader(http.StatusOK)
+
+	_ = writeICalendar(w, plan, time.Now())
+}
+
+const iCalendarTimeFormat = "20060102T150405Z"
+
+var iCalendarEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	";", `\;`,
+	",", `\,`,
+	"\n", `\n`,
+)
+
+// foldICalendarLine splits content lines longer than 75 octets as required
+// by RFC 5545, without breaking multi-byte characters.
+func foldICalendarLine(line string) string {
+	var builder strings.Builder
+
+	width := 0
+
+	for _, char := range line {
+		size := len(string(char))
+		if width+size > 75 {
+			builder.WriteString("\r\n ")
+
+			width = 1
+		}
+
+		builder.WriteRune(char)
+		width += size
+	}
+
+	return builder.String()
+}
+
+// writeICalendar renders the plan timeline as an iCalendar document with
+// one event per step.
+func writeICalendar(w io.Writer, plan *JourneyPlan, now time.Time) error {
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//Poppy Journey Planner//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+	}
+
+	description := fmt.Sprintf(
+		"%s %s (%s), estimated cost €%.2f with %s",
+		plan.Vehicle.Model.Make,
+		plan.Vehicle.Model.Name,
+		plan.Vehicle.Plate,
+		plan.TotalCost,
+		plan.PricingModel.DisplayName(),
+	)
+
+	id := calendarID(plan)
+
+	for i, event := range plan.Timeline {
+		lines = append(lines,
+			"BEGIN:VEVENT",
+			fmt.Sprintf("UID:%s-%d@poppy-journey-planner", id, i),
+			"DTSTAMP:"+now.UTC().Format(iCalendarTimeFormat),
+			"DTSTART:"+event.Start.UTC().Format(iCalendarTimeFormat),
+			"DTEND:"+event.End.UTC().Format(iCalendarTimeFormat),
+			"SUMMARY:"+iCalendarEscaper.Replace(event.Label),
+			"DESCRIPTION:"+iCalendarEscaper.Replace(description),
+			fmt.Sprintf("GEO:%.6f;%.6f", event.Location.Lat, event.Location.Lng),
+			"END:VEVENT",
+		)
+	}
+
+	lines = append(lines, "END:VCALENDAR")
+
+	for _, line := range lines {
+		if _, err := io.WriteString(w, foldICalendarLine(line)+"\r\n"); err != nil {
+			return fmt.Errorf("[writeICalendar] could not write calendar: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// calendarID identifies the plan in iCalendar UIDs: its quote ID, or a
+// hash of the plan when it was not recorded. Two plans starting at the
+// same time no longer share UIDs, and exporting a plan again updates its
+// events instead of duplicating them.
+func calendarID(plan *JourneyPlan) string {
+	if plan.QuoteID != "" {
+		return plan.QuoteID
+	}
+
+	data, _ := json.Marshal(plan)
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:12])
+}
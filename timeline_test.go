@@ -0,0 +1,297 @@
+//nolint:package-comments,revive,mnd,exhaustruct
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestTimelinePlan() *JourneyPlan {
+	return &JourneyPlan{
+		Vehicle: Vehicle{
+			Plate:             "2HFP336",
+			LocationLatitude:  50.8360,
+			LocationLongitude: 4.3580,
+			Model:             Model{Make: "Opel", Name: "CORSA"},
+		},
+		Journey: Journey{
+			Legs: []TripLeg{
+				{
+					StartLocation: Location{Lat: 50.8355, Lng: 4.3573},
+					EndLocation:   Location{Lat: 50.8245, Lng: 4.3635},
+					PauseMinutes:  120,
+				},
+				{
+					StartLocation: Location{Lat: 50.8245, Lng: 4.3635},
+					EndLocation:   Location{Lat: 50.8275, Lng: 4.3745},
+				},
+			},
+		},
+		TotalCost:    32.30,
+		PricingModel: pricingPlanSmart,
+		route: journeyRoute{
+			WalkToVehicleMinutes: 2,
+			Legs: []legRoute{
+				{WalkToStartMinutes: 2, DrivingMinutes: 8},
+				{WalkToStartMinutes: 0, DrivingMinutes: 5},
+			},
+		},
+	}
+}
+
+func TestBuildTimeline(t *testing.T) {
+	departure := time.Date(2025, 9, 18, 9, 0, 0, 0, time.UTC)
+
+	timeline := buildTimeline(departure, newTestTimelinePlan())
+
+	expected := []struct {
+		kind  timelineEventKind
+		start string
+		end   string
+	}{
+		{timelineEventWalk, "09:00", "09:02"},
+		{timelineEventUnlock, "09:02", "09:02"},
+		{timelineEventDrive, "09:02", "09:10"},
+		{timelineEventPause, "09:10", "11:10"},
+		{timelineEventDrive, "11:10", "11:15"},
+		{timelineEventLock, "11:15", "11:15"},
+	}
+
+	if len(timeline) != len(expected) {
+		t.Fatalf("Expected %d events but got %d: %+v", len(expected), len(timeline), timeline)
+	}
+
+	for i, event := range timeline {
+		if event.Kind != expected[i].kind ||
+			event.Start.Format("15:04") != expected[i].start ||
+			event.End.Format("15:04") != expected[i].end {
+			t.Errorf("Event %d: expected %s %s-%s but got %s %s-%s",
+				i, expected[i].kind, expected[i].start, expected[i].end,
+				event.Kind, event.Start.Format("15:04"), event.End.Format("15:04"))
+		}
+	}
+}
+
+func TestApplyTimeline_Conflicts(t *testing.T) {
+	departure := time.Date(2025, 9, 18, 9, 0, 0, 0, time.UTC)
+
+	plan := newTestTimelinePlan()
+	plan.Journey.Legs[1].StartTime = departure.Add(3 * time.Hour)
+	plan.Journey.Legs[1].EndTime = departure.Add(2 * time.Hour)
+
+	original := plan.Journey.Legs
+
+	applyTimeline(plan, departure)
+
+	if len(plan.Warnings) != 1 {
+		t.Fatalf("Expected 1 conflict but got %+v", plan.Warnings)
+	}
+
+	if plan.Warnings[0].Code != validationCodeTimelineConflict ||
+		plan.Warnings[0].Field != "endTime" {
+		t.Errorf("Expected an end time conflict but got %+v", plan.Warnings[0])
+	}
+
+	if got := plan.Journey.Legs[0].StartTime.Format("15:04"); got != "09:02" {
+		t.Errorf("Expected leg 1 start time to be filled with 09:02 but got %s", got)
+	}
+
+	if !plan.Journey.Legs[1].StartTime.Equal(departure.Add(3 * time.Hour)) {
+		t.Error("Expected user-supplied start time to be kept")
+	}
+
+	if !original[0].StartTime.IsZero() {
+		t.Error("Expected the request legs to be left untouched")
+	}
+}
+
+func TestWriteICalendar(t *testing.T) {
+	departure := time.Date(2025, 9, 18, 9, 0, 0, 0, time.UTC)
+
+	plan := newTestTimelinePlan()
+	applyTimeline(plan, departure)
+
+	var builder strings.Builder
+	if err := writeICalendar(&builder, plan, departure); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	calendar := builder.String()
+
+	if strings.Count(calendar, "BEGIN:VEVENT") != len(plan.Timeline) {
+		t.Errorf("Expected %d events in calendar", len(plan.Timeline))
+	}
+
+	for _, expected := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"DTSTART:20250918T091000Z\r\n",
+		"SUMMARY:Pause after leg 1\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(calendar, expected) {
+			t.Errorf("Expected calendar to contain %q", expected)
+		}
+	}
+
+	for _, line := range strings.Split(calendar, "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("Line exceeds 75 octets: %q", line)
+		}
+	}
+}
+
+func TestScheduleLegWaits(t *testing.T) {
+	departure := time.Date(2025, 9, 18, 9, 0, 0, 0, time.UTC)
+
+	plan := newTestTimelinePlan()
+	plan.Journey.Legs[0].StartTime = departure.Add(30 * time.Minute)
+	plan.Journey.Legs[1].StartTime = departure.Add(3 * time.Hour)
+
+	scheduleLegWaits(&plan.route, plan.Journey, departure)
+
+	// Leg 1 could start at 09:02, and leg 2 at 11:38 once leg 1 waits.
+	waits := []float64{plan.route.Legs[0].WaitMinutes, plan.route.Legs[1].WaitMinutes}
+	if waits[0] != 28 || waits[1] != 22 {
+		t.Fatalf("Expected waits of 28 and 22 minutes but got %v", waits)
+	}
+
+	applyTimeline(plan, departure)
+
+	expected := []struct {
+		kind  timelineEventKind
+		start string
+		end   string
+	}{
+		{timelineEventWait, "09:00", "09:28"},
+		{timelineEventWalk, "09:28", "09:30"},
+		{timelineEventUnlock, "09:30", "09:30"},
+		{timelineEventDrive, "09:30", "09:38"},
+		{timelineEventPause, "09:38", "11:38"},
+		{timelineEventWait, "11:38", "12:00"},
+		{timelineEventDrive, "12:00", "12:05"},
+		{timelineEventLock, "12:05", "12:05"},
+	}
+
+	if len(plan.Timeline) != len(expected) {
+		t.Fatalf("Expected %d events but got %d: %+v", len(expected), len(plan.Timeline), plan.Timeline)
+	}
+
+	for i, event := range plan.Timeline {
+		if event.Kind != expected[i].kind ||
+			event.Start.Format("15:04") != expected[i].start ||
+			event.End.Format("15:04") != expected[i].end {
+			t.Errorf("Event %d: expected %s %s-%s but got %s %s-%s",
+				i, expected[i].kind, expected[i].start, expected[i].end,
+				event.Kind, event.Start.Format("15:04"), event.End.Format("15:04"))
+		}
+	}
+
+	if len(plan.Warnings) != 0 {
+		t.Errorf("Expected the later start times to be honoured but got %+v", plan.Warnings)
+	}
+}
+
+func TestCalculateCostForPricingPlan_PricesWaits(t *testing.T) {
+	plan := newTestTimelinePlan()
+	pricing := PricingModel{Type: pricingPlanPerMinute, PauseUnitPrice: 100, DayCapPrice: 1000000}
+
+	tests := []struct {
+		name             string
+		waits            []float64
+		endInParkingZone bool
+		expected         float64
+	}{
+		{name: "No wait", waits: []float64{0, 0}, endInParkingZone: true, expected: 12},
+		{name: "Wait before setting off is free", waits: []float64{30, 0}, endInParkingZone: true, expected: 12},
+		{name: "Wait parked in a zone", waits: []float64{0, 20}, endInParkingZone: true, expected: 14},
+		{name: "Wait parked outside a zone", waits: []float64{0, 20}, endInParkingZone: false, expected: 21},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := plan.route
+			route.Legs = []legRoute{
+				{DrivingMinutes: 8, WaitMinutes: tt.waits[0], EndInParkingZone: tt.endInParkingZone},
+				{DrivingMinutes: 5, WaitMinutes: tt.waits[1], EndInParkingZone: true},
+			}
+
+			priced := calculateCostForPricingPlan(plan.Journey, route, plan.Vehicle, pricing, pricingPlanPerMinute)
+			if math.Abs(priced.CostBreakdown.PauseCost-tt.expected) > 1e-9 {
+				t.Errorf("Expected a pause cost of %.2f but got %.2f", tt.expected, priced.CostBreakdown.PauseCost)
+			}
+		})
+	}
+}
+
+func TestCalculateCostForPricingPlan_LegCosts(t *testing.T) {
+	plan := newTestTimelinePlan()
+	pricing := PricingModel{Type: pricingPlanPerKilometer, KilometerPrice: 1000, IncludedKilometers: 5, DayCapPrice: 1000000}
+
+	route := plan.route
+	route.Legs = []legRoute{
+		{DrivingMinutes: 8, DistanceKm: 4, EndInParkingZone: true},
+		{DrivingMinutes: 5, DistanceKm: 3, WaitMinutes: 20, EndInParkingZone: true},
+	}
+
+	priced := calculateCostForPricingPlan(plan.Journey, route, plan.Vehicle, pricing, pricingPlanPerKilometer)
+
+	legs := priced.CostBreakdown.Legs
+	if len(legs) != 2 {
+		t.Fatalf("Expected a cost per leg but got %+v", legs)
+	}
+
+	if legs[0].TravelCost != 0 || math.Abs(legs[1].TravelCost-2) > 1e-9 {
+		t.Errorf("Expected the included kilometers to be used up by the first leg but got %+v", legs)
+	}
+
+	if math.Abs(legs[0].TravelCost+legs[1].TravelCost-priced.CostBreakdown.TravelCost) > 1e-9 ||
+		math.Abs(legs[0].PauseCost+legs[1].PauseCost-priced.CostBreakdown.PauseCost) > 1e-9 {
+		t.Errorf("Expected the leg costs to add up to the breakdown %+v", priced.CostBreakdown)
+	}
+}
+
+func TestWriteICalendar_UIDs(t *testing.T) {
+	departure := time.Date(2025, 9, 18, 9, 0, 0, 0, time.UTC)
+
+	render := func(plan *JourneyPlan) string {
+		var builder strings.Builder
+		_ = writeICalendar(&builder, plan, departure)
+
+		return builder.String()
+	}
+
+	quoted := newTestTimelinePlan()
+	quoted.QuoteID = "0123456789abcdef01234567"
+	applyTimeline(quoted, departure)
+
+	if !strings.Contains(render(quoted), "UID:0123456789abcdef01234567-0@poppy-journey-planner\r\n") {
+		t.Error("Expected the UIDs to use the quote ID")
+	}
+
+	first := newTestTimelinePlan()
+	applyTimeline(first, departure)
+
+	other := newTestTimelinePlan()
+	other.Vehicle.Plate = "1-XYZ-999"
+	applyTimeline(other, departure)
+
+	uid := func(calendar string) string {
+		for _, line := range strings.Split(calendar, "\r\n") {
+			if strings.HasPrefix(line, "UID:") {
+				return line
+			}
+		}
+
+		return ""
+	}
+
+	if uid(render(first)) != uid(render(first)) {
+		t.Error("Expected the same plan to keep its UIDs")
+	}
+
+	if uid(render(first)) == uid(render(other)) {
+		t.Error("Expected plans starting at the same time to get different UIDs")
+	}
+}
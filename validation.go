@@ -0,0 +1,199 @@
+//nolint:package-comments,revive,mnd,exhaustruct
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type validationCode string
+
+const (
+	validationCodeNoLegs                    validationCode = "journey_no_legs"
+	validationCodeMissingLocation           validationCode = "missing_location"
+	validationCodeInvalidCoordinates        validationCode = "invalid_coordinates"
+	validationCodeNegativePause             validationCode = "negative_pause"
+	validationCodeEndOutsideParkingZone     validationCode = "end_outside_parking_zone"
+	validationCodeStartOutsideOperatingArea validationCode = "start_outside_operating_area"
+	validationCodeEndOutsideOperatingArea   validationCode = "end_outside_operating_area"
+	validationCodeEndInForbiddenArea        validationCode = "end_in_forbidden_area"
+	validationCodeVehiclesUnavailable       validationCode = "vehicles_unavailable"
+	validationCodeNoVehicle                 validationCode = "no_vehicle_available"
+	validationCodePricingUnavailable        validationCode = "pricing_unavailable"
+	validationCodeGeozonesUnavailable       validationCode = "geozones_unavailable"
+	validationCodeAddressNotFound           validationCode = "address_not_found"
+	validationCodeTimelineConflict          validationCode = "timeline_conflict"
+	validationCodePlanningFailed            validationCode = "planning_failed"
+	validationCodeNoStops                   validationCode = "no_stops"
+	validationCodeTooManyStops              validationCode = "too_many_stops"
+	validationCodeTooManyLegs               validationCode = "too_many_legs"
+	validationCodeInvalidField              validationCode = "invalid_field"
+	validationCodeBatchEmpty                validationCode = "batch_empty"
+	validationCodeBatchTooLarge             validationCode = "batch_too_large"
+)
+
+// maxJourneyLegs bounds the routing work of a single plan. It leaves room
+// for the longest journeys the stop optimizer builds.
+const maxJourneyLegs = 30
+
+type ValidationIssue struct {
+	Code     validationCode `json:"code"`
+	LegIndex *int           `json:"legIndex,omitempty"`
+	Field    string         `json:"field,omitempty"`
+	Message  string         `json:"message"`
+	Remedy   string         `json:"remedy,omitempty"`
+}
+
+// Location describes where the issue was found, e.g. "Leg 2 · endLocation".
+func (i ValidationIssue) Location() string {
+	var parts []string
+
+	if i.LegIndex != nil {
+		parts = append(parts, fmt.Sprintf("Leg %d", *i.LegIndex+1))
+	}
+
+	if i.Field != "" {
+		parts = append(parts, i.Field)
+	}
+
+	return strings.Join(parts, " · ")
+}
+
+// ValidationError collects every problem found while planning a journey.
+// The underlying cause, if any, is kept for callers that need to tell
+// upstream failures apart from invalid input.
+type ValidationError struct {
+	Issues []ValidationIssue
+	cause  error
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Issues))
+
+	for _, issue := range e.Issues {
+		if location := issue.Location(); location != "" {
+			messages = append(messages, location+": "+issue.Message)
+
+			continue
+		}
+
+		messages = append(messages, issue.Message)
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.cause
+}
+
+func (e *ValidationError) add(issue ValidationIssue) {
+	e.Issues = append(e.Issues, issue)
+}
+
+func newValidationError(cause error, issue ValidationIssue) *ValidationError {
+	return &ValidationError{
+		Issues: []ValidationIssue{issue},
+		cause:  cause,
+	}
+}
+
+// validationIssues returns the issues carried by err, or a single generic
+// issue when err is not a validation error.
+func validationIssues(err error) []ValidationIssue {
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return validationErr.Issues
+	}
+
+	return []ValidationIssue{{
+		Code:    validationCodePlanningFailed,
+		Message: err.Error(),
+	}}
+}
+
+func legIndex(index int) *int {
+	return &index
+}
+
+func validateJourney(journey Journey) *ValidationError {
+	result := &ValidationError{}
+
+	if len(journey.Legs) == 0 {
+		result.add(ValidationIssue{
+			Code:    validationCodeNoLegs,
+			Field:   "legs",
+			Message: "Journey has no legs",
+			Remedy:  "Add at least one leg with a start and end location",
+		})
+	}
+
+	if len(journey.Legs) > maxJourneyLegs {
+		result.add(ValidationIssue{
+			Code:  validationCodeTooManyLegs,
+			Field: "legs",
+			Message: fmt.Sprintf(
+				"Journey has %d legs, the maximum is %d",
+				len(journey.Legs),
+				maxJourneyLegs,
+			),
+			Remedy: "Split the journey into several trips",
+		})
+	}
+
+	for i, leg := range journey.Legs {
+		validateLocation(result, i, "startLocation", leg.StartLocation)
+		validateLocation(result, i, "endLocation", leg.EndLocation)
+
+		if leg.PauseMinutes < 0 {
+			result.add(ValidationIssue{
+				Code:     validationCodeNegativePause,
+				LegIndex: legIndex(i),
+				Field:    "pauseMinutes",
+				Message:  fmt.Sprintf("Pause of %d minutes is negative", leg.PauseMinutes),
+				Remedy:   "Use 0 for no pause",
+			})
+		}
+	}
+
+	if len(result.Issues) == 0 {
+		return nil
+	}
+
+	return result
+}
+
+func validateLocation(
+	result *ValidationError,
+	index int,
+	field string,
+	location Location,
+) {
+	if location.Lat == 0 && location.Lng == 0 {
+		result.add(ValidationIssue{
+			Code:     validationCodeMissingLocation,
+			LegIndex: legIndex(index),
+			Field:    field,
+			Message:  "Location is missing",
+			Remedy:   "Enter an address or both a latitude and a longitude",
+		})
+
+		return
+	}
+
+	if location.Lat < -90 || location.Lat > 90 ||
+		location.Lng < -180 || location.Lng > 180 {
+		result.add(ValidationIssue{
+			Code:     validationCodeInvalidCoordinates,
+			LegIndex: legIndex(index),
+			Field:    field,
+			Message: fmt.Sprintf(
+				"Coordinates (%.5f, %.5f) are out of range",
+				location.Lat,
+				location.Lng,
+			),
+			Remedy: "Latitude must be within ±90 and longitude within ±180",
+		})
+	}
+}
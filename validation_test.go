@@ -0,0 +1,113 @@
+//nolint:package-comments,revive,mnd,exhaustruct
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateJourney(t *testing.T) {
+	tests := []struct {
+		name          string
+		journey       Journey
+		expectedCodes []validationCode
+	}{
+		{
+			name: "Valid journey",
+			journey: Journey{
+				Legs: []TripLeg{
+					{
+						StartLocation: Location{Lat: 50.8355, Lng: 4.3573},
+						EndLocation:   Location{Lat: 50.8245, Lng: 4.3635},
+						PauseMinutes:  30,
+					},
+				},
+			},
+			expectedCodes: nil,
+		},
+		{
+			name:          "No legs",
+			journey:       Journey{},
+			expectedCodes: []validationCode{validationCodeNoLegs},
+		},
+		{
+			name: "Every problem is reported",
+			journey: Journey{
+				Legs: []TripLeg{
+					{
+						StartLocation: Location{Lat: 50.8355, Lng: 4.3573},
+						EndLocation:   Location{Lat: 95, Lng: 4.3635},
+					},
+					{
+						StartLocation: Location{},
+						EndLocation:   Location{Lat: 50.8275, Lng: 4.3745},
+						PauseMinutes:  -5,
+					},
+				},
+			},
+			expectedCodes: []validationCode{
+				validationCodeInvalidCoordinates,
+				validationCodeMissingLocation,
+				validationCodeNegativePause,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := validateJourney(test.journey)
+
+			if test.expectedCodes == nil {
+				if result != nil {
+					t.Errorf("Expected no issues but got %v", result)
+				}
+
+				return
+			}
+
+			if result == nil {
+				t.Fatalf("Expected issues %v but got none", test.expectedCodes)
+			}
+
+			if len(result.Issues) != len(test.expectedCodes) {
+				t.Fatalf("Expected %d issues but got %d: %v",
+					len(test.expectedCodes), len(result.Issues), result)
+			}
+
+			for i, issue := range result.Issues {
+				if issue.Code != test.expectedCodes[i] {
+					t.Errorf("Expected issue %d to be %s but got %s",
+						i, test.expectedCodes[i], issue.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestValidationIssues(t *testing.T) {
+	cause := errors.New("connection refused")
+	err := newValidationError(cause, ValidationIssue{
+		Code:     validationCodeEndOutsideParkingZone,
+		LegIndex: legIndex(1),
+		Field:    "endLocation",
+		Message:  "Destination is outside every parking zone",
+	})
+
+	if !errors.Is(err, cause) {
+		t.Error("Expected validation error to wrap its cause")
+	}
+
+	if err.Error() != "Leg 2 · endLocation: Destination is outside every parking zone" {
+		t.Errorf("Unexpected error message %q", err.Error())
+	}
+
+	issues := validationIssues(err)
+	if len(issues) != 1 || issues[0].Code != validationCodeEndOutsideParkingZone {
+		t.Errorf("Expected the wrapped issue but got %v", issues)
+	}
+
+	issues = validationIssues(errors.New("boom"))
+	if len(issues) != 1 || issues[0].Code != validationCodePlanningFailed {
+		t.Errorf("Expected a generic issue but got %v", issues)
+	}
+}
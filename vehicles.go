@@ -0,0 +1,510 @@
+//nolint:package-comments,revive,mnd,exhaustruct,errchkjson
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+const (
+	maxVehicleSearchLimit  = 500
+	maxVehicleSearchRadius = 20000
+	csvContentType         = "text/csv"
+	geoJSONContentType     = "application/geo+json"
+)
+
+type vehicleSort string
+
+const (
+	vehicleSortNone     vehicleSort = ""
+	vehicleSortDistance vehicleSort = "distance"
+	vehicleSortAutonomy vehicleSort = "autonomy"
+	vehicleSortTier     vehicleSort = "tier"
+)
+
+// tierRanks orders the vehicle tiers by size. Unknown tiers sort last.
+var tierRanks = map[string]int{"XS": 1, "S": 2, "M": 3, "L": 4, "XL": 5}
+
+func tierRank(tier string) int {
+	if rank, ok := tierRanks[strings.ToUpper(tier)]; ok {
+		return rank
+	}
+
+	return len(tierRanks) + 1
+}
+
+// VehicleResult is a vehicle found by a search. The distance fields are
+// only set when the search has a query point.
+type VehicleResult struct {
+	Vehicle
+	DistanceMeters *float64 `json:"distanceMeters,omitempty"`
+	WalkingMinutes *float64 `json:"walkingMinutes,omitempty"`
+}
+
+// vehicleSearch is a parsed vehicle search query. A zero limit returns
+// every result from offset on.
+type vehicleSearch struct {
+	near            *Location
+	radiusMeters    float64
+	sort            vehicleSort
+	limit           int
+	offset          int
+	walkingSpeedKmh float64
+}
+
+func invalidSearchParameter(field, message, remedy string) ValidationIssue {
+	return ValidationIssue{
+		Code:    validationCodeInvalidField,
+		Field:   field,
+		Message: message,
+		Remedy:  remedy,
+	}
+}
+
+// parseRadius reads a radius in meters, such as "800" or "800m", or in
+// walking minutes, such as "10min".
+func parseRadius(value string, walkingSpeedKmh float64) (float64, bool) {
+	if minutes, ok := strings.CutSuffix(value, "min"); ok {
+		parsed, err := strconv.ParseFloat(minutes, 64)
+
+		return walkingMinutesToMeters(parsed, walkingSpeedKmh), err == nil && parsed > 0
+	}
+
+	parsed, err := strconv.ParseFloat(strings.TrimSuffix(value, "m"), 64)
+
+	return parsed, err == nil && parsed > 0
+}
+
+func parseVehicleSearch(query url.Values, walkingSpeedKmh float64) (vehicleSearch, *ValidationError) {
+	search := vehicleSearch{sort: vehicleSort(query.Get("sort")), walkingSpeedKmh: walkingSpeedKmh}
+	result := &ValidationError{}
+
+	if near := query.Get("near"); near != "" {
+		lat, lng, _ := strings.Cut(near, ",")
+
+		location, err := parseCoordinates(lat, lng)
+		if err != nil {
+			result.add(ValidationIssue{
+				Code:    validationCodeInvalidCoordinates,
+				Field:   "near",
+				Message: fmt.Sprintf("%q is not a lat,lng pair", near),
+				Remedy:  "Use near=50.8466,4.3528",
+			})
+		} else {
+			search.near = &location
+		}
+	}
+
+	if radius := query.Get("radius"); radius != "" {
+		meters, ok := parseRadius(radius, walkingSpeedKmh)
+
+		switch {
+		case !ok || meters > maxVehicleSearchRadius:
+			result.add(invalidSearchParameter(
+				"radius",
+				fmt.Sprintf("%q is not a valid radius", radius),
+				fmt.Sprintf("Give up to %d meters, e.g. radius=800, or walking minutes, e.g. radius=10min", maxVehicleSearchRadius),
+			))
+		case query.Get("near") == "":
+			result.add(invalidSearchParameter("radius", "A radius needs a query point", "Add near=lat,lng"))
+		default:
+			search.radiusMeters = meters
+		}
+	}
+
+	switch search.sort {
+	case vehicleSortNone, vehicleSortAutonomy, vehicleSortTier:
+	case vehicleSortDistance:
+		if query.Get("near") == "" {
+			result.add(invalidSearchParameter("sort", "Sorting by distance needs a query point", "Add near=lat,lng"))
+		}
+	default:
+		result.add(invalidSearchParameter(
+			"sort",
+			fmt.Sprintf("Unknown sort %q", search.sort),
+			"Sort by distance, autonomy or tier",
+		))
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed < 1 || parsed > maxVehicleSearchLimit {
+			result.add(invalidSearchParameter(
+				"limit",
+				fmt.Sprintf("%q is not a valid limit", limit),
+				fmt.Sprintf("Give a limit between 1 and %d", maxVehicleSearchLimit),
+			))
+		}
+
+		search.limit = parsed
+	}
+
+	if offset := query.Get("offset"); offset != "" {
+		parsed, err := strconv.Atoi(offset)
+		if err != nil || parsed < 0 {
+			result.add(invalidSearchParameter(
+				"offset",
+				fmt.Sprintf("%q is not a valid offset", offset),
+				"Give an offset of 0 or more",
+			))
+		}
+
+		search.offset = parsed
+	}
+
+	if len(result.Issues) > 0 {
+		return search, result
+	}
+
+	// Results near a point come closest first unless asked otherwise.
+	if search.sort == vehicleSortNone && search.near != nil {
+		search.sort = vehicleSortDistance
+	}
+
+	return search, nil
+}
+
+func parseCoordinates(lat, lng string) (Location, error) {
+	parsedLat, err := strconv.ParseFloat(strings.TrimSpace(lat), 64)
+	if err != nil {
+		return Location{}, fmt.Errorf("[parseCoordinates] invalid latitude: %w", err)
+	}
+
+	parsedLng, err := strconv.ParseFloat(strings.TrimSpace(lng), 64)
+	if err != nil {
+		return Location{}, fmt.Errorf("[parseCoordinates] invalid longitude: %w", err)
+	}
+
+	if parsedLat < -90 || parsedLat > 90 || parsedLng < -180 || parsedLng > 180 {
+		return Location{}, fmt.Errorf("[parseCoordinates] (%f, %f) is out of range", parsedLat, parsedLng)
+	}
+
+	return Location{Lat: parsedLat, Lng: parsedLng}, nil
+}
+
+// searchVehicles filters, sorts and pages vehicles. It also returns the
+// number of results before paging.
+func searchVehicles(vehicles []Vehicle, search vehicleSearch) ([]VehicleResult, int) {
+	results := make([]VehicleResult, 0, len(vehicles))
+
+	for _, vehicle := range vehicles {
+		result := VehicleResult{Vehicle: vehicle}
+
+		if search.near != nil {
+			distance := calculateDistance(
+				search.near.Lat,
+				search.near.Lng,
+				vehicle.LocationLatitude,
+				vehicle.LocationLongitude,
+			) * 1000
+			if search.radiusMeters > 0 && distance > search.radiusMeters {
+				continue
+			}
+
+			walkingMinutes := distance / 1000 / search.walkingSpeedKmh * 60
+			result.DistanceMeters = &distance
+			result.WalkingMinutes = &walkingMinutes
+		}
+
+		results = append(results, result)
+	}
+
+	distance := func(result VehicleResult) float64 {
+		if result.DistanceMeters == nil {
+			return 0
+		}
+
+		return *result.DistanceMeters
+	}
+
+	switch search.sort {
+	case vehicleSortDistance:
+		slices.SortStableFunc(results, func(a, b VehicleResult) int {
+			return cmp.Compare(distance(a), distance(b))
+		})
+	case vehicleSortAutonomy:
+		slices.SortStableFunc(results, func(a, b VehicleResult) int {
+			return cmp.Or(
+				cmp.Compare(b.AutonomyPercentage, a.AutonomyPercentage),
+				cmp.Compare(distance(a), distance(b)),
+			)
+		})
+	case vehicleSortTier:
+		slices.SortStableFunc(results, func(a, b VehicleResult) int {
+			return cmp.Or(
+				cmp.Compare(tierRank(a.Model.Tier), tierRank(b.Model.Tier)),
+				strings.Compare(a.Model.Tier, b.Model.Tier),
+				cmp.Compare(distance(a), distance(b)),
+			)
+		})
+	case vehicleSortNone:
+	}
+
+	total := len(results)
+	results = results[min(search.offset, total):]
+
+	if search.limit > 0 && search.limit < len(results) {
+		results = results[:search.limit]
+	}
+
+	return results, total
+}
+
+// vehicleFormat picks the response format: the format query parameter
+// wins over the Accept header, of which the type with the highest quality
+// wins. JSON is the default and wins ties.
+func vehicleFormat(r *http.Request) string {
+	switch format := r.URL.Query().Get("format"); format {
+	case "geojson":
+		return geoJSONContentType
+	case "csv":
+		return csvContentType
+	case "json":
+		return "application/json"
+	}
+
+	accept := r.Header.Get("Accept")
+	format, quality := "application/json", acceptQuality(accept, "application/json")
+
+	for _, mediaType := range []string{geoJSONContentType, csvContentType} {
+		if q := acceptQuality(accept, mediaType); q > quality {
+			format, quality = mediaType, q
+		}
+	}
+
+	return format
+}
+
+func vehicleFeatureCollection(results []VehicleResult) *geojson.FeatureCollection {
+	collection := geojson.NewFeatureCollection()
+
+	for _, result := range results {
+		feature := geojson.NewFeature(orb.Point{result.LocationLongitude, result.LocationLatitude})
+		feature.ID = result.UUID
+		feature.Properties["uuid"] = result.UUID
+		feature.Properties["plate"] = result.Plate
+		feature.Properties["make"] = result.Model.Make
+		feature.Properties["model"] = result.Model.Name
+		feature.Properties["modelType"] = result.Model.Type
+		feature.Properties["energy"] = result.Model.Energy
+		feature.Properties["tier"] = result.Model.Tier
+		feature.Properties["autonomyPercentage"] = result.AutonomyPercentage
+
+		if result.DistanceMeters != nil {
+			feature.Properties["distanceMeters"] = *result.DistanceMeters
+			feature.Properties["walkingMinutes"] = *result.WalkingMinutes
+		}
+
+		collection.Append(feature)
+	}
+
+	return collection
+}
+
+var vehicleCSVHeader = []string{
+	"uuid", "plate", "make", "model", "modelType", "energy", "tier",
+	"lat", "lng", "autonomy", "autonomyPercentage", "distanceMeters", "walkingMinutes",
+}
+
+// writeVehicleCSV writes one row per vehicle. The text columns come from
+// upstream and are escaped like those of plan exports.
+func writeVehicleCSV(w http.ResponseWriter, results []VehicleResult) {
+	writer := csv.NewWriter(w)
+	_ = writer.Write(vehicleCSVHeader)
+
+	for _, result := range results {
+		distance, walking := "", ""
+		if result.DistanceMeters != nil {
+			distance = strconv.FormatFloat(*result.DistanceMeters, 'f', 0, 64)
+			walking = strconv.FormatFloat(*result.WalkingMinutes, 'f', 1, 64)
+		}
+
+		_ = writer.Write([]string{
+			csvText(result.UUID),
+			csvText(result.Plate),
+			csvText(result.Model.Make),
+			csvText(result.Model.Name),
+			csvText(string(result.Model.Type)),
+			csvText(result.Model.Energy),
+			csvText(result.Model.Tier),
+			formatFloat(result.LocationLatitude),
+			formatFloat(result.LocationLongitude),
+			formatFloat(result.Autonomy),
+			formatFloat(result.AutonomyPercentage),
+			distance,
+			walking,
+		})
+	}
+
+	writer.Flush()
+}
+
+// respondVehicles writes search results in the negotiated format. The
+// total before paging goes in the X-Total-Count header.
+func respondVehicles(w http.ResponseWriter, r *http.Request, results []VehicleResult, total int) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	w.Header().Add("Vary", "Accept")
+
+	switch vehicleFormat(r) {
+	case geoJSONContentType:
+		w.Header().Set("Content-Type", geoJSONContentType)
+		w.WriteHeader(http.StatusOK)
+
+		_ = json.NewEncoder(w).Encode(vehicleFeatureCollection(results))
+	case csvContentType:
+		w.Header().Set("Content-Type", csvContentType+"; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+
+		writeVehicleCSV(w, results)
+	default:
+		respondJSON(w, http.StatusOK, APIResponse{
+			Success: true,
+			Data:    results,
+		})
+	}
+}
+
+// GeozoneSummary counts the zones that apply to a vehicle model type.
+type GeozoneSummary struct {
+	ModelType string         `json:"modelType"`
+	Zones     int            `json:"zones"`
+	ByType    map[string]int `json:"byType"`
+}
+
+// VehicleDetail is a vehicle with the tariffs and zones that apply to it.
+// The zone fields are left out, with a warning, when Poppy's geozones
+// could not be fetched.
+type VehicleDetail struct {
+	Vehicle        Vehicle           `json:"vehicle"`
+	Pricing        Tariff            `json:"pricing"`
+	InParkingZone  *bool             `json:"inParkingZone,omitempty"`
+	ZonesAt        []string          `json:"zonesAt,omitempty"`
+	GeozoneSummary *GeozoneSummary   `json:"geozoneSummary,omitempty"`
+	Warnings       []ValidationIssue `json:"warnings,omitempty"`
+}
+
+func summarizeGeozone(geozone *GeoZone, modelType vehicleModelType) GeozoneSummary {
+	summary := GeozoneSummary{ModelType: string(modelType), ByType: map[string]int{}}
+
+	if geozone == nil {
+		return summary
+	}
+
+	for _, item := range *geozone {
+		if item.ModelType != string(modelType) {
+			continue
+		}
+
+		summary.Zones++
+		summary.ByType[item.GeofencingType]++
+	}
+
+	return summary
+}
+
+func normalizePlate(plate string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(plate))
+}
+
+// findVehicle looks a vehicle up by UUID, or else by plate with or without
+// dashes.
+func findVehicle(vehicles []Vehicle, id string) (Vehicle, bool) {
+	for _, vehicle := range vehicles {
+		if vehicle.UUID == id {
+			return vehicle, true
+		}
+	}
+
+	plate := normalizePlate(id)
+
+	for _, vehicle := range vehicles {
+		if normalizePlate(vehicle.Plate) == plate {
+			return vehicle, true
+		}
+	}
+
+	return Vehicle{}, false
+}
+
+func vehicleDetailHandler(client *http.Client, cache *upstreamCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		respondUpstreamError := func(err error) {
+			respondJSON(w, http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+		}
+
+		vehicles, err := cache.fetchVehicles(ctx, client)
+		if err != nil {
+			respondUpstreamError(err)
+
+			return
+		}
+
+		vehicle, ok := findVehicle(vehicles, r.PathValue("uuid"))
+		if !ok {
+			respondJSON(w, http.StatusNotFound, APIResponse{
+				Success: false,
+				Error:   "Vehicle not found or not available",
+			})
+
+			return
+		}
+
+		pricing, err := cache.fetchPricing(ctx, client, vehicle.Model.Type, vehicle.Model.Tier)
+		if err != nil {
+			respondUpstreamError(err)
+
+			return
+		}
+
+		detail := VehicleDetail{
+			Vehicle: vehicle,
+			Pricing: newTariff(vehicle.Model.Type, vehicle.Model.Tier, pricing),
+		}
+
+		zones, err := cache.fetchZoneIndex(ctx, client, vehicle)
+		if err != nil {
+			slog.WarnContext(ctx, "vehicle detail without geozones",
+				"vehicle_uuid", vehicle.UUID,
+				"error", err,
+			)
+
+			detail.Warnings = append(detail.Warnings, ValidationIssue{
+				Code:    validationCodeGeozonesUnavailable,
+				Message: "Could not fetch parking zones from Poppy",
+				Remedy:  "Try again in a few moments",
+			})
+		} else {
+			location := Location{Lat: vehicle.LocationLatitude, Lng: vehicle.LocationLongitude}
+			inParkingZone := zones.inParkingZone(location)
+			summary := summarizeGeozone(zones.zone(), vehicle.Model.Type)
+
+			detail.InParkingZone = &inParkingZone
+			detail.ZonesAt = zones.zonesAt(location)
+			detail.GeozoneSummary = &summary
+		}
+
+		respondJSON(w, http.StatusOK, APIResponse{
+			Success: true,
+			Data:    detail,
+		})
+	}
+}
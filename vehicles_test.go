@@ -0,0 +1,382 @@
+//nolint:package-comments,revive,mnd,exhaustruct,errchkjson,err113
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func newTestSearchVehicles() []Vehicle {
+	return []Vehicle{
+		{
+			UUID: "far", Plate: "1-FAR-001", LocationLatitude: 50.87, LocationLongitude: 4.35,
+			Model: Model{Type: "car", Tier: "M"}, AutonomyPercentage: 90,
+		},
+		{
+			UUID: "near", Plate: "1-NEA-002", LocationLatitude: 50.851, LocationLongitude: 4.35,
+			Model: Model{Type: "car", Tier: "S"}, AutonomyPercentage: 30,
+		},
+		{
+			UUID: "middle", Plate: "1-MID-003", LocationLatitude: 50.855, LocationLongitude: 4.35,
+			Model: Model{Type: "car", Tier: "L"}, AutonomyPercentage: 60,
+		},
+	}
+}
+
+func TestParseVehicleSearch(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		radius   float64
+		sort     vehicleSort
+		invalid  []string
+		hasPoint bool
+	}{
+		{name: "Empty", query: ""},
+		{name: "Near defaults to distance", query: "near=50.85,4.35", sort: vehicleSortDistance, hasPoint: true},
+		{name: "Radius in meters", query: "near=50.85,4.35&radius=800m", radius: 800, sort: vehicleSortDistance, hasPoint: true},
+		{
+			name:     "Radius in walking minutes",
+			query:    "near=50.85,4.35&radius=6min&sort=autonomy",
+			radius:   500,
+			sort:     vehicleSortAutonomy,
+			hasPoint: true,
+		},
+		{name: "Bad point", query: "near=50.85", invalid: []string{"near"}},
+		{name: "Radius without point", query: "radius=500", invalid: []string{"radius"}},
+		{name: "Distance without point", query: "sort=distance", invalid: []string{"sort"}},
+		{name: "Unknown sort", query: "sort=price", invalid: []string{"sort"}},
+		{name: "Bad paging", query: "limit=0&offset=-1", invalid: []string{"limit", "offset"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _ := url.ParseQuery(tt.query)
+			search, err := parseVehicleSearch(query, defaultConfig().WalkingSpeedKmh)
+
+			var fields []string
+			if err != nil {
+				for _, issue := range err.Issues {
+					fields = append(fields, issue.Field)
+				}
+			}
+
+			if len(fields) != len(tt.invalid) {
+				t.Fatalf("Expected issues on %v but got %v", tt.invalid, fields)
+			}
+
+			for i := range fields {
+				if fields[i] != tt.invalid[i] {
+					t.Errorf("Expected issues on %v but got %v", tt.invalid, fields)
+				}
+			}
+
+			if err != nil {
+				return
+			}
+
+			if search.radiusMeters != tt.radius || search.sort != tt.sort || (search.near != nil) != tt.hasPoint {
+				t.Errorf("Expected radius %v, sort %q and point %t but got %+v", tt.radius, tt.sort, tt.hasPoint, search)
+			}
+		})
+	}
+}
+
+func TestSearchVehicles(t *testing.T) {
+	near := &Location{Lat: 50.85, Lng: 4.35}
+
+	tests := []struct {
+		name     string
+		search   vehicleSearch
+		expected []string
+		total    int
+	}{
+		{name: "Everything", search: vehicleSearch{}, expected: []string{"far", "near", "middle"}, total: 3},
+		{
+			name:     "By distance within radius",
+			search:   vehicleSearch{near: near, radiusMeters: 1000, sort: vehicleSortDistance},
+			expected: []string{"near", "middle"},
+			total:    2,
+		},
+		{
+			name:     "By autonomy",
+			search:   vehicleSearch{sort: vehicleSortAutonomy},
+			expected: []string{"far", "middle", "near"},
+			total:    3,
+		},
+		{
+			name:     "By tier",
+			search:   vehicleSearch{sort: vehicleSortTier},
+			expected: []string{"near", "far", "middle"},
+			total:    3,
+		},
+		{
+			name:     "Paged",
+			search:   vehicleSearch{near: near, sort: vehicleSortDistance, limit: 1, offset: 1},
+			expected: []string{"middle"},
+			total:    3,
+		},
+		{
+			name:     "Past the end",
+			search:   vehicleSearch{offset: 5},
+			expected: []string{},
+			total:    3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, total := searchVehicles(newTestSearchVehicles(), tt.search)
+
+			if total != tt.total {
+				t.Errorf("Expected a total of %d but got %d", tt.total, total)
+			}
+
+			if len(results) != len(tt.expected) {
+				t.Fatalf("Expected %v but got %d results", tt.expected, len(results))
+			}
+
+			for i, result := range results {
+				if result.UUID != tt.expected[i] {
+					t.Errorf("Expected result %d to be %s but got %s", i, tt.expected[i], result.UUID)
+				}
+
+				if (result.DistanceMeters != nil) != (tt.search.near != nil) {
+					t.Errorf("Expected a distance only with a query point but got %v", result.DistanceMeters)
+				}
+			}
+		})
+	}
+}
+
+func TestVehiclesHandler_Formats(t *testing.T) {
+	cache := newUpstreamCache()
+	_, _ = cache.vehicles.get(context.Background(), brusselsUUID, func(context.Context) ([]Vehicle, error) {
+		return newTestSearchVehicles(), nil
+	})
+
+	handler := withConfigContext(defaultConfig(), vehiclesHandler(http.DefaultClient, cache))
+
+	tests := []struct {
+		name        string
+		query       string
+		accept      string
+		status      int
+		contentType string
+	}{
+		{name: "JSON", query: "?near=50.85,4.35&limit=2", status: http.StatusOK, contentType: "application/json"},
+		{name: "GeoJSON by Accept", accept: geoJSONContentType, status: http.StatusOK, contentType: geoJSONContentType},
+		{name: "CSV by format", query: "?format=csv", accept: geoJSONContentType, status: http.StatusOK, contentType: "text/csv; charset=utf-8"},
+		{name: "CSV refused", accept: "text/csv;q=0", status: http.StatusOK, contentType: "application/json"},
+		{name: "CSV preferred", accept: "application/json;q=0.5, text/csv", status: http.StatusOK, contentType: "text/csv; charset=utf-8"},
+		{name: "Invalid", query: "?sort=price", status: http.StatusBadRequest, contentType: "application/json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/api/v1/vehicles"+tt.query, nil)
+			if tt.accept != "" {
+				request.Header.Set("Accept", tt.accept)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.status {
+				t.Fatalf("Expected status %d but got %d", tt.status, recorder.Code)
+			}
+
+			if contentType := recorder.Header().Get("Content-Type"); contentType != tt.contentType {
+				t.Errorf("Expected content type %s but got %s", tt.contentType, contentType)
+			}
+
+			if tt.status == http.StatusOK && recorder.Header().Get("X-Total-Count") != "3" {
+				t.Errorf("Expected a total count of 3 but got %q", recorder.Header().Get("X-Total-Count"))
+			}
+		})
+	}
+}
+
+func TestVehiclesHandler_Bodies(t *testing.T) {
+	cache := newUpstreamCache()
+	_, _ = cache.vehicles.get(context.Background(), brusselsUUID, func(context.Context) ([]Vehicle, error) {
+		return newTestSearchVehicles(), nil
+	})
+
+	handler := withConfigContext(defaultConfig(), vehiclesHandler(http.DefaultClient, cache))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/vehicles?near=50.85,4.35&limit=1", nil))
+
+	var response struct {
+		Data []VehicleResult `json:"data"`
+	}
+
+	_ = json.NewDecoder(recorder.Body).Decode(&response)
+
+	if len(response.Data) != 1 || response.Data[0].Plate != "1-NEA-002" || response.Data[0].DistanceMeters == nil {
+		t.Errorf("Expected the nearest vehicle with its distance but got %+v", response.Data)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/vehicles?format=geojson", nil))
+
+	var collection struct {
+		Type     string `json:"type"`
+		Features []struct {
+			Geometry struct {
+				Coordinates []float64 `json:"coordinates"`
+			} `json:"geometry"`
+		} `json:"features"`
+	}
+
+	_ = json.NewDecoder(recorder.Body).Decode(&collection)
+
+	if collection.Type != "FeatureCollection" || len(collection.Features) != 3 ||
+		collection.Features[0].Geometry.Coordinates[0] != 4.35 {
+		t.Errorf("Expected 3 lng,lat point features but got %+v", collection)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/vehicles?format=csv", nil))
+
+	rows, err := csv.NewReader(recorder.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("Expected valid CSV but got %v", err)
+	}
+
+	if len(rows) != 4 || rows[0][0] != "uuid" || rows[1][1] != "1-FAR-001" {
+		t.Errorf("Expected a header and 3 rows but got %v", rows)
+	}
+}
+
+func TestWriteVehicleCSV_EscapesFormulas(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	writeVehicleCSV(recorder, []VehicleResult{{Vehicle: Vehicle{
+		UUID:  "v1",
+		Plate: "=HYPERLINK(\"http://example.com\")",
+		Model: Model{Type: "car", Make: "@SUM(A1)", Name: "+1", Tier: "S"},
+	}}})
+
+	rows, err := csv.NewReader(recorder.Body).ReadAll()
+	if err != nil || len(rows) != 2 {
+		t.Fatalf("Expected a header and a row but got %v, %v", rows, err)
+	}
+
+	for _, column := range []int{1, 2, 3} {
+		if !strings.HasPrefix(rows[1][column], "'") {
+			t.Errorf("Expected column %s to be escaped but got %q", rows[0][column], rows[1][column])
+		}
+	}
+}
+
+func TestVehicleDetailHandler(t *testing.T) {
+	ctx := context.Background()
+	cache := newUpstreamCache()
+	_, _ = cache.vehicles.get(ctx, brusselsUUID, func(context.Context) ([]Vehicle, error) {
+		return newTestSearchVehicles(), nil
+	})
+	_, _ = cache.pricing.get(ctx, "car/S", func(context.Context) (*PricingResponse, error) {
+		return &PricingResponse{PricingPerMinute: PricingModel{Type: pricingPlanPerMinute, MinutePrice: 30}}, nil
+	})
+	_, _ = cache.geozones.get(ctx, "car", func(context.Context) (*zoneIndex, error) {
+		return newZoneIndex(newTestQuoteGeoZone()), nil
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/vehicles/{uuid}", vehicleDetailHandler(http.DefaultClient, cache))
+
+	tests := []struct {
+		name     string
+		id       string
+		expected int
+	}{
+		{name: "By UUID", id: "near", expected: http.StatusOK},
+		{name: "By plate", id: "1nea002", expected: http.StatusOK},
+		{name: "Unknown", id: "missing", expected: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/vehicles/"+tt.id, nil))
+
+			if recorder.Code != tt.expected {
+				t.Fatalf("Expected status %d but got %d", tt.expected, recorder.Code)
+			}
+
+			if tt.expected != http.StatusOK {
+				return
+			}
+
+			var response struct {
+				Data VehicleDetail `json:"data"`
+			}
+
+			_ = json.NewDecoder(recorder.Body).Decode(&response)
+
+			detail := response.Data
+			if detail.Vehicle.UUID != "near" || len(detail.Pricing.Plans) == 0 ||
+				detail.Pricing.Plans[0].PerMinute != toEuros(30) {
+				t.Errorf("Expected the vehicle with its tariffs in euros but got %+v", detail)
+			}
+
+			if detail.InParkingZone == nil || !*detail.InParkingZone ||
+				len(detail.ZonesAt) != 1 || detail.ZonesAt[0] != "parking" {
+				t.Errorf("Expected the vehicle to stand in the parking zone but got %+v", detail)
+			}
+
+			if detail.GeozoneSummary == nil || detail.GeozoneSummary.Zones != 1 ||
+				detail.GeozoneSummary.ByType["parking"] != 1 {
+				t.Errorf("Expected one parking zone in the summary but got %+v", detail.GeozoneSummary)
+			}
+		})
+	}
+}
+
+func TestVehicleDetailHandler_WithoutGeozones(t *testing.T) {
+	ctx := context.Background()
+	cache := newUpstreamCache()
+	_, _ = cache.vehicles.get(ctx, brusselsUUID, func(context.Context) ([]Vehicle, error) {
+		return newTestSearchVehicles(), nil
+	})
+	_, _ = cache.pricing.get(ctx, "car/S", func(context.Context) (*PricingResponse, error) {
+		return &PricingResponse{}, nil
+	})
+
+	client := &http.Client{Transport: roundTripFunc(func(*http.Request) (*http.Response, error) {
+		return nil, errors.New("offline")
+	})}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /api/v1/vehicles/{uuid}", withConfigContext(defaultConfig(), vehicleDetailHandler(client, cache)))
+
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/vehicles/near", nil))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 but got %d", recorder.Code)
+	}
+
+	var response struct {
+		Data VehicleDetail `json:"data"`
+	}
+
+	_ = json.NewDecoder(recorder.Body).Decode(&response)
+
+	detail := response.Data
+	if detail.InParkingZone != nil || detail.ZonesAt != nil || detail.GeozoneSummary != nil {
+		t.Errorf("Expected the zone fields to be left out but got %+v", detail)
+	}
+
+	if len(detail.Warnings) != 1 || detail.Warnings[0].Code != validationCodeGeozonesUnavailable {
+		t.Errorf("Expected a geozones_unavailable warning but got %+v", detail.Warnings)
+	}
+}
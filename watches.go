@@ -0,0 +1,769 @@
+//nolint:package-comments,revive,mnd,exhaustruct
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	watchPollInterval      = time.Minute
+	maxWatchRadiusMeters   = 5000
+	maxWatchWalkingMinutes = 60
+	webhookTimeout         = 5 * time.Second
+	webhookAttempts        = 3
+	// webhookTestTimeout bounds the single attempt of a test event, which
+	// holds the caller's request open.
+	webhookTestTimeout = 2 * time.Second
+	// webhookWorkers bounds the deliveries in flight, so a slow receiver
+	// delays its own watches only, and webhookQueueSize the deliveries
+	// waiting for a worker. A delivery that does not fit is retried on the
+	// next poll.
+	webhookWorkers          = 4
+	webhookQueueSize        = 256
+	webhookSignatureHeader  = "X-Poppy-Signature"
+	webhookTimestampHeader  = "X-Poppy-Timestamp"
+	webhookEventHeader      = "X-Poppy-Event"
+	webhookDeliveryHeader   = "X-Poppy-Delivery"
+	watchEventVehicleNearby = "vehicle.available"
+	watchEventTest          = "watch.test"
+)
+
+// VehicleFilter narrows a watch down to some vehicles. Empty fields match
+// every vehicle; text fields compare case-insensitively.
+type VehicleFilter struct {
+	Make                  string  `json:"make,omitempty"`
+	Model                 string  `json:"model,omitempty"`
+	Energy                string  `json:"energy,omitempty"`
+	Tier                  string  `json:"tier,omitempty"`
+	MinAutonomyPercentage float64 `json:"minAutonomyPercentage,omitempty"`
+}
+
+type WatchInput struct {
+	Location       Location      `json:"location"`
+	RadiusMeters   float64       `json:"radiusMeters"`
+	WalkingMinutes float64       `json:"walkingMinutes"`
+	Filter         VehicleFilter `json:"filter"`
+	WebhookURL     string        `json:"webhookUrl"`
+	Secret         string        `json:"secret"`
+}
+
+// Watch fires a webhook whenever a matching vehicle appears near its
+// location. The vehicles already notified are kept in Matching, so a
+// vehicle fires again only after it has left and come back.
+type Watch struct {
+	ID                string        `json:"id"`
+	Location          Location      `json:"location"`
+	RadiusMeters      float64       `json:"radiusMeters"`
+	WalkingMinutes    float64       `json:"walkingMinutes,omitempty"`
+	Filter            VehicleFilter `json:"filter"`
+	WebhookURL        string        `json:"webhookUrl"`
+	Secret            string        `json:"secret,omitempty"`
+	CreatedAt         time.Time     `json:"createdAt"`
+	Owner             string        `json:"owner,omitempty"`
+	Matching          []string      `json:"matching"`
+	LastNotifiedAt    *time.Time    `json:"lastNotifiedAt,omitempty"`
+	LastDeliveryError string        `json:"lastDeliveryError,omitempty"`
+}
+
+// WatchNotification is the webhook body.
+type WatchNotification struct {
+	Event          string    `json:"event"`
+	WatchID        string    `json:"watchId"`
+	Vehicle        *Vehicle  `json:"vehicle,omitempty"`
+	DistanceMeters float64   `json:"distanceMeters,omitempty"`
+	WalkingMinutes float64   `json:"walkingMinutes,omitempty"`
+	OccurredAt     time.Time `json:"occurredAt"`
+}
+
+type watchStore = jsonStore[Watch]
+
+// withoutSecret is how a watch is shown after creation: the secret is
+// only returned once.
+func (w Watch) withoutSecret() Watch {
+	w.Secret = ""
+
+	return w
+}
+
+// matches reports whether vehicle is inside the watch radius and passes
+// its filter, with its crow-flies distance in meters.
+func (w Watch) matches(vehicle Vehicle) (bool, float64) {
+	distance := calculateDistance(
+		w.Location.Lat,
+		w.Location.Lng,
+		vehicle.LocationLatitude,
+		vehicle.LocationLongitude,
+	) * 1000
+
+	if distance > w.RadiusMeters {
+		return false, distance
+	}
+
+	filter := w.Filter
+
+	return matchesText(filter.Make, vehicle.Model.Make) &&
+		matchesText(filter.Model, vehicle.Model.Name) &&
+		matchesText(filter.Energy, vehicle.Model.Energy) &&
+		matchesText(filter.Tier, vehicle.Model.Tier) &&
+		vehicle.AutonomyPercentage >= filter.MinAutonomyPercentage, distance
+}
+
+func matchesText(filter, value string) bool {
+	return filter == "" || strings.EqualFold(filter, value)
+}
+
+func walkingMinutesToMeters(minutes, walkingSpeedKmh float64) float64 {
+	return minutes / 60 * walkingSpeedKmh * 1000
+}
+
+func newWebhookSecret() string {
+	buffer := make([]byte, 32)
+	_, _ = rand.Read(buffer)
+
+	return hex.EncodeToString(buffer)
+}
+
+// signWebhook signs the timestamp and body together, so a captured
+// delivery cannot be replayed later with a fresh timestamp.
+func signWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+var errWebhookAddressNotAllowed = errors.New("webhook address not allowed")
+
+// sharedAddressSpace is the carrier-grade NAT range, private in practice
+// but not covered by netip.Addr.IsPrivate.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// isPublicAddr reports whether a webhook may be sent to addr. Loopback,
+// private, link-local (including cloud metadata endpoints) and other
+// special addresses are refused, so a watch cannot reach internal
+// services.
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	return addr.IsValid() && addr.IsGlobalUnicast() && !addr.IsPrivate() &&
+		!sharedAddressSpace.Contains(addr)
+}
+
+// checkWebhookHost rejects webhook hosts that are known to be internal
+// without resolving them. Names that resolve to internal addresses are
+// refused when dialing instead.
+func checkWebhookHost(host string) error {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: %s", errWebhookAddressNotAllowed, host)
+	}
+
+	if addr, err := netip.ParseAddr(host); err == nil && !isPublicAddr(addr) {
+		return fmt.Errorf("%w: %s", errWebhookAddressNotAllowed, host)
+	}
+
+	return nil
+}
+
+// webhookDialControl refuses connections to internal addresses, after DNS
+// resolution and for every redirect.
+func webhookDialControl(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("[webhookDialControl] %w", err)
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !isPublicAddr(addr) {
+		return fmt.Errorf("[webhookDialControl] %w: %s", errWebhookAddressNotAllowed, host)
+	}
+
+	return nil
+}
+
+// newWebhookClient builds the client webhooks are sent with. Unlike the
+// upstream client it verifies certificates and ignores proxies, and unless
+// allowPrivate is set it only connects to public addresses.
+func newWebhookClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: webhookTimeout}
+	if !allowPrivate {
+		dialer.Control = webhookDialControl
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: webhookTimeout,
+			MaxIdleConns:        webhookWorkers,
+			IdleConnTimeout:     time.Minute,
+		},
+		Timeout: webhookTimeout,
+	}
+}
+
+func validateWatchInput(input WatchInput, allowPrivate bool) *ValidationError {
+	result := &ValidationError{}
+
+	switch {
+	case input.Location.Lat == 0 && input.Location.Lng == 0:
+		result.add(ValidationIssue{
+			Code:    validationCodeMissingLocation,
+			Field:   "location",
+			Message: "Location is missing",
+			Remedy:  "Give both a latitude and a longitude",
+		})
+	case input.Location.Lat < -90 || input.Location.Lat > 90 ||
+		input.Location.Lng < -180 || input.Location.Lng > 180:
+		result.add(ValidationIssue{
+			Code:    validationCodeInvalidCoordinates,
+			Field:   "location",
+			Message: fmt.Sprintf("Coordinates (%.5f, %.5f) are out of range", input.Location.Lat, input.Location.Lng),
+			Remedy:  "Latitude must be within ±90 and longitude within ±180",
+		})
+	}
+
+	switch {
+	case (input.RadiusMeters > 0) == (input.WalkingMinutes > 0):
+		result.add(ValidationIssue{
+			Code:    validationCodeInvalidField,
+			Field:   "radiusMeters",
+			Message: "Exactly one of radiusMeters and walkingMinutes must be set",
+			Remedy:  "Give a radius in meters or a walking time in minutes",
+		})
+	case input.RadiusMeters > maxWatchRadiusMeters:
+		result.add(ValidationIssue{
+			Code:    validationCodeInvalidField,
+			Field:   "radiusMeters",
+			Message: fmt.Sprintf("A radius of %.0f m is too large", input.RadiusMeters),
+			Remedy:  fmt.Sprintf("Use a radius of at most %d m", maxWatchRadiusMeters),
+		})
+	case input.WalkingMinutes > maxWatchWalkingMinutes:
+		result.add(ValidationIssue{
+			Code:    validationCodeInvalidField,
+			Field:   "walkingMinutes",
+			Message: fmt.Sprintf("A walking time of %.0f minutes is too long", input.WalkingMinutes),
+			Remedy:  fmt.Sprintf("Use a walking time of at most %d minutes", maxWatchWalkingMinutes),
+		})
+	}
+
+	parsed, err := url.Parse(input.WebhookURL)
+
+	switch {
+	case err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "":
+		result.add(ValidationIssue{
+			Code:    validationCodeInvalidField,
+			Field:   "webhookUrl",
+			Message: fmt.Sprintf("%q is not an http or https URL", input.WebhookURL),
+			Remedy:  "Use an absolute URL such as https://example.com/hooks/poppy",
+		})
+	case !allowPrivate && checkWebhookHost(parsed.Hostname()) != nil:
+		result.add(ValidationIssue{
+			Code:    validationCodeInvalidField,
+			Field:   "webhookUrl",
+			Message: fmt.Sprintf("%q points at a loopback, private or link-local address", input.WebhookURL),
+			Remedy:  "Use a publicly reachable receiver",
+		})
+	}
+
+	if len(result.Issues) == 0 {
+		return nil
+	}
+
+	return result
+}
+
+// webhookDelivery is a notification waiting to be sent. done is called
+// with the outcome once every attempt was made.
+type webhookDelivery struct {
+	watch        Watch
+	notification WatchNotification
+	done         func(error)
+}
+
+func (d webhookDelivery) key() string {
+	if d.notification.Vehicle == nil {
+		return d.watch.ID
+	}
+
+	return d.watch.ID + "/" + d.notification.Vehicle.UUID
+}
+
+// webhookSender delivers signed notifications, either right away with send
+// or from a queue served by webhookWorkers workers.
+type webhookSender struct {
+	client *http.Client
+	// retryDelay is the pause before the second attempt, doubled after
+	// each further failure.
+	retryDelay time.Duration
+
+	queue   chan webhookDelivery
+	mu      sync.Mutex
+	pending map[string]bool
+}
+
+func newWebhookSender(client *http.Client) *webhookSender {
+	return &webhookSender{
+		client:     client,
+		retryDelay: time.Second,
+		queue:      make(chan webhookDelivery, webhookQueueSize),
+		pending:    map[string]bool{},
+	}
+}
+
+// enqueue queues delivery unless the same notification is still pending.
+// It reports false when the delivery was not queued, including when the
+// queue is full.
+func (s *webhookSender) enqueue(delivery webhookDelivery) bool {
+	key := delivery.key()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pending[key] {
+		return false
+	}
+
+	select {
+	case s.queue <- delivery:
+		s.pending[key] = true
+
+		return true
+	default:
+		return false
+	}
+}
+
+// run delivers queued notifications until ctx is cancelled. Deliveries
+// still queued then are dropped; their watches retry on the next poll.
+func (s *webhookSender) run(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	for range webhookWorkers {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case delivery := <-s.queue:
+					delivery.done(s.send(ctx, delivery.watch, delivery.notification))
+
+					s.mu.Lock()
+					delete(s.pending, delivery.key())
+					s.mu.Unlock()
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+// sendOnce makes a single attempt, for callers waiting on the outcome.
+func (s *webhookSender) sendOnce(ctx context.Context, watch Watch, notification WatchNotification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return fmt.Errorf("[webhookSender] could not encode notification: %w", err)
+	}
+
+	return s.post(ctx, watch, notification.Event, newID(), body)
+}
+
+func (s *webhookSender) send(ctx context.Context, watch Watch, notification WatchNotification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return fmt.Errorf("[webhookSender] could not encode notification: %w", err)
+	}
+
+	deliveryID := newID()
+	delay := s.retryDelay
+
+	for attempt := 1; ; attempt++ {
+		err = s.post(ctx, watch, notification.Event, deliveryID, body)
+		if err == nil || attempt == webhookAttempts {
+			return err
+		}
+
+		select {
+		case <-time.After(delay):
+			delay *= 2
+		case <-ctx.Done():
+			return fmt.Errorf("[webhookSender] %w", ctx.Err())
+		}
+	}
+}
+
+func (s *webhookSender) post(
+	ctx context.Context,
+	watch Watch,
+	event string,
+	deliveryID string,
+	body []byte,
+) error {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, watch.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("[webhookSender] could not create request: %w", err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, event)
+	req.Header.Set(webhookDeliveryHeader, deliveryID)
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, signWebhook(watch.Secret, timestamp, body))
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("[webhookSender] request failed: %w", err)
+	}
+
+	_ = res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("[webhookSender] receiver returned status %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// watchPoller checks the fleet against every watch on each tick.
+type watchPoller struct {
+	client *http.Client
+	cache  *upstreamCache
+	store  *watchStore
+	sender *webhookSender
+}
+
+func (p *watchPoller) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		p.poll(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *watchPoller) poll(ctx context.Context) {
+	watches := p.store.list()
+	if len(watches) == 0 {
+		return
+	}
+
+	vehicles, err := p.cache.fetchVehicles(ctx, p.client)
+	if err != nil {
+		slog.WarnContext(ctx, "watch poll could not fetch vehicles", "error", err)
+
+		return
+	}
+
+	for _, watch := range watches {
+		p.check(ctx, watch, vehicles)
+	}
+}
+
+// check queues a notification for every vehicle matching watch that it
+// was not notified of yet, and forgets the vehicles that left, so they fire
+// again when they come back. Failed deliveries are retried on the next
+// poll.
+func (p *watchPoller) check(ctx context.Context, watch Watch, vehicles []Vehicle) {
+	matching := map[string]bool{}
+
+	for _, vehicle := range vehicles {
+		matches, distance := watch.matches(vehicle)
+		if !matches {
+			continue
+		}
+
+		matching[vehicle.UUID] = true
+
+		if slices.Contains(watch.Matching, vehicle.UUID) {
+			continue
+		}
+
+		p.sender.enqueue(webhookDelivery{
+			watch: watch,
+			notification: WatchNotification{
+				Event:          watchEventVehicleNearby,
+				WatchID:        watch.ID,
+				Vehicle:        &vehicle,
+				DistanceMeters: distance,
+				WalkingMinutes: distance / 1000 / configFromContext(ctx).WalkingSpeedKmh * 60,
+				OccurredAt:     time.Now().UTC(),
+			},
+			done: func(err error) { p.delivered(ctx, watch.ID, vehicle.UUID, err) },
+		})
+	}
+
+	// Vehicles notified since watch was read are still matching, so they
+	// are kept. A watch is only written when a vehicle left it.
+	p.updateWatch(ctx, watch.ID, func(stored *Watch) bool {
+		left := len(stored.Matching)
+		stored.Matching = slices.DeleteFunc(stored.Matching, func(uuid string) bool {
+			return !matching[uuid]
+		})
+
+		return len(stored.Matching) != left
+	})
+}
+
+// delivered records the outcome of notifying watchID of vehicleUUID.
+func (p *watchPoller) delivered(ctx context.Context, watchID, vehicleUUID string, err error) {
+	if err != nil {
+		slog.WarnContext(ctx, "webhook delivery failed",
+			"watch_id", watchID,
+			"vehicle_uuid", vehicleUUID,
+			"error", err,
+		)
+	}
+
+	p.updateWatch(ctx, watchID, func(stored *Watch) bool {
+		if err != nil {
+			changed := stored.LastDeliveryError != err.Error()
+			stored.LastDeliveryError = err.Error()
+
+			return changed
+		}
+
+		now := time.Now().UTC()
+		stored.LastNotifiedAt = &now
+		stored.LastDeliveryError = ""
+
+		if !slices.Contains(stored.Matching, vehicleUUID) {
+			stored.Matching = append(stored.Matching, vehicleUUID)
+		}
+
+		return true
+	})
+}
+
+// errWatchUnchanged aborts a store update that would write the watch as it
+// already is.
+var errWatchUnchanged = errors.New("watch unchanged")
+
+// updateWatch applies change to the stored watch, and writes it only when
+// change reports it changed something.
+func (p *watchPoller) updateWatch(ctx context.Context, watchID string, change func(*Watch) bool) {
+	_, err := p.store.update(watchID, func(stored *Watch) error {
+		if !change(stored) {
+			return errWatchUnchanged
+		}
+
+		return nil
+	})
+	if err != nil && !errors.Is(err, errNotFound) && !errors.Is(err, errWatchUnchanged) {
+		slog.WarnContext(ctx, "could not update watch", "watch_id", watchID, "error", err)
+	}
+}
+
+func respondWatchNotFound(w http.ResponseWriter) {
+	respondJSON(w, http.StatusNotFound, APIResponse{
+		Success: false,
+		Error:   "Watch not found",
+	})
+}
+
+func createWatchHandler(store *watchStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input WatchInput
+
+		err := apiSpec.decodeRequest(r, "/api/v1/watches", &input)
+		if err == nil {
+			allowPrivate := configFromContext(r.Context()).WebhookAllowPrivate
+			if validationErr := validateWatchInput(input, allowPrivate); validationErr != nil {
+				err = validationErr
+			}
+		}
+
+		if respondBodyTooLarge(w, r, err) {
+			return
+		}
+
+		if errors.Is(err, errInvalidRequestBody) {
+			respondJSON(w, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error:   "Invalid JSON request body",
+			})
+
+			return
+		}
+
+		if err != nil {
+			respondJSON(w, http.StatusBadRequest, APIResponse{
+				Success: false,
+				Error:   err.Error(),
+				Issues:  validationIssues(err),
+			})
+
+			return
+		}
+
+		watch := Watch{
+			ID:             newID(),
+			Location:       input.Location,
+			RadiusMeters:   input.RadiusMeters,
+			WalkingMinutes: input.WalkingMinutes,
+			Filter:         input.Filter,
+			WebhookURL:     input.WebhookURL,
+			Secret:         input.Secret,
+			CreatedAt:      time.Now().UTC(),
+			Owner:          requestOwner(r.Context()),
+			Matching:       []string{},
+		}
+
+		if watch.WalkingMinutes > 0 {
+			watch.RadiusMeters = walkingMinutesToMeters(
+				watch.WalkingMinutes,
+				configFromContext(r.Context()).WalkingSpeedKmh,
+			)
+		}
+
+		if watch.Secret == "" {
+			watch.Secret = newWebhookSecret()
+		}
+
+		if err := store.put(watch.ID, watch); err != nil {
+			respondJSON(w, http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+
+			return
+		}
+
+		respondJSON(w, http.StatusCreated, APIResponse{
+			Success: true,
+			Data:    watch,
+		})
+	}
+}
+
+func listWatchesHandler(store *watchStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		watches := []Watch{}
+
+		for _, watch := range store.list() {
+			if canAccessOwner(r.Context(), watch.Owner) {
+				watches = append(watches, watch.withoutSecret())
+			}
+		}
+
+		slices.SortFunc(watches, func(a, b Watch) int {
+			return a.CreatedAt.Compare(b.CreatedAt)
+		})
+
+		respondJSON(w, http.StatusOK, APIResponse{
+			Success: true,
+			Data:    watches,
+		})
+	}
+}
+
+func getWatchHandler(store *watchStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		watch, ok := store.get(r.PathValue("id"))
+		if !ok || !canAccessOwner(r.Context(), watch.Owner) {
+			respondWatchNotFound(w)
+
+			return
+		}
+
+		respondJSON(w, http.StatusOK, APIResponse{
+			Success: true,
+			Data:    watch.withoutSecret(),
+		})
+	}
+}
+
+func deleteWatchHandler(store *watchStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if watch, ok := store.get(r.PathValue("id")); !ok || !canAccessOwner(r.Context(), watch.Owner) {
+			respondWatchNotFound(w)
+
+			return
+		}
+
+		err := store.delete(r.PathValue("id"))
+		if errors.Is(err, errNotFound) {
+			respondWatchNotFound(w)
+
+			return
+		}
+
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+
+			return
+		}
+
+		respondJSON(w, http.StatusOK, APIResponse{Success: true})
+	}
+}
+
+// testWatchHandler sends a test event to the webhook of a watch, to check
+// a receiver and its signature verification.
+func testWatchHandler(store *watchStore, sender *webhookSender) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		watch, ok := store.get(r.PathValue("id"))
+		if !ok || !canAccessOwner(r.Context(), watch.Owner) {
+			respondWatchNotFound(w)
+
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), webhookTestTimeout)
+		defer cancel()
+
+		err := sender.sendOnce(ctx, watch, WatchNotification{
+			Event:      watchEventTest,
+			WatchID:    watch.ID,
+			OccurredAt: time.Now().UTC(),
+		})
+		if err != nil {
+			respondJSON(w, http.StatusBadGateway, APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+
+			return
+		}
+
+		respondJSON(w, http.StatusOK, APIResponse{Success: true})
+	}
+}
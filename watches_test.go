@@ -0,0 +1,551 @@
+//nolint:package-comments,revive,mnd,exhaustruct
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWatch_Matches(t *testing.T) {
+	watch := Watch{
+		Location:     Location{Lat: 50.85, Lng: 4.35},
+		RadiusMeters: 500,
+		Filter:       VehicleFilter{Energy: "electric", MinAutonomyPercentage: 40},
+	}
+
+	tests := []struct {
+		name     string
+		vehicle  Vehicle
+		expected bool
+	}{
+		{
+			name: "Nearby match",
+			vehicle: Vehicle{
+				LocationLatitude: 50.851, LocationLongitude: 4.35,
+				Model: Model{Energy: "ELECTRIC"}, AutonomyPercentage: 80,
+			},
+			expected: true,
+		},
+		{
+			name: "Too far",
+			vehicle: Vehicle{
+				LocationLatitude: 50.86, LocationLongitude: 4.35,
+				Model: Model{Energy: "electric"}, AutonomyPercentage: 80,
+			},
+			expected: false,
+		},
+		{
+			name: "Wrong energy",
+			vehicle: Vehicle{
+				LocationLatitude: 50.851, LocationLongitude: 4.35,
+				Model: Model{Energy: "petrol"}, AutonomyPercentage: 80,
+			},
+			expected: false,
+		},
+		{
+			name: "Low autonomy",
+			vehicle: Vehicle{
+				LocationLatitude: 50.851, LocationLongitude: 4.35,
+				Model: Model{Energy: "electric"}, AutonomyPercentage: 20,
+			},
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if matches, _ := watch.matches(tt.vehicle); matches != tt.expected {
+				t.Errorf("Expected %t but got %t", tt.expected, matches)
+			}
+		})
+	}
+}
+
+func TestValidateWatchInput(t *testing.T) {
+	valid := WatchInput{
+		Location:     Location{Lat: 50.85, Lng: 4.35},
+		RadiusMeters: 300,
+		WebhookURL:   "https://hooks.example.com/poppy",
+	}
+
+	tests := []struct {
+		name         string
+		change       func(input *WatchInput)
+		allowPrivate bool
+		expected     []validationCode
+	}{
+		{name: "Valid", change: func(*WatchInput) {}},
+		{
+			name:     "Missing location",
+			change:   func(input *WatchInput) { input.Location = Location{} },
+			expected: []validationCode{validationCodeMissingLocation},
+		},
+		{
+			name:     "Radius and walking time",
+			change:   func(input *WatchInput) { input.WalkingMinutes = 5 },
+			expected: []validationCode{validationCodeInvalidField},
+		},
+		{
+			name:     "Neither radius nor walking time",
+			change:   func(input *WatchInput) { input.RadiusMeters = 0 },
+			expected: []validationCode{validationCodeInvalidField},
+		},
+		{
+			name:     "Radius too large",
+			change:   func(input *WatchInput) { input.RadiusMeters = maxWatchRadiusMeters + 1 },
+			expected: []validationCode{validationCodeInvalidField},
+		},
+		{
+			name: "Walking time too long",
+			change: func(input *WatchInput) {
+				input.RadiusMeters = 0
+				input.WalkingMinutes = maxWatchWalkingMinutes + 1
+			},
+			expected: []validationCode{validationCodeInvalidField},
+		},
+		{
+			name:     "Relative webhook",
+			change:   func(input *WatchInput) { input.WebhookURL = "/hooks" },
+			expected: []validationCode{validationCodeInvalidField},
+		},
+		{
+			name:     "Localhost webhook",
+			change:   func(input *WatchInput) { input.WebhookURL = "http://localhost:9000/hooks" },
+			expected: []validationCode{validationCodeInvalidField},
+		},
+		{
+			name:     "Metadata webhook",
+			change:   func(input *WatchInput) { input.WebhookURL = "http://169.254.169.254/latest" },
+			expected: []validationCode{validationCodeInvalidField},
+		},
+		{
+			name:     "Private webhook",
+			change:   func(input *WatchInput) { input.WebhookURL = "http://[::ffff:10.0.0.1]/hooks" },
+			expected: []validationCode{validationCodeInvalidField},
+		},
+		{
+			name:         "Private webhook allowed",
+			change:       func(input *WatchInput) { input.WebhookURL = "http://localhost:9000/hooks" },
+			allowPrivate: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := valid
+			tt.change(&input)
+
+			var codes []validationCode
+			if err := validateWatchInput(input, tt.allowPrivate); err != nil {
+				for _, issue := range err.Issues {
+					codes = append(codes, issue.Code)
+				}
+			}
+
+			if len(codes) != len(tt.expected) {
+				t.Fatalf("Expected issues %v but got %v", tt.expected, codes)
+			}
+
+			for i := range codes {
+				if codes[i] != tt.expected[i] {
+					t.Errorf("Expected issues %v but got %v", tt.expected, codes)
+				}
+			}
+		})
+	}
+}
+
+// webhookReceiver records the notifications whose signature checks out.
+type webhookReceiver struct {
+	mu            sync.Mutex
+	secret        string
+	status        int
+	notifications []WatchNotification
+	badSignatures int
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	expected := signWebhook(r.secret, req.Header.Get(webhookTimestampHeader), body)
+	if req.Header.Get(webhookSignatureHeader) != expected {
+		r.badSignatures++
+		w.WriteHeader(http.StatusUnauthorized)
+
+		return
+	}
+
+	var notification WatchNotification
+	_ = json.Unmarshal(body, &notification)
+	r.notifications = append(r.notifications, notification)
+
+	if r.status != 0 {
+		w.WriteHeader(r.status)
+	}
+}
+
+func newTestWatch(t *testing.T, receiver *webhookReceiver) *watchStore {
+	t.Helper()
+
+	server := httptest.NewServer(receiver)
+	t.Cleanup(server.Close)
+
+	store, _ := openJSONStore[Watch]("")
+	watch := Watch{
+		ID:           "watch-1",
+		Location:     Location{Lat: 50.85, Lng: 4.35},
+		RadiusMeters: 500,
+		WebhookURL:   server.URL,
+		Secret:       receiver.secret,
+		Matching:     []string{},
+	}
+	_ = store.put(watch.ID, watch)
+
+	return store
+}
+
+// startTestWebhookSender runs a sender over client until the test ends.
+func startTestWebhookSender(t *testing.T, client *http.Client) *webhookSender {
+	t.Helper()
+
+	sender := newWebhookSender(client)
+	sender.retryDelay = 0
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		sender.run(ctx)
+		close(done)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return sender
+}
+
+// waitForDeliveries blocks until sender has nothing queued or in flight.
+func waitForDeliveries(t *testing.T, sender *webhookSender) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for time.Now().Before(deadline) {
+		sender.mu.Lock()
+		pending := len(sender.pending)
+		sender.mu.Unlock()
+
+		if pending == 0 {
+			return
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatal("Expected the queued webhooks to be delivered")
+}
+
+func TestWatchPoller_NotifiesOncePerAppearance(t *testing.T) {
+	receiver := &webhookReceiver{secret: "shh"}
+	store := newTestWatch(t, receiver)
+
+	sender := startTestWebhookSender(t, http.DefaultClient)
+	poller := &watchPoller{store: store, sender: sender}
+
+	nearby := Vehicle{UUID: "vehicle-1", LocationLatitude: 50.851, LocationLongitude: 4.35}
+	far := Vehicle{UUID: "vehicle-2", LocationLatitude: 50.95, LocationLongitude: 4.35}
+
+	polls := [][]Vehicle{{nearby, far}, {nearby, far}, {far}, {nearby}}
+	for _, vehicles := range polls {
+		watch, _ := store.get("watch-1")
+		poller.check(withConfig(context.Background(), defaultConfig()), watch, vehicles)
+		waitForDeliveries(t, sender)
+	}
+
+	if receiver.badSignatures != 0 {
+		t.Errorf("Expected every signature to verify but got %d bad ones", receiver.badSignatures)
+	}
+
+	if len(receiver.notifications) != 2 {
+		t.Fatalf("Expected a notification per appearance but got %d", len(receiver.notifications))
+	}
+
+	notification := receiver.notifications[0]
+	if notification.Event != watchEventVehicleNearby || notification.WatchID != "watch-1" ||
+		notification.Vehicle == nil || notification.Vehicle.UUID != "vehicle-1" {
+		t.Errorf("Expected a vehicle.available event for vehicle-1 but got %+v", notification)
+	}
+
+	if watch, _ := store.get("watch-1"); watch.LastNotifiedAt == nil || len(watch.Matching) != 1 {
+		t.Errorf("Expected the watch to record the notification but got %+v", watch)
+	}
+}
+
+func TestWatchPoller_WritesOnlyChanges(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := openJSONStore[Watch](dir)
+	_ = store.put("watch-1", Watch{
+		ID:           "watch-1",
+		Location:     Location{Lat: 50.85, Lng: 4.35},
+		RadiusMeters: 500,
+		Matching:     []string{"vehicle-1"},
+	})
+
+	path := filepath.Join(dir, "watch-1.json")
+	written := time.Date(2025, 6, 2, 9, 0, 0, 0, time.UTC)
+	_ = os.Chtimes(path, written, written)
+
+	poller := &watchPoller{store: store, sender: newWebhookSender(http.DefaultClient)}
+	ctx := withConfig(context.Background(), defaultConfig())
+	nearby := Vehicle{UUID: "vehicle-1", LocationLatitude: 50.851, LocationLongitude: 4.35}
+
+	watch, _ := store.get("watch-1")
+	poller.check(ctx, watch, []Vehicle{nearby})
+
+	if info, _ := os.Stat(path); !info.ModTime().Equal(written) {
+		t.Errorf("Expected an unchanged watch not to be written but it was at %v", info.ModTime())
+	}
+
+	watch, _ = store.get("watch-1")
+	poller.check(ctx, watch, []Vehicle{})
+
+	if info, _ := os.Stat(path); info.ModTime().Equal(written) {
+		t.Error("Expected the watch to be written once its vehicle left")
+	}
+}
+
+func TestWatchPoller_RetriesFailedDelivery(t *testing.T) {
+	receiver := &webhookReceiver{secret: "shh", status: http.StatusInternalServerError}
+	store := newTestWatch(t, receiver)
+
+	sender := startTestWebhookSender(t, http.DefaultClient)
+	poller := &watchPoller{store: store, sender: sender}
+
+	vehicles := []Vehicle{{UUID: "vehicle-1", LocationLatitude: 50.851, LocationLongitude: 4.35}}
+
+	watch, _ := store.get("watch-1")
+	poller.check(withConfig(context.Background(), defaultConfig()), watch, vehicles)
+	waitForDeliveries(t, sender)
+
+	if len(receiver.notifications) != webhookAttempts {
+		t.Errorf("Expected %d attempts but got %d", webhookAttempts, len(receiver.notifications))
+	}
+
+	watch, _ = store.get("watch-1")
+	if watch.LastDeliveryError == "" || len(watch.Matching) != 0 {
+		t.Fatalf("Expected the failure to be recorded and retried later but got %+v", watch)
+	}
+
+	receiver.mu.Lock()
+	receiver.status = http.StatusOK
+	receiver.mu.Unlock()
+
+	poller.check(withConfig(context.Background(), defaultConfig()), watch, vehicles)
+	waitForDeliveries(t, sender)
+
+	if watch, _ = store.get("watch-1"); watch.LastDeliveryError != "" || len(watch.Matching) != 1 {
+		t.Errorf("Expected the next poll to deliver but got %+v", watch)
+	}
+}
+
+func TestWatchPoller_SlowReceiverDoesNotBlockOthers(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	slow := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { <-release }))
+	t.Cleanup(slow.Close)
+
+	receiver := &webhookReceiver{secret: "shh"}
+	store := newTestWatch(t, receiver)
+	_ = store.put("watch-slow", Watch{
+		ID:           "watch-slow",
+		Location:     Location{Lat: 50.85, Lng: 4.35},
+		RadiusMeters: 500,
+		WebhookURL:   slow.URL,
+		Matching:     []string{},
+	})
+
+	sender := startTestWebhookSender(t, http.DefaultClient)
+	poller := &watchPoller{store: store, sender: sender}
+
+	vehicles := []Vehicle{{UUID: "vehicle-1", LocationLatitude: 50.851, LocationLongitude: 4.35}}
+
+	slowWatch, _ := store.get("watch-slow")
+	poller.check(withConfig(context.Background(), defaultConfig()), slowWatch, vehicles)
+
+	watch, _ := store.get("watch-1")
+	poller.check(withConfig(context.Background(), defaultConfig()), watch, vehicles)
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if watch, _ = store.get("watch-1"); len(watch.Matching) == 1 {
+			return
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Error("Expected the fast receiver to be notified while the slow one hangs")
+}
+
+func TestNewWebhookClient_RefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	t.Cleanup(server.Close)
+
+	resp, err := newWebhookClient(false).Get(server.URL)
+	if err == nil {
+		resp.Body.Close()
+	}
+
+	if !errors.Is(err, errWebhookAddressNotAllowed) {
+		t.Errorf("Expected the loopback receiver to be refused but got %v", err)
+	}
+
+	resp, err = newWebhookClient(true).Get(server.URL)
+	if err != nil {
+		t.Fatalf("Expected the loopback receiver to be allowed for tests but got %v", err)
+	}
+
+	resp.Body.Close()
+}
+
+func TestWatchHandlers(t *testing.T) {
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	t.Cleanup(server.Close)
+
+	store, _ := openJSONStore[Watch]("")
+	sender := newWebhookSender(http.DefaultClient)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/watches", listWatchesHandler(store))
+	mux.HandleFunc("POST /api/v1/watches", createWatchHandler(store))
+	mux.HandleFunc("GET /api/v1/watches/{id}", getWatchHandler(store))
+	mux.HandleFunc("DELETE /api/v1/watches/{id}", deleteWatchHandler(store))
+	mux.HandleFunc("POST /api/v1/watches/{id}/test", testWatchHandler(store, sender))
+
+	config := defaultConfig()
+	config.WebhookAllowPrivate = true
+
+	alice := &apiClient{key: APIKey{Name: "alice"}}
+	bob := &apiClient{key: APIKey{Name: "bob"}}
+	admin := &apiClient{key: APIKey{Name: "ops", Admin: true}}
+
+	serveAs := func(client *apiClient, method, path, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		request = request.WithContext(withORSBudget(withConfig(request.Context(), config), client))
+
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, request)
+
+		return recorder
+	}
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		return serveAs(alice, method, path, body)
+	}
+
+	invalid := serve(http.MethodPost, "/api/v1/watches", `{"location": {"lat": 50.85, "lng": 4.35}, "webhookUrl": "`+server.URL+`"}`)
+	if invalid.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without a radius but got %d", invalid.Code)
+	}
+
+	created := serve(http.MethodPost, "/api/v1/watches",
+		`{"location": {"lat": 50.85, "lng": 4.35}, "walkingMinutes": 6, "webhookUrl": "`+server.URL+`"}`)
+	if created.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201 but got %d: %s", created.Code, created.Body.String())
+	}
+
+	var response struct {
+		Data Watch `json:"data"`
+	}
+
+	_ = json.NewDecoder(created.Body).Decode(&response)
+
+	watch := response.Data
+	if watch.Secret == "" || watch.RadiusMeters != 500 {
+		t.Errorf("Expected a generated secret and a 500m radius but got %+v", watch)
+	}
+
+	if watch.Owner != "alice" {
+		t.Errorf("Expected the watch to belong to alice but got %q", watch.Owner)
+	}
+
+	fetched := serve(http.MethodGet, "/api/v1/watches/"+watch.ID, "")
+	if fetched.Code != http.StatusOK || strings.Contains(fetched.Body.String(), watch.Secret) {
+		t.Errorf("Expected the watch without its secret but got %d: %s", fetched.Code, fetched.Body.String())
+	}
+
+	access := []struct {
+		name   string
+		client *apiClient
+		status int
+		listed bool
+	}{
+		{name: "Owner", client: alice, status: http.StatusOK, listed: true},
+		{name: "Other key", client: bob, status: http.StatusNotFound, listed: false},
+		{name: "Admin key", client: admin, status: http.StatusOK, listed: true},
+	}
+
+	for _, tt := range access {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := serveAs(tt.client, http.MethodGet, "/api/v1/watches/"+watch.ID, ""); got.Code != tt.status {
+				t.Errorf("Expected status %d but got %d", tt.status, got.Code)
+			}
+
+			listed := serveAs(tt.client, http.MethodGet, "/api/v1/watches", "")
+			if strings.Contains(listed.Body.String(), watch.ID) != tt.listed {
+				t.Errorf("Expected listed to be %t but got %s", tt.listed, listed.Body.String())
+			}
+		})
+	}
+
+	if deleted := serveAs(bob, http.MethodDelete, "/api/v1/watches/"+watch.ID, ""); deleted.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 when another key deletes but got %d", deleted.Code)
+	}
+
+	receiver.secret = watch.Secret
+
+	if tested := serve(http.MethodPost, "/api/v1/watches/"+watch.ID+"/test", ""); tested.Code != http.StatusOK {
+		t.Errorf("Expected the test event to be delivered but got %d", tested.Code)
+	}
+
+	if len(receiver.notifications) != 1 || receiver.notifications[0].Event != watchEventTest {
+		t.Errorf("Expected one signed test event but got %+v", receiver.notifications)
+	}
+
+	receiver.status = http.StatusInternalServerError
+
+	if tested := serve(http.MethodPost, "/api/v1/watches/"+watch.ID+"/test", ""); tested.Code != http.StatusBadGateway {
+		t.Errorf("Expected status 502 when the receiver fails but got %d", tested.Code)
+	}
+
+	if len(receiver.notifications) != 2 {
+		t.Errorf("Expected a single attempt for the test event but got %d deliveries", len(receiver.notifications)-1)
+	}
+
+	if deleted := serve(http.MethodDelete, "/api/v1/watches/"+watch.ID, ""); deleted.Code != http.StatusOK {
+		t.Errorf("Expected status 200 on delete but got %d", deleted.Code)
+	}
+
+	if missing := serve(http.MethodGet, "/api/v1/watches/"+watch.ID, ""); missing.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 after delete but got %d", missing.Code)
+	}
+}
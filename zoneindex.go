@@ -0,0 +1,320 @@
+//nolint:package-comments,revive,mnd,exhaustruct
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/paulmach/orb"
+)
+
+// rtreeNodeCapacity is the fan-out of the R-tree. Small nodes keep the
+// bounds tight, which matters more than depth for point queries.
+const rtreeNodeCapacity = 8
+
+// preparedRing caches the bounds of a ring so most containment tests are
+// rejected before walking its edges.
+type preparedRing struct {
+	bound  orb.Bound
+	points orb.Ring
+}
+
+func newPreparedRing(ring orb.Ring) preparedRing {
+	return preparedRing{bound: ring.Bound(), points: ring}
+}
+
+// contains runs an even-odd ray casting test against the ring.
+func (r preparedRing) contains(point orb.Point) bool {
+	if !r.bound.Contains(point) {
+		return false
+	}
+
+	inside := false
+	points := r.points
+
+	for i, j := 0, len(points)-1; i < len(points); j, i = i, i+1 {
+		if (points[i][1] > point[1]) == (points[j][1] > point[1]) {
+			continue
+		}
+
+		crossingX := (points[j][0]-points[i][0])*(point[1]-points[i][1])/
+			(points[j][1]-points[i][1]) + points[i][0]
+
+		if point[0] < crossingX {
+			inside = !inside
+		}
+	}
+
+	return inside
+}
+
+type preparedPolygon struct {
+	geofencingType string
+	kind           zoneKind
+	modelType      string
+	outer          preparedRing
+	holes          []preparedRing
+}
+
+func (p preparedPolygon) contains(point orb.Point) bool {
+	if !p.outer.contains(point) {
+		return false
+	}
+
+	for _, hole := range p.holes {
+		if hole.contains(point) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type rtreeNode struct {
+	bound    orb.Bound
+	children []*rtreeNode
+	items    []int
+}
+
+// search calls visit for every item whose bounds contain point, stopping
+// early when visit returns false.
+func (n *rtreeNode) search(
+	point orb.Point,
+	bounds []orb.Bound,
+	visit func(int) bool,
+) bool {
+	if !n.bound.Contains(point) {
+		return true
+	}
+
+	for _, item := range n.items {
+		if !bounds[item].Contains(point) {
+			continue
+		}
+
+		if !visit(item) {
+			return false
+		}
+	}
+
+	for _, child := range n.children {
+		if !child.search(point, bounds, visit) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// buildRTree bulk loads a static R-tree using Sort-Tile-Recursive packing.
+func buildRTree(bounds []orb.Bound) *rtreeNode {
+	if len(bounds) == 0 {
+		return nil
+	}
+
+	nodes := make([]*rtreeNode, len(bounds))
+	for i, bound := range bounds {
+		nodes[i] = &rtreeNode{bound: bound, items: []int{i}}
+	}
+
+	leaves := true
+
+	for len(nodes) > 1 || leaves {
+		nodes = packRTreeLevel(nodes, leaves)
+		leaves = false
+	}
+
+	return nodes[0]
+}
+
+func packRTreeLevel(nodes []*rtreeNode, leaves bool) []*rtreeNode {
+	groupCount := int(math.Ceil(float64(len(nodes)) / rtreeNodeCapacity))
+	sliceCount := int(math.Ceil(math.Sqrt(float64(groupCount))))
+	sliceSize := sliceCount * rtreeNodeCapacity
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].bound.Center()[0] < nodes[j].bound.Center()[0]
+	})
+
+	var parents []*rtreeNode
+
+	for start := 0; start < len(nodes); start += sliceSize {
+		slab := nodes[start:min(start+sliceSize, len(nodes))]
+
+		sort.Slice(slab, func(i, j int) bool {
+			return slab[i].bound.Center()[1] < slab[j].bound.Center()[1]
+		})
+
+		for offset := 0; offset < len(slab); offset += rtreeNodeCapacity {
+			group := slab[offset:min(offset+rtreeNodeCapacity, len(slab))]
+			parent := &rtreeNode{bound: group[0].bound}
+
+			for _, node := range group {
+				parent.bound = parent.bound.Union(node.bound)
+
+				if leaves {
+					parent.items = append(parent.items, node.items...)
+				} else {
+					parent.children = append(parent.children, node)
+				}
+			}
+
+			parents = append(parents, parent)
+		}
+	}
+
+	return parents
+}
+
+// zoneIndex is a geozone set compiled for fast containment queries. It is
+// immutable once built and safe to share across requests.
+type zoneIndex struct {
+	geozone  *GeoZone
+	polygons []preparedPolygon
+	bounds   []orb.Bound
+	tree     *rtreeNode
+	kinds    map[zoneKind]bool
+
+	storageOnce sync.Once
+	storageKey  string
+	storageErr  error
+}
+
+func newZoneIndex(geozone *GeoZone) *zoneIndex {
+	index := &zoneIndex{geozone: geozone, kinds: map[zoneKind]bool{}}
+
+	if geozone == nil {
+		return index
+	}
+
+	for _, item := range *geozone {
+		var polygons []orb.Polygon
+
+		switch geom := item.Geom.Geometry.Geometry().(type) {
+		case orb.Polygon:
+			polygons = append(polygons, geom)
+		case orb.MultiPolygon:
+			polygons = append(polygons, geom...)
+		}
+
+		for _, polygon := range polygons {
+			if len(polygon) == 0 {
+				continue
+			}
+
+			prepared := preparedPolygon{
+				geofencingType: item.GeofencingType,
+				kind:           classifyGeofencingType(item.GeofencingType),
+				modelType:      item.ModelType,
+				outer:          newPreparedRing(polygon[0]),
+			}
+
+			for _, hole := range polygon[1:] {
+				prepared.holes = append(prepared.holes, newPreparedRing(hole))
+			}
+
+			if prepared.modelType == string(vehicleModelTypeCar) {
+				index.kinds[prepared.kind] = true
+			}
+
+			index.polygons = append(index.polygons, prepared)
+			index.bounds = append(index.bounds, prepared.outer.bound)
+		}
+	}
+
+	index.tree = buildRTree(index.bounds)
+
+	return index
+}
+
+// containing calls visit for every polygon that contains location, stopping
+// early when visit returns false.
+func (z *zoneIndex) containing(location Location, visit func(preparedPolygon) bool) {
+	if z == nil || z.tree == nil {
+		return
+	}
+
+	point := orb.Point{location.Lng, location.Lat}
+
+	z.tree.search(point, z.bounds, func(i int) bool {
+		if !z.polygons[i].contains(point) {
+			return true
+		}
+
+		return visit(z.polygons[i])
+	})
+}
+
+func (z *zoneIndex) inZone(location Location, kind zoneKind) bool {
+	found := false
+
+	z.containing(location, func(polygon preparedPolygon) bool {
+		found = polygon.kind == kind &&
+			polygon.modelType == string(vehicleModelTypeCar)
+
+		return !found
+	})
+
+	return found
+}
+
+func (z *zoneIndex) inParkingZone(location Location) bool {
+	return z.inZone(location, zoneKindParking)
+}
+
+// hasZones reports whether the geozone data defines any car zone of kind.
+func (z *zoneIndex) hasZones(kind zoneKind) bool {
+	return z != nil && z.kinds[kind]
+}
+
+// zonesAt returns the sorted geofencing types of every car zone that
+// contains location.
+func (z *zoneIndex) zonesAt(location Location) []string {
+	types := []string{}
+
+	z.containing(location, func(polygon preparedPolygon) bool {
+		if polygon.modelType == string(vehicleModelTypeCar) &&
+			!slices.Contains(types, polygon.geofencingType) {
+			types = append(types, polygon.geofencingType)
+		}
+
+		return true
+	})
+
+	slices.Sort(types)
+
+	return types
+}
+
+func (z *zoneIndex) zone() *GeoZone {
+	if z == nil {
+		return nil
+	}
+
+	return z.geozone
+}
+
+// storageID is the hash of the geozone set as stored with quotes. It is
+// computed once per index, and the cache builds an index per upstream
+// fetch, so plans do not re-hash unchanged geozones.
+func (z *zoneIndex) storageID() (string, error) {
+	z.storageOnce.Do(func() {
+		data, err := json.Marshal(z.geozone)
+		if err != nil {
+			z.storageErr = fmt.Errorf("[zoneIndex.storageID] could not encode: %w", err)
+
+			return
+		}
+
+		sum := sha256.Sum256(data)
+		z.storageKey = hex.EncodeToString(sum[:])
+	})
+
+	return z.storageKey, z.storageErr
+}
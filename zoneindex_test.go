@@ -0,0 +1,142 @@
+//nolint:package-comments,revive,mnd,exhaustruct,gosec
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/planar"
+)
+
+// newBenchmarkGeoZone builds a city-sized geozone: a grid of circular
+// parking areas with finely detailed rings, bundled in one multipolygon,
+// plus an operating area covering the whole grid.
+func newBenchmarkGeoZone(gridSize int, vertices int) GeoZone {
+	var parking orb.MultiPolygon
+
+	for row := range gridSize {
+		for col := range gridSize {
+			center := orb.Point{4.25 + float64(col)*0.01, 50.78 + float64(row)*0.01}
+			ring := make(orb.Ring, 0, vertices+1)
+
+			for i := range vertices {
+				angle := 2 * math.Pi * float64(i) / float64(vertices)
+				ring = append(ring, orb.Point{
+					center[0] + 0.004*math.Cos(angle),
+					center[1] + 0.004*math.Sin(angle),
+				})
+			}
+
+			ring = append(ring, ring[0])
+			parking = append(parking, orb.Polygon{ring})
+		}
+	}
+
+	extent := float64(gridSize) * 0.01
+
+	return GeoZone{
+		newTestGeoZone("operating", orb.Polygon{{
+			{4.24, 50.77}, {4.24 + extent, 50.77}, {4.24 + extent, 50.77 + extent},
+			{4.24, 50.77 + extent}, {4.24, 50.77},
+		}}),
+		newTestGeoZone("parking", parking),
+	}
+}
+
+func randomBenchmarkLocations(count int, gridSize int) []Location {
+	random := rand.New(rand.NewSource(1))
+	extent := float64(gridSize) * 0.01
+
+	locations := make([]Location, count)
+	for i := range locations {
+		locations[i] = Location{
+			Lat: 50.77 + random.Float64()*extent,
+			Lng: 4.24 + random.Float64()*extent,
+		}
+	}
+
+	return locations
+}
+
+// linearInParkingZone is the unindexed scan the planner used before the
+// zone index: every polygon is tested with planar.PolygonContains.
+func linearInParkingZone(location Location, geozone *GeoZone) bool {
+	point := orb.Point{location.Lng, location.Lat}
+
+	for _, polygon := range parkingPolygons(geozone) {
+		if planar.PolygonContains(polygon, point) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestZoneIndex_MatchesLinearScan(t *testing.T) {
+	geozone := newBenchmarkGeoZone(10, 64)
+	index := newZoneIndex(&geozone)
+
+	for _, location := range randomBenchmarkLocations(2000, 10) {
+		expected := linearInParkingZone(location, &geozone)
+		if actual := index.inParkingZone(location); actual != expected {
+			t.Fatalf("Location %+v: expected %v but got %v", location, expected, actual)
+		}
+	}
+}
+
+func TestZoneIndex_Holes(t *testing.T) {
+	geozone := GeoZone{
+		newTestGeoZone("parking", orb.Polygon{
+			{{4.30, 50.80}, {4.40, 50.80}, {4.40, 50.90}, {4.30, 50.90}, {4.30, 50.80}},
+			{{4.34, 50.84}, {4.36, 50.84}, {4.36, 50.86}, {4.34, 50.86}, {4.34, 50.84}},
+		}),
+	}
+
+	index := newZoneIndex(&geozone)
+
+	if !index.inParkingZone(Location{Lat: 50.82, Lng: 4.32}) {
+		t.Error("Expected point in the outer ring to be in the zone")
+	}
+
+	if index.inParkingZone(Location{Lat: 50.85, Lng: 4.35}) {
+		t.Error("Expected point in the hole to be outside the zone")
+	}
+
+	var nilIndex *zoneIndex
+	if nilIndex.inParkingZone(Location{Lat: 50.82, Lng: 4.32}) {
+		t.Error("Expected nil index to contain nothing")
+	}
+}
+
+func BenchmarkInParkingZone_Linear(b *testing.B) {
+	geozone := newBenchmarkGeoZone(20, 256)
+	locations := randomBenchmarkLocations(1024, 20)
+
+	b.ResetTimer()
+
+	for i := range b.N {
+		linearInParkingZone(locations[i%len(locations)], &geozone)
+	}
+}
+
+func BenchmarkInParkingZone_Index(b *testing.B) {
+	geozone := newBenchmarkGeoZone(20, 256)
+	index := newZoneIndex(&geozone)
+	locations := randomBenchmarkLocations(1024, 20)
+
+	b.ResetTimer()
+
+	for i := range b.N {
+		index.inParkingZone(locations[i%len(locations)])
+	}
+}
+
+func BenchmarkNewZoneIndex(b *testing.B) {
+	geozone := newBenchmarkGeoZone(20, 256)
+
+	for range b.N {
+		newZoneIndex(&geozone)
+	}
+}